@@ -17,16 +17,25 @@ import (
 	"github.com/reusee/june/key"
 	"github.com/reusee/june/keyset"
 	"github.com/reusee/june/naming"
+	"github.com/reusee/june/store"
+	"github.com/reusee/june/storebolt"
 	"github.com/reusee/june/storedisk"
+	"github.com/reusee/june/storehashsharded"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/june/storelimit"
 	"github.com/reusee/june/storemem"
+	"github.com/reusee/june/storemirror"
 	"github.com/reusee/june/storemonotree"
 	"github.com/reusee/june/storenssharded"
 	"github.com/reusee/june/storeonedrive"
+	"github.com/reusee/june/storepack"
 	"github.com/reusee/june/storepebble"
 	"github.com/reusee/june/stores3"
+	"github.com/reusee/june/storesftp"
 	"github.com/reusee/june/storesqlite"
 	"github.com/reusee/june/storestacked"
 	"github.com/reusee/june/storetap"
+	"github.com/reusee/june/storewebdav"
 	"github.com/reusee/june/sys"
 	"github.com/reusee/june/tx"
 	"github.com/reusee/june/vars"
@@ -49,6 +58,16 @@ func Test_codec_TestCodecStacked(t *testing.T) {
 	runTest(t, codec.TestCodecStacked)
 }
 
+func Test_codec_TestCodecXChaCha20Poly1305(t *testing.T) {
+	t.Parallel()
+	runTest(t, codec.TestCodecXChaCha20Poly1305)
+}
+
+func Test_codec_TestConvergent(t *testing.T) {
+	t.Parallel()
+	runTest(t, codec.TestConvergent)
+}
+
 func Test_codec_TestHybridCompressed(t *testing.T) {
 	t.Parallel()
 	runTest(t, codec.TestHybridCompressed)
@@ -64,6 +83,11 @@ func Test_codec_TestHybridZstd(t *testing.T) {
 	runTest(t, codec.TestHybridZstd)
 }
 
+func Test_codec_TestKeyring(t *testing.T) {
+	t.Parallel()
+	runTest(t, codec.TestKeyring)
+}
+
 func Test_codec_TestOnion(t *testing.T) {
 	t.Parallel()
 	runTest(t, codec.TestOnion)
@@ -84,6 +108,11 @@ func Test_codec_TestSnappyStream(t *testing.T) {
 	runTest(t, codec.TestSnappyStream)
 }
 
+func Test_codec_TestZstdDict(t *testing.T) {
+	t.Parallel()
+	runTest(t, codec.TestZstdDict)
+}
+
 func Test_entity_TestDelete(t *testing.T) {
 	t.Parallel()
 	runTest(t, entity.TestDelete)
@@ -104,6 +133,11 @@ func Test_entity_TestGC(t *testing.T) {
 	runTest(t, entity.TestGC)
 }
 
+func Test_entity_TestGCTrash(t *testing.T) {
+	t.Parallel()
+	runTest(t, entity.TestGCTrash)
+}
+
 func Test_entity_TestGCWithEmptyIndex(t *testing.T) {
 	t.Parallel()
 	runTest(t, entity.TestGCWithEmptyIndex)
@@ -149,6 +183,11 @@ func Test_entity_TestSummaryUpdate(t *testing.T) {
 	runTest(t, entity.TestSummaryUpdate)
 }
 
+func Test_entity_TestZstdDict(t *testing.T) {
+	t.Parallel()
+	runTest(t, entity.TestZstdDict)
+}
+
 func Test_file_TestBuild(t *testing.T) {
 	t.Parallel()
 	runTest(t, file.TestBuild)
@@ -239,6 +278,11 @@ func Test_index_TestIdxUnknown(t *testing.T) {
 	runTest(t, index.TestIdxUnknown)
 }
 
+func Test_index_TestOrderedKey(t *testing.T) {
+	t.Parallel()
+	runTest(t, index.TestOrderedKey)
+}
+
 func Test_index_TestTuple(t *testing.T) {
 	t.Parallel()
 	runTest(t, index.TestTuple)
@@ -284,6 +328,51 @@ func Test_naming_TestTypeName(t *testing.T) {
 	runTest(t, naming.TestTypeName)
 }
 
+func Test_store_TestScrubRepair(t *testing.T) {
+	t.Parallel()
+	runTest(t, store.TestScrubRepair)
+}
+
+func Test_storebolt_TestBatch(t *testing.T) {
+	t.Parallel()
+	runTest(t, storebolt.TestBatch)
+}
+
+func Test_storebolt_TestBatchIndex(t *testing.T) {
+	t.Parallel()
+	runTest(t, storebolt.TestBatchIndex)
+}
+
+func Test_storebolt_TestBatchKV(t *testing.T) {
+	t.Parallel()
+	runTest(t, storebolt.TestBatchKV)
+}
+
+func Test_storebolt_TestIndex(t *testing.T) {
+	t.Parallel()
+	runTest(t, storebolt.TestIndex)
+}
+
+func Test_storebolt_TestIndexOrder(t *testing.T) {
+	t.Parallel()
+	runTest(t, storebolt.TestIndexOrder)
+}
+
+func Test_storebolt_TestKV(t *testing.T) {
+	t.Parallel()
+	runTest(t, storebolt.TestKV)
+}
+
+func Test_storedisk_TestTrash(t *testing.T) {
+	t.Parallel()
+	runTest(t, storedisk.TestTrash)
+}
+
+func Test_storedisk_TestDurability(t *testing.T) {
+	t.Parallel()
+	runTest(t, storedisk.TestDurability)
+}
+
 func Test_storedisk_TestStore(t *testing.T) {
 	t.Parallel()
 	runTest(t, storedisk.TestStore)
@@ -294,6 +383,41 @@ func Test_storedisk_TestStoreSoftDelete(t *testing.T) {
 	runTest(t, storedisk.TestStoreSoftDelete)
 }
 
+func Test_storehashsharded_TestPersistState(t *testing.T) {
+	t.Parallel()
+	runTest(t, storehashsharded.TestPersistState)
+}
+
+func Test_storehashsharded_TestRebalance(t *testing.T) {
+	t.Parallel()
+	runTest(t, storehashsharded.TestRebalance)
+}
+
+func Test_storehashsharded_TestStore(t *testing.T) {
+	t.Parallel()
+	runTest(t, storehashsharded.TestStore)
+}
+
+func Test_storekv_TestPassphrase(t *testing.T) {
+	t.Parallel()
+	runTest(t, storekv.TestPassphrase)
+}
+
+func Test_storekv_TestRecodec(t *testing.T) {
+	t.Parallel()
+	runTest(t, storekv.TestRecodec)
+}
+
+func Test_storelimit_TestKV(t *testing.T) {
+	t.Parallel()
+	runTest(t, storelimit.TestKV)
+}
+
+func Test_storelimit_TestLimit(t *testing.T) {
+	t.Parallel()
+	runTest(t, storelimit.TestLimit)
+}
+
 func Test_storemem_TestIndex(t *testing.T) {
 	t.Parallel()
 	runTest(t, storemem.TestIndex)
@@ -304,6 +428,16 @@ func Test_storemem_TestStore(t *testing.T) {
 	runTest(t, storemem.TestStore)
 }
 
+func Test_storemirror_TestMirror(t *testing.T) {
+	t.Parallel()
+	runTest(t, storemirror.TestMirror)
+}
+
+func Test_storemirror_TestStore(t *testing.T) {
+	t.Parallel()
+	runTest(t, storemirror.TestStore)
+}
+
 func Test_storemonotree_TestStore(t *testing.T) {
 	t.Parallel()
 	runTest(t, storemonotree.TestStore)
@@ -314,11 +448,46 @@ func Test_storenssharded_TestStore(t *testing.T) {
 	runTest(t, storenssharded.TestStore)
 }
 
+func Test_storeonedrive_TestFakeKV(t *testing.T) {
+	t.Parallel()
+	runTest(t, storeonedrive.TestFakeKV)
+}
+
 func Test_storeonedrive_TestKV(t *testing.T) {
 	t.Parallel()
 	runTest(t, storeonedrive.TestKV)
 }
 
+func Test_storeonedrive_TestRetryAfter(t *testing.T) {
+	t.Parallel()
+	runTest(t, storeonedrive.TestRetryAfter)
+}
+
+func Test_storeonedrive_TestUploadSession(t *testing.T) {
+	t.Parallel()
+	runTest(t, storeonedrive.TestUploadSession)
+}
+
+func Test_storepack_TestKV(t *testing.T) {
+	t.Parallel()
+	runTest(t, storepack.TestKV)
+}
+
+func Test_storepack_TestPack(t *testing.T) {
+	t.Parallel()
+	runTest(t, storepack.TestPack)
+}
+
+func Test_storepack_TestPackWriters(t *testing.T) {
+	t.Parallel()
+	runTest(t, storepack.TestPackWriters)
+}
+
+func Test_storepebble_TestBackup(t *testing.T) {
+	t.Parallel()
+	runTest(t, storepebble.TestBackup)
+}
+
 func Test_storepebble_TestBatchIndex(t *testing.T) {
 	t.Parallel()
 	runTest(t, storepebble.TestBatchIndex)
@@ -329,6 +498,11 @@ func Test_storepebble_TestBatchKV(t *testing.T) {
 	runTest(t, storepebble.TestBatchKV)
 }
 
+func Test_storepebble_TestCompact(t *testing.T) {
+	t.Parallel()
+	runTest(t, storepebble.TestCompact)
+}
+
 func Test_storepebble_TestIndex(t *testing.T) {
 	t.Parallel()
 	runTest(t, storepebble.TestIndex)
@@ -349,11 +523,76 @@ func Test_storepebble_TestMixedKV(t *testing.T) {
 	runTest(t, storepebble.TestMixedKV)
 }
 
+func Test_stores3_TestFakeKV(t *testing.T) {
+	t.Parallel()
+	runTest(t, stores3.TestFakeKV)
+}
+
 func Test_stores3_TestKV(t *testing.T) {
 	t.Parallel()
 	runTest(t, stores3.TestKV)
 }
 
+func Test_stores3_TestMultipart(t *testing.T) {
+	t.Parallel()
+	runTest(t, stores3.TestMultipart)
+}
+
+func Test_stores3_TestRetry(t *testing.T) {
+	t.Parallel()
+	runTest(t, stores3.TestRetry)
+}
+
+func Test_stores3_TestRetryNetworkError(t *testing.T) {
+	t.Parallel()
+	runTest(t, stores3.TestRetryNetworkError)
+}
+
+func Test_storesftp_TestIter(t *testing.T) {
+	t.Parallel()
+	runTest(t, storesftp.TestIter)
+}
+
+func Test_storesftp_TestKV(t *testing.T) {
+	t.Parallel()
+	runTest(t, storesftp.TestKV)
+}
+
+func Test_storesftp_TestReconnect(t *testing.T) {
+	t.Parallel()
+	runTest(t, storesftp.TestReconnect)
+}
+
+func Test_storesqlite_TestBackup(t *testing.T) {
+	t.Parallel()
+	runTest(t, storesqlite.TestBackup)
+}
+
+func Test_storesqlite_TestCompact(t *testing.T) {
+	t.Parallel()
+	runTest(t, storesqlite.TestCompact)
+}
+
+func Test_storesqlite_TestIndex(t *testing.T) {
+	t.Parallel()
+	runTest(t, storesqlite.TestIndex)
+}
+
+func Test_storesqlite_TestIndexOrder(t *testing.T) {
+	t.Parallel()
+	runTest(t, storesqlite.TestIndexOrder)
+}
+
+func Test_storesqlite_TestKeyMany(t *testing.T) {
+	t.Parallel()
+	runTest(t, storesqlite.TestKeyMany)
+}
+
+func Test_storesqlite_TestKeyStat(t *testing.T) {
+	t.Parallel()
+	runTest(t, storesqlite.TestKeyStat)
+}
+
 func Test_storesqlite_TestKV(t *testing.T) {
 	t.Parallel()
 	runTest(t, storesqlite.TestKV)
@@ -364,16 +603,46 @@ func Test_storestacked_TestStore(t *testing.T) {
 	runTest(t, storestacked.TestStore)
 }
 
+func Test_storestacked_TestTiered(t *testing.T) {
+	t.Parallel()
+	runTest(t, storestacked.TestTiered)
+}
+
+func Test_storestacked_TestWriteBack(t *testing.T) {
+	t.Parallel()
+	runTest(t, storestacked.TestWriteBack)
+}
+
 func Test_storetap_TestStore(t *testing.T) {
 	t.Parallel()
 	runTest(t, storetap.TestStore)
 }
 
+func Test_storewebdav_TestIter(t *testing.T) {
+	t.Parallel()
+	runTest(t, storewebdav.TestIter)
+}
+
+func Test_storewebdav_TestKV(t *testing.T) {
+	t.Parallel()
+	runTest(t, storewebdav.TestKV)
+}
+
 func Test_sys_TestTesting(t *testing.T) {
 	t.Parallel()
 	runTest(t, sys.TestTesting)
 }
 
+func Test_tx_TestBoltTx(t *testing.T) {
+	t.Parallel()
+	runTest(t, tx.TestBoltTx)
+}
+
+func Test_tx_TestBoltTxEntityDelete(t *testing.T) {
+	t.Parallel()
+	runTest(t, tx.TestBoltTxEntityDelete)
+}
+
 func Test_tx_TestPebbleTx(t *testing.T) {
 	t.Parallel()
 	runTest(t, tx.TestPebbleTx)
@@ -384,7 +653,16 @@ func Test_tx_TestPebbleTxEntityDelete(t *testing.T) {
 	runTest(t, tx.TestPebbleTxEntityDelete)
 }
 
+func Test_vars_TestReplicateCheckpoint(t *testing.T) {
+	t.Parallel()
+	runTest(t, vars.TestReplicateCheckpoint)
+}
+
 func Test_vars_TestVars(t *testing.T) {
 	t.Parallel()
 	runTest(t, vars.TestVars)
 }
+
+func Benchmark_storedisk_BenchmarkDurability(b *testing.B) {
+	runBench(b, storedisk.BenchmarkDurability)
+}
@@ -10,11 +10,14 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 
+	"github.com/reusee/e5"
 	"github.com/reusee/june/opts"
 	"github.com/reusee/sb"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 type aeadCodec struct {
@@ -39,6 +42,76 @@ func (a aeadCodec) ID() string {
 }
 
 func (a aeadCodec) Encode(sink sb.Sink, options ...Option) sb.Sink {
+	return encodeSealed(sink, options, func(plaintext []byte) (any, error) {
+		nonce, ciphertext, err := seal(a.newAEAD, plaintext, nil)
+		if err != nil {
+			return nil, err
+		}
+		return func() ([]byte, []byte) {
+			return nonce, ciphertext
+		}, nil
+	})
+}
+
+func (a aeadCodec) Decode(stream sb.Stream, options ...Option) sb.Stream {
+	var nonce []byte
+	var ciphertext []byte
+	return decodeOpened(
+		stream,
+		func(n []byte, c []byte) {
+			nonce = n
+			ciphertext = c
+		},
+		func() ([]byte, error) {
+			return open(a.newAEAD, nonce, ciphertext, nil)
+		},
+	)
+}
+
+var ErrBadNonce = errors.New("bad nonce")
+
+// seal encrypts plaintext with a random nonce
+func seal(
+	newAEAD func() (cipher.AEAD, error),
+	plaintext []byte,
+	additionalData []byte,
+) (nonce []byte, ciphertext []byte, err error) {
+	defer he(&err)
+	aead, err := newAEAD()
+	ce(err)
+	nonce = make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	ce(err)
+	ciphertext = aead.Seal(nil, nonce, plaintext, additionalData)
+	return
+}
+
+// open decrypts ciphertext, nonce is read from encoded value and checked before use
+func open(
+	newAEAD func() (cipher.AEAD, error),
+	nonce []byte,
+	ciphertext []byte,
+	additionalData []byte,
+) (_ []byte, err error) {
+	defer he(&err)
+	aead, err := newAEAD()
+	ce(err)
+	if len(nonce) != aead.NonceSize() {
+		return nil, we.With(
+			e5.Info("expecting %d bytes, got %d", aead.NonceSize(), len(nonce)),
+		)(ErrBadNonce)
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	ce(err)
+	return plaintext, nil
+}
+
+// encodeSealed buffers encoding of the value, and marshals the result of sealFn to sink
+func encodeSealed(
+	sink sb.Sink,
+	options []Option,
+	sealFn func(plaintext []byte) (any, error),
+) sb.Sink {
 	var buf *bytes.Buffer
 	for _, option := range options {
 		switch option := option.(type) {
@@ -54,17 +127,10 @@ func (a aeadCodec) Encode(sink sb.Sink, options ...Option) sb.Sink {
 		make([]byte, 8),
 		func(_ *sb.Token) (_ sb.Sink, err error) {
 			defer he(&err)
-			plaintext := buf.Bytes()
-			aead, err := a.newAEAD()
+			sealed, err := sealFn(buf.Bytes())
 			ce(err)
-			nonce := make([]byte, aead.NonceSize())
-			_, err = io.ReadFull(rand.Reader, nonce)
-			ce(err)
-			ciphertext := aead.Seal(nil, nonce, plaintext, nil)
 			if err := sb.Copy(
-				sb.Marshal(func() ([]byte, []byte) {
-					return nonce, ciphertext
-				}),
+				sb.Marshal(sealed),
 				sink,
 			); err != nil {
 				return nil, err
@@ -74,23 +140,21 @@ func (a aeadCodec) Encode(sink sb.Sink, options ...Option) sb.Sink {
 	)
 }
 
-func (a aeadCodec) Decode(stream sb.Stream, options ...Option) sb.Stream {
+// decodeOpened unmarshals the sealed value to target, and decodes the result of openFn
+func decodeOpened(
+	stream sb.Stream,
+	target any,
+	openFn func() ([]byte, error),
+) sb.Stream {
 	proc := sb.Proc(func() (_ *sb.Token, _ sb.Proc, err error) {
 		defer he(&err)
-		var nonce []byte
-		var ciphertext []byte
 		if err := sb.Copy(
 			stream,
-			sb.Unmarshal(func(n []byte, c []byte) {
-				nonce = n
-				ciphertext = c
-			}),
+			sb.Unmarshal(target),
 		); err != nil {
 			return nil, nil, err
 		}
-		aead, err := a.newAEAD()
-		ce(err)
-		plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+		plaintext, err := openFn()
 		ce(err)
 		br := bytes.NewReader(plaintext)
 		buf := make([]byte, 8)
@@ -100,7 +164,14 @@ func (a aeadCodec) Decode(stream sb.Stream, options ...Option) sb.Stream {
 }
 
 func AESGCM(key []byte) aeadCodec {
-	newAEAD := func() (_ cipher.AEAD, err error) {
+	return NewAEADCodec(
+		fmt.Sprintf("aead-%x-", keyHash(key)),
+		newAESGCM(key),
+	)
+}
+
+func newAESGCM(key []byte) func() (cipher.AEAD, error) {
+	return func() (_ cipher.AEAD, err error) {
 		defer he(&err)
 		block, err := aes.NewCipher(key)
 		ce(err)
@@ -108,6 +179,23 @@ func AESGCM(key []byte) aeadCodec {
 		ce(err)
 		return aead, nil
 	}
+}
+
+// XChaCha20Poly1305 returns a codec with 24 bytes random nonces, key must be 32 bytes
+func XChaCha20Poly1305(key []byte) aeadCodec {
+	return NewAEADCodec(
+		fmt.Sprintf("xchacha20poly1305-%x-", keyHash(key)),
+		newXChaCha20Poly1305(key),
+	)
+}
+
+func newXChaCha20Poly1305(key []byte) func() (cipher.AEAD, error) {
+	return func() (cipher.AEAD, error) {
+		return chacha20poly1305.NewX(key)
+	}
+}
+
+func keyHash(key []byte) []byte {
 	var h []byte
 	if err := sb.Copy(
 		sb.Marshal(key),
@@ -115,8 +203,5 @@ func AESGCM(key []byte) aeadCodec {
 	); err != nil {
 		panic(err)
 	}
-	return NewAEADCodec(
-		fmt.Sprintf("aead-%x-", h),
-		newAEAD,
-	)
+	return h
 }
@@ -0,0 +1,127 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/reusee/june/opts"
+	"github.com/reusee/sb"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// convergentCodec encrypts deterministically, same plaintexts encoded with the same secret have the same ciphertext.
+// For plaintext p:
+//
+//	id = HMAC-SHA256(secret, "id" || SHA256(p))
+//	key = HMAC-SHA256(secret, "key" || id)
+//	ciphertext = XChaCha20-Poly1305(key, zero nonce, p, additional data id)
+//
+// Keys are unique per plaintext, so the fixed nonce is never reused with different plaintexts.
+// Encoded values are the tuple (id, ciphertext), decoding verifies id against the decrypted plaintext
+type convergentCodec struct {
+	secret []byte
+	id     string
+}
+
+var _ Codec = convergentCodec{}
+
+var ErrConvergentIDNotMatch = errors.New("convergent id not match")
+
+func Convergent(secret []byte) convergentCodec {
+	return convergentCodec{
+		secret: secret,
+		id:     fmt.Sprintf("convergent-%x-", keyHash(secret)),
+	}
+}
+
+func (c convergentCodec) ID() string {
+	return c.id
+}
+
+func (c convergentCodec) mac(label string, data []byte) []byte {
+	m := hmac.New(sha256.New, c.secret)
+	m.Write([]byte(label))
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+func (c convergentCodec) objectID(plaintext []byte) []byte {
+	h := sha256.Sum256(plaintext)
+	return c.mac("id", h[:])
+}
+
+func (c convergentCodec) newAEAD(id []byte) (cipher.AEAD, error) {
+	return chacha20poly1305.NewX(c.mac("key", id))
+}
+
+var zeroNonce = make([]byte, chacha20poly1305.NonceSizeX)
+
+func (c convergentCodec) Encode(sink sb.Sink, options ...Option) sb.Sink {
+	var buf *bytes.Buffer
+	for _, option := range options {
+		switch option := option.(type) {
+		case opts.NewBytesBuffer:
+			buf = option()
+		}
+	}
+	if buf == nil {
+		buf = new(bytes.Buffer)
+	}
+	return sb.EncodeBuffer(
+		buf,
+		make([]byte, 8),
+		func(_ *sb.Token) (_ sb.Sink, err error) {
+			defer he(&err)
+			plaintext := buf.Bytes()
+			id := c.objectID(plaintext)
+			aead, err := c.newAEAD(id)
+			ce(err)
+			ciphertext := aead.Seal(nil, zeroNonce, plaintext, id)
+			if err := sb.Copy(
+				sb.Marshal(func() ([]byte, []byte) {
+					return id, ciphertext
+				}),
+				sink,
+			); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		},
+	)
+}
+
+func (c convergentCodec) Decode(stream sb.Stream, options ...Option) sb.Stream {
+	proc := sb.Proc(func() (_ *sb.Token, _ sb.Proc, err error) {
+		defer he(&err)
+		var id []byte
+		var ciphertext []byte
+		if err := sb.Copy(
+			stream,
+			sb.Unmarshal(func(i []byte, c []byte) {
+				id = i
+				ciphertext = c
+			}),
+		); err != nil {
+			return nil, nil, err
+		}
+		aead, err := c.newAEAD(id)
+		ce(err)
+		plaintext, err := aead.Open(nil, zeroNonce, ciphertext, id)
+		ce(err)
+		if !hmac.Equal(c.objectID(plaintext), id) {
+			return nil, nil, we(ErrConvergentIDNotMatch)
+		}
+		br := bytes.NewReader(plaintext)
+		buf := make([]byte, 8)
+		return nil, sb.DecodeBuffer(br, br, buf, nil), nil
+	})
+	return &proc
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/sb"
+)
+
+// AEADKey is a key in keyring
+type AEADKey struct {
+	ID      string
+	NewAEAD func() (cipher.AEAD, error)
+}
+
+func AESGCMKey(id string, key []byte) AEADKey {
+	return AEADKey{
+		ID:      id,
+		NewAEAD: newAESGCM(key),
+	}
+}
+
+func XChaCha20Poly1305Key(id string, key []byte) AEADKey {
+	return AEADKey{
+		ID:      id,
+		NewAEAD: newXChaCha20Poly1305(key),
+	}
+}
+
+var ErrUnknownKey = errors.New("unknown key")
+
+// KeyringCodec encrypts with the current key, and decrypts with any key in the ring.
+// Encoded values carry the key ID, which is also authenticated as additional data.
+// The codec ID does not depend on keys, so rotating keys does not change the ID recorded by OnionCodec
+type KeyringCodec struct {
+	id      string
+	current AEADKey
+	keys    map[string]AEADKey
+}
+
+var _ Codec = KeyringCodec{}
+
+func NewKeyringCodec(
+	id string,
+	current AEADKey,
+	previous ...AEADKey,
+) KeyringCodec {
+	keys := make(map[string]AEADKey)
+	for _, key := range previous {
+		keys[key.ID] = key
+	}
+	keys[current.ID] = current
+	return KeyringCodec{
+		id:      id,
+		current: current,
+		keys:    keys,
+	}
+}
+
+func (k KeyringCodec) ID() string {
+	return fmt.Sprintf("keyring-%s", k.id)
+}
+
+func (k KeyringCodec) Encode(sink sb.Sink, options ...Option) sb.Sink {
+	return encodeSealed(sink, options, func(plaintext []byte) (any, error) {
+		// key id is authenticated as additional data
+		keyID := k.current.ID
+		nonce, ciphertext, err := seal(k.current.NewAEAD, plaintext, []byte(keyID))
+		if err != nil {
+			return nil, err
+		}
+		return func() (string, []byte, []byte) {
+			return keyID, nonce, ciphertext
+		}, nil
+	})
+}
+
+func (k KeyringCodec) Decode(stream sb.Stream, options ...Option) sb.Stream {
+	var keyID string
+	var nonce []byte
+	var ciphertext []byte
+	return decodeOpened(
+		stream,
+		func(id string, n []byte, c []byte) {
+			keyID = id
+			nonce = n
+			ciphertext = c
+		},
+		func() ([]byte, error) {
+			key, ok := k.keys[keyID]
+			if !ok {
+				return nil, we.With(
+					e5.Info("no such key: %s", keyID),
+				)(ErrUnknownKey)
+			}
+			return open(key.NewAEAD, nonce, ciphertext, []byte(keyID))
+		},
+	)
+}
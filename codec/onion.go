@@ -43,6 +43,26 @@ func (_ OnionCodec) ID() string {
 	return "onion"
 }
 
+// EncoderIDs returns ids of encoders, in the order written in headers of encoded values
+func (o OnionCodec) EncoderIDs() []string {
+	return o.encoderIDs
+}
+
+// OnionEncoderIDs returns encoder ids in the header of value encoded by OnionCodec
+func OnionEncoderIDs(stream sb.Stream) (ids []string, err error) {
+	var tokens sb.Tokens
+	if err := sb.Copy(
+		stream,
+		sb.Unmarshal(&sb.Tuple{
+			sb.Unmarshal(&ids),
+			sb.CollectValueTokens(&tokens),
+		}),
+	); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
 func (o OnionCodec) Encode(sink sb.Sink, options ...Option) sb.Sink {
 	headerWritten := false
 
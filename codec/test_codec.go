@@ -69,6 +69,13 @@ func TestCodecAESGCM(
 	testCodec(t, codec)
 }
 
+func TestCodecXChaCha20Poly1305(
+	t *testing.T,
+) {
+	codec := XChaCha20Poly1305([]byte("12345678901234567890123456789012"))
+	testCodec(t, codec)
+}
+
 func TestCodecSnappy(
 	t *testing.T,
 ) {
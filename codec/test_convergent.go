@@ -0,0 +1,158 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/sb"
+)
+
+// Threat model of the convergent codec
+//
+// Parties sharing the secret are trusted, stores and anyone reading stored values are not.
+//
+// Protected against parties without the secret:
+//   - reading plaintexts
+//   - confirming a guessed plaintext is stored, since ids and keys depend on the secret
+//   - modifying values or swapping ids undetected
+//
+// Not protected:
+//   - equality: identical plaintexts have identical encoded values, which is what makes deduplication possible.
+//     Stores learn which objects are shared by machines and how often an object is written
+//   - sizes of plaintexts
+//   - parties holding the secret can confirm guessed plaintexts, or brute-force low entropy ones,
+//     so the secret should only be shared by machines that may read each other's data
+//   - leaked secret exposes all values encoded with it, there is no per-object forward secrecy
+//
+// Use AESGCM or XChaCha20Poly1305 if equality of objects should be hidden.
+func TestConvergent(
+	t *testing.T,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	secret := []byte("foo")
+	codec := Convergent(secret)
+	testCodec(t, codec)
+
+	encode := func(codec Codec, v any) []byte {
+		buf := new(bytes.Buffer)
+		ce(sb.Copy(
+			sb.Marshal(v),
+			codec.Encode(sb.Encode(buf)),
+		))
+		return buf.Bytes()
+	}
+	decode := func(codec Codec, bs []byte) (ret string, err error) {
+		err = sb.Copy(
+			codec.Decode(sb.Decode(bytes.NewReader(bs))),
+			sb.Unmarshal(&ret),
+		)
+		return
+	}
+
+	// deterministic across machines sharing the secret
+	a := encode(codec, "foo")
+	b := encode(Convergent(secret), "foo")
+	if !bytes.Equal(a, b) {
+		t.Fatal("not deterministic")
+	}
+	if codec.ID() != Convergent(secret).ID() {
+		t.Fatal()
+	}
+	s, err := decode(Convergent(secret), a)
+	ce(err)
+	if s != "foo" {
+		t.Fatal()
+	}
+
+	// different plaintexts
+	if bytes.Equal(encode(codec, "bar"), a) {
+		t.Fatal()
+	}
+
+	// not readable and not comparable without the secret
+	other := Convergent([]byte("bar"))
+	if bytes.Equal(encode(other, "foo"), a) {
+		t.Fatal()
+	}
+	if other.ID() == codec.ID() {
+		t.Fatal()
+	}
+	if _, err := decode(other, a); err == nil {
+		t.Fatal()
+	}
+	if bytes.Contains(a, []byte("foo")) {
+		t.Fatal("plaintext leaked")
+	}
+
+	// tampering
+	var id, ciphertext []byte
+	ce(sb.Copy(
+		sb.Decode(bytes.NewReader(a)),
+		sb.Unmarshal(func(i []byte, c []byte) {
+			id = i
+			ciphertext = c
+		}),
+	))
+	reencode := func(id, ciphertext []byte) []byte {
+		buf := new(bytes.Buffer)
+		ce(sb.Copy(
+			sb.Marshal(func() ([]byte, []byte) {
+				return id, ciphertext
+			}),
+			sb.Encode(buf),
+		))
+		return buf.Bytes()
+	}
+	flipped := append(ciphertext[:0:0], ciphertext...)
+	flipped[0] ^= 1
+	if _, err := decode(codec, reencode(id, flipped)); err == nil {
+		t.Fatal()
+	}
+	var barID []byte
+	ce(sb.Copy(
+		sb.Decode(bytes.NewReader(encode(codec, "bar"))),
+		sb.Unmarshal(func(i []byte, _ []byte) {
+			barID = i
+		}),
+	))
+	if _, err := decode(codec, reencode(barID, ciphertext)); err == nil {
+		t.Fatal()
+	}
+
+	// a secret holder can not store a plaintext under another id
+	forged := func() []byte {
+		buf := new(bytes.Buffer)
+		// encrypt "bar" with the key of "foo"
+		plaintext := new(bytes.Buffer)
+		ce(sb.Copy(sb.Marshal("bar"), sb.Encode(plaintext)))
+		aead, err := codec.newAEAD(id)
+		ce(err)
+		ce(sb.Copy(
+			sb.Marshal(func() ([]byte, []byte) {
+				return id, aead.Seal(nil, zeroNonce, plaintext.Bytes(), id)
+			}),
+			sb.Encode(buf),
+		))
+		return buf.Bytes()
+	}()
+	if _, err := decode(codec, forged); !errors.Is(err, ErrConvergentIDNotMatch) {
+		t.Fatalf("got %v", err)
+	}
+
+	// stacked with compression
+	stacked := Stacked(Snappy(), codec)
+	testCodec(t, stacked)
+	if !bytes.Equal(
+		encode(stacked, "foo"),
+		encode(Stacked(Snappy(), Convergent(secret)), "foo"),
+	) {
+		t.Fatal()
+	}
+}
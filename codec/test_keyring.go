@@ -0,0 +1,108 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/sb"
+)
+
+func TestKeyring(
+	t *testing.T,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	key1 := AESGCMKey("1", []byte("1111111111111111"))
+	key2 := XChaCha20Poly1305Key("2", []byte("22222222222222222222222222222222"))
+
+	ring1 := NewKeyringCodec("foo", key1)
+	ring2 := NewKeyringCodec("foo", key2, key1)
+	testCodec(t, ring1)
+	testCodec(t, ring2)
+	if ring1.ID() != ring2.ID() {
+		t.Fatal()
+	}
+
+	encode := func(codec Codec, v any) []byte {
+		buf := new(bytes.Buffer)
+		ce(sb.Copy(
+			sb.Marshal(v),
+			codec.Encode(sb.Encode(buf)),
+		))
+		return buf.Bytes()
+	}
+	decode := func(codec Codec, bs []byte) (ret string, err error) {
+		err = sb.Copy(
+			codec.Decode(sb.Decode(bytes.NewReader(bs))),
+			sb.Unmarshal(&ret),
+		)
+		return
+	}
+
+	// decode values written under previous key
+	old := encode(ring1, "foo")
+	s, err := decode(ring2, old)
+	ce(err)
+	if s != "foo" {
+		t.Fatal()
+	}
+
+	// new values use current key
+	var keyID string
+	ce(sb.Copy(
+		sb.Decode(bytes.NewReader(encode(ring2, "bar"))),
+		sb.Unmarshal(func(id string, _ []byte, _ []byte) {
+			keyID = id
+		}),
+	))
+	if keyID != "2" {
+		t.Fatalf("got %s", keyID)
+	}
+
+	// retired key
+	_, err = decode(NewKeyringCodec("foo", key2), old)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("got %v", err)
+	}
+
+	// key id is authenticated
+	_, err = decode(NewKeyringCodec("foo", AESGCMKey("1", []byte("3333333333333333"))), old)
+	if err == nil {
+		t.Fatal()
+	}
+
+	// bad nonce
+	buf := new(bytes.Buffer)
+	ce(sb.Copy(
+		sb.Marshal(func() (string, []byte, []byte) {
+			return "1", []byte("foo"), []byte("bar")
+		}),
+		sb.Encode(buf),
+	))
+	_, err = decode(ring2, buf.Bytes())
+	if !errors.Is(err, ErrBadNonce) {
+		t.Fatalf("got %v", err)
+	}
+
+	// onion
+	onion1 := NewOnionCodec(
+		[]Codec{Snappy(), ring1},
+		[]Codec{Snappy(), ring1},
+	)
+	onion2 := NewOnionCodec(
+		[]Codec{Snappy(), ring2},
+		[]Codec{Snappy(), ring2},
+	)
+	testCodec(t, onion2)
+	s, err = decode(onion2, encode(onion1, "foo"))
+	ce(err)
+	if s != "foo" {
+		t.Fatal()
+	}
+}
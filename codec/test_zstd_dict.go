@@ -0,0 +1,141 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/sb"
+)
+
+func TestZstdDict(
+	t *testing.T,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	type Record struct {
+		Name     string
+		Size     int64
+		Mode     uint32
+		Tags     []string
+		Modified int64
+	}
+	newRecord := func() Record {
+		return Record{
+			Name:     fmt.Sprintf("dir/file-%d.txt", rand.Intn(100000)),
+			Size:     rand.Int63n(1 << 30),
+			Mode:     0644,
+			Tags:     []string{"document", "archive"},
+			Modified: rand.Int63(),
+		}
+	}
+	encode := func(codec Codec, v any) []byte {
+		buf := new(bytes.Buffer)
+		ce(sb.Copy(
+			sb.Marshal(v),
+			codec.Encode(sb.Encode(buf)),
+		))
+		return buf.Bytes()
+	}
+	decode := func(codec Codec, bs []byte) (ret Record, err error) {
+		err = sb.Copy(
+			codec.Decode(sb.Decode(bytes.NewReader(bs))),
+			sb.Unmarshal(&ret),
+		)
+		return
+	}
+
+	var samples [][]byte
+	for i := 0; i < 512; i++ {
+		buf := new(bytes.Buffer)
+		ce(sb.Copy(sb.Marshal(newRecord()), sb.Encode(buf)))
+		samples = append(samples, buf.Bytes())
+	}
+	content := TrainZstdDict(samples, 4096)
+	if len(content) == 0 || len(content) > 4096 {
+		t.Fatalf("got %d", len(content))
+	}
+	dict := ZstdDict{
+		ID:      []byte("dict1"),
+		Content: content,
+	}
+
+	codec, err := ZstdDictCodec(dict, nil)
+	ce(err)
+	testCodec(t, codec)
+	noDict, err := ZstdDictCodec(ZstdDict{}, nil)
+	ce(err)
+	testCodec(t, noDict)
+	if codec.ID() != noDict.ID() {
+		t.Fatal()
+	}
+
+	// compress small values better than HybridZstd
+	var size, hybridSize int
+	var encoded [][]byte
+	var records []Record
+	for i := 0; i < 128; i++ {
+		record := newRecord()
+		records = append(records, record)
+		bs := encode(codec, record)
+		encoded = append(encoded, bs)
+		size += len(bs)
+		hybridSize += len(encode(HybridZstd(), record))
+	}
+	if size*3 > hybridSize*2 {
+		t.Fatalf("got %d, hybrid zstd %d", size, hybridSize)
+	}
+
+	// previous dictionary
+	dict2 := ZstdDict{
+		ID:      []byte("dict2"),
+		Content: TrainZstdDict(samples[:256], 2048),
+	}
+	loaded := 0
+	codec2, err := ZstdDictCodec(dict2, func(id []byte) ([]byte, error) {
+		loaded++
+		if bytes.Equal(id, dict.ID) {
+			return dict.Content, nil
+		}
+		return nil, we(ErrZstdDictNotFound)
+	})
+	ce(err)
+	for i, bs := range encoded {
+		record, err := decode(codec2, bs)
+		ce(err)
+		if record.Name != records[i].Name || record.Size != records[i].Size {
+			t.Fatal()
+		}
+	}
+	if loaded != 1 {
+		t.Fatalf("got %d", loaded)
+	}
+
+	// not found
+	_, err = decode(noDict, encoded[0])
+	if !errors.Is(err, ErrZstdDictNotFound) {
+		t.Fatalf("got %v", err)
+	}
+	codec3, err := ZstdDictCodec(ZstdDict{}, func(id []byte) ([]byte, error) {
+		return nil, we(ErrZstdDictNotFound)
+	})
+	ce(err)
+	_, err = decode(codec3, encode(codec2, records[0]))
+	if !errors.Is(err, ErrZstdDictNotFound) {
+		t.Fatalf("got %v", err)
+	}
+
+	_, err = ZstdDictCodec(ZstdDict{
+		Content: content,
+	}, nil)
+	if err == nil {
+		t.Fatal()
+	}
+}
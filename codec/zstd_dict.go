@@ -0,0 +1,315 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/reusee/e5"
+	"github.com/reusee/june/opts"
+	"github.com/reusee/sb"
+)
+
+// ZstdDict is a raw content zstd dictionary
+type ZstdDict struct {
+	// ID is written in the header of encoded values to select the dictionary for decoding
+	ID      []byte
+	Content []byte
+}
+
+// LoadZstdDict returns the content of dictionary with the id
+type LoadZstdDict = func(id []byte) ([]byte, error)
+
+var ErrZstdDictNotFound = errors.New("zstd dictionary not found")
+
+// zstdDictCodec compresses values with the current dictionary.
+// Values encoded with previous dictionaries are decodable as long as load returns their contents,
+// so dictionaries can be retrained without rewriting existing values.
+// Encoded values are the tuple (dictionary id, compressed, data).
+// Values not smaller after compression are stored uncompressed, like HybridZstd.
+type zstdDictCodec struct {
+	current  ZstdDict
+	load     LoadZstdDict
+	encoder  *zstd.Encoder
+	mu       sync.Mutex
+	decoders map[string]*zstd.Decoder
+}
+
+var _ Codec = new(zstdDictCodec)
+
+// ZstdDictCodec returns a codec compressing with the current dictionary.
+// Empty current content compresses without dictionary.
+// load is called to get dictionaries not current, and may be nil if there is no previous dictionary
+func ZstdDictCodec(current ZstdDict, load LoadZstdDict) (_ *zstdDictCodec, err error) {
+	defer he(&err)
+	encoderOptions := []zstd.EOption{
+		// checksums and content hashes are provided by stores
+		zstd.WithEncoderCRC(false),
+		// SpeedDefault encoder does not use raw content dictionaries
+		zstd.WithEncoderLevel(zstd.SpeedBetterCompression),
+	}
+	if len(current.Content) > 0 {
+		if len(current.ID) == 0 {
+			return nil, we(errors.New("empty dictionary id"))
+		}
+		encoderOptions = append(encoderOptions, zstd.WithEncoderDictRaw(0, current.Content))
+	} else {
+		current.ID = nil
+	}
+	encoder, err := zstd.NewWriter(nil, encoderOptions...)
+	ce(err)
+	return &zstdDictCodec{
+		current:  current,
+		load:     load,
+		encoder:  encoder,
+		decoders: make(map[string]*zstd.Decoder),
+	}, nil
+}
+
+func (c *zstdDictCodec) ID() string {
+	// not depending on dictionaries, values encoded with different dictionaries are in the same key space
+	return "zstd-dict"
+}
+
+func (c *zstdDictCodec) Encode(sink sb.Sink, options ...Option) sb.Sink {
+	var buf *bytes.Buffer
+	for _, option := range options {
+		switch option := option.(type) {
+		case opts.NewBytesBuffer:
+			buf = option()
+		}
+	}
+	if buf == nil {
+		buf = new(bytes.Buffer)
+	}
+	return sb.EncodeBuffer(
+		buf,
+		make([]byte, 8),
+		func(_ *sb.Token) (sb.Sink, error) {
+			src := buf.Bytes()
+			id := c.current.ID
+			compressed := true
+			data := c.encoder.EncodeAll(src, nil)
+			if len(data) >= len(src) {
+				id = nil
+				compressed = false
+				data = src
+			}
+			if err := sb.Copy(
+				sb.Marshal(func() ([]byte, bool, []byte) {
+					return id, compressed, data
+				}),
+				sink,
+			); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		},
+	)
+}
+
+func (c *zstdDictCodec) Decode(stream sb.Stream, options ...Option) sb.Stream {
+	proc := sb.Proc(func() (_ *sb.Token, _ sb.Proc, err error) {
+		defer he(&err)
+		var id []byte
+		var compressed bool
+		var bs []byte
+		if err := sb.Copy(
+			stream,
+			sb.Unmarshal(func(i []byte, c bool, b []byte) {
+				id = i
+				compressed = c
+				bs = b
+			}),
+		); err != nil {
+			return nil, nil, err
+		}
+		data := bs
+		if compressed {
+			decoder, err := c.decoder(id)
+			ce(err)
+			data, err = decoder.DecodeAll(bs, nil)
+			ce(err, e5.Info("dictionary id: %x", id))
+		}
+		br := bytes.NewReader(data)
+		buf := make([]byte, 8)
+		return nil, sb.DecodeBuffer(br, br, buf, nil), nil
+	})
+	return &proc
+}
+
+func (c *zstdDictCodec) decoder(id []byte) (_ *zstd.Decoder, err error) {
+	defer he(&err, e5.Info("dictionary id: %x", id))
+
+	c.mu.Lock()
+	decoder, ok := c.decoders[string(id)]
+	c.mu.Unlock()
+	if ok {
+		return decoder, nil
+	}
+
+	decoderOptions := []zstd.DOption{
+		zstd.WithDecoderMaxMemory(64 * 1024 * 1024),
+	}
+	if len(id) > 0 {
+		var content []byte
+		if bytes.Equal(id, c.current.ID) {
+			content = c.current.Content
+		} else if c.load != nil {
+			content, err = c.load(id)
+			ce(err)
+		} else {
+			return nil, we(ErrZstdDictNotFound)
+		}
+		decoderOptions = append(decoderOptions, zstd.WithDecoderDictRaw(0, content))
+	}
+	decoder, err = zstd.NewReader(nil, decoderOptions...)
+	ce(err)
+
+	c.mu.Lock()
+	if d, ok := c.decoders[string(id)]; ok {
+		// loaded concurrently
+		decoder.Close()
+		decoder = d
+	} else {
+		c.decoders[string(id)] = decoder
+	}
+	c.mu.Unlock()
+
+	return decoder, nil
+}
+
+const (
+	zstdDictSegmentSize = 16
+	zstdDictDmerSize    = 6
+)
+
+// TrainZstdDict builds a raw content dictionary of at most maxSize bytes from samples.
+// Segments covering byte sequences shared by many samples are selected greedily,
+// and placed near the end of the dictionary in descending value order, where offsets are cheaper
+func TrainZstdDict(samples [][]byte, maxSize int) []byte {
+
+	// number of samples containing each dmer
+	freqs := make(map[uint64]int)
+	lastSample := make(map[uint64]int)
+	for i, sample := range samples {
+		for pos := 0; pos+zstdDictDmerSize <= len(sample); pos++ {
+			dmer := dmerAt(sample, pos)
+			if last, ok := lastSample[dmer]; ok && last == i {
+				continue
+			}
+			lastSample[dmer] = i
+			freqs[dmer]++
+		}
+	}
+
+	// dmers in only one sample are not useful
+	score := func(segment []byte) (ret int) {
+		var seen [zstdDictSegmentSize]uint64
+		n := 0
+	loop:
+		for pos := 0; pos+zstdDictDmerSize <= len(segment); pos++ {
+			dmer := dmerAt(segment, pos)
+			for _, d := range seen[:n] {
+				if d == dmer {
+					continue loop
+				}
+			}
+			seen[n] = dmer
+			n++
+			if f := freqs[dmer]; f > 1 {
+				ret += f - 1
+			}
+		}
+		return
+	}
+
+	var candidates zstdDictCandidates
+	for i, sample := range samples {
+		for pos := 0; pos+zstdDictSegmentSize <= len(sample); pos++ {
+			s := score(sample[pos : pos+zstdDictSegmentSize])
+			if s > 0 {
+				candidates = append(candidates, zstdDictCandidate{
+					sample: i,
+					pos:    pos,
+					score:  s,
+				})
+			}
+		}
+	}
+	heap.Init(&candidates)
+
+	// lazy greedy selection, scores only decrease when dmers are covered
+	var segments [][]byte
+	size := 0
+	for candidates.Len() > 0 && size+zstdDictSegmentSize <= maxSize {
+		candidate := heap.Pop(&candidates).(zstdDictCandidate)
+		segment := samples[candidate.sample][candidate.pos : candidate.pos+zstdDictSegmentSize]
+		s := score(segment)
+		if s == 0 {
+			continue
+		}
+		if candidates.Len() > 0 && s < candidates[0].score {
+			candidate.score = s
+			heap.Push(&candidates, candidate)
+			continue
+		}
+		segments = append(segments, segment)
+		size += len(segment)
+		for pos := 0; pos+zstdDictDmerSize <= len(segment); pos++ {
+			delete(freqs, dmerAt(segment, pos))
+		}
+	}
+
+	dict := make([]byte, 0, size)
+	for i := len(segments) - 1; i >= 0; i-- {
+		dict = append(dict, segments[i]...)
+	}
+	return dict
+}
+
+func dmerAt(bs []byte, pos int) (ret uint64) {
+	for _, b := range bs[pos : pos+zstdDictDmerSize] {
+		ret = ret<<8 | uint64(b)
+	}
+	return
+}
+
+type zstdDictCandidate struct {
+	sample int
+	pos    int
+	score  int
+}
+
+type zstdDictCandidates []zstdDictCandidate
+
+var _ heap.Interface = new(zstdDictCandidates)
+
+func (c zstdDictCandidates) Len() int {
+	return len(c)
+}
+
+func (c zstdDictCandidates) Less(i, j int) bool {
+	return c[i].score > c[j].score
+}
+
+func (c zstdDictCandidates) Swap(i, j int) {
+	c[i], c[j] = c[j], c[i]
+}
+
+func (c *zstdDictCandidates) Push(x any) {
+	*c = append(*c, x.(zstdDictCandidate))
+}
+
+func (c *zstdDictCandidates) Pop() any {
+	old := *c
+	ret := old[len(old)-1]
+	*c = old[:len(old)-1]
+	return ret
+}
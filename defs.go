@@ -15,17 +15,24 @@ import (
 	"github.com/reusee/june/keyset"
 	"github.com/reusee/june/naming"
 	"github.com/reusee/june/store"
+	"github.com/reusee/june/storebolt"
 	"github.com/reusee/june/storedisk"
+	"github.com/reusee/june/storehashsharded"
 	"github.com/reusee/june/storekv"
+	"github.com/reusee/june/storelimit"
 	"github.com/reusee/june/storemem"
+	"github.com/reusee/june/storemirror"
 	"github.com/reusee/june/storemonotree"
 	"github.com/reusee/june/storenssharded"
 	"github.com/reusee/june/storeonedrive"
+	"github.com/reusee/june/storepack"
 	"github.com/reusee/june/storepebble"
 	"github.com/reusee/june/stores3"
+	"github.com/reusee/june/storesftp"
 	"github.com/reusee/june/storesqlite"
 	"github.com/reusee/june/storestacked"
 	"github.com/reusee/june/storetap"
+	"github.com/reusee/june/storewebdav"
 	"github.com/reusee/june/sys"
 	"github.com/reusee/june/tx"
 	"github.com/reusee/june/vars"
@@ -42,17 +49,24 @@ var Defs = dscope.Methods(
 	keyset.Def{},
 	naming.Def{},
 	store.Def{},
+	storebolt.Def{},
 	storedisk.Def{},
+	storehashsharded.Def{},
 	storekv.Def{},
+	storelimit.Def{},
 	storemem.Def{},
+	storemirror.Def{},
 	storemonotree.Def{},
 	storenssharded.Def{},
 	storeonedrive.Def{},
+	storepack.Def{},
 	storepebble.Def{},
 	stores3.Def{},
+	storesftp.Def{},
 	storesqlite.Def{},
 	storestacked.Def{},
 	storetap.Def{},
+	storewebdav.Def{},
 	sys.Def{},
 	tx.Def{},
 	vars.Def{},
@@ -26,6 +26,7 @@ type (
 	WriteOption   = store.WriteOption
 	Order         = index.Order
 	Store         = store.Store
+	Trasher       = store.Trasher
 	Scope         = dscope.Scope
 	IndexEntry    = index.Entry
 	IndexPreEntry = index.PreEntry
@@ -60,7 +61,12 @@ var (
 	NewEntry      = index.NewEntry
 	MatchEntry    = index.MatchEntry
 	MatchPreEntry = index.MatchPreEntry
+	ExistsMany    = store.ExistsMany
+	trash         = store.Trash
+	Break         = store.Break
 
 	ErrKeyNotFound = store.ErrKeyNotFound
 	ErrKeyNotMatch = store.ErrKeyNotMatch
+
+	ErrTrashNotSupported = store.ErrTrashNotSupported
 )
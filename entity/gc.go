@@ -54,6 +54,7 @@ func (Def) GC(
 		var tapIter TapIterKey
 		var tapDead TapDeadObjects
 		var tapSweep TapSweepDeadObject
+		var useTrash UseTrash
 
 		for _, option := range options {
 			switch option := option.(type) {
@@ -67,11 +68,24 @@ func (Def) GC(
 				tapDead = option
 			case TapSweepDeadObject:
 				tapSweep = option
+			case UseTrash:
+				useTrash = option
 			default:
 				panic(fmt.Errorf("unknown option: %T", option))
 			}
 		}
 
+		deleteKeys := store.Delete
+		if useTrash {
+			trasher, ok := store.(Trasher)
+			if !ok {
+				return we(ErrTrashNotSupported)
+			}
+			// check support before deleting anything
+			ce(trasher.Trash(nil))
+			deleteKeys = trasher.Trash
+		}
+
 		// mark
 		var reachable sync.Map // Key: struct{}
 
@@ -173,10 +187,10 @@ func (Def) GC(
 			defer he(&err)
 
 			if obj.Key.Namespace == NSSummary {
-				ce(deleteSummary(ctx, obj.Summary, obj.Key))
+				ce(deleteSummary(ctx, obj.Summary, obj.Key, useTrash))
 			} else {
 				if len(batchKeys[proc]) > 500 {
-					ce(store.Delete(batchKeys[proc]))
+					ce(deleteKeys(batchKeys[proc]))
 					batchKeys[proc] = batchKeys[proc][:0]
 				}
 				batchKeys[proc] = append(batchKeys[proc], obj.Key)
@@ -191,7 +205,7 @@ func (Def) GC(
 		}
 		ce(wait(true))
 		for _, keys := range batchKeys {
-			ce(store.Delete(keys))
+			ce(deleteKeys(keys))
 		}
 
 		return nil
@@ -31,6 +31,14 @@ type TapSweepDeadObject func(DeadObject)
 
 func (TapSweepDeadObject) IsGCOption() {}
 
+// UseTrash moves objects to store trash instead of deleting them, store must implement store.Trasher.
+// Trashed objects can be restored by store.Trasher.Restore, and indexes of restored summaries rebuilt by UpdateIndex
+type UseTrash bool
+
+func (UseTrash) IsGCOption() {}
+
+func (UseTrash) IsDeleteSummaryOption() {}
+
 type TapSummary func(*Summary)
 
 func (TapSummary) IsSaveOption() {}
@@ -87,19 +87,50 @@ func (Def) Push(
 		}
 		toScope := scope.Fork(toDecls...)
 
-		// func to check summary key existence
-		var keyExisted func(summaryKey Key) (bool, error)
+		// func to check summary keys existence
+		var keysExisted func(summaryKeys []Key) ([]bool, error)
 
-		if toIndex == nil {
+		if toIndex == nil && len(keys) > 0 {
+			// check pushing keys only, in batches
+			var existed sync.Map
+			keysExisted = func(summaryKeys []Key) (_ []bool, err error) {
+				defer he(&err)
+				ret := make([]bool, len(summaryKeys))
+				var unknown []Key
+				var unknownIndexes []int
+				for i, key := range summaryKeys {
+					if v, ok := existed.Load(key); ok {
+						ret[i] = v.(bool)
+						continue
+					}
+					unknown = append(unknown, key)
+					unknownIndexes = append(unknownIndexes, i)
+				}
+				if len(unknown) == 0 {
+					return ret, nil
+				}
+				oks, err := ExistsMany(to, unknown)
+				ce(err)
+				for i, ok := range oks {
+					existed.Store(unknown[i], ok)
+					ret[unknownIndexes[i]] = ok
+				}
+				return ret, nil
+			}
+
+		} else if toIndex == nil {
 			// iterate keys
 			keySet := make(map[Key]struct{})
 			ce(to.IterKeys(NSSummary, func(key Key) error {
 				keySet[key] = struct{}{}
 				return nil
 			}))
-			keyExisted = func(key Key) (bool, error) {
-				_, ok := keySet[key]
-				return ok, nil
+			keysExisted = func(summaryKeys []Key) ([]bool, error) {
+				ret := make([]bool, len(summaryKeys))
+				for i, key := range summaryKeys {
+					_, ret[i] = keySet[key]
+				}
+				return ret, nil
 			}
 
 		} else {
@@ -114,9 +145,12 @@ func (Def) Push(
 					keySet[summaryKey] = struct{}{}
 				}),
 			))
-			keyExisted = func(key Key) (bool, error) {
-				_, ok := keySet[key]
-				return ok, nil
+			keysExisted = func(summaryKeys []Key) ([]bool, error) {
+				ret := make([]bool, len(summaryKeys))
+				for i, key := range summaryKeys {
+					_, ret[i] = keySet[key]
+				}
+				return ret, nil
 			}
 		}
 
@@ -221,9 +255,9 @@ func (Def) Push(
 				defer he(&err)
 
 				// check to store existence
-				existed, err := keyExisted(summaryKey)
+				existed, err := keysExisted([]Key{summaryKey})
 				ce(err)
-				if existed {
+				if existed[0] {
 					put(cont)
 					return nil
 				}
@@ -285,6 +319,9 @@ func (Def) Push(
 							))
 						}
 					}
+					// check existence in one batch
+					_, err = keysExisted(referedSummaryKeys)
+					ce(err)
 					n = int64(len(referedSummaryKeys))
 					for _, sKey := range referedSummaryKeys {
 						put(check(sKey, done))
@@ -301,10 +338,24 @@ func (Def) Push(
 		}
 
 		// push
-		iterKeys(func(summaryKey Key) (err error) {
-			put(check(summaryKey, nil))
-			return nil
-		})
+		if len(keys) > 0 {
+			var summaryKeys []Key
+			iterKeys(func(summaryKey Key) (err error) {
+				summaryKeys = append(summaryKeys, summaryKey)
+				return nil
+			})
+			// check existence in one batch
+			_, err = keysExisted(summaryKeys)
+			ce(err)
+			for _, summaryKey := range summaryKeys {
+				put(check(summaryKey, nil))
+			}
+		} else {
+			iterKeys(func(summaryKey Key) (err error) {
+				put(check(summaryKey, nil))
+				return nil
+			})
+		}
 
 		ce(wait(false))
 		ce(wait(true))
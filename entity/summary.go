@@ -409,10 +409,15 @@ type DeleteSummary func(
 	ctx context.Context,
 	summary *Summary,
 	summaryKey Key,
+	options ...DeleteSummaryOption,
 ) (
 	err error,
 )
 
+type DeleteSummaryOption interface {
+	IsDeleteSummaryOption()
+}
+
 func (_ Def) DeleteSummary(
 	store store.Store,
 	index Index,
@@ -423,11 +428,22 @@ func (_ Def) DeleteSummary(
 		ctx context.Context,
 		summary *Summary,
 		summaryKey Key,
+		options ...DeleteSummaryOption,
 	) (
 		err error,
 	) {
 		defer he(&err)
 
+		var useTrash UseTrash
+		for _, option := range options {
+			switch option := option.(type) {
+			case UseTrash:
+				useTrash = option
+			default:
+				panic(fmt.Errorf("unknown option: %T", option))
+			}
+		}
+
 		unlock := locks.Lock(summary.Key)
 		defer unlock()
 
@@ -441,7 +457,11 @@ func (_ Def) DeleteSummary(
 		}
 
 		// delete
-		ce(store.Delete([]Key{summaryKey}))
+		if useTrash {
+			ce(trash(store, []Key{summaryKey}))
+		} else {
+			ce(store.Delete([]Key{summaryKey}))
+		}
 
 		return
 	}
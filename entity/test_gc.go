@@ -10,10 +10,13 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/reusee/e5"
 	"github.com/reusee/june/index"
 	"github.com/reusee/june/store"
+	"github.com/reusee/june/storedisk"
+	"github.com/reusee/june/storekv"
 	"github.com/reusee/june/storemem"
 	"github.com/reusee/pr2"
 )
@@ -223,3 +226,134 @@ func TestGCWithEmptyIndex(
 	})
 
 }
+
+func TestGCTrash(
+	t *testing.T,
+	newDisk storedisk.New,
+	newKV storekv.New,
+	newMemStore storemem.New,
+	scope Scope,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	disk, err := newDisk(wg, t.TempDir())
+	ce(err)
+	kv, err := newKV(wg, disk, "foo")
+	ce(err)
+	indexManager := newMemStore(wg)
+
+	scope.Fork(
+		func() store.Store {
+			return kv
+		},
+		func() index.IndexManager {
+			return indexManager
+		},
+	).Call(func(
+		saveEntity SaveEntity,
+		gc GC,
+		checkRef CheckRef,
+		updateIndex UpdateIndex,
+		store store.Store,
+	) {
+
+		type Foo struct {
+			Content any
+		}
+		live, err := saveEntity(wg, Foo{
+			Content: "live",
+		})
+		ce(err)
+		dead, err := saveEntity(wg, Foo{
+			Content: "dead",
+		})
+		ce(err)
+		root, err := saveEntity(wg, Foo{
+			Content: live.Key,
+		})
+		ce(err)
+
+		var sweeped []Key
+		ce(gc(
+			wg,
+			[]Key{root.Key},
+			UseTrash(true),
+			TapSweepDeadObject(func(obj DeadObject) {
+				sweeped = append(sweeped, obj.Key)
+			}),
+		))
+		if len(sweeped) == 0 {
+			t.Fatal()
+		}
+		ok, err := store.Exists(dead.Key)
+		ce(err)
+		if ok {
+			t.Fatal()
+		}
+
+		// trashed
+		trasher := store.(Trasher)
+		trashed := make(map[Key]bool)
+		ce(trasher.IterTrash(func(key Key, _ time.Time) error {
+			trashed[key] = true
+			return nil
+		}))
+		for _, key := range sweeped {
+			if !trashed[key] {
+				t.Fatalf("not in trash: %v", key)
+			}
+		}
+		if !trashed[dead.Key] {
+			t.Fatal()
+		}
+
+		// undo
+		var keys []Key
+		for key := range trashed {
+			keys = append(keys, key)
+		}
+		ce(trasher.Restore(keys))
+		_, err = updateIndex(wg)
+		ce(err)
+		ok, err = store.Exists(dead.Key)
+		ce(err)
+		if !ok {
+			t.Fatal()
+		}
+		ce(checkRef(wg))
+
+		// sweep again
+		n := 0
+		ce(gc(
+			wg,
+			[]Key{root.Key},
+			UseTrash(true),
+			TapSweepDeadObject(func(_ DeadObject) {
+				n++
+			}),
+		))
+		if n != len(sweeped) {
+			t.Fatalf("got %d, expected %d", n, len(sweeped))
+		}
+	})
+
+	// not supported
+	memKV, err := newKV(wg, newMemStore(wg), "foo")
+	ce(err)
+	scope.Fork(
+		func() store.Store {
+			return memKV
+		},
+	).Call(func(
+		gc GC,
+		saveEntity SaveEntity,
+	) {
+		res, err := saveEntity(wg, 42)
+		ce(err)
+		err = gc(wg, []Key{res.Key}, UseTrash(true))
+		if !is(err, ErrTrashNotSupported) {
+			t.Fatalf("got %v", err)
+		}
+	})
+}
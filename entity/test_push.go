@@ -192,6 +192,51 @@ func TestPush(
 
 		}
 
+		// index manager not specified, keys specified
+		{
+			mem2 := newMem(wg)
+			store2, err := newKV(wg, mem2, "foo")
+			ce(err)
+
+			var numSave int64
+			err = push(
+				wg,
+				store2, nil,
+				[]Key{key},
+				TapPushSave(func(_ Key, _ *Summary) {
+					atomic.AddInt64(&numSave, 1)
+				}),
+			)
+			ce(err)
+			if numSave != 3 {
+				t.Fatal()
+			}
+
+			// all existed
+			numSave = 0
+			err = push(
+				wg,
+				store2, nil,
+				[]Key{key},
+				TapPushSave(func(_ Key, _ *Summary) {
+					atomic.AddInt64(&numSave, 1)
+				}),
+			)
+			ce(err)
+			if numSave != 0 {
+				t.Fatal()
+			}
+
+			scope.Fork(func() Store {
+				return store2
+			}).Call(func(
+				checkRef CheckRef,
+			) {
+				ce(checkRef(wg))
+			})
+
+		}
+
 		// ignore
 		{
 			mem2 := newMem(wg)
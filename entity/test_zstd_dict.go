@@ -0,0 +1,161 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package entity
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/codec"
+	"github.com/reusee/june/index"
+	"github.com/reusee/june/store"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/june/storemem"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+func TestZstdDict(
+	t *testing.T,
+	newKV storekv.New,
+	newMemStore storemem.New,
+	scope Scope,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	mem := newMemStore(wg)
+	indexManager := newMemStore(wg)
+
+	type Foo struct {
+		Name string
+		Size int64
+		Tags []string
+	}
+
+	// stores sharing the same kv, with different current dictionaries
+	withStore := func(current codec.ZstdDict, fn any) {
+		var load LoadZstdDict
+		c, err := codec.ZstdDictCodec(current, func(id []byte) ([]byte, error) {
+			return load(id)
+		})
+		ce(err)
+		kv, err := newKV(wg, mem, "foo", storekv.WithCodec(c))
+		ce(err)
+		s := scope.Fork(
+			func() store.Store {
+				return kv
+			},
+			func() index.IndexManager {
+				return indexManager
+			},
+		)
+		s.Assign(&load)
+		s.Call(fn)
+	}
+
+	n := 0
+	save := func(saveEntity SaveEntity) {
+		for i := 0; i < 256; i++ {
+			_, err := saveEntity(wg, Foo{
+				Name: fmt.Sprintf("foo-%d", n),
+				Size: int64(n * 42),
+				Tags: []string{"foo", "bar"},
+			})
+			ce(err)
+			n++
+		}
+	}
+
+	// train from values without dictionary
+	var dict1 codec.ZstdDict
+	withStore(codec.ZstdDict{}, func(
+		saveEntity SaveEntity,
+		train TrainZstdDict,
+	) {
+		save(saveEntity)
+		var err error
+		dict1, err = train(wg, ZstdDictMaxSamples(128), ZstdDictSize(4096))
+		ce(err)
+		if len(dict1.Content) == 0 || len(dict1.Content) > 4096 {
+			t.Fatalf("got %d", len(dict1.Content))
+		}
+	})
+
+	// retrain
+	var dict2 codec.ZstdDict
+	withStore(dict1, func(
+		saveEntity SaveEntity,
+		train TrainZstdDict,
+		store store.Store,
+	) {
+		save(saveEntity)
+		var err error
+		dict2, err = train(wg)
+		ce(err)
+		if bytes.Equal(dict1.ID, dict2.ID) {
+			t.Fatal()
+		}
+		var dictKeys []Key
+		ce(store.IterKeys(NSZstdDict, func(key Key) error {
+			dictKeys = append(dictKeys, key)
+			return nil
+		}))
+		if len(dictKeys) != 2 {
+			t.Fatalf("got %d", len(dictKeys))
+		}
+	})
+
+	// values encoded with previous dictionaries
+	withStore(dict2, func(
+		store store.Store,
+		fetch Fetch,
+		load LoadZstdDict,
+	) {
+		c := 0
+		ce(store.IterKeys(NSEntity, func(key Key) error {
+			var foo Foo
+			ce(fetch(key, &foo))
+			c++
+			return nil
+		}))
+		if c != n {
+			t.Fatalf("got %d", c)
+		}
+
+		content, err := load(dict1.ID)
+		ce(err)
+		if !bytes.Equal(content, dict1.Content) {
+			t.Fatal()
+		}
+		_, err = load([]byte("foo"))
+		if !is(err, codec.ErrZstdDictNotFound) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	// not decodable without dictionaries
+	c, err := codec.ZstdDictCodec(codec.ZstdDict{}, nil)
+	ce(err)
+	kv, err := newKV(wg, mem, "foo", storekv.WithCodec(c))
+	ce(err)
+	var failed bool
+	ce(kv.IterKeys(NSEntity, func(key Key) error {
+		err := kv.Read(key, func(s sb.Stream) error {
+			return sb.Copy(s, sb.Discard)
+		})
+		if is(err, codec.ErrZstdDictNotFound) {
+			failed = true
+			return Break
+		}
+		ce(err)
+		return nil
+	}))
+	if !failed {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package entity
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/codec"
+	"github.com/reusee/june/key"
+	"github.com/reusee/sb"
+)
+
+var NSZstdDict = key.Namespace{'c', '-', 'z', 'd', 'i', 'c', 't'}
+
+// zstdDictIDLen is the length of key hash prefix used as dictionary id in codec headers
+const zstdDictIDLen = 8
+
+// TrainZstdDict trains a zstd dictionary from sampled objects of the store, and saves it as an object of NSZstdDict.
+// The returned dictionary is for codec.ZstdDictCodec, its id is the key hash prefix of the saved object.
+// Calling again retrains, values encoded with previous dictionaries are decodable by LoadZstdDict
+type TrainZstdDict func(
+	ctx context.Context,
+	options ...TrainZstdDictOption,
+) (
+	dict codec.ZstdDict,
+	err error,
+)
+
+type TrainZstdDictOption interface {
+	IsTrainZstdDictOption()
+}
+
+// ZstdDictMaxSamples is the max number of objects sampled
+type ZstdDictMaxSamples int
+
+func (ZstdDictMaxSamples) IsTrainZstdDictOption() {}
+
+// ZstdDictSize is the max size of dictionary
+type ZstdDictSize int
+
+func (ZstdDictSize) IsTrainZstdDictOption() {}
+
+const (
+	defaultZstdDictMaxSamples = 4096
+	defaultZstdDictSize       = 64 * 1024
+
+	// larger objects compress well without dictionary
+	zstdDictMaxSampleLen = 16 * 1024
+)
+
+// LoadZstdDict loads dictionary content by id from objects of NSZstdDict
+type LoadZstdDict func(id []byte) ([]byte, error)
+
+func (Def) TrainZstdDict(
+	store Store,
+) TrainZstdDict {
+	return func(
+		ctx context.Context,
+		options ...TrainZstdDictOption,
+	) (dict codec.ZstdDict, err error) {
+		defer he(&err)
+
+		maxSamples := defaultZstdDictMaxSamples
+		dictSize := defaultZstdDictSize
+		for _, option := range options {
+			switch option := option.(type) {
+			case ZstdDictMaxSamples:
+				maxSamples = int(option)
+			case ZstdDictSize:
+				dictSize = int(option)
+			default:
+				panic(fmt.Errorf("unknown option: %T", option))
+			}
+		}
+
+		// reservoir sampling
+		var keys []Key
+		n := 0
+		ce(store.IterAllKeys(func(key Key) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			if key.Namespace == NSZstdDict {
+				return nil
+			}
+			n++
+			if len(keys) < maxSamples {
+				keys = append(keys, key)
+			} else if i := rand.Intn(n); i < maxSamples {
+				keys[i] = key
+			}
+			return nil
+		}))
+
+		// encoded the same way as codecs see
+		var samples [][]byte
+		for _, key := range keys {
+			buf := new(bytes.Buffer)
+			ce(store.Read(key, func(s sb.Stream) error {
+				return sb.Copy(s, sb.Encode(buf))
+			}), e5.Info("read %s", key))
+			if buf.Len() > zstdDictMaxSampleLen {
+				continue
+			}
+			samples = append(samples, buf.Bytes())
+		}
+		if len(samples) == 0 {
+			return dict, we(fmt.Errorf("no sample"))
+		}
+
+		content := codec.TrainZstdDict(samples, dictSize)
+		if len(content) == 0 {
+			return dict, we(fmt.Errorf("no common content in %d samples", len(samples)))
+		}
+		res, err := store.Write(NSZstdDict, sb.Marshal(content))
+		ce(err)
+
+		return codec.ZstdDict{
+			ID:      res.Key.Hash[:zstdDictIDLen],
+			Content: content,
+		}, nil
+	}
+}
+
+func (Def) LoadZstdDict(
+	store Store,
+) LoadZstdDict {
+	return func(id []byte) (content []byte, err error) {
+		defer he(&err, e5.Info("dictionary id: %x", id))
+		found := false
+		ce(store.IterKeys(NSZstdDict, func(key Key) error {
+			if !bytes.Equal(key.Hash[:zstdDictIDLen], id) {
+				return nil
+			}
+			found = true
+			if err := store.Read(key, func(s sb.Stream) error {
+				return sb.Copy(s, sb.Unmarshal(&content))
+			}); err != nil {
+				return err
+			}
+			return Break
+		}))
+		if !found {
+			return nil, we(codec.ErrZstdDictNotFound)
+		}
+		return content, nil
+	}
+}
@@ -10,10 +10,17 @@ import (
 
 	"github.com/reusee/e5"
 	"github.com/reusee/june/entity"
+	"github.com/reusee/june/store"
 )
 
 type ContentReader struct {
 	fetch entity.Fetch
+	store store.Store
+
+	// pending range of a partially read chunk
+	rangeKey    *Key
+	rangeOffset int64
+	rangeEnd    int64
 
 	remainBytes []byte
 	keys        []Key
@@ -30,8 +37,12 @@ type NewContentReader func(
 	lengths []int64,
 ) *ContentReader
 
+// max bytes fetched by one ranged read after seeking into a chunk
+const contentRangeWindow = 64 * 1024
+
 func (_ Def) NewContentReader(
 	fetch entity.Fetch,
+	store store.Store,
 ) NewContentReader {
 	return func(
 		keys []Key,
@@ -39,6 +50,7 @@ func (_ Def) NewContentReader(
 	) *ContentReader {
 		r := &ContentReader{
 			fetch:   fetch,
+			store:   store,
 			keys:    keys,
 			lengths: make([]*int64, len(keys)),
 		}
@@ -61,6 +73,12 @@ func (c *ContentReader) Read(buf []byte) (_ int, err error) {
 		return n, nil
 	}
 
+	if c.rangeKey != nil {
+		err := c.readRange(len(buf))
+		ce(err)
+		return c.Read(buf)
+	}
+
 	if c.keyIndex >= len(c.keys) {
 		return 0, io.EOF
 	}
@@ -76,6 +94,37 @@ func (c *ContentReader) Read(buf []byte) (_ int, err error) {
 	return c.Read(buf)
 }
 
+func (c *ContentReader) readRange(size int) (err error) {
+	defer he(&err)
+	key := *c.rangeKey
+	if size < contentRangeWindow {
+		size = contentRangeWindow
+	}
+	length := c.rangeEnd - c.rangeOffset
+	if length > int64(size) {
+		length = int64(size)
+	}
+	err = c.store.(store.RangeReader).ReadRange(key, c.rangeOffset, length, func(bs []byte) error {
+		c.remainBytes = append(c.remainBytes[:0], bs...)
+		return nil
+	})
+	if is(err, store.ErrRangeNotSupported) {
+		// fetch whole content
+		var content Content
+		ce(c.fetch(key, &content),
+			e5.Info("fetch %s", key))
+		c.remainBytes = content[c.rangeOffset:]
+		c.rangeKey = nil
+		return nil
+	}
+	ce(err, e5.Info("read range %s", key))
+	c.rangeOffset += int64(len(c.remainBytes))
+	if c.rangeOffset >= c.rangeEnd || len(c.remainBytes) == 0 {
+		c.rangeKey = nil
+	}
+	return nil
+}
+
 func (c *ContentReader) getLen() (ret int64) {
 	for i, p := range c.lengths {
 		if p == nil {
@@ -107,6 +156,7 @@ func (c *ContentReader) Seek(offset int64, whence int) (_ int64, err error) {
 	}
 
 	c.remainBytes = c.remainBytes[:0]
+	c.rangeKey = nil
 	c.keyIndex = 0
 	c.offset = 0
 	for i, p := range c.lengths {
@@ -133,6 +183,14 @@ func (c *ContentReader) Seek(offset int64, whence int) (_ int64, err error) {
 			l := *p
 			if offset <= c.offset+l {
 				key := c.keys[i]
+				if _, ok := c.store.(store.RangeReader); ok {
+					// defer fetching to Read, only needed bytes will be read
+					c.rangeKey = &key
+					c.rangeOffset = offset - c.offset
+					c.rangeEnd = l
+					c.offset = offset
+					return c.offset, nil
+				}
 				var content Content
 				ce(c.fetch(key, &content),
 					e5.Info("fetch %s", key))
@@ -45,12 +45,13 @@ func main() {
 		return
 	}
 
-	var testingTType types.Type
+	var testingTType, testingBType types.Type
 	packages.Visit(pkgs, func(pkg *packages.Package) bool {
 		if pkg.Name != "testing" {
 			return true
 		}
 		testingTType = types.NewPointer(pkg.Types.Scope().Lookup("T").Type())
+		testingBType = types.NewPointer(pkg.Types.Scope().Lookup("B").Type())
 		return false
 	}, nil)
 	if testingTType == nil {
@@ -62,6 +63,7 @@ func main() {
 		PkgName string
 		PkgPath string
 		OS      string
+		IsBench bool
 	}
 
 	funcsByOS := make(map[string][]Func)
@@ -76,7 +78,14 @@ func main() {
 			if !ok {
 				continue
 			}
-			if !strings.HasPrefix(fn.Name(), "Test") {
+			var argType types.Type
+			isBench := false
+			if strings.HasPrefix(fn.Name(), "Test") {
+				argType = testingTType
+			} else if strings.HasPrefix(fn.Name(), "Benchmark") {
+				argType = testingBType
+				isBench = true
+			} else {
 				continue
 			}
 			signature := fn.Type().(*types.Signature)
@@ -85,7 +94,7 @@ func main() {
 				continue
 			}
 			p1 := params.At(0)
-			if !types.Identical(p1.Type(), testingTType) {
+			if !types.Identical(p1.Type(), argType) {
 				continue
 			}
 
@@ -104,6 +113,7 @@ func main() {
 					PkgName: pkg.Name,
 					PkgPath: pkg.PkgPath,
 					OS:      os,
+					IsBench: isBench,
 				},
 			)
 		}
@@ -114,6 +124,9 @@ func main() {
 		sort.Slice(fns, func(i, j int) bool {
 			f1 := fns[i]
 			f2 := fns[j]
+			if f1.IsBench != f2.IsBench {
+				return f2.IsBench
+			}
 			if f1.PkgPath != f2.PkgPath {
 				return f1.PkgPath < f2.PkgPath
 			}
@@ -144,6 +157,15 @@ func main() {
 		ce(err)
 
 		for _, fn := range fns {
+			if fn.IsBench {
+				_, err = buf.WriteString(`
+		func Benchmark_` + fn.PkgName + `_` + fn.Name + `(b *testing.B) {
+		  runBench(b, ` + fn.PkgName + "." + fn.Name + `)
+		}
+		    `)
+				ce(err)
+				continue
+			}
 			_, err = buf.WriteString(`
 		func Test_` + fn.PkgName + `_` + fn.Name + `(t *testing.T) {
 		  t.Parallel()
@@ -30,9 +30,9 @@ require (
 	github.com/reusee/sb v0.0.0-20230401065353-d64e11ed6d5d
 	github.com/rogpeppe/go-internal v1.11.0 // indirect
 	github.com/rs/xid v1.4.0 // indirect
-	golang.org/x/crypto v0.31.0 // indirect
+	golang.org/x/crypto v0.31.0
 	golang.org/x/exp v0.0.0-20230321023759-10a507213a29 // indirect
-	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/net v0.25.0
 	golang.org/x/oauth2 v0.6.0
 	golang.org/x/sys v0.28.0
 	golang.org/x/text v0.21.0 // indirect
@@ -43,8 +43,10 @@ require (
 )
 
 require (
+	github.com/pkg/sftp v1.13.9
 	github.com/reusee/e5 v0.0.0-20230128094953-f2ff5c9c135a
 	github.com/reusee/pr2 v0.0.0-20230306155640-52a016ca8efe
+	go.etcd.io/bbolt v1.3.10
 )
 
 require (
@@ -58,6 +60,7 @@ require (
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/jbenet/go-context v0.0.0-20150711004518-d14ea06fba99 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
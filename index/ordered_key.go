@@ -0,0 +1,123 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/reusee/sb"
+)
+
+// OrderedKey encodes the stream to bytes that sort bytewise in the order of sb.Compare.
+// It is for storages without custom comparers, where sb encoding can not be used as index keys.
+// The encoding is not decodable, values of index keys should be stored separately
+func OrderedKey(stream sb.Stream) (ret []byte, err error) {
+	defer he(&err)
+	for {
+		token, err := stream.Next()
+		ce(err)
+		if token == nil {
+			break
+		}
+		ret, err = appendOrderedToken(ret, token)
+		ce(err)
+	}
+	return
+}
+
+func appendOrderedToken(buf []byte, token *sb.Token) ([]byte, error) {
+	buf = append(buf, byte(token.Kind))
+
+	switch token.Kind {
+
+	case sb.KindBool:
+		if token.Value.(bool) {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+
+	case sb.KindInt, sb.KindInt64:
+		buf = appendSigned(buf, reflect.ValueOf(token.Value).Int(), 8)
+	case sb.KindInt8:
+		buf = appendSigned(buf, reflect.ValueOf(token.Value).Int(), 1)
+	case sb.KindInt16:
+		buf = appendSigned(buf, reflect.ValueOf(token.Value).Int(), 2)
+	case sb.KindInt32:
+		buf = appendSigned(buf, reflect.ValueOf(token.Value).Int(), 4)
+
+	case sb.KindUint, sb.KindUint64, sb.KindPointer:
+		buf = appendUnsigned(buf, reflect.ValueOf(token.Value).Uint(), 8)
+	case sb.KindUint8:
+		buf = appendUnsigned(buf, reflect.ValueOf(token.Value).Uint(), 1)
+	case sb.KindUint16:
+		buf = appendUnsigned(buf, reflect.ValueOf(token.Value).Uint(), 2)
+	case sb.KindUint32:
+		buf = appendUnsigned(buf, reflect.ValueOf(token.Value).Uint(), 4)
+
+	case sb.KindFloat32:
+		bits := uint64(math.Float32bits(token.Value.(float32)))
+		buf = appendUnsigned(buf, orderedFloatBits(bits, 32), 4)
+	case sb.KindFloat64:
+		bits := math.Float64bits(token.Value.(float64))
+		buf = appendUnsigned(buf, orderedFloatBits(bits, 64), 8)
+
+	case sb.KindString, sb.KindTypeName, sb.KindLiteral,
+		sb.KindBytes, sb.KindRef:
+		var bs []byte
+		switch v := token.Value.(type) {
+		case string:
+			bs = []byte(v)
+		case []byte:
+			bs = v
+		default:
+			return nil, fmt.Errorf("bad token value: %T", token.Value)
+		}
+		// escape 0x00 as 0x00 0xff, terminated by 0x00 0x00
+		for _, b := range bs {
+			buf = append(buf, b)
+			if b == 0 {
+				buf = append(buf, 0xff)
+			}
+		}
+		buf = append(buf, 0, 0)
+
+	case sb.KindMin,
+		sb.KindArrayEnd, sb.KindObjectEnd, sb.KindMapEnd, sb.KindTupleEnd,
+		sb.KindNil, sb.KindNaN,
+		sb.KindArray, sb.KindObject, sb.KindMap, sb.KindTuple,
+		sb.KindMax:
+
+	default:
+		return nil, fmt.Errorf("bad token kind: %v", token.Kind)
+	}
+
+	return buf, nil
+}
+
+// appendUnsigned appends lowest width bytes of v in big endian
+func appendUnsigned(buf []byte, v uint64, width int) []byte {
+	var bs [8]byte
+	binary.BigEndian.PutUint64(bs[:], v)
+	return append(buf, bs[8-width:]...)
+}
+
+// appendSigned flips the sign bit, negative values sort before positive ones
+func appendSigned(buf []byte, v int64, width int) []byte {
+	return appendUnsigned(buf, uint64(v)^(1<<(width*8-1)), width)
+}
+
+// orderedFloatBits flips all bits of negative values and the sign bit of positive values
+func orderedFloatBits(bits uint64, size int) uint64 {
+	sign := uint64(1) << (size - 1)
+	if bits&sign != 0 {
+		mask := uint64(math.MaxUint64) >> (64 - size)
+		return ^bits & mask
+	}
+	return bits | sign
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/sb"
+)
+
+func TestOrderedKey(
+	t *testing.T,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	values := []any{
+		sb.Min, sb.Max, nil, true, false,
+		0, 1, -1, 256, -256, math.MaxInt64, math.MinInt64,
+		int8(-1), int8(1), int16(-300), int32(70000), int64(-1),
+		uint(1), uint8(255), uint16(1), uint32(1 << 20), uint64(math.MaxUint64),
+		float32(-1.5), float32(2), 0.0, -0.5, 1.5, math.Inf(1), math.Inf(-1),
+		"", "a", "ab", "a\x00", "a\x00b", "b", "\xff",
+		[]byte{}, []byte{0}, []byte{0, 0}, []byte{1},
+		[]int{}, []int{1}, []int{1, 2}, []int{-1},
+		sb.Tuple{"a", 1}, sb.Tuple{"a", -1}, sb.Tuple{"a"},
+	}
+	for i := 0; i < 100; i++ {
+		values = append(values, rand.Int63()-rand.Int63(), rand.Float64()-0.5)
+	}
+
+	type pair struct {
+		value any
+		key   []byte
+	}
+	var pairs []pair
+	for _, value := range values {
+		key, err := OrderedKey(sb.Marshal(value))
+		ce(err)
+		pairs = append(pairs, pair{value, key})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return bytes.Compare(pairs[i].key, pairs[j].key) < 0
+	})
+	for i := 1; i < len(pairs); i++ {
+		if res := sb.MustCompare(
+			sb.Marshal(pairs[i-1].value),
+			sb.Marshal(pairs[i].value),
+		); res > 0 {
+			t.Fatalf("%#v > %#v", pairs[i-1].value, pairs[i].value)
+		}
+	}
+}
@@ -169,6 +169,39 @@ func runTest(
 
 }
 
+// runBench calls fn with memory store and index
+func runBench(
+	b *testing.B,
+	fn any,
+) {
+	b.Helper()
+	wg := pr2.NewWaitGroup(context.Background())
+	defs := append(Defs[:0:0], Defs...)
+	defs = append(defs,
+		memStore,
+		memIndexManager,
+		func() *testing.B {
+			return b
+		},
+		func() vars.VarsSpec {
+			return func() (string, *pr2.WaitGroup) {
+				return b.TempDir(), wg
+			}
+		},
+		func() *pr2.WaitGroup {
+			return wg
+		},
+	)
+	scope := dscope.New(defs...).Fork(
+		func() sys.Testing {
+			return true
+		},
+	)
+	scope.Call(fn)
+	wg.Cancel()
+	wg.Wait()
+}
+
 var indexManagerDefs = []any{
 	// mem
 	0: memIndexManager,
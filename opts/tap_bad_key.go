@@ -7,3 +7,9 @@ package opts
 type TapBadKey func(Key)
 
 func (_ TapBadKey) IsScrubOption() {}
+
+func (_ TapBadKey) IsReplicateOption() {}
+
+func (_ TapBadKey) IsRepairOption() {}
+
+func (_ TapBadKey) IsRecodecOption() {}
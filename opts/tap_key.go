@@ -21,3 +21,7 @@ func (_ TapKey) IsSelectOption() {}
 func (_ TapKey) IsResaveOption() {}
 
 func (_ TapKey) IsSaveSummaryOption() {}
+
+func (_ TapKey) IsReplicateOption() {}
+
+func (_ TapKey) IsRecodecOption() {}
@@ -0,0 +1,62 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"github.com/reusee/sb"
+)
+
+// BatchStore is implemented by stores that can check or read many keys in fewer backend calls
+type BatchStore interface {
+	// ExistsMany returns existence of keys, in the same order
+	ExistsMany(
+		keys []Key,
+	) ([]bool, error)
+
+	// ReadMany calls fn for each key, in any order.
+	// Missing keys return ErrKeyNotFound
+	ReadMany(
+		keys []Key,
+		fn func(Key, sb.Stream) error,
+	) error
+}
+
+// ExistsMany checks existence of keys, using BatchStore if implemented
+func ExistsMany(
+	s Store,
+	keys []Key,
+) (_ []bool, err error) {
+	if b, ok := s.(BatchStore); ok {
+		return b.ExistsMany(keys)
+	}
+	defer he(&err)
+	ret := make([]bool, len(keys))
+	for i, key := range keys {
+		ok, err := s.Exists(key)
+		ce(err)
+		ret[i] = ok
+	}
+	return ret, nil
+}
+
+// ReadMany reads keys, using BatchStore if implemented
+func ReadMany(
+	s Store,
+	keys []Key,
+	fn func(Key, sb.Stream) error,
+) (err error) {
+	if b, ok := s.(BatchStore); ok {
+		return b.ReadMany(keys, fn)
+	}
+	defer he(&err)
+	for _, key := range keys {
+		key := key
+		err := s.Read(key, func(stream sb.Stream) error {
+			return fn(key, stream)
+		})
+		ce(err)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package store
+
+// Checkpoint persists progress of long running operations, see vars.NewCheckpoint
+type Checkpoint interface {
+	// Load unmarshals saved progress to target, returns false if nothing saved
+	Load(target any) (ok bool, err error)
+	Save(value any) error
+}
+
+type WithCheckpoint struct {
+	Checkpoint
+}
+
+func (WithCheckpoint) IsReplicateOption() {}
+
+func (WithCheckpoint) IsRecodecOption() {}
@@ -13,3 +13,5 @@ type TapWriteResult func(WriteResult)
 func (TapWriteResult) IsSaveOption() {}
 
 func (TapWriteResult) IsWriteOption() {}
+
+func (TapWriteResult) IsReplicateOption() {}
@@ -0,0 +1,96 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"errors"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/sb"
+)
+
+var ErrRangeNotSupported = errors.New("range read not supported")
+
+// RangeReader is implemented by stores that can read part of a bytes object without fetching the whole encoded value.
+// A bytes object is a single bytes token, or an array of bytes tokens whose values are concatenated, as filebase.Content.
+// Ranges past the end of the object are truncated.
+// Implementations may return ErrRangeNotSupported for objects they cannot read partially.
+// Ranged reads do not verify the object hash.
+type RangeReader interface {
+	ReadRange(
+		key Key,
+		offset int64,
+		length int64,
+		fn func([]byte) error,
+	) error
+}
+
+// ReadRange reads [offset, offset+length) of a bytes object, falling back to a full read if s cannot read ranges.
+func ReadRange(
+	s Store,
+	key Key,
+	offset int64,
+	length int64,
+	fn func([]byte) error,
+) error {
+	if r, ok := s.(RangeReader); ok {
+		err := r.ReadRange(key, offset, length, fn)
+		if !is(err, ErrRangeNotSupported) {
+			return err
+		}
+	}
+	return ReadRangeFull(s, key, offset, length, fn)
+}
+
+// ReadRangeFull reads the whole object and passes the requested range to fn.
+func ReadRangeFull(
+	s Store,
+	key Key,
+	offset int64,
+	length int64,
+	fn func([]byte) error,
+) (err error) {
+	defer he(&err, e5.With(key))
+	var data []byte
+	var depth int
+	err = s.Read(key, func(stream sb.Stream) (err error) {
+		defer he(&err)
+		for {
+			token, err := stream.Next()
+			ce(err)
+			if token == nil {
+				break
+			}
+			switch token.Kind {
+			case sb.KindBytes:
+				bs, _ := token.Value.([]byte)
+				data = append(data, bs...)
+			case sb.KindArray:
+				depth++
+				if depth > 1 {
+					return we(sb.BadTokenKind)
+				}
+			case sb.KindArrayEnd:
+				depth--
+			default:
+				return we(sb.BadTokenKind)
+			}
+		}
+		return nil
+	})
+	ce(err)
+	return fn(sliceRange(data, offset, length))
+}
+
+func sliceRange(data []byte, offset int64, length int64) []byte {
+	if offset >= int64(len(data)) || offset < 0 {
+		return nil
+	}
+	end := offset + length
+	if end > int64(len(data)) || length < 0 {
+		end = int64(len(data))
+	}
+	return data[offset:end]
+}
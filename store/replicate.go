@@ -0,0 +1,176 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/opts"
+	"github.com/reusee/june/sys"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+type ReplicateOption interface {
+	IsReplicateOption()
+}
+
+// ReplicateBatchSize is the number of keys checked and copied between checkpoints
+type ReplicateBatchSize int
+
+func (ReplicateBatchSize) IsReplicateOption() {}
+
+// Replicate copies keys missing in to from from.
+// Keys are copied in order, progress is saved to the checkpoint after each batch, and an interrupted run resumes from the last saved key.
+// Content read from from is verified before writing, and the key computed by to must match.
+// opts.TapKey is called for copied keys, TapWriteResult for writes, and opts.TapBadKey for keys not matching the content in from, which are skipped.
+type Replicate func(
+	ctx context.Context,
+	from Store,
+	to Store,
+	options ...ReplicateOption,
+) error
+
+type replicateProgress struct {
+	Last Key // keys not greater than Last are done
+}
+
+func (Def) Replicate(
+	newHashState key.NewHashState,
+	parallel sys.Parallel,
+) Replicate {
+
+	return func(
+		ctx context.Context,
+		from Store,
+		to Store,
+		options ...ReplicateOption,
+	) (err error) {
+		defer he(&err)
+
+		var tapKey opts.TapKey
+		var tapResult TapWriteResult
+		var tapBad opts.TapBadKey
+		var checkpoint Checkpoint
+		batchSize := 1024
+		for _, option := range options {
+			switch option := option.(type) {
+			case opts.TapKey:
+				tapKey = option
+			case TapWriteResult:
+				tapResult = option
+			case opts.TapBadKey:
+				tapBad = option
+			case WithCheckpoint:
+				checkpoint = option.Checkpoint
+			case ReplicateBatchSize:
+				batchSize = int(option)
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+
+		var progress replicateProgress
+		if checkpoint != nil {
+			_, err := checkpoint.Load(&progress)
+			ce(err)
+		}
+
+		var keys []Key
+		ce(from.IterAllKeys(func(key Key) error {
+			if key.Compare(progress.Last) > 0 {
+				keys = append(keys, key)
+			}
+			return nil
+		}))
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Compare(keys[j]) < 0
+		})
+
+		copyKey := func(key Key) (err error) {
+			defer he(&err, e5.With(key))
+			var tokens sb.Tokens
+			var sum []byte
+			err = from.Read(key, func(stream sb.Stream) error {
+				return sb.Copy(
+					stream,
+					sb.CollectTokens(&tokens),
+					sb.Hash(newHashState, &sum, nil),
+				)
+			})
+			if is(err, ErrKeyNotMatch) {
+				sum = nil
+			} else if is(err, ErrKeyNotFound) {
+				// deleted
+				return nil
+			} else {
+				ce(err)
+			}
+			if !bytes.Equal(sum, key.Hash[:]) {
+				if tapBad != nil {
+					tapBad(key)
+				}
+				return nil
+			}
+			res, err := to.Write(key.Namespace, tokens.Iter())
+			ce(err)
+			if res.Key != key {
+				return we.With(e5.With(res.Key))(ErrKeyNotMatch)
+			}
+			if tapKey != nil {
+				tapKey(key)
+			}
+			if tapResult != nil {
+				tapResult(res)
+			}
+			return nil
+		}
+
+		for len(keys) > 0 {
+			n := batchSize
+			if n > len(keys) {
+				n = len(keys)
+			}
+			batch := keys[:n]
+			keys = keys[n:]
+
+			oks, err := ExistsMany(to, batch)
+			ce(err)
+
+			wg := pr2.NewWaitGroup(ctx)
+			put, wait := pr2.Consume(wg, int(parallel), func(_ int, key Key) error {
+				return copyKey(key)
+			})
+			for i, key := range batch {
+				if oks[i] {
+					continue
+				}
+				put(key)
+			}
+			err = wait(true)
+			wg.Cancel()
+			ce(err)
+			// do not save progress of an interrupted batch
+			ce(ctx.Err())
+
+			if checkpoint != nil {
+				progress.Last = batch[len(batch)-1]
+				ce(checkpoint.Save(progress))
+			}
+		}
+
+		if checkpoint != nil {
+			// next run starts over
+			ce(checkpoint.Save(replicateProgress{}))
+		}
+
+		return nil
+	}
+}
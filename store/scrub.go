@@ -8,7 +8,9 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sync"
 
+	"github.com/reusee/e5"
 	"github.com/reusee/june/key"
 	"github.com/reusee/june/opts"
 	"github.com/reusee/june/sys"
@@ -20,6 +22,48 @@ type ScrubOption interface {
 	IsScrubOption()
 }
 
+// RepairFrom makes Scrub rewrite bad keys with good copies from the replicas.
+// Stores implementing Rewriter replace bad objects in place.
+// For other stores, good copies are written first, and bad objects are deleted and written again if the write is skipped for existing key
+type RepairFrom []Store
+
+func (RepairFrom) IsScrubOption() {}
+
+// Rewriter is implemented by stores that can replace stored objects, for repairing damaged ones
+type Rewriter interface {
+	Rewrite(ns key.Namespace, stream sb.Stream, options ...WriteOption) (WriteResult, error)
+}
+
+// Rewrite writes tokens to s, replacing the existing object of the same key.
+// If s is not a Rewriter, tokens are written first, and the existing object is deleted and written again if the write is skipped
+func Rewrite(s Store, ns key.Namespace, tokens sb.Tokens, options ...WriteOption) (res WriteResult, err error) {
+	defer he(&err)
+	if rewriter, ok := s.(Rewriter); ok {
+		return rewriter.Rewrite(ns, tokens.Iter(), options...)
+	}
+	// write first, the damaged object is kept if writing fails
+	res, err = s.Write(ns, tokens.Iter(), options...)
+	ce(err)
+	if !res.Written {
+		// skipped for existing key
+		ce(s.Delete([]Key{res.Key}))
+		res, err = s.Write(ns, tokens.Iter(), options...)
+		ce(err)
+	}
+	return
+}
+
+type ScrubReport struct {
+	Bad          []Key
+	Repaired     []Key
+	Unrepairable []Key // no good copy in replicas
+}
+
+// TapScrubReport is called after scrubbing with bad keys and repair results
+type TapScrubReport func(ScrubReport)
+
+func (TapScrubReport) IsScrubOption() {}
+
 type Scrub func(
 	ctx context.Context,
 	store Store,
@@ -40,17 +84,58 @@ func (Def) Scrub(
 
 		var tapKey opts.TapKey
 		var tapBad opts.TapBadKey
+		var tapReport TapScrubReport
+		var replicas []Store
 		for _, option := range options {
 			switch option := option.(type) {
 			case opts.TapKey:
 				tapKey = option
 			case opts.TapBadKey:
 				tapBad = option
+			case TapScrubReport:
+				tapReport = option
+			case RepairFrom:
+				replicas = append(replicas, option...)
 			default:
 				panic(fmt.Errorf("bad option: %T", option))
 			}
 		}
 
+		var report ScrubReport
+		var l sync.Mutex
+
+		// read from replicas and rewrite
+		repair := func(key Key) (ok bool, err error) {
+			defer he(&err, e5.With(key))
+			for _, replica := range replicas {
+				var tokens sb.Tokens
+				var sum []byte
+				err := replica.Read(key, func(s sb.Stream) error {
+					return sb.Copy(
+						s,
+						sb.CollectTokens(&tokens),
+						sb.Hash(newHashState, &sum, nil),
+					)
+				})
+				if is(err, ErrKeyNotFound) ||
+					is(err, ErrKeyNotMatch) ||
+					is(err, sb.DecodeError) {
+					continue
+				}
+				ce(err)
+				if !bytes.Equal(key.Hash[:], sum) {
+					continue
+				}
+				res, err := Rewrite(store, key.Namespace, tokens)
+				ce(err)
+				if res.Key != key {
+					return false, we.With(e5.With(res.Key))(ErrKeyNotMatch)
+				}
+				return true, nil
+			}
+			return false, nil
+		}
+
 		wg := pr2.NewWaitGroup(ctx)
 		defer wg.Cancel()
 		put, wait := pr2.Consume(wg, int(parallel), func(_ int, v any) error {
@@ -58,6 +143,7 @@ func (Def) Scrub(
 			if tapKey != nil {
 				tapKey(key)
 			}
+			bad := false
 			if err := store.Read(key, func(s sb.Stream) error {
 				var sum []byte
 				if err := sb.Copy(
@@ -67,14 +153,38 @@ func (Def) Scrub(
 					return err
 				}
 				if !bytes.Equal(key.Hash[:], sum) {
-					if tapBad != nil {
-						tapBad(key)
-					}
+					bad = true
 				}
 				return nil
-			}); err != nil {
+			}); is(err, ErrKeyNotMatch) || is(err, sb.DecodeError) {
+				// detected by store
+				bad = true
+			} else if err != nil {
 				return err
 			}
+			if !bad {
+				return nil
+			}
+
+			if tapBad != nil {
+				tapBad(key)
+			}
+			repaired := false
+			if len(replicas) > 0 {
+				var err error
+				repaired, err = repair(key)
+				if err != nil {
+					return err
+				}
+			}
+			l.Lock()
+			defer l.Unlock()
+			report.Bad = append(report.Bad, key)
+			if repaired {
+				report.Repaired = append(report.Repaired, key)
+			} else if len(replicas) > 0 {
+				report.Unrepairable = append(report.Unrepairable, key)
+			}
 			return nil
 		})
 
@@ -86,6 +196,10 @@ func (Def) Scrub(
 		}
 		ce(wait(true))
 
+		if tapReport != nil {
+			tapReport(report)
+		}
+
 		return nil
 	}
 }
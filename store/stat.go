@@ -0,0 +1,44 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/sb"
+)
+
+type ObjectInfo struct {
+	Key        Key
+	EncodedLen int64     // length of the stored value
+	CodecID    string    // ID of the codec the value encoded with
+	WriteTime  time.Time // zero if not known by the backend
+}
+
+// Stater is implemented by stores that can provide object metadata without reading the object
+type Stater interface {
+	Stat(Key) (ObjectInfo, error)
+}
+
+// Stat returns metadata of the object, reading the object if s is not a Stater.
+// When reading, EncodedLen is the length of the sb encoding of the object, and CodecID and WriteTime are left empty
+func Stat(s Store, key Key) (info ObjectInfo, err error) {
+	if stater, ok := s.(Stater); ok {
+		return stater.Stat(key)
+	}
+	defer he(&err, e5.With(key))
+	var l int
+	err = s.Read(key, func(stream sb.Stream) error {
+		return sb.Copy(
+			stream,
+			sb.EncodedLen(&l, nil),
+		)
+	})
+	ce(err)
+	info.Key = key
+	info.EncodedLen = int64(l)
+	return
+}
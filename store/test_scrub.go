@@ -0,0 +1,154 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/opts"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+// corruptStore returns wrong content for bad keys until rewritten
+type corruptStore struct {
+	Store
+	l   sync.Mutex
+	bad map[Key]bool
+}
+
+func (c *corruptStore) Read(key Key, fn func(sb.Stream) error) error {
+	c.l.Lock()
+	bad := c.bad[key]
+	c.l.Unlock()
+	if bad {
+		return c.Store.Read(key, func(sb.Stream) error {
+			return fn(sb.Marshal("corrupted"))
+		})
+	}
+	return c.Store.Read(key, fn)
+}
+
+func (c *corruptStore) Write(ns key.Namespace, stream sb.Stream, options ...WriteOption) (WriteResult, error) {
+	res, err := c.Store.Write(ns, stream, options...)
+	if err == nil {
+		c.l.Lock()
+		delete(c.bad, res.Key)
+		c.l.Unlock()
+	}
+	return res, err
+}
+
+// rewritingCorruptStore repairs bad keys by Rewriter
+type rewritingCorruptStore struct {
+	*corruptStore
+}
+
+var _ Rewriter = rewritingCorruptStore{}
+
+func (c rewritingCorruptStore) Rewrite(ns key.Namespace, stream sb.Stream, options ...WriteOption) (WriteResult, error) {
+	res, err := c.Store.(Rewriter).Rewrite(ns, stream, options...)
+	if err == nil {
+		c.l.Lock()
+		delete(c.bad, res.Key)
+		c.l.Unlock()
+	}
+	return res, err
+}
+
+func TestScrubRepair(
+	t *testing.T,
+	store Store,
+	scrub Scrub,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	ns := key.Namespace{'f', 'o', 'o'}
+	var keys []Key
+	for i := 0; i < 8; i++ {
+		res, err := store.Write(ns, sb.Marshal(i))
+		ce(err)
+		keys = append(keys, res.Key)
+	}
+
+	for _, rewrite := range []bool{false, true} {
+		corrupted := &corruptStore{
+			Store: store,
+			bad: map[Key]bool{
+				keys[0]: true,
+				keys[1]: true,
+			},
+		}
+		var damaged Store = corrupted
+		if rewrite {
+			if _, ok := store.(Rewriter); !ok {
+				continue
+			}
+			damaged = rewritingCorruptStore{corrupted}
+		}
+		replica := &corruptStore{
+			Store: store,
+			bad: map[Key]bool{
+				keys[1]: true,
+			},
+		}
+
+		var report ScrubReport
+		var numBad int64
+		ce(scrub(
+			wg,
+			damaged,
+			RepairFrom{replica},
+			opts.TapBadKey(func(_ Key) {
+				atomic.AddInt64(&numBad, 1)
+			}),
+			TapScrubReport(func(r ScrubReport) {
+				report = r
+			}),
+		))
+		if numBad != 2 || len(report.Bad) != 2 {
+			t.Fatalf("got %d", numBad)
+		}
+		if len(report.Repaired) != 1 || report.Repaired[0] != keys[0] {
+			t.Fatal()
+		}
+		if len(report.Unrepairable) != 1 || report.Unrepairable[0] != keys[1] {
+			t.Fatal()
+		}
+
+		// repaired
+		ce(damaged.Read(keys[0], func(s sb.Stream) error {
+			var i int
+			if err := sb.Copy(s, sb.Unmarshal(&i)); err != nil {
+				return err
+			}
+			if i != 0 {
+				t.Fatal()
+			}
+			return nil
+		}))
+
+		// scrub again
+		report = ScrubReport{}
+		ce(scrub(
+			wg,
+			damaged,
+			TapScrubReport(func(r ScrubReport) {
+				report = r
+			}),
+		))
+		if len(report.Bad) != 1 || report.Bad[0] != keys[1] {
+			t.Fatal()
+		}
+		if len(report.Repaired) != 0 || len(report.Unrepairable) != 0 {
+			t.Fatal()
+		}
+	}
+}
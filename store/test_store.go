@@ -5,6 +5,7 @@
 package store
 
 import (
+	"bytes"
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
@@ -35,6 +36,7 @@ type TestStore func(
 
 func (Def) TestStore(
 	scrub Scrub,
+	usage Usage,
 ) TestStore {
 
 	return func(
@@ -314,6 +316,156 @@ func (Def) TestStore(
 				}
 			})
 
+			t.Run("batch", func(t *testing.T) {
+				defer he(nil, e5.TestingFatal(t))
+				nsBatch := key.Namespace{'b', 'a', 't', 'c', 'h'}
+				var keys []Key
+				values := make(map[Key]int64)
+				for i := 0; i < 8; i++ {
+					v := rand.Int63()
+					res, err := store.Write(nsBatch, sb.Marshal(v))
+					ce(err)
+					keys = append(keys, res.Key)
+					values[res.Key] = v
+				}
+				missing := Key{Namespace: nsBatch, Hash: Hash{1, 2, 3}}
+				oks, err := ExistsMany(store, append([]Key{missing}, append(keys, missing)...))
+				ce(err)
+				if len(oks) != len(keys)+2 {
+					t.Fatal()
+				}
+				if oks[0] || oks[len(oks)-1] {
+					t.Fatal()
+				}
+				for _, ok := range oks[1 : len(oks)-1] {
+					if !ok {
+						t.Fatal()
+					}
+				}
+				var l sync.Mutex
+				read := make(map[Key]bool)
+				err = ReadMany(store, keys, func(key Key, stream sb.Stream) (err error) {
+					defer he(&err)
+					var v int64
+					err = sb.Copy(stream, sb.Unmarshal(&v))
+					ce(err)
+					if v != values[key] {
+						t.Fatal()
+					}
+					l.Lock()
+					read[key] = true
+					l.Unlock()
+					return nil
+				})
+				ce(err)
+				if len(read) != len(keys) {
+					t.Fatal()
+				}
+				err = ReadMany(store, []Key{keys[0], missing}, func(Key, sb.Stream) error {
+					return nil
+				})
+				if !is(err, ErrKeyNotFound) {
+					t.Fatal()
+				}
+				ce(store.Delete(keys))
+			})
+
+			t.Run("stat", func(t *testing.T) {
+				defer he(nil, e5.TestingFatal(t))
+				nsStat := key.Namespace{'s', 't', 'a', 't'}
+				var sum, max int64
+				var keys []Key
+				for i := 0; i < 8; i++ {
+					res, err := store.Write(nsStat, sb.Marshal(make([]byte, i*100)))
+					ce(err)
+					keys = append(keys, res.Key)
+					info, err := Stat(store, res.Key)
+					ce(err)
+					if info.Key != res.Key {
+						t.Fatal()
+					}
+					if info.EncodedLen <= 0 {
+						t.Fatalf("got %d", info.EncodedLen)
+					}
+					sum += info.EncodedLen
+					if info.EncodedLen > max {
+						max = info.EncodedLen
+					}
+				}
+				_, err := Stat(store, Key{Namespace: nsStat, Hash: Hash{1, 2, 3}})
+				if !is(err, ErrKeyNotFound) {
+					t.Fatal()
+				}
+				var n int64
+				info, err := usage(ctx, store, nsStat, TapObjectInfo(func(_ ObjectInfo) {
+					atomic.AddInt64(&n, 1)
+				}))
+				ce(err)
+				if info.NumObjects != 8 || n != 8 {
+					t.Fatalf("got %d", info.NumObjects)
+				}
+				if info.EncodedLen != sum {
+					t.Fatalf("got %d, expected %d", info.EncodedLen, sum)
+				}
+				if info.MaxLen != max {
+					t.Fatal()
+				}
+				ce(store.Delete(keys))
+			})
+
+			t.Run("read range", func(t *testing.T) {
+				defer he(nil, e5.TestingFatal(t))
+				nsRange := key.Namespace{'r', 'a', 'n', 'g', 'e'}
+				data := make([]byte, 1000)
+				for i := range data {
+					data[i] = byte(rand.Intn(256))
+				}
+				var chunks [][]byte
+				for i := 0; i < len(data); i += 300 {
+					end := i + 300
+					if end > len(data) {
+						end = len(data)
+					}
+					chunks = append(chunks, data[i:end])
+				}
+				var keys []Key
+				for _, value := range []any{data, chunks} {
+					res, err := store.Write(nsRange, sb.Marshal(value))
+					ce(err)
+					keys = append(keys, res.Key)
+					for _, r := range [][2]int64{
+						{0, 1},
+						{0, 1000},
+						{1, 299},
+						{299, 2},
+						{300, 300},
+						{450, 500},
+						{899, 101},
+						{900, 200},
+						{999, 1},
+						{1000, 1},
+						{2000, 1},
+					} {
+						expected := sliceRange(data, r[0], r[1])
+						err := ReadRange(store, res.Key, r[0], r[1], func(bs []byte) error {
+							if !bytes.Equal(bs, expected) {
+								t.Fatalf("range %v: got %d bytes", r, len(bs))
+							}
+							return nil
+						})
+						ce(err)
+					}
+				}
+				err := ReadRange(store, Key{Namespace: nsRange, Hash: Hash{1, 2, 3}}, 0, 1, func([]byte) error {
+					return nil
+				})
+				if !is(err, ErrKeyNotFound) {
+					t.Fatal()
+				}
+				err = store.Delete(keys)
+				ce(err)
+			})
+
 		}
 
 		withStore(
@@ -0,0 +1,29 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrTrashNotSupported = errors.New("trash not supported")
+
+// Trasher is implemented by stores that can move objects to a trash instead of deleting them.
+// Trashed objects are not visible to reads and iterations, and can be restored until purged by the backend.
+// Implementations may return ErrTrashNotSupported if the backend has no trash, Trash with no keys can be used to check that
+type Trasher interface {
+	Trash(keys []Key) error
+	Restore(keys []Key) error
+	IterTrash(fn func(key Key, deletedAt time.Time) error) error
+}
+
+// Trash moves objects to trash, returns ErrTrashNotSupported if s is not a Trasher
+func Trash(s Store, keys []Key) error {
+	if t, ok := s.(Trasher); ok {
+		return t.Trash(keys)
+	}
+	return we(ErrTrashNotSupported)
+}
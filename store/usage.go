@@ -0,0 +1,98 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/sys"
+	"github.com/reusee/pr2"
+)
+
+type UsageOption interface {
+	IsUsageOption()
+}
+
+type TapObjectInfo func(ObjectInfo)
+
+func (TapObjectInfo) IsUsageOption() {}
+
+type UsageInfo struct {
+	Namespace  key.Namespace
+	NumObjects int64
+	EncodedLen int64
+	MaxLen     int64
+	MaxKey     Key // key of the largest object
+}
+
+// Usage aggregates storage usage of a namespace
+type Usage func(
+	ctx context.Context,
+	store Store,
+	ns key.Namespace,
+	options ...UsageOption,
+) (UsageInfo, error)
+
+func (Def) Usage(
+	parallel sys.Parallel,
+) Usage {
+	return func(
+		ctx context.Context,
+		store Store,
+		ns key.Namespace,
+		options ...UsageOption,
+	) (usage UsageInfo, err error) {
+		defer he(&err)
+
+		var tapInfo TapObjectInfo
+		for _, option := range options {
+			switch option := option.(type) {
+			case TapObjectInfo:
+				tapInfo = option
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+
+		usage.Namespace = ns
+		var l sync.Mutex
+		wg := pr2.NewWaitGroup(ctx)
+		defer wg.Cancel()
+		put, wait := pr2.Consume(wg, int(parallel), func(_ int, key Key) error {
+			info, err := Stat(store, key)
+			if is(err, ErrKeyNotFound) {
+				// deleted
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if tapInfo != nil {
+				tapInfo(info)
+			}
+			l.Lock()
+			defer l.Unlock()
+			usage.NumObjects++
+			usage.EncodedLen += info.EncodedLen
+			if info.EncodedLen > usage.MaxLen {
+				usage.MaxLen = info.EncodedLen
+				usage.MaxKey = key
+			}
+			return nil
+		})
+
+		if err := store.IterKeys(ns, func(key Key) error {
+			put(key)
+			return nil
+		}); err != nil {
+			return usage, err
+		}
+		ce(wait(true))
+
+		return
+	}
+}
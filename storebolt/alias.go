@@ -0,0 +1,35 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storebolt
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/reusee/june/index"
+	"github.com/reusee/june/juneerr"
+	"github.com/reusee/june/store"
+)
+
+type (
+	any = interface{}
+
+	IndexEntry      = index.Entry
+	StoreID         = store.ID
+	StoreIndex      = index.StoreIndex
+	IndexTapEntry   = index.TapEntry
+	IndexSaveOption = index.SaveOption
+)
+
+var (
+	is = errors.Is
+	pt = fmt.Printf
+	we = juneerr.Wrap
+	ce = juneerr.Check
+	he = juneerr.Handle
+
+	Break          = store.Break
+	ErrKeyNotFound = store.ErrKeyNotFound
+)
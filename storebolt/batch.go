@@ -0,0 +1,232 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storebolt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/reusee/june/index"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+)
+
+// Batch buffers writes in memory and applies them to the store in one transaction by Commit.
+// Reads see buffered writes over the current store content
+type Batch struct {
+	wg    *pr2.WaitGroup
+	name  string
+	store *Store
+
+	mu     sync.RWMutex
+	writes map[string]map[string]write // bucket -> key -> write
+}
+
+var batchSerial int64
+
+type NewBatch func(
+	ctx context.Context,
+	store *Store,
+) (*Batch, error)
+
+func (Def) NewBatch() NewBatch {
+	return func(
+		ctx context.Context,
+		store *Store,
+	) (_ *Batch, err error) {
+		return &Batch{
+			wg: pr2.NewWaitGroup(ctx),
+			name: fmt.Sprintf("bolt-batch%d(%s)",
+				atomic.AddInt64(&batchSerial, 1),
+				store.Name(),
+			),
+			store:  store,
+			writes: make(map[string]map[string]write),
+		}, nil
+	}
+}
+
+func (b *Batch) Name() string {
+	return b.name
+}
+
+func (b *Batch) StoreID() string {
+	return b.store.StoreID()
+}
+
+// Commit applies buffered writes to the store atomically
+func (b *Batch) Commit() (err error) {
+	defer he(&err)
+	defer b.wg.Add()()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	writes := make(map[string][]write)
+	for bucket, m := range b.writes {
+		for _, w := range m {
+			writes[bucket] = append(writes[bucket], w)
+		}
+	}
+	ce(b.store.apply(writes))
+	b.writes = make(map[string]map[string]write)
+	return nil
+}
+
+// Abort discards buffered writes
+func (b *Batch) Abort() (err error) {
+	defer he(&err)
+	defer b.wg.Add()()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.writes = make(map[string]map[string]write)
+	return nil
+}
+
+var _ ops = new(Batch)
+
+func (b *Batch) begin() func() {
+	return b.wg.Add()
+}
+
+func (b *Batch) get(bucket string, key []byte) (value []byte, ok bool, err error) {
+	b.mu.RLock()
+	w, buffered := b.writes[bucket][string(key)]
+	b.mu.RUnlock()
+	if buffered {
+		if w.deleted {
+			return nil, false, nil
+		}
+		return w.value, true, nil
+	}
+	return b.store.get(bucket, key)
+}
+
+func (b *Batch) scan(
+	bucket string,
+	lower, upper []byte,
+	desc bool,
+	n int,
+	withValue bool,
+) (
+	entries []entry,
+	cursor []byte,
+	err error,
+) {
+	defer he(&err)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stored, cursor, err := b.store.scan(bucket, lower, upper, desc, n, withValue)
+	ce(err)
+	less := func(a, b []byte) bool {
+		if desc {
+			return bytes.Compare(a, b) > 0
+		}
+		return bytes.Compare(a, b) < 0
+	}
+
+	// buffered writes in range, and not after the last stored entry if there are more
+	var buffered []write
+	for _, w := range b.writes[bucket] {
+		if bytes.Compare(w.key, lower) < 0 ||
+			(upper != nil && bytes.Compare(w.key, upper) >= 0) {
+			continue
+		}
+		if cursor != nil && less(stored[len(stored)-1].key, w.key) {
+			continue
+		}
+		buffered = append(buffered, w)
+	}
+	sort.Slice(buffered, func(i, j int) bool {
+		return less(buffered[i].key, buffered[j].key)
+	})
+
+	// merge
+	for len(stored) > 0 || len(buffered) > 0 {
+		if len(buffered) == 0 ||
+			(len(stored) > 0 && less(stored[0].key, buffered[0].key)) {
+			entries = append(entries, stored[0])
+			stored = stored[1:]
+			continue
+		}
+		w := buffered[0]
+		buffered = buffered[1:]
+		if len(stored) > 0 && bytes.Equal(stored[0].key, w.key) {
+			// overridden
+			stored = stored[1:]
+		}
+		if w.deleted {
+			continue
+		}
+		e := entry{
+			key: w.key,
+		}
+		if withValue {
+			e.value = w.value
+		}
+		entries = append(entries, e)
+	}
+
+	return
+}
+
+func (b *Batch) write(bucket string, writes ...write) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.writes[bucket]
+	if !ok {
+		m = make(map[string]write)
+		b.writes[bucket] = m
+	}
+	for _, w := range writes {
+		m[string(w.key)] = w
+	}
+	return nil
+}
+
+var _ storekv.KV = new(Batch)
+
+func (b *Batch) CostInfo() storekv.CostInfo {
+	return costInfo
+}
+
+func (b *Batch) KeyExists(key string) (bool, error) {
+	return keyExists(b, key)
+}
+
+func (b *Batch) KeyGet(key string, fn func(io.Reader) error) error {
+	return keyGet(b, key, fn)
+}
+
+func (b *Batch) KeyPut(key string, r io.Reader) error {
+	return keyPut(b, key, r)
+}
+
+func (b *Batch) KeyIter(prefix string, fn func(key string) error) error {
+	return keyIter(b, prefix, fn)
+}
+
+func (b *Batch) KeyDelete(keys ...string) error {
+	return keyDelete(b, keys...)
+}
+
+var _ index.IndexManager = new(Batch)
+
+func (b *Batch) IndexFor(id StoreID) (index.Index, error) {
+	return Index{
+		name: fmt.Sprintf("bolt-batch-index%d(%v, %v)",
+			atomic.AddInt64(&indexSerial, 1),
+			b.Name(),
+			id,
+		),
+		ops: b,
+		id:  id,
+	}, nil
+}
@@ -0,0 +1,7 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storebolt
+
+type Def struct{}
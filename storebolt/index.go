@@ -0,0 +1,209 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storebolt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/index"
+	"github.com/reusee/pp"
+	"github.com/reusee/sb"
+)
+
+var _ index.IndexManager = new(Store)
+
+func (s *Store) IndexFor(id StoreID) (index.Index, error) {
+	return Index{
+		name: fmt.Sprintf("bolt-index%d(%v, %v)",
+			atomic.AddInt64(&indexSerial, 1),
+			s.Name(),
+			id,
+		),
+		ops: s,
+		id:  id,
+	}, nil
+}
+
+var indexSerial int64
+
+// Index stores entries in idx bucket.
+// Keys are ordered encoding of StoreIndex, values are sb encoding of entries
+type Index struct {
+	name string
+	ops  ops
+	id   StoreID
+}
+
+func (i Index) Name() string {
+	return i.name
+}
+
+// indexKey returns the bucket key of value
+func (i Index) indexKey(value sb.Stream) ([]byte, error) {
+	return index.OrderedKey(sb.Marshal(StoreIndex{
+		ID:    i.id,
+		Value: value,
+	}))
+}
+
+func (i Index) Save(entry IndexEntry, options ...IndexSaveOption) (err error) {
+	defer he(&err)
+	defer i.ops.begin()()
+
+	if entry.Type == nil {
+		return we.With(
+			e5.Info("entry type is nil: %+v", entry),
+		)(index.ErrInvalidEntry)
+	}
+	if entry.Key == nil && entry.Path == nil {
+		return we.With(
+			e5.Info("both entry key and path is nil: %+v", entry),
+		)(index.ErrInvalidEntry)
+	}
+
+	var tapEntry []IndexTapEntry
+	for _, option := range options {
+		switch option := option.(type) {
+		case IndexTapEntry:
+			tapEntry = append(tapEntry, option)
+		default:
+			panic(fmt.Errorf("unknown option: %T", option))
+		}
+	}
+
+	var writes []write
+	for _, obj := range entryObjects(entry) {
+		key, err := i.indexKey(sb.Marshal(obj))
+		ce(err)
+		buf := new(bytes.Buffer)
+		ce(sb.Copy(
+			sb.Marshal(obj),
+			sb.Encode(buf),
+		))
+		writes = append(writes, write{
+			key:   key,
+			value: buf.Bytes(),
+		})
+	}
+	ce(i.ops.write(idxBucket, writes...))
+
+	for _, tap := range tapEntry {
+		tap(entry)
+	}
+
+	return nil
+}
+
+// entryObjects returns the entry and its pre-entry
+func entryObjects(entry IndexEntry) []any {
+	objs := []any{entry}
+	if entry.Key != nil {
+		objs = append(objs, index.PreEntry{
+			Key:   *entry.Key,
+			Type:  entry.Type,
+			Tuple: entry.Tuple,
+		})
+	}
+	return objs
+}
+
+func (i Index) Delete(entry IndexEntry) (err error) {
+	defer he(&err)
+	defer i.ops.begin()()
+
+	var writes []write
+	for _, obj := range entryObjects(entry) {
+		key, err := i.indexKey(sb.Marshal(obj))
+		ce(err)
+		writes = append(writes, write{
+			key:     key,
+			deleted: true,
+		})
+	}
+	ce(i.ops.write(idxBucket, writes...))
+
+	return nil
+}
+
+type indexIter struct {
+	ops     ops
+	lower   []byte
+	upper   []byte
+	desc    bool
+	entries []entry
+	end     bool
+}
+
+func (i Index) Iter(
+	lower *sb.Tokens,
+	upper *sb.Tokens,
+	order index.Order,
+) (
+	_ pp.Src,
+	_ io.Closer,
+	err error,
+) {
+	defer he(&err)
+	defer i.ops.begin()()
+
+	iter := &indexIter{
+		ops:  i.ops,
+		desc: order == index.Desc,
+	}
+
+	if lower == nil {
+		iter.lower, err = i.indexKey(sb.Marshal(sb.Min))
+	} else {
+		iter.lower, err = i.indexKey(lower.Iter())
+	}
+	ce(err)
+
+	if upper == nil {
+		iter.upper, err = i.indexKey(sb.Marshal(sb.Max))
+	} else {
+		iter.upper, err = i.indexKey(upper.Iter())
+	}
+	ce(err)
+
+	return iter.Iter, iter, nil
+}
+
+func (m *indexIter) Close() error {
+	return nil
+}
+
+func (m *indexIter) Iter() (_ any, _ pp.Src, err error) {
+	defer he(&err)
+
+	// pages are read in separated transactions
+	for len(m.entries) == 0 && !m.end {
+		entries, cursor, err := m.ops.scan(idxBucket, m.lower, m.upper, m.desc, scanPageSize, true)
+		ce(err)
+		m.entries = entries
+		if cursor == nil {
+			m.end = true
+		} else if m.desc {
+			m.upper = cursor
+		} else {
+			m.lower = cursor
+		}
+	}
+	if len(m.entries) == 0 {
+		return nil, nil, nil
+	}
+	e := m.entries[0]
+	m.entries = m.entries[1:]
+
+	tokens, err := sb.TokensFromStream(
+		sb.Decode(bytes.NewReader(e.value)),
+	)
+	ce(err)
+
+	return tokens.Iter(), m.Iter, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storebolt
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+)
+
+var _ storekv.KV = new(Store)
+
+var costInfo = storekv.CostInfo{
+	Put:    1,
+	Delete: 1,
+}
+
+func (s *Store) CostInfo() storekv.CostInfo {
+	return costInfo
+}
+
+func (s *Store) KeyExists(key string) (bool, error) {
+	return keyExists(s, key)
+}
+
+func keyExists(o ops, key string) (ok bool, err error) {
+	defer he(&err)
+	defer o.begin()()
+	_, ok, err = o.get(kvBucket, []byte(key))
+	ce(err)
+	return
+}
+
+func (s *Store) KeyGet(key string, fn func(io.Reader) error) error {
+	return keyGet(s, key, fn)
+}
+
+func keyGet(o ops, key string, fn func(io.Reader) error) (err error) {
+	defer he(&err,
+		e5.With(storekv.StringKey(key)),
+	)
+	defer o.begin()()
+	value, ok, err := o.get(kvBucket, []byte(key))
+	ce(err)
+	if !ok {
+		return we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
+	}
+	if fn != nil {
+		ce(fn(bytes.NewReader(value)))
+	}
+	return nil
+}
+
+func (s *Store) KeyPut(key string, r io.Reader) error {
+	return keyPut(s, key, r)
+}
+
+func keyPut(o ops, key string, r io.Reader) (err error) {
+	defer he(&err,
+		e5.With(storekv.StringKey(key)),
+	)
+	defer o.begin()()
+	_, ok, err := o.get(kvBucket, []byte(key))
+	ce(err)
+	if ok {
+		return nil
+	}
+	// copy, not retaining reader bytes
+	buf := new(bytes.Buffer)
+	_, err = io.Copy(buf, r)
+	ce(err)
+	ce(o.write(kvBucket, write{
+		key:   []byte(key),
+		value: buf.Bytes(),
+	}))
+	return nil
+}
+
+// max entries in one scan
+const scanPageSize = 512
+
+func (s *Store) KeyIter(prefix string, fn func(key string) error) error {
+	return keyIter(s, prefix, fn)
+}
+
+func keyIter(o ops, prefix string, fn func(key string) error) (err error) {
+	defer he(&err, e5.Info("prefix %s", prefix))
+	defer o.begin()()
+
+	lower := []byte(prefix)
+	upper := prefixUpperBound(lower)
+	for {
+		// fn is called out of transaction
+		entries, cursor, err := o.scan(kvBucket, lower, upper, false, scanPageSize, false)
+		ce(err)
+		for _, entry := range entries {
+			key := string(entry.key)
+			err := fn(key)
+			if is(err, Break) {
+				return nil
+			}
+			ce(err, e5.Info("key %s", key))
+		}
+		if cursor == nil {
+			break
+		}
+		lower = cursor
+	}
+
+	return nil
+}
+
+// prefixUpperBound returns the least key greater than keys with the prefix, or nil if not exists
+func prefixUpperBound(prefix []byte) []byte {
+	upper := append(prefix[:0:0], prefix...)
+	for i := len(upper) - 1; i >= 0; i-- {
+		if upper[i] < 0xff {
+			upper[i]++
+			return upper[:i+1]
+		}
+	}
+	return nil
+}
+
+func (s *Store) KeyDelete(keys ...string) error {
+	return keyDelete(s, keys...)
+}
+
+func keyDelete(o ops, keys ...string) (err error) {
+	defer he(&err)
+	defer o.begin()()
+	writes := make([]write, 0, len(keys))
+	for _, key := range keys {
+		writes = append(writes, write{
+			key:     []byte(key),
+			deleted: true,
+		})
+	}
+	ce(o.write(kvBucket, writes...))
+	return nil
+}
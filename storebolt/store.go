@@ -0,0 +1,264 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storebolt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/reusee/june/fsys"
+	"github.com/reusee/june/naming"
+	"github.com/reusee/pr2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store stores keys and index entries in buckets of a bolt database file
+type Store struct {
+	wg      *pr2.WaitGroup
+	name    string
+	storeID string
+	DB      *bolt.DB
+}
+
+// create new bolt store
+type New func(
+	ctx context.Context,
+	path string,
+) (*Store, error)
+
+const (
+	kvBucket  = "kv"
+	idxBucket = "idx"
+)
+
+func (Def) New(
+	machine naming.MachineName,
+	setRestrictedPath fsys.SetRestrictedPath,
+) New {
+	return func(
+		ctx context.Context,
+		path string,
+	) (_ *Store, err error) {
+		defer he(&err)
+
+		db, err := bolt.Open(path, 0600, &bolt.Options{
+			// file is locked by opened database
+			Timeout: time.Second * 10,
+		})
+		ce(err)
+
+		ce(setRestrictedPath(path))
+
+		ce(db.Update(func(tx *bolt.Tx) error {
+			for _, name := range []string{kvBucket, idxBucket} {
+				if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		s := &Store{
+			wg: pr2.NewWaitGroup(ctx),
+			name: fmt.Sprintf("bolt%d(%s)",
+				atomic.AddInt64(&storeSerial, 1),
+				filepath.Base(path),
+			),
+			storeID: fmt.Sprintf("bolt(%s, %s)",
+				machine,
+				path,
+			),
+			DB: db,
+		}
+
+		wg := pr2.GetWaitGroup(ctx)
+		if wg == nil {
+			panic("no wait group")
+		}
+		done := wg.Add()
+		context.AfterFunc(wg, func() {
+			defer done()
+			s.wg.Wait()
+			ce(db.Close())
+		})
+
+		return s, nil
+	}
+}
+
+var storeSerial int64
+
+func (s *Store) Name() string {
+	return s.name
+}
+
+func (s *Store) StoreID() string {
+	return s.storeID
+}
+
+// entry is a key-value pair read from bucket
+type entry struct {
+	key   []byte
+	value []byte
+}
+
+// write is a put or delete of key
+type write struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+// ops is the storage of Store and Batch
+type ops interface {
+	begin() func()
+	// get returns a copy of the value
+	get(bucket string, key []byte) (value []byte, ok bool, err error)
+	// scan returns at most n entries in [lower, upper), in descending order if desc.
+	// nil upper is unbounded. cursor is the next lower bound if ascending, or upper bound if descending. nil cursor indicates the end
+	scan(bucket string, lower, upper []byte, desc bool, n int, withValue bool) (entries []entry, cursor []byte, err error)
+	write(bucket string, writes ...write) error
+}
+
+var _ ops = new(Store)
+
+func (s *Store) begin() func() {
+	return s.wg.Add()
+}
+
+func (s *Store) get(bucket string, key []byte) (value []byte, ok bool, err error) {
+	defer he(&err)
+	defer s.wg.Add()()
+	ce(s.DB.View(func(tx *bolt.Tx) error {
+		// Bucket.Get may not distinguish empty value from missing key
+		k, v := tx.Bucket([]byte(bucket)).Cursor().Seek(key)
+		if k == nil || !bytes.Equal(k, key) {
+			return nil
+		}
+		ok = true
+		// values are only valid in transaction
+		value = append(v[:0:0], v...)
+		return nil
+	}))
+	return
+}
+
+func (s *Store) scan(
+	bucket string,
+	lower, upper []byte,
+	desc bool,
+	n int,
+	withValue bool,
+) (
+	entries []entry,
+	cursor []byte,
+	err error,
+) {
+	defer he(&err)
+	defer s.wg.Add()()
+
+	ce(s.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(bucket)).Cursor()
+		add := func(k, v []byte) {
+			e := entry{
+				key: append(k[:0:0], k...),
+			}
+			if withValue {
+				e.value = append(v[:0:0], v...)
+			}
+			entries = append(entries, e)
+		}
+
+		if !desc {
+			for k, v := c.Seek(lower); k != nil && len(entries) < n; k, v = c.Next() {
+				if upper != nil && bytes.Compare(k, upper) >= 0 {
+					break
+				}
+				add(k, v)
+			}
+			return nil
+		}
+
+		var k, v []byte
+		if upper == nil {
+			k, v = c.Last()
+		} else if k, _ = c.Seek(upper); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && len(entries) < n; k, v = c.Prev() {
+			if bytes.Compare(k, lower) < 0 {
+				break
+			}
+			add(k, v)
+		}
+		return nil
+	}))
+
+	if len(entries) == n {
+		cursor = nextCursor(entries[len(entries)-1].key, desc)
+	}
+
+	return
+}
+
+// nextCursor returns the bound for scanning entries after key
+func nextCursor(key []byte, desc bool) []byte {
+	if desc {
+		// exclusive upper bound
+		return key
+	}
+	// the least key greater than key
+	return append(key[:len(key):len(key)], 0)
+}
+
+func (s *Store) write(bucket string, writes ...write) error {
+	return s.apply(map[string][]write{
+		bucket: writes,
+	})
+}
+
+// apply writes to buckets in one transaction
+func (s *Store) apply(writes map[string][]write) (err error) {
+	defer he(&err)
+	defer s.wg.Add()()
+
+	buckets := make([]string, 0, len(writes))
+	for bucket := range writes {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	ce(s.DB.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			bucket := tx.Bucket([]byte(name))
+			for _, w := range writes[name] {
+				if w.deleted {
+					if err := bucket.Delete(w.key); err != nil {
+						return err
+					}
+					continue
+				}
+				value := w.value
+				if value == nil {
+					// nil value is not allowed
+					value = []byte{}
+				}
+				if err := bucket.Put(w.key, value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storebolt
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/index"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+)
+
+func TestBatchKV(
+	t *testing.T,
+	test storekv.TestKV,
+	newStore New,
+	newBatch NewBatch,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+	with := func(fn func(storekv.KV, string)) {
+		s, err := newStore(wg, filepath.Join(t.TempDir(), "db"))
+		ce(err)
+		batch, err := newBatch(wg, s)
+		ce(err)
+		fn(batch, "foo")
+	}
+	test(wg, t, with)
+}
+
+func TestBatchIndex(
+	t *testing.T,
+	newStore New,
+	newBatch NewBatch,
+	test index.TestIndex,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+	s, err := newStore(wg, filepath.Join(t.TempDir(), "db"))
+	ce(err)
+	batch, err := newBatch(wg, s)
+	ce(err)
+	with := func(fn func(index.IndexManager)) {
+		fn(batch)
+	}
+	test(with, t)
+}
+
+func TestBatch(
+	t *testing.T,
+	newStore New,
+	newBatch NewBatch,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+	s, err := newStore(wg, filepath.Join(t.TempDir(), "db"))
+	ce(err)
+
+	const num = scanPageSize * 2
+	for i := 0; i < num; i += 2 {
+		key := fmt.Sprintf("foo%04d", i)
+		ce(s.KeyPut(key, strings.NewReader(key)))
+	}
+
+	batch, err := newBatch(wg, s)
+	ce(err)
+	for i := 0; i < num; i++ {
+		key := fmt.Sprintf("foo%04d", i)
+		if i%4 == 0 {
+			ce(batch.KeyDelete(key))
+		} else {
+			ce(batch.KeyPut(key, strings.NewReader(key)))
+		}
+	}
+
+	keys := func(kv storekv.KV) (ret []string) {
+		ce(kv.KeyIter("foo", func(key string) error {
+			ret = append(ret, key)
+			return nil
+		}))
+		return
+	}
+	if n := len(keys(s)); n != num/2 {
+		t.Fatalf("got %d", n)
+	}
+	batchKeys := keys(batch)
+	if len(batchKeys) != num/4*3 {
+		t.Fatalf("got %d", len(batchKeys))
+	}
+	for i := 1; i < len(batchKeys); i++ {
+		if batchKeys[i-1] >= batchKeys[i] {
+			t.Fatal()
+		}
+	}
+	ok, err := batch.KeyExists("foo0000")
+	ce(err)
+	if ok {
+		t.Fatal()
+	}
+	ok, err = s.KeyExists("foo0000")
+	ce(err)
+	if !ok {
+		t.Fatal()
+	}
+
+	// abort
+	ce(batch.Abort())
+	if n := len(keys(batch)); n != num/2 {
+		t.Fatalf("got %d", n)
+	}
+
+	// commit
+	ce(batch.KeyPut("foo0001", strings.NewReader("foo")))
+	ce(batch.KeyDelete("foo0000"))
+	ce(batch.Commit())
+	ok, err = s.KeyExists("foo0001")
+	ce(err)
+	if !ok {
+		t.Fatal()
+	}
+	ok, err = s.KeyExists("foo0000")
+	ce(err)
+	if ok {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storebolt
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+)
+
+func TestKV(
+	t *testing.T,
+	test storekv.TestKV,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+	with := func(fn func(storekv.KV, string)) {
+		s, err := newStore(wg, filepath.Join(t.TempDir(), "db"))
+		ce(err)
+		fn(s, "foo")
+	}
+	test(wg, t, with)
+}
@@ -21,16 +21,16 @@ import (
 )
 
 type Store struct {
-	wg          *pr2.WaitGroup
-	name        string
-	storeID     string
-	id          string
-	ensureDir   fsys.EnsureDir
-	dirOK       sync.Map
-	dir         string
-	syncPending int32
-	softDelete  SoftDelete
-	noSync      bool
+	wg         *pr2.WaitGroup
+	name       string
+	storeID    string
+	id         string
+	ensureDir  fsys.EnsureDir
+	dirOK      sync.Map
+	dir        string
+	softDelete SoftDelete
+	durability Durability
+	group      *groupCommit
 }
 
 type NewOption interface {
@@ -90,18 +90,29 @@ func (Def) New(
 				machine,
 				dir,
 			),
-			dir:       dir,
-			ensureDir: ensureDir,
-			noSync:    noSync,
+			dir:        dir,
+			ensureDir:  ensureDir,
+			durability: SyncBatched{},
+		}
+		if noSync {
+			store.durability = SyncNone{}
 		}
 		for _, option := range options {
 			switch option := option.(type) {
 			case SoftDelete:
 				store.softDelete = option
+			case Durability:
+				store.durability = option
 			default:
 				panic(fmt.Errorf("unknown option: %T", option))
 			}
 		}
+		if mode, ok := store.durability.(SyncBatched); ok {
+			store.group = newGroupCommit(mode)
+			wg.Go(func() {
+				store.group.run(wg.Done())
+			})
+		}
 		return store, nil
 	}
 }
@@ -5,6 +5,7 @@
 package storedisk
 
 import (
+	"errors"
 	"io"
 	"io/fs"
 	"math/rand"
@@ -12,8 +13,6 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync/atomic"
-	"time"
 
 	"github.com/reusee/e5"
 	"github.com/reusee/june/storekv"
@@ -86,6 +85,49 @@ func (s *Store) KeyGet(key string, fn func(io.Reader) error) (err error) {
 	return fn(f)
 }
 
+var _ storekv.RangeKV = new(Store)
+
+func (s *Store) KeyGetRange(key string, offset, length int64, fn func(io.Reader) error) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer he(&err,
+		e5.With(storekv.StringKey(key)),
+	)
+	path := s.keyToPath(key)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
+	} else {
+		ce(err)
+	}
+	defer f.Close()
+	return fn(io.NewSectionReader(f, offset, length))
+}
+
+var _ storekv.StatKV = new(Store)
+
+func (s *Store) KeyStat(key string) (info storekv.KeyInfo, err error) {
+	select {
+	case <-s.wg.Done():
+		return info, ErrClosed
+	default:
+	}
+	defer he(&err,
+		e5.With(storekv.StringKey(key)),
+	)
+	stat, err := os.Stat(s.keyToPath(key))
+	if os.IsNotExist(err) {
+		return info, we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
+	}
+	ce(err)
+	info.Size = stat.Size()
+	info.ModTime = stat.ModTime()
+	return
+}
+
 func (s *Store) KeyIter(prefix string, fn func(string) error) (err error) {
 	select {
 	case <-s.wg.Done():
@@ -153,73 +195,112 @@ func (s *Store) KeyPut(key string, r io.Reader) (err error) {
 		e5.Info("dir: %s", dir),
 		e5.With(storekv.StringKey(key)),
 	)
+	defer s.wg.Add()()
 
-	if _, ok := s.dirOK.Load(dir); !ok {
-		if _, err := os.Stat(dir); err == nil {
-			s.dirOK.Store(dir, struct{}{})
-		} else if os.IsNotExist(err) {
-			err = os.MkdirAll(dir, 0755)
-			ce(err)
-			s.dirOK.Store(dir, struct{}{})
-		} else {
-			return err
-		}
-	}
+	ce(s.prepareDir(dir))
 
 	tmpPath := path + ".tmp." + strconv.FormatInt(rand.Int63(), 10)
 	f, err := os.Create(tmpPath)
 	ce(err)
-	defer func() {
-		if err != nil {
-			os.Remove(tmpPath)
-		}
-	}()
 	_, err = io.Copy(f, r)
-	ce(err, e5.Close(f))
-	if !s.noSync {
-		err = f.Sync()
-		ce(err)
+	if err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
 	}
-	ce(f.Close())
-	ce(os.Rename(tmpPath, path))
 
-	if atomic.CompareAndSwapInt32(&s.syncPending, 0, 1) {
+	switch s.durability.(type) {
 
-		done := s.wg.Add()
-		timer := time.AfterFunc(time.Second, func() {
-			defer done()
-			atomic.StoreInt32(&s.syncPending, 0)
-			f, err := os.Open(dir)
-			if err != nil {
-				// skip
-				return
-			}
-			defer f.Close()
-			if err := f.Sync(); err != nil {
-				// skip
-				return
-			}
-		})
-
-		go func() {
-			select {
-			case <-s.wg.Done():
-				// cancel timer
-				if !timer.Stop() {
-					// func started
-				} else {
-					// func not start
-					done()
-				}
-			case <-timer.C:
-			}
-		}()
+	case SyncBatched:
+		// synced and renamed with other concurrent puts
+		ce(s.group.put(f, tmpPath, path))
+
+	case SyncPerWrite:
+		ce(commitOne(f, tmpPath, path))
+
+	case SyncNone:
+		if err := f.Close(); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+		if err := os.Rename(tmpPath, path); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
 
 	}
 
 	return nil
 }
 
+// prepareDir creates dir of keys if not exists
+func (s *Store) prepareDir(dir string) error {
+	if _, ok := s.dirOK.Load(dir); ok {
+		return nil
+	}
+	if _, err := os.Stat(dir); err == nil {
+		s.dirOK.Store(dir, struct{}{})
+	} else if os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		s.dirOK.Store(dir, struct{}{})
+	} else {
+		return err
+	}
+	return nil
+}
+
+var _ storekv.CreateKV = new(Store)
+
+// KeyCreate links a temporary file to the path, linking fails if the path exists
+func (s *Store) KeyCreate(key string, r io.Reader) (created bool, err error) {
+	select {
+	case <-s.wg.Done():
+		return false, ErrClosed
+	default:
+	}
+	path := s.keyToPath(key)
+	dir := filepath.Dir(path)
+
+	defer he(
+		&err,
+		e5.Info("path: %s", path),
+		e5.Info("dir: %s", dir),
+		e5.With(storekv.StringKey(key)),
+	)
+	defer s.wg.Add()()
+
+	ce(s.prepareDir(dir))
+
+	tmpPath := path + ".tmp." + strconv.FormatInt(rand.Int63(), 10)
+	f, err := os.Create(tmpPath)
+	ce(err)
+	defer os.Remove(tmpPath)
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return false, err
+	}
+
+	_, noSync := s.durability.(SyncNone)
+	if noSync {
+		ce(f.Close())
+	} else {
+		ce(syncAndClose(f))
+	}
+	if err := os.Link(tmpPath, path); errors.Is(err, fs.ErrExist) {
+		return false, nil
+	} else {
+		ce(err)
+	}
+	if !noSync {
+		ce(syncDir(dir))
+	}
+
+	return true, nil
+}
+
 func (s *Store) KeyDelete(keys ...string) (err error) {
 	select {
 	case <-s.wg.Done():
@@ -230,11 +311,7 @@ func (s *Store) KeyDelete(keys ...string) (err error) {
 	for _, key := range keys {
 		path := s.keyToPath(key)
 		if s.softDelete {
-			err := os.Rename(path, path+".deleted")
-			ce(err,
-				e5.With(storekv.StringKey(key)),
-				e5.Info("path: %s", path),
-			)
+			ce(s.trash(key))
 		} else {
 			err := os.Remove(path)
 			ce(err,
@@ -0,0 +1,239 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storedisk
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/reusee/e5"
+)
+
+// Durability is the syncing mode of KeyPut
+type Durability interface {
+	NewOption
+	IsDurability()
+}
+
+// SyncPerWrite syncs the file and its directory before KeyPut returns
+type SyncPerWrite struct{}
+
+func (SyncPerWrite) IsNewOption() {}
+
+func (SyncPerWrite) IsDurability() {}
+
+// SyncBatched syncs concurrent puts in groups, sharing directory syncs.
+// KeyPut returns after its group is synced.
+// A group is committed when MaxBatch puts are pending, or MaxLatency passed since the first pending put.
+// Zero MaxLatency commits pending puts as soon as the previous group is done.
+// This is the default mode
+type SyncBatched struct {
+	MaxLatency time.Duration
+	MaxBatch   int
+}
+
+func (SyncBatched) IsNewOption() {}
+
+func (SyncBatched) IsDurability() {}
+
+// SyncNone does not sync. Files written recently may be lost or truncated on system crash
+type SyncNone struct{}
+
+func (SyncNone) IsNewOption() {}
+
+func (SyncNone) IsDurability() {}
+
+const defaultMaxBatch = 1024
+
+// pendingPut is a written temporary file waiting for group commit
+type pendingPut struct {
+	file    *os.File
+	tmpPath string
+	path    string
+	done    chan error
+}
+
+type groupCommit struct {
+	mode    SyncBatched
+	mu      sync.Mutex
+	pending []*pendingPut
+	closed  bool
+	kick    chan struct{}
+}
+
+func newGroupCommit(mode SyncBatched) *groupCommit {
+	if mode.MaxBatch <= 0 {
+		mode.MaxBatch = defaultMaxBatch
+	}
+	return &groupCommit{
+		mode: mode,
+		kick: make(chan struct{}, 1),
+	}
+}
+
+// put waits until the file is synced and renamed to path
+func (g *groupCommit) put(file *os.File, tmpPath, path string) error {
+	g.mu.Lock()
+	if g.closed {
+		// committer exited
+		g.mu.Unlock()
+		return commitOne(file, tmpPath, path)
+	}
+	p := &pendingPut{
+		file:    file,
+		tmpPath: tmpPath,
+		path:    path,
+		done:    make(chan error, 1),
+	}
+	g.pending = append(g.pending, p)
+	g.mu.Unlock()
+
+	select {
+	case g.kick <- struct{}{}:
+	default:
+	}
+
+	return <-p.done
+}
+
+// run commits pending puts until done is closed
+func (g *groupCommit) run(done <-chan struct{}) {
+	for {
+		select {
+		case <-g.kick:
+		case <-done:
+			g.mu.Lock()
+			g.closed = true
+			puts := g.pending
+			g.pending = nil
+			g.mu.Unlock()
+			commitPuts(puts)
+			return
+		}
+
+		if g.mode.MaxLatency > 0 {
+			// wait for more puts
+			timer := time.NewTimer(g.mode.MaxLatency)
+		wait:
+			for {
+				g.mu.Lock()
+				n := len(g.pending)
+				g.mu.Unlock()
+				if n >= g.mode.MaxBatch {
+					break
+				}
+				select {
+				case <-timer.C:
+					break wait
+				case <-g.kick:
+				case <-done:
+					break wait
+				}
+			}
+			timer.Stop()
+		}
+
+		for {
+			g.mu.Lock()
+			n := len(g.pending)
+			if n > g.mode.MaxBatch {
+				n = g.mode.MaxBatch
+			}
+			puts := g.pending[:n:n]
+			g.pending = g.pending[n:]
+			g.mu.Unlock()
+			if len(puts) == 0 {
+				break
+			}
+			commitPuts(puts)
+		}
+	}
+}
+
+// commitOne syncs and renames one file
+func commitOne(file *os.File, tmpPath, path string) error {
+	p := &pendingPut{
+		file:    file,
+		tmpPath: tmpPath,
+		path:    path,
+		done:    make(chan error, 1),
+	}
+	commitPuts([]*pendingPut{p})
+	return <-p.done
+}
+
+// commitPuts syncs files, renames them, then syncs each directory once
+func commitPuts(puts []*pendingPut) {
+	if len(puts) == 0 {
+		return
+	}
+
+	// sync files concurrently
+	errs := make([]error, len(puts))
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i, p := range puts {
+		i := i
+		p := p
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = syncAndClose(p.file)
+		}()
+	}
+	wg.Wait()
+
+	// rename
+	dirs := make(map[string][]int)
+	for i, p := range puts {
+		if errs[i] == nil {
+			errs[i] = os.Rename(p.tmpPath, p.path)
+		}
+		if errs[i] != nil {
+			os.Remove(p.tmpPath)
+			continue
+		}
+		dir := filepath.Dir(p.path)
+		dirs[dir] = append(dirs[dir], i)
+	}
+
+	// sync directories
+	for dir, indexes := range dirs {
+		if err := syncDir(dir); err != nil {
+			for _, i := range indexes {
+				errs[i] = err
+			}
+		}
+	}
+
+	for i, p := range puts {
+		p.done <- errs[i]
+	}
+}
+
+func syncAndClose(f *os.File) error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir syncs the directory to persist its entries
+func syncDir(dir string) (err error) {
+	defer he(&err, e5.Info("dir: %s", dir))
+	f, err := os.Open(dir)
+	ce(err)
+	defer f.Close()
+	ce(f.Sync())
+	return nil
+}
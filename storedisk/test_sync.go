@@ -0,0 +1,148 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storedisk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+)
+
+var testDurabilities = []Durability{
+	SyncPerWrite{},
+	SyncBatched{},
+	SyncBatched{
+		MaxLatency: time.Millisecond * 5,
+		MaxBatch:   8,
+	},
+	SyncNone{},
+}
+
+func TestDurability(
+	t *testing.T,
+	test storekv.TestKV,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	for _, durability := range testDurabilities {
+		durability := durability
+		t.Run(fmt.Sprintf("%T%+v", durability, durability), func(t *testing.T) {
+			defer he(nil, e5.TestingFatal(t))
+
+			with := func(fn func(storekv.KV, string)) {
+				dir, err := os.MkdirTemp(t.TempDir(), "")
+				ce(err)
+				s, err := newStore(wg, dir, durability)
+				ce(err)
+				fn(s, "foo")
+			}
+			test(wg, t, with)
+
+			// concurrent puts
+			ctx := pr2.NewWaitGroup(wg)
+			dir := t.TempDir()
+			s, err := newStore(ctx, dir, durability)
+			ce(err)
+			const num = 256
+			var putWG sync.WaitGroup
+			errs := make(chan error, num)
+			for i := 0; i < num; i++ {
+				i := i
+				putWG.Add(1)
+				go func() {
+					defer putWG.Done()
+					errs <- s.KeyPut(
+						fmt.Sprintf("foo/%016x", i),
+						strings.NewReader(fmt.Sprintf("%d", i)),
+					)
+				}()
+			}
+			putWG.Wait()
+			close(errs)
+			for err := range errs {
+				ce(err)
+			}
+			for i := 0; i < num; i++ {
+				ce(s.KeyGet(fmt.Sprintf("foo/%016x", i), func(r io.Reader) error {
+					bs, err := io.ReadAll(r)
+					ce(err)
+					if !bytes.Equal(bs, []byte(fmt.Sprintf("%d", i))) {
+						t.Fatalf("got %s", bs)
+					}
+					return nil
+				}))
+			}
+
+			// close
+			ctx.Cancel()
+			ctx.Wait()
+			if err := s.KeyPut("foo/ffff", strings.NewReader("foo")); !is(err, ErrClosed) {
+				t.Fatalf("got %v", err)
+			}
+
+			// no temporary files
+			ce(filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+				ce(err)
+				if strings.Contains(entry.Name(), ".tmp.") {
+					t.Fatalf("temporary file not removed: %s", path)
+				}
+				return nil
+			}))
+		})
+	}
+}
+
+func BenchmarkDurability(
+	b *testing.B,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	value := bytes.Repeat([]byte("x"), 512)
+	for _, durability := range testDurabilities {
+		durability := durability
+		b.Run(fmt.Sprintf("%T%+v", durability, durability), func(b *testing.B) {
+			// stop the store after each round
+			wg := pr2.NewWaitGroup(wg)
+			defer func() {
+				wg.Cancel()
+				wg.Wait()
+			}()
+			s, err := newStore(wg, b.TempDir(), durability)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var n int64
+			b.SetBytes(int64(len(value)))
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&n, 1)
+					if err := s.KeyPut(
+						fmt.Sprintf("foo/%016x", i),
+						bytes.NewReader(value),
+					); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storedisk
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/pr2"
+)
+
+func TestTrash(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	s, err := newStore(wg, t.TempDir(), SoftDelete(true))
+	ce(err)
+
+	keys := []string{
+		"foo/aaaa",
+		"foo/bbbb",
+		"bar/cccc",
+	}
+	for _, key := range keys {
+		ce(s.KeyPut(key, strings.NewReader(key)))
+	}
+
+	listTrash := func(prefix string) map[string]time.Time {
+		ret := make(map[string]time.Time)
+		ce(s.TrashIter(prefix, func(key string, deletedAt time.Time) error {
+			ret[key] = deletedAt
+			return nil
+		}))
+		return ret
+	}
+
+	// soft delete
+	before := time.Now().Add(-time.Second)
+	ce(s.KeyDelete("foo/aaaa"))
+	ce(s.KeyTrash("bar/cccc"))
+	ok, err := s.KeyExists("foo/aaaa")
+	ce(err)
+	if ok {
+		t.Fatal()
+	}
+	ce(s.KeyIter("", func(key string) error {
+		if key != "foo/bbbb" {
+			t.Fatalf("got %s", key)
+		}
+		return nil
+	}))
+	trashed := listTrash("")
+	if len(trashed) != 2 {
+		t.Fatalf("got %v", trashed)
+	}
+	for _, deletedAt := range trashed {
+		if deletedAt.Before(before) {
+			t.Fatalf("bad deletion time: %v", deletedAt)
+		}
+	}
+	if len(listTrash("foo/")) != 1 {
+		t.Fatal()
+	}
+
+	// restore
+	ce(s.KeyRestore("foo/aaaa"))
+	ok, err = s.KeyExists("foo/aaaa")
+	ce(err)
+	if !ok {
+		t.Fatal()
+	}
+	if len(listTrash("foo/")) != 0 {
+		t.Fatal()
+	}
+	err = s.KeyRestore("foo/aaaa")
+	if !is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v", err)
+	}
+
+	// purge
+	ce(s.KeyDelete("foo/aaaa", "foo/bbbb"))
+	n, err := s.TrashPurge(time.Hour)
+	ce(err)
+	if n != 0 {
+		t.Fatal()
+	}
+	old := time.Now().Add(-time.Hour * 2)
+	ce(os.Chtimes(s.keyToPath("foo/aaaa")+trashSuffix, old, old))
+	n, err = s.TrashPurge(time.Hour)
+	ce(err)
+	if n != 1 {
+		t.Fatalf("got %d", n)
+	}
+	trashed = listTrash("")
+	if len(trashed) != 2 {
+		t.Fatalf("got %v", trashed)
+	}
+	if _, ok := trashed["foo/aaaa"]; ok {
+		t.Fatal()
+	}
+	n, err = s.TrashPurge(0)
+	ce(err)
+	if n != 2 {
+		t.Fatalf("got %d", n)
+	}
+	if len(listTrash("")) != 0 {
+		t.Fatal()
+	}
+
+	// hard delete does not trash
+	s, err = newStore(wg, t.TempDir())
+	ce(err)
+	ce(s.KeyPut("foo/aaaa", strings.NewReader("foo")))
+	ce(s.KeyDelete("foo/aaaa"))
+	if len(listTrash("")) != 0 {
+		t.Fatal()
+	}
+}
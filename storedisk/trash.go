@@ -0,0 +1,153 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storedisk
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+)
+
+// trashed values are renamed to path with this suffix, with modification time set to the deletion time
+const trashSuffix = ".deleted"
+
+var _ storekv.TrashKV = new(Store)
+
+// KeyTrash moves values to trash, regardless of SoftDelete option
+func (s *Store) KeyTrash(keys ...string) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer he(&err)
+	for _, key := range keys {
+		ce(s.trash(key))
+	}
+	return nil
+}
+
+func (s *Store) trash(key string) (err error) {
+	path := s.keyToPath(key)
+	defer he(&err,
+		e5.With(storekv.StringKey(key)),
+		e5.Info("path: %s", path),
+	)
+	trashPath := path + trashSuffix
+	ce(os.Rename(path, trashPath))
+	now := time.Now()
+	ce(os.Chtimes(trashPath, now, now))
+	return nil
+}
+
+// KeyRestore moves values back from trash
+func (s *Store) KeyRestore(keys ...string) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer he(&err)
+	for _, key := range keys {
+		path := s.keyToPath(key)
+		err := os.Rename(path+trashSuffix, path)
+		if os.IsNotExist(err) {
+			return we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
+		}
+		ce(err,
+			e5.With(storekv.StringKey(key)),
+			e5.Info("path: %s", path),
+		)
+	}
+	return nil
+}
+
+// TrashIter iterates trashed keys with prefix and their deletion time
+func (s *Store) TrashIter(prefix string, fn func(key string, deletedAt time.Time) error) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer he(&err,
+		e5.Info("prefix: %s", prefix),
+	)
+
+	err = s.iterTrash(func(key string, path string, info fs.FileInfo) error {
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		return fn(key, info.ModTime())
+	})
+	if is(err, Break) {
+		return nil
+	}
+	ce(err)
+
+	return nil
+}
+
+// TrashPurge removes values trashed before retention duration, returns the number of removed values
+func (s *Store) TrashPurge(retention time.Duration) (n int, err error) {
+	select {
+	case <-s.wg.Done():
+		return 0, ErrClosed
+	default:
+	}
+	defer he(&err)
+
+	deadline := time.Now().Add(-retention)
+	ce(s.iterTrash(func(key string, path string, info fs.FileInfo) error {
+		if !info.ModTime().Before(deadline) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return we.With(
+				e5.With(storekv.StringKey(key)),
+				e5.Info("path: %s", path),
+			)(err)
+		}
+		n++
+		return nil
+	}))
+
+	return
+}
+
+func (s *Store) iterTrash(fn func(key string, path string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) (retErr error) {
+		defer he(&retErr)
+		ce(err)
+		if entry.IsDir() {
+			return nil
+		}
+		if !strings.HasSuffix(entry.Name(), trashSuffix) ||
+			strings.Contains(entry.Name(), ".tmp.") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		ce(err)
+		parts := strings.Split(strings.TrimSuffix(rel, trashSuffix), PathSeparator)
+		if len(parts) < 2 {
+			return nil
+		}
+		key := strings.Join(
+			append(parts[:len(parts)-2], parts[len(parts)-1]),
+			"/",
+		)
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			// restored or purged
+			return nil
+		}
+		ce(err)
+		return fn(key, path, info)
+	})
+}
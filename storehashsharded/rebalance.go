@@ -0,0 +1,227 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storehashsharded
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/store"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+type RebalanceOption interface {
+	IsRebalanceOption()
+}
+
+// TapMove is called after key is moved to its owner shard
+type TapMove func(key Key, from store.Store, to store.Store)
+
+func (TapMove) IsRebalanceOption() {}
+
+// AddShard adds a shard to the ring, and moves keys owned by it
+func (s *Store) AddShard(
+	ctx context.Context,
+	shardStore store.Store,
+	options ...RebalanceOption,
+) (err error) {
+	defer he(&err)
+
+	id, err := shardStore.ID()
+	ce(err)
+
+	s.rebalanceMu.Lock()
+	defer s.rebalanceMu.Unlock()
+
+	// wait writes routed by the old ring
+	s.writeMu.Lock()
+	s.mu.Lock()
+	for _, shard := range append(s.shards, s.draining...) {
+		if shard.id == id {
+			s.mu.Unlock()
+			s.writeMu.Unlock()
+			return we.With(e5.Info("store %s", id))(ErrDuplicatedShard)
+		}
+	}
+	shards := append(s.shards[:len(s.shards):len(s.shards)], &shard{
+		id:    id,
+		store: shardStore,
+	})
+	if err := s.saveState(shards, s.draining, true); err != nil {
+		s.mu.Unlock()
+		s.writeMu.Unlock()
+		return err
+	}
+	s.shards = shards
+	s.ring = newRing(s.shards, s.vnodes)
+	s.unbalanced = true
+	s.mu.Unlock()
+	s.writeMu.Unlock()
+
+	return s.rebalance(ctx, options...)
+}
+
+// RemoveShard removes a shard from the ring, and moves its keys to the remaining shards.
+// The shard is still read until all keys are moved
+func (s *Store) RemoveShard(
+	ctx context.Context,
+	shardStore store.Store,
+	options ...RebalanceOption,
+) (err error) {
+	defer he(&err)
+
+	id, err := shardStore.ID()
+	ce(err)
+
+	s.rebalanceMu.Lock()
+	defer s.rebalanceMu.Unlock()
+
+	// wait writes routed by the old ring
+	s.writeMu.Lock()
+	s.mu.Lock()
+	var shards []*shard
+	var removed *shard
+	for _, shard := range s.shards {
+		if shard.id == id {
+			removed = shard
+			continue
+		}
+		shards = append(shards, shard)
+	}
+	if removed == nil {
+		s.mu.Unlock()
+		s.writeMu.Unlock()
+		return we.With(e5.Info("store %s", id))(ErrShardNotFound)
+	}
+	if len(shards) == 0 {
+		s.mu.Unlock()
+		s.writeMu.Unlock()
+		return we(ErrNoShard)
+	}
+	draining := append(s.draining[:len(s.draining):len(s.draining)], removed)
+	if err := s.saveState(shards, draining, true); err != nil {
+		s.mu.Unlock()
+		s.writeMu.Unlock()
+		return err
+	}
+	s.shards = shards
+	s.draining = draining
+	s.ring = newRing(s.shards, s.vnodes)
+	s.unbalanced = true
+	s.mu.Unlock()
+	s.writeMu.Unlock()
+
+	return s.rebalance(ctx, options...)
+}
+
+// Rebalance moves keys not in their owner shards.
+// Call it to resume an interrupted AddShard or RemoveShard
+func (s *Store) Rebalance(
+	ctx context.Context,
+	options ...RebalanceOption,
+) error {
+	s.rebalanceMu.Lock()
+	defer s.rebalanceMu.Unlock()
+	s.writeMu.Lock()
+	s.mu.Lock()
+	s.unbalanced = true
+	s.mu.Unlock()
+	s.writeMu.Unlock()
+	return s.rebalance(ctx, options...)
+}
+
+func (s *Store) rebalance(
+	ctx context.Context,
+	options ...RebalanceOption,
+) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	defer he(&err)
+
+	var tapMove TapMove
+	for _, option := range options {
+		switch option := option.(type) {
+		case TapMove:
+			tapMove = option
+		default:
+			panic(fmt.Errorf("bad option: %T", option))
+		}
+	}
+
+	v := s.view()
+
+	type move struct {
+		key  Key
+		from *shard
+		to   *shard
+	}
+
+	for _, from := range v.all {
+		ce(ctx.Err())
+
+		// collect first, not modifying the shard while iterating
+		var moves []move
+		ce(from.store.IterAllKeys(func(key Key) error {
+			if to := v.ring.owner(key); to != from {
+				moves = append(moves, move{
+					key:  key,
+					from: from,
+					to:   to,
+				})
+			}
+			return nil
+		}))
+
+		wg := pr2.NewWaitGroup(ctx)
+		put, wait := pr2.Consume(wg, s.parallel, func(_ int, m move) (err error) {
+			defer he(&err, e5.With(m.key))
+			// not racing with deletes
+			defer s.lockKeys([]Key{m.key})()
+			err = m.from.store.Read(m.key, func(stream sb.Stream) (err error) {
+				defer he(&err)
+				res, err := m.to.store.Write(m.key.Namespace, stream)
+				ce(err)
+				if res.Key != m.key {
+					return we.With(e5.With(res.Key))(ErrKeyNotMatch)
+				}
+				return nil
+			})
+			if is(err, ErrKeyNotFound) {
+				// deleted
+				return nil
+			}
+			ce(err)
+			ce(m.from.store.Delete([]Key{m.key}))
+			if tapMove != nil {
+				tapMove(m.key, m.from.store, m.to.store)
+			}
+			return nil
+		})
+		for _, m := range moves {
+			if !put(m) {
+				break
+			}
+		}
+		err := wait(true)
+		wg.Cancel()
+		ce(err)
+		ce(ctx.Err())
+	}
+
+	// writes are routed by the current ring, no key is put in other shards after scanning
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ce(s.saveState(s.shards, nil, false))
+	s.draining = nil
+	s.unbalanced = false
+
+	return nil
+}
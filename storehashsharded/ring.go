@@ -0,0 +1,55 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storehashsharded
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// ring is a consistent hash ring of shards
+type ring struct {
+	points []point
+}
+
+type point struct {
+	pos   uint64
+	shard *shard
+}
+
+func newRing(shards []*shard, vnodes int) *ring {
+	r := new(ring)
+	for _, shard := range shards {
+		for i := 0; i < vnodes; i++ {
+			sum := sha256.Sum256([]byte(string(shard.id) + "#" + strconv.Itoa(i)))
+			r.points = append(r.points, point{
+				pos:   binary.BigEndian.Uint64(sum[:8]),
+				shard: shard,
+			})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		a, b := r.points[i], r.points[j]
+		if a.pos != b.pos {
+			return a.pos < b.pos
+		}
+		return a.shard.id < b.shard.id
+	})
+	return r
+}
+
+// owner returns the shard owning the key
+func (r *ring) owner(key Key) *shard {
+	pos := binary.BigEndian.Uint64(key.Hash[:8])
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].pos >= pos
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].shard
+}
@@ -0,0 +1,559 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storehashsharded
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/store"
+	"github.com/reusee/june/sys"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+// Store distributes keys across shards by consistent hashing of key hash.
+// Adding or removing a shard moves only the keys owned by that shard
+type Store struct {
+	wg           *pr2.WaitGroup
+	name         string
+	vnodes       int
+	parallel     int
+	newHashState key.NewHashState
+
+	mu         sync.RWMutex
+	shards     []*shard
+	draining   []*shard // removed from ring, keys not moved yet
+	ring       *ring
+	unbalanced bool // keys may be in shards other than the owner
+	checkpoint store.Checkpoint
+
+	rebalanceMu sync.Mutex
+	writeMu     sync.RWMutex // read locked by writes and deletes, locked when changing the ring
+	keyLocks    [256]sync.Mutex
+}
+
+type shard struct {
+	id    StoreID
+	store store.Store
+}
+
+type New func(
+	ctx context.Context,
+	shards []store.Store,
+	options ...NewOption,
+) (*Store, error)
+
+type NewOption interface {
+	IsNewOption()
+}
+
+// VirtualNodes is the number of ring points of each shard
+type VirtualNodes int
+
+func (VirtualNodes) IsNewOption() {}
+
+// PersistState saves shards and rebalancing state to the checkpoint.
+// Stores passed to New are restored as draining if they were removed but not drained,
+// and an interrupted rebalance is known after restart
+type PersistState struct {
+	store.Checkpoint
+}
+
+func (PersistState) IsNewOption() {}
+
+// persistedState is the state saved to PersistState checkpoint
+type persistedState struct {
+	Shards     []StoreID
+	Draining   []StoreID
+	Unbalanced bool
+}
+
+var (
+	ErrNoShard         = errors.New("no shard")
+	ErrShardNotFound   = errors.New("shard not found")
+	ErrDuplicatedShard = errors.New("duplicated shard")
+)
+
+func (Def) New(
+	parallel sys.Parallel,
+	newHashState key.NewHashState,
+) New {
+	return func(
+		ctx context.Context,
+		stores []store.Store,
+		options ...NewOption,
+	) (_ *Store, err error) {
+		defer he(&err)
+
+		vnodes := 64
+		var checkpoint store.Checkpoint
+		for _, option := range options {
+			switch option := option.(type) {
+			case VirtualNodes:
+				vnodes = int(option)
+			case PersistState:
+				checkpoint = option.Checkpoint
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+
+		if len(stores) == 0 {
+			return nil, we(ErrNoShard)
+		}
+
+		var shards []*shard
+		var names []string
+		ids := make(map[StoreID]bool)
+		for _, s := range stores {
+			id, err := s.ID()
+			ce(err)
+			if ids[id] {
+				return nil, we.With(e5.Info("store %s", id))(ErrDuplicatedShard)
+			}
+			ids[id] = true
+			shards = append(shards, &shard{
+				id:    id,
+				store: s,
+			})
+			names = append(names, s.Name())
+		}
+
+		s := &Store{
+			wg: pr2.NewWaitGroup(ctx),
+			name: fmt.Sprintf("hashsharded%d(%s)",
+				atomic.AddInt64(&serial, 1),
+				strings.Join(names, ", "),
+			),
+			vnodes:       vnodes,
+			parallel:     int(parallel),
+			newHashState: newHashState,
+			shards:       shards,
+			checkpoint:   checkpoint,
+		}
+
+		if checkpoint != nil {
+			var state persistedState
+			ok, err := checkpoint.Load(&state)
+			ce(err)
+			if ok {
+				ce(s.restore(state))
+			}
+			ce(s.saveState(s.shards, s.draining, s.unbalanced))
+		}
+
+		s.ring = newRing(s.shards, s.vnodes)
+		return s, nil
+	}
+}
+
+// restore splits shards into ring shards and draining shards by saved state
+func (s *Store) restore(state persistedState) (err error) {
+	defer he(&err)
+
+	isDraining := make(map[StoreID]bool)
+	for _, id := range state.Draining {
+		isDraining[id] = true
+	}
+	var shards, draining []*shard
+	ids := make(map[StoreID]bool)
+	for _, shard := range s.shards {
+		ids[shard.id] = true
+		if isDraining[shard.id] {
+			draining = append(draining, shard)
+		} else {
+			shards = append(shards, shard)
+		}
+	}
+	for _, id := range state.Draining {
+		if !ids[id] {
+			// keys in it are not reachable
+			return we.With(e5.Info("draining store %s", id))(ErrShardNotFound)
+		}
+	}
+	if len(shards) == 0 {
+		return we(ErrNoShard)
+	}
+
+	unbalanced := state.Unbalanced || len(draining) > 0 || len(shards) != len(state.Shards)
+	inState := make(map[StoreID]bool)
+	for _, id := range state.Shards {
+		inState[id] = true
+	}
+	for _, shard := range shards {
+		if !inState[shard.id] {
+			unbalanced = true
+		}
+	}
+
+	s.shards = shards
+	s.draining = draining
+	s.unbalanced = unbalanced
+	return nil
+}
+
+// saveState saves the state to checkpoint if PersistState is provided
+func (s *Store) saveState(shards []*shard, draining []*shard, unbalanced bool) error {
+	if s.checkpoint == nil {
+		return nil
+	}
+	state := persistedState{
+		Unbalanced: unbalanced,
+	}
+	for _, shard := range shards {
+		state.Shards = append(state.Shards, shard.id)
+	}
+	for _, shard := range draining {
+		state.Draining = append(state.Draining, shard.id)
+	}
+	return s.checkpoint.Save(state)
+}
+
+// lockKeys locks keys in lock order, for deletions and moves
+func (s *Store) lockKeys(keys []Key) (unlock func()) {
+	var indexes []int
+	seen := make(map[byte]bool)
+	for _, key := range keys {
+		if seen[key.Hash[0]] {
+			continue
+		}
+		seen[key.Hash[0]] = true
+		indexes = append(indexes, int(key.Hash[0]))
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		s.keyLocks[i].Lock()
+	}
+	return func() {
+		for _, i := range indexes {
+			s.keyLocks[i].Unlock()
+		}
+	}
+}
+
+var serial int64
+
+var _ store.Store = new(Store)
+
+func (s *Store) Name() string {
+	return s.name
+}
+
+func (s *Store) ID() (StoreID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ids []string
+	for _, shard := range s.shards {
+		ids = append(ids, string(shard.id))
+	}
+	sort.Strings(ids)
+	return StoreID("(hash-sharded(" + strings.Join(ids, ",") + "))"), nil
+}
+
+type view struct {
+	ring       *ring
+	all        []*shard
+	unbalanced bool
+}
+
+func (s *Store) view() view {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make([]*shard, 0, len(s.shards)+len(s.draining))
+	all = append(all, s.shards...)
+	all = append(all, s.draining...)
+	return view{
+		ring:       s.ring,
+		all:        all,
+		unbalanced: s.unbalanced,
+	}
+}
+
+func (s *Store) Read(key Key, fn func(sb.Stream) error) error {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	v := s.view()
+	err := s.read(v, key, fn)
+	if is(err, ErrKeyNotFound) && s.view().ring != v.ring {
+		// ring changed while reading, key may be moved
+		err = s.read(s.view(), key, fn)
+	}
+	return err
+}
+
+func (s *Store) read(v view, key Key, fn func(sb.Stream) error) error {
+	owner := v.ring.owner(key)
+	err := owner.store.Read(key, fn)
+	if !v.unbalanced || !is(err, ErrKeyNotFound) {
+		return err
+	}
+	// may be not moved yet
+	for _, shard := range v.all {
+		if shard == owner {
+			continue
+		}
+		if err := shard.store.Read(key, fn); !is(err, ErrKeyNotFound) {
+			return err
+		}
+	}
+	// may be moved to owner while reading others
+	return owner.store.Read(key, fn)
+}
+
+func (s *Store) Exists(key Key) (bool, error) {
+	select {
+	case <-s.wg.Done():
+		return false, ErrClosed
+	default:
+	}
+
+	v := s.view()
+	ok, err := s.exists(v, key)
+	if err == nil && !ok && s.view().ring != v.ring {
+		// ring changed while checking, key may be moved
+		ok, err = s.exists(s.view(), key)
+	}
+	return ok, err
+}
+
+func (s *Store) exists(v view, key Key) (bool, error) {
+	owner := v.ring.owner(key)
+	ok, err := owner.store.Exists(key)
+	if err != nil || ok || !v.unbalanced {
+		return ok, err
+	}
+	for _, shard := range v.all {
+		if shard == owner {
+			continue
+		}
+		ok, err := shard.store.Exists(key)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	// may be moved to owner while checking others
+	return owner.store.Exists(key)
+}
+
+func (s *Store) Write(
+	ns key.Namespace,
+	stream sb.Stream,
+	options ...WriteOption,
+) (res WriteResult, err error) {
+	select {
+	case <-s.wg.Done():
+		err = ErrClosed
+		return
+	default:
+	}
+
+	defer he(&err)
+
+	// key is unknown before hashing
+	tokens, err := sb.TokensFromStream(stream)
+	ce(err)
+	var hash []byte
+	ce(sb.Copy(tokens.Iter(), sb.Hash(s.newHashState, &hash, nil)))
+	var k Key
+	k.Namespace = ns
+	copy(k.Hash[:], hash)
+
+	// the ring is not changed before writing to the owner
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	v := s.view()
+	return v.ring.owner(k).store.Write(ns, tokens.Iter(), options...)
+}
+
+// iter calls fn for each key once.
+// Shards are iterated concurrently and merged by Key.Compare, keys are called in order if shards iterate in order.
+// When balanced, keys not in their owner shards are stale copies of moved keys and skipped.
+// When unbalanced, they are skipped if the owner has it
+func (s *Store) iter(
+	iter func(store.Store, func(Key) error) error,
+	fn func(Key) error,
+) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	defer he(&err)
+
+	v := s.view()
+
+	ctx, cancel := context.WithCancel(s.wg)
+	defer cancel()
+
+	// iterate shards
+	chans := make([]chan Key, len(v.all))
+	errs := make([]error, len(v.all))
+	var producers sync.WaitGroup
+	for i, shard := range v.all {
+		i := i
+		shard := shard
+		ch := make(chan Key, 64)
+		chans[i] = ch
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			defer close(ch)
+			errs[i] = iter(shard.store, func(key Key) error {
+				select {
+				case ch <- key:
+					return nil
+				case <-ctx.Done():
+					return Break
+				}
+			})
+		}()
+	}
+	defer func() {
+		cancel()
+		producers.Wait()
+		if err == nil {
+			err = errors.Join(errs...)
+		}
+	}()
+
+	// merge
+	h := make(iterHeap, 0, len(chans))
+	next := func(i int) {
+		if key, ok := <-chans[i]; ok {
+			heap.Push(&h, iterHead{
+				key:   key,
+				shard: i,
+			})
+		}
+	}
+	for i := range chans {
+		next(i)
+	}
+	for h.Len() > 0 {
+		head := heap.Pop(&h).(iterHead)
+		key := head.key
+		owner := v.ring.owner(key)
+		inOwner := v.all[head.shard] == owner
+		next(head.shard)
+		// same key in other shards
+		for h.Len() > 0 && h[0].key == key {
+			head := heap.Pop(&h).(iterHead)
+			inOwner = inOwner || v.all[head.shard] == owner
+			next(head.shard)
+		}
+
+		if !inOwner {
+			if !v.unbalanced {
+				continue
+			}
+			ok, err := owner.store.Exists(key)
+			ce(err)
+			if ok {
+				continue
+			}
+		}
+
+		err := fn(key)
+		if is(err, Break) {
+			return nil
+		}
+		ce(err)
+	}
+
+	return nil
+}
+
+type iterHead struct {
+	key   Key
+	shard int
+}
+
+type iterHeap []iterHead
+
+var _ heap.Interface = new(iterHeap)
+
+func (h iterHeap) Len() int {
+	return len(h)
+}
+
+func (h iterHeap) Less(i, j int) bool {
+	return h[i].key.Compare(h[j].key) < 0
+}
+
+func (h iterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *iterHeap) Push(x any) {
+	*h = append(*h, x.(iterHead))
+}
+
+func (h *iterHeap) Pop() any {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+func (s *Store) IterKeys(ns key.Namespace, fn func(Key) error) error {
+	return s.iter(func(store store.Store, fn func(Key) error) error {
+		return store.IterKeys(ns, fn)
+	}, fn)
+}
+
+func (s *Store) IterAllKeys(fn func(Key) error) error {
+	return s.iter(func(store store.Store, fn func(Key) error) error {
+		return store.IterAllKeys(fn)
+	}, fn)
+}
+
+func (s *Store) Delete(keys []Key) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	defer he(&err)
+
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	v := s.view()
+	if v.unbalanced {
+		// not racing with moves
+		defer s.lockKeys(keys)()
+		for _, shard := range v.all {
+			ce(shard.store.Delete(keys))
+		}
+		return nil
+	}
+
+	byShard := make(map[*shard][]Key)
+	for _, key := range keys {
+		owner := v.ring.owner(key)
+		byShard[owner] = append(byShard[owner], key)
+	}
+	for shard, keys := range byShard {
+		ce(shard.store.Delete(keys))
+	}
+	return nil
+}
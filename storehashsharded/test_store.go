@@ -0,0 +1,357 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storehashsharded
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/store"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/june/storemem"
+	"github.com/reusee/june/vars"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+func TestStore(
+	t *testing.T,
+	testStore store.TestStore,
+	scope dscope.Scope,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	with := func(fn func(store.Store), defs ...any) {
+		scope.Fork(defs...).Call(func(
+			newMem storemem.New,
+			newKV storekv.New,
+			newStore New,
+		) {
+			var shards []store.Store
+			for i := 0; i < 4; i++ {
+				s, err := newKV(wg, newMem(wg), "foo")
+				ce(err)
+				shards = append(shards, s)
+			}
+			s, err := newStore(wg, shards)
+			ce(err)
+			fn(s)
+		})
+	}
+	testStore(wg, with, t)
+}
+
+func TestRebalance(
+	t *testing.T,
+	newMem storemem.New,
+	newKV storekv.New,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	newShard := func() store.Store {
+		s, err := newKV(wg, newMem(wg), "foo")
+		ce(err)
+		return s
+	}
+	shards := []store.Store{
+		newShard(),
+		newShard(),
+		newShard(),
+	}
+	s, err := newStore(wg, shards)
+	ce(err)
+
+	ns := key.Namespace{'f', 'o', 'o'}
+	const num = 512
+	var keys []Key
+	for i := 0; i < num; i++ {
+		res, err := s.Write(ns, sb.Marshal(i))
+		ce(err)
+		keys = append(keys, res.Key)
+	}
+
+	count := func(shard store.Store) (n int) {
+		ce(shard.IterAllKeys(func(Key) error {
+			n++
+			return nil
+		}))
+		return
+	}
+	for _, shard := range shards {
+		if n := count(shard); n == 0 || n == num {
+			t.Fatalf("got %d", n)
+		}
+	}
+
+	checkAll := func() {
+		for i, key := range keys {
+			var v int
+			ce(s.Read(key, func(stream sb.Stream) error {
+				return sb.Copy(stream, sb.Unmarshal(&v))
+			}))
+			if v != i {
+				t.Fatal()
+			}
+		}
+		seen := make(map[Key]bool)
+		ce(s.IterKeys(ns, func(key Key) error {
+			if seen[key] {
+				t.Fatal()
+			}
+			seen[key] = true
+			return nil
+		}))
+		if len(seen) != num {
+			t.Fatalf("got %d", len(seen))
+		}
+	}
+
+	// add shard, reading concurrently
+	newOne := newShard()
+	var moved []Key
+	var l sync.Mutex
+	done := make(chan struct{})
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(readErr)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, key := range keys {
+				if err := s.Read(key, func(sb.Stream) error {
+					return nil
+				}); err != nil {
+					readErr <- err
+					return
+				}
+			}
+		}
+	}()
+	ce(s.AddShard(wg, newOne, TapMove(func(key Key, _ store.Store, to store.Store) {
+		if to != newOne {
+			t.Fatal()
+		}
+		l.Lock()
+		moved = append(moved, key)
+		l.Unlock()
+	})))
+	close(done)
+	ce(<-readErr)
+	if n := count(newOne); n == 0 || n != len(moved) {
+		t.Fatalf("got %d, moved %d", n, len(moved))
+	}
+	checkAll()
+
+	// only owned keys in shards
+	for _, shard := range append(shards, newOne) {
+		ce(shard.IterAllKeys(func(key Key) error {
+			if s.ring.owner(key).store != shard {
+				t.Fatal()
+			}
+			return nil
+		}))
+	}
+
+	// copies in non-owner shards
+	for _, shard := range append(shards, newOne) {
+		if s.ring.owner(keys[0]).store == shard {
+			continue
+		}
+		_, err := shard.Write(ns, sb.Marshal(0))
+		ce(err)
+	}
+	checkAll()
+
+	// remove shard
+	removed := shards[1]
+	ce(s.RemoveShard(wg, removed))
+	if n := count(removed); n != 0 {
+		t.Fatalf("got %d", n)
+	}
+	checkAll()
+	err = s.RemoveShard(wg, removed)
+	if !is(err, ErrShardNotFound) {
+		t.Fatal()
+	}
+
+	// interrupted
+	another := newShard()
+	ctx, cancel := context.WithCancel(wg)
+	cancel()
+	err = s.AddShard(ctx, another)
+	if err == nil {
+		t.Fatal()
+	}
+	checkAll()
+	ce(s.Rebalance(wg))
+	checkAll()
+	if n := count(another); n == 0 {
+		t.Fatal()
+	}
+
+	// writes and deletes during rebalance
+	written := make(chan Key, 1024)
+	deleted := make(chan Key, 1024)
+	errs := make(chan error, 1)
+	done = make(chan struct{})
+	go func() {
+		defer close(errs)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			res, err := s.Write(ns, sb.Marshal(num+i))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if i%2 == 0 {
+				if err := s.Delete([]Key{res.Key}); err != nil {
+					errs <- err
+					return
+				}
+				select {
+				case deleted <- res.Key:
+				default:
+				}
+			} else {
+				select {
+				case written <- res.Key:
+				default:
+				}
+			}
+		}
+	}()
+	ce(s.RemoveShard(wg, another))
+	ce(s.AddShard(wg, another))
+	close(done)
+	ce(<-errs)
+	close(written)
+	close(deleted)
+	for key := range written {
+		ok, err := s.Exists(key)
+		ce(err)
+		if !ok {
+			t.Fatal()
+		}
+	}
+	for key := range deleted {
+		ok, err := s.Exists(key)
+		ce(err)
+		if ok {
+			t.Fatal()
+		}
+	}
+	for _, key := range keys {
+		ok, err := s.Exists(key)
+		ce(err)
+		if !ok {
+			t.Fatal()
+		}
+	}
+}
+
+func TestPersistState(
+	t *testing.T,
+	newMem storemem.New,
+	newKV storekv.New,
+	newStore New,
+	scope dscope.Scope,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	newShard := func() store.Store {
+		s, err := newKV(wg, newMem(wg), "foo")
+		ce(err)
+		return s
+	}
+	shards := []store.Store{
+		newShard(),
+		newShard(),
+		newShard(),
+	}
+
+	scope.Fork(func() vars.VarsSpec {
+		return func() (string, *pr2.WaitGroup) {
+			return t.TempDir(), wg
+		}
+	}).Call(func(
+		newCheckpoint vars.NewCheckpoint,
+	) {
+		checkpoint := newCheckpoint("sharded")
+		s, err := newStore(wg, shards, PersistState{checkpoint})
+		ce(err)
+		ns := key.Namespace{'f', 'o', 'o'}
+		var keys []Key
+		for i := 0; i < 128; i++ {
+			res, err := s.Write(ns, sb.Marshal(i))
+			ce(err)
+			keys = append(keys, res.Key)
+		}
+
+		// interrupted removing
+		ctx, cancel := context.WithCancel(wg)
+		cancel()
+		if err := s.RemoveShard(ctx, shards[0]); err == nil {
+			t.Fatal()
+		}
+
+		// restart
+		s, err = newStore(wg, shards, PersistState{checkpoint})
+		ce(err)
+		if len(s.draining) != 1 || s.draining[0].store != shards[0] || !s.unbalanced {
+			t.Fatal()
+		}
+		for _, key := range keys {
+			ok, err := s.Exists(key)
+			ce(err)
+			if !ok {
+				t.Fatal()
+			}
+		}
+
+		// draining shard missing
+		_, err = newStore(wg, shards[1:], PersistState{checkpoint})
+		if !is(err, ErrShardNotFound) {
+			t.Fatalf("got %v", err)
+		}
+
+		// resume
+		ce(s.Rebalance(wg))
+		n := 0
+		ce(shards[0].IterAllKeys(func(Key) error {
+			n++
+			return nil
+		}))
+		if n != 0 {
+			t.Fatalf("got %d", n)
+		}
+		s, err = newStore(wg, shards[1:], PersistState{checkpoint})
+		ce(err)
+		if len(s.draining) != 0 || s.unbalanced {
+			t.Fatal()
+		}
+		for _, key := range keys {
+			ok, err := s.Exists(key)
+			ce(err)
+			if !ok {
+				t.Fatal()
+			}
+		}
+	})
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storekv
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/store"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+// BatchKV is implemented by KVs that can check or get many keys in one call
+type BatchKV interface {
+	KeyExistsMany(keys []string) ([]bool, error)
+	// fn is called for each key, in any order. missing keys return ErrKeyNotFound
+	KeyGetMany(keys []string, fn func(key string, r io.Reader) error) error
+}
+
+var _ store.BatchStore = new(Store)
+
+func (s *Store) ExistsMany(keys []Key) (_ []bool, err error) {
+	defer he(&err)
+
+	paths := make([]string, len(keys))
+	for i, key := range keys {
+		paths[i] = s.keyToPath(key)
+	}
+
+	if kv, ok := s.kv.(BatchKV); ok {
+		return kv.KeyExistsMany(paths)
+	}
+
+	ret := make([]bool, len(keys))
+
+	// group by listing prefix
+	groups := make(map[string]map[string][]int)
+	for i, key := range keys {
+		prefix := s.nsPrefix(key.Namespace) + key.Hash.String()[:2]
+		group, ok := groups[prefix]
+		if !ok {
+			group = make(map[string][]int)
+			groups[prefix] = group
+		}
+		group[paths[i]] = append(group[paths[i]], i)
+	}
+
+	if s.costInfo.Iter*len(groups) < s.costInfo.Exists*len(keys) {
+		// listing is cheaper
+		for prefix, group := range groups {
+			err := s.kv.KeyIter(prefix, func(path string) error {
+				for _, i := range group[path] {
+					ret[i] = true
+				}
+				return nil
+			})
+			ce(err)
+		}
+		return ret, nil
+	}
+
+	wg := pr2.NewWaitGroup(s.wg)
+	defer wg.Cancel()
+	put, wait := pr2.Consume(wg, s.parallel, func(_ int, i int) error {
+		ok, err := s.kv.KeyExists(paths[i])
+		if err != nil {
+			return err
+		}
+		ret[i] = ok
+		return nil
+	})
+	for i := range paths {
+		put(i)
+	}
+	ce(wait(true))
+
+	return ret, nil
+}
+
+func (s *Store) ReadMany(keys []Key, fn func(Key, sb.Stream) error) (err error) {
+	defer he(&err)
+
+	kv, ok := s.kv.(BatchKV)
+	// reads missing keys during Recodec are retried by Read
+	if !ok || s.cache != nil || atomic.LoadInt32(&s.recodecs) > 0 {
+		for _, key := range keys {
+			key := key
+			err := s.Read(key, func(stream sb.Stream) error {
+				return fn(key, stream)
+			})
+			ce(err)
+		}
+		return nil
+	}
+
+	paths := make([]string, len(keys))
+	pathKeys := make(map[string]Key, len(keys))
+	for i, key := range keys {
+		paths[i] = s.keyToPath(key)
+		pathKeys[paths[i]] = key
+	}
+
+	return kv.KeyGetMany(paths, func(path string, r io.Reader) error {
+		key, ok := pathKeys[path]
+		if !ok {
+			return we.With(e5.With(StringKey(path)))(ErrKeyNotFound)
+		}
+		return s.readValue(key, r, func(stream sb.Stream) error {
+			return fn(key, stream)
+		})
+	})
+}
@@ -0,0 +1,13 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storekv
+
+import "io"
+
+// CreateKV is implemented by KVs that can put a key only if it does not exist, atomically
+type CreateKV interface {
+	// KeyCreate returns false if key exists, the value is not written
+	KeyCreate(key string, r io.Reader) (created bool, err error)
+}
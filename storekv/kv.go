@@ -60,6 +60,8 @@ type Store struct {
 	parallel      int
 	offloads      []WithOffload
 	costInfo      CostInfo
+	recodecs      int32
+	keyLocks      [256]sync.RWMutex
 }
 
 var _ store.Store = new(Store)
@@ -0,0 +1,333 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storekv
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/codec"
+	"github.com/reusee/sb"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Passphrase mode encrypts values with a random data encryption key.
+// The data key is wrapped by a key derived from the passphrase, and stored with salt and derivation parameters
+// in metadata objects under prefix, next to the store ID object.
+// Changing the passphrase re-wraps the data key, encoded values are not changed.
+
+const passphrasePrefix = "__passphrase__/"
+
+var ErrBadPassphrase = errors.New("bad passphrase")
+
+type PassphraseOption interface {
+	IsPassphraseOption()
+}
+
+// Argon2id derives keys by argon2id. This is the default
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+}
+
+func (Argon2id) IsPassphraseOption() {}
+
+var defaultArgon2id = Argon2id{
+	Time:    1,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+// Scrypt derives keys by scrypt
+type Scrypt struct {
+	N int
+	R int
+	P int
+}
+
+func (Scrypt) IsPassphraseOption() {}
+
+type kdfParams struct {
+	Name    string
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	N       int
+	R       int
+	P       int
+}
+
+// passphraseMeta is the metadata object
+type passphraseMeta struct {
+	Generation int64
+	KeyID      string
+	KDF        kdfParams
+	Salt       []byte
+	Nonce      []byte
+	WrappedKey []byte
+}
+
+const (
+	dataKeySize = chacha20poly1305.KeySize
+	saltSize    = 16
+)
+
+func kdfFromOptions(options []PassphraseOption) kdfParams {
+	argon := defaultArgon2id
+	params := kdfParams{
+		Name:    "argon2id",
+		Time:    argon.Time,
+		Memory:  argon.Memory,
+		Threads: argon.Threads,
+	}
+	for _, option := range options {
+		switch option := option.(type) {
+		case Argon2id:
+			params = kdfParams{
+				Name:    "argon2id",
+				Time:    option.Time,
+				Memory:  option.Memory,
+				Threads: option.Threads,
+			}
+		case Scrypt:
+			params = kdfParams{
+				Name: "scrypt",
+				N:    option.N,
+				R:    option.R,
+				P:    option.P,
+			}
+		default:
+			panic(fmt.Errorf("unknown option: %T", option))
+		}
+	}
+	return params
+}
+
+func deriveKey(params kdfParams, passphrase []byte, salt []byte) (_ []byte, err error) {
+	defer he(&err)
+	switch params.Name {
+	case "argon2id":
+		if params.Time == 0 || params.Memory == 0 || params.Threads == 0 {
+			return nil, we(fmt.Errorf("bad argon2id parameters: %+v", params))
+		}
+		return argon2.IDKey(passphrase, salt, params.Time, params.Memory, params.Threads, dataKeySize), nil
+	case "scrypt":
+		key, err := scrypt.Key(passphrase, salt, params.N, params.R, params.P, dataKeySize)
+		ce(err)
+		return key, nil
+	}
+	return nil, we(fmt.Errorf("unknown kdf: %s", params.Name))
+}
+
+// wrapAD binds wrapped key to key ID and derivation parameters
+func (m *passphraseMeta) wrapAD() []byte {
+	return []byte(fmt.Sprintf("june-passphrase %s %+v", m.KeyID, m.KDF))
+}
+
+// wrap sets a new salt and wraps dataKey with key derived from passphrase
+func (m *passphraseMeta) wrap(params kdfParams, passphrase []byte, dataKey []byte) (err error) {
+	defer he(&err)
+	m.KDF = params
+	m.Salt = make([]byte, saltSize)
+	_, err = io.ReadFull(rand.Reader, m.Salt)
+	ce(err)
+	kek, err := deriveKey(params, passphrase, m.Salt)
+	ce(err)
+	aead, err := chacha20poly1305.NewX(kek)
+	ce(err)
+	m.Nonce = make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, m.Nonce)
+	ce(err)
+	m.WrappedKey = aead.Seal(nil, m.Nonce, dataKey, m.wrapAD())
+	return nil
+}
+
+func (m *passphraseMeta) unwrap(passphrase []byte) (_ []byte, err error) {
+	defer he(&err)
+	kek, err := deriveKey(m.KDF, passphrase, m.Salt)
+	ce(err)
+	aead, err := chacha20poly1305.NewX(kek)
+	ce(err)
+	dataKey, err := aead.Open(nil, m.Nonce, m.WrappedKey, m.wrapAD())
+	if err != nil {
+		return nil, we(ErrBadPassphrase)
+	}
+	return dataKey, nil
+}
+
+func passphraseMetaPath(prefix string, generation int64) string {
+	return fmt.Sprintf("%s%s%016x", prefix, passphrasePrefix, generation)
+}
+
+// loadPassphraseMeta returns the metadata of the latest generation, or nil if not exists
+func loadPassphraseMeta(kv KV, prefix string) (meta *passphraseMeta, paths []string, err error) {
+	defer he(&err)
+	dir := prefix + passphrasePrefix
+	latest := int64(-1)
+	var latestPath string
+	ce(kv.KeyIter(dir, func(path string) error {
+		generation, err := strconv.ParseInt(strings.TrimPrefix(path, dir), 16, 64)
+		if err != nil {
+			// ignore
+			return nil
+		}
+		paths = append(paths, path)
+		if generation > latest {
+			latest = generation
+			latestPath = path
+		}
+		return nil
+	}))
+	if latestPath == "" {
+		return nil, nil, nil
+	}
+	ce(kv.KeyGet(latestPath, func(r io.Reader) error {
+		return sb.Copy(
+			sb.Decode(r),
+			sb.Unmarshal(&meta),
+		)
+	}), e5.Info("path %s", latestPath))
+	return
+}
+
+func encodePassphraseMeta(meta *passphraseMeta) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	if err := sb.Copy(
+		sb.Marshal(meta),
+		sb.Encode(buf),
+	); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func savePassphraseMeta(kv KV, prefix string, meta *passphraseMeta) (err error) {
+	defer he(&err)
+	buf, err := encodePassphraseMeta(meta)
+	ce(err)
+	ce(kv.KeyPut(passphraseMetaPath(prefix, meta.Generation), buf))
+	return nil
+}
+
+// createPassphraseMeta saves the first generation if no one exists, and returns the saved metadata.
+// KVs not implementing CreateKV are put then reloaded, concurrent setups may not agree on the data key
+func createPassphraseMeta(kv KV, prefix string, meta *passphraseMeta) (_ *passphraseMeta, err error) {
+	defer he(&err)
+	if c, ok := kv.(CreateKV); ok {
+		buf, err := encodePassphraseMeta(meta)
+		ce(err)
+		created, err := c.KeyCreate(passphraseMetaPath(prefix, meta.Generation), buf)
+		ce(err)
+		if created {
+			return meta, nil
+		}
+	} else {
+		ce(savePassphraseMeta(kv, prefix, meta))
+	}
+	// created by others, use the stored one
+	meta, _, err = loadPassphraseMeta(kv, prefix)
+	ce(err)
+	if meta == nil {
+		return nil, we(fmt.Errorf("passphrase metadata not saved"))
+	}
+	return meta, nil
+}
+
+func passphraseCodec(meta *passphraseMeta, dataKey []byte) Codec {
+	return codec.NewAEADCodec(
+		fmt.Sprintf("passphrase-%s-", meta.KeyID),
+		func() (cipher.AEAD, error) {
+			return chacha20poly1305.NewX(dataKey)
+		},
+	)
+}
+
+// PassphraseCodec returns the codec of passphrase mode for stores of kv and prefix.
+// The data key is created on first use, later calls return ErrBadPassphrase if passphrase not match.
+// Concurrent first uses agree on one data key if kv implements CreateKV.
+// Options set key derivation parameters of the created metadata, existing metadata use their recorded parameters
+func PassphraseCodec(
+	kv KV,
+	prefix string,
+	passphrase []byte,
+	options ...PassphraseOption,
+) (_ Codec, err error) {
+	defer he(&err)
+
+	meta, _, err := loadPassphraseMeta(kv, prefix)
+	ce(err)
+
+	if meta == nil {
+		// setup
+		params := kdfFromOptions(options)
+		dataKey := make([]byte, dataKeySize)
+		_, err = io.ReadFull(rand.Reader, dataKey)
+		ce(err)
+		keyID := make([]byte, 8)
+		_, err = io.ReadFull(rand.Reader, keyID)
+		ce(err)
+		meta = &passphraseMeta{
+			KeyID: hex.EncodeToString(keyID),
+		}
+		ce(meta.wrap(params, passphrase, dataKey))
+		// another process may set up concurrently
+		meta, err = createPassphraseMeta(kv, prefix, meta)
+		ce(err)
+	}
+
+	dataKey, err := meta.unwrap(passphrase)
+	ce(err)
+
+	return passphraseCodec(meta, dataKey), nil
+}
+
+// ChangePassphrase re-wraps the data key with the new passphrase.
+// Derivation parameters are not changed if no options provided.
+// Metadata of the new passphrase is saved as a new generation before removing old ones
+func ChangePassphrase(
+	kv KV,
+	prefix string,
+	oldPassphrase []byte,
+	newPassphrase []byte,
+	options ...PassphraseOption,
+) (err error) {
+	defer he(&err)
+
+	meta, paths, err := loadPassphraseMeta(kv, prefix)
+	ce(err)
+	if meta == nil {
+		return we.With(
+			e5.Info("no passphrase metadata in %s", prefix),
+		)(ErrKeyNotFound)
+	}
+	dataKey, err := meta.unwrap(oldPassphrase)
+	ce(err)
+
+	newMeta := &passphraseMeta{
+		Generation: meta.Generation + 1,
+		KeyID:      meta.KeyID,
+	}
+	params := meta.KDF
+	if len(options) > 0 {
+		params = kdfFromOptions(options)
+	}
+	ce(newMeta.wrap(params, newPassphrase, dataKey))
+	ce(savePassphraseMeta(kv, prefix, newMeta))
+
+	ce(kv.KeyDelete(paths...))
+
+	return nil
+}
@@ -18,16 +18,25 @@ import (
 const objPrefix = "obj/"
 
 func (s *Store) objPrefix() string {
+	return s.codecObjPrefix(s.codec.ID())
+}
+
+// codecObjPrefix returns the object prefix of values encoded by the codec
+func (s *Store) codecObjPrefix(codecID string) string {
 	buf := new(strings.Builder)
-	buf.WriteString(s.codec.ID())
+	buf.WriteString(codecID)
 	buf.WriteString(s.prefix)
 	buf.WriteString(objPrefix)
 	return buf.String()
 }
 
 func (s *Store) nsPrefix(ns key.Namespace) string {
+	return s.codecNSPrefix(s.codec.ID(), ns)
+}
+
+func (s *Store) codecNSPrefix(codecID string, ns key.Namespace) string {
 	buf := new(strings.Builder)
-	buf.WriteString(s.objPrefix())
+	buf.WriteString(s.codecObjPrefix(codecID))
 	i := bytes.IndexByte(ns[:], '0')
 	if i == -1 {
 		buf.WriteString(hex.EncodeToString(ns[:]))
@@ -39,8 +48,12 @@ func (s *Store) nsPrefix(ns key.Namespace) string {
 }
 
 func (s *Store) keyToPath(key Key) string {
+	return s.codecKeyToPath(s.codec.ID(), key)
+}
+
+func (s *Store) codecKeyToPath(codecID string, key Key) string {
 	buf := new(strings.Builder)
-	buf.WriteString(s.nsPrefix(key.Namespace))
+	buf.WriteString(s.codecNSPrefix(codecID, key.Namespace))
 	buf.WriteString(key.Hash.String())
 	return buf.String()
 }
@@ -48,8 +61,12 @@ func (s *Store) keyToPath(key Key) string {
 var pathPattern = regexp.MustCompile(`([^)]+)/([0-9a-f]+)$`)
 
 func (s *Store) pathToKey(path string) (key Key, err error) {
+	return s.codecPathToKey(s.codec.ID(), path)
+}
+
+func (s *Store) codecPathToKey(codecID string, path string) (key Key, err error) {
 	defer he(&err, e5.Info("path %s", path))
-	path = strings.TrimPrefix(path, s.codec.ID())
+	path = strings.TrimPrefix(path, codecID)
 	path = strings.TrimPrefix(path, s.prefix)
 	path = strings.TrimPrefix(path, objPrefix)
 	parts := pathPattern.FindStringSubmatch(path)
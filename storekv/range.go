@@ -0,0 +1,145 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storekv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/store"
+	"github.com/reusee/sb"
+)
+
+// RangeKV is implemented by KVs that can read part of a value.
+// Ranges past the end of the value are truncated.
+type RangeKV interface {
+	KeyGetRange(key string, offset, length int64, fn func(io.Reader) error) error
+}
+
+var _ store.RangeReader = new(Store)
+
+// max encoded size of a bytes token header: kind, length prefix, uvarint
+const bytesHeaderMaxLen = 1 + 1 + binary.MaxVarintLen64
+
+func (s *Store) keyGetRange(kv RangeKV, path string, offset, length int64) (ret []byte, err error) {
+	err = kv.KeyGetRange(path, offset, length, func(r io.Reader) error {
+		ret, err = io.ReadAll(r)
+		return err
+	})
+	return
+}
+
+// parseBytesHeader parses the kind and length prefix of an encoded bytes token
+func parseBytesHeader(bs []byte) (headerLen int, length int64, ok bool) {
+	if len(bs) < 2 || sb.Kind(bs[0]) != sb.KindBytes {
+		return
+	}
+	if bs[1] < 128 {
+		return 2, int64(bs[1]), true
+	}
+	n := int(^bs[1])
+	if n > binary.MaxVarintLen64 || len(bs) < 2+n {
+		return
+	}
+	l, err := binary.ReadUvarint(bytes.NewReader(bs[2 : 2+n]))
+	if err != nil {
+		return
+	}
+	return 2 + n, int64(l), true
+}
+
+// ReadRange reads part of a bytes object.
+// Only values stored unencoded and not offloaded can be read partially, other values return ErrRangeNotSupported.
+// Array values are assumed to be chunked uniformly except the last chunk, as filebase.Content does, and layouts not matching that return ErrRangeNotSupported.
+func (s *Store) ReadRange(key Key, offset, length int64, fn func([]byte) error) (err error) {
+	defer he(&err, e5.With(key))
+
+	kv, ok := s.kv.(RangeKV)
+	if !ok ||
+		s.codec.ID() != DefaultCodec.ID() ||
+		len(s.offloads) > 0 {
+		return we.With(e5.With(key))(store.ErrRangeNotSupported)
+	}
+	if offset < 0 || length <= 0 {
+		return fn(nil)
+	}
+	path := s.keyToPath(key)
+
+	header, err := s.keyGetRange(kv, path, 0, 1+bytesHeaderMaxLen)
+	ce(err)
+	if len(header) == 0 {
+		return we.With(e5.With(key))(store.ErrRangeNotSupported)
+	}
+
+	switch sb.Kind(header[0]) {
+
+	case sb.KindBytes:
+		headerLen, total, ok := parseBytesHeader(header)
+		if !ok {
+			return we.With(e5.With(key))(store.ErrRangeNotSupported)
+		}
+		if offset >= total {
+			return fn(nil)
+		}
+		if offset+length > total {
+			length = total - offset
+		}
+		data, err := s.keyGetRange(kv, path, int64(headerLen)+offset, length)
+		ce(err)
+		return fn(data)
+
+	case sb.KindArray:
+		headerLen, chunkLen, ok := parseBytesHeader(header[1:])
+		if !ok || chunkLen == 0 {
+			return we.With(e5.With(key))(store.ErrRangeNotSupported)
+		}
+		stride := int64(headerLen) + chunkLen
+		index := offset / chunkLen
+		skip := offset % chunkLen
+		// read from the header of the first chunk to the end of the last needed chunk, and the next token kind
+		lastIndex := (offset + length - 1) / chunkLen
+		start := 1 + index*stride
+		end := 1 + (lastIndex+1)*stride + 1
+		raw, err := s.keyGetRange(kv, path, start, end-start)
+		ce(err)
+
+		data := make([]byte, 0, length)
+		for int64(len(data)) < length {
+			if len(raw) == 0 {
+				break
+			}
+			if sb.Kind(raw[0]) == sb.KindArrayEnd {
+				break
+			}
+			l, n, ok := parseBytesHeader(raw)
+			if !ok || int64(len(raw)) < int64(l)+n {
+				return we.With(e5.With(key))(store.ErrRangeNotSupported)
+			}
+			chunk := raw[l : int64(l)+n]
+			raw = raw[int64(l)+n:]
+			if n != chunkLen {
+				// short chunk must be the last
+				if len(raw) == 0 || sb.Kind(raw[0]) != sb.KindArrayEnd {
+					return we.With(e5.With(key))(store.ErrRangeNotSupported)
+				}
+			}
+			if skip >= int64(len(chunk)) {
+				break
+			}
+			chunk = chunk[skip:]
+			skip = 0
+			if remain := length - int64(len(data)); int64(len(chunk)) > remain {
+				chunk = chunk[:remain]
+			}
+			data = append(data, chunk...)
+		}
+		return fn(data)
+
+	}
+
+	return we.With(e5.With(key))(store.ErrRangeNotSupported)
+}
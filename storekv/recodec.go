@@ -0,0 +1,344 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storekv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/codec"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/opts"
+	"github.com/reusee/june/store"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+type RecodecOption interface {
+	IsRecodecOption()
+}
+
+// RecodecBatchSize is the number of keys rewritten between checkpoints
+type RecodecBatchSize int
+
+func (RecodecBatchSize) IsRecodecOption() {}
+
+// RecodecFrom is the codec of existing values, for stores not using a codec.OnionCodec
+type RecodecFrom struct {
+	Codec
+}
+
+func (RecodecFrom) IsRecodecOption() {}
+
+var ErrRecodecNotOnion = errors.New("recodec requires OnionCodec or RecodecFrom of another codec")
+
+type recodecProgress struct {
+	Last Key // keys not greater than Last are done
+}
+
+// Recodec rewrites values to the store codec.
+//
+// If RecodecFrom is provided with a codec other than the store codec, values under paths of that codec are decoded by it,
+// encoded by the store codec, written under paths of the store codec, then deleted from the old paths.
+// Keys not moved yet are not found in this store.
+// Otherwise the store codec must be a codec.OnionCodec, and values not encoded by its current encoders are rewritten in place,
+// since the onion codec id does not depend on encoders. Codecs of existing values must be in the onion decoder list.
+//
+// Keys are listed in pages of the same namespace and first hash byte, and rewritten in order.
+// Progress is saved to store.WithCheckpoint after each batch, and an interrupted run resumes from the last saved key.
+// Reads of this store are served during the run. For KVs not replacing existing values in KeyPut, values rewritten in place are deleted before putting,
+// reads of this store wait for the rewrite, but other stores sharing the KV may not see the key in the meantime.
+// opts.TapKey is called for rewritten keys, and opts.TapBadKey for keys not matching their content, which are skipped.
+func (s *Store) Recodec(
+	ctx context.Context,
+	options ...RecodecOption,
+) (err error) {
+	defer he(&err)
+
+	var tapKey opts.TapKey
+	var tapBad opts.TapBadKey
+	var checkpoint store.Checkpoint
+	var from Codec
+	batchSize := 1024
+	for _, option := range options {
+		switch option := option.(type) {
+		case opts.TapKey:
+			tapKey = option
+		case opts.TapBadKey:
+			tapBad = option
+		case store.WithCheckpoint:
+			checkpoint = option.Checkpoint
+		case RecodecBatchSize:
+			batchSize = int(option)
+		case RecodecFrom:
+			from = option.Codec
+		default:
+			panic(fmt.Errorf("unknown option: %T", option))
+		}
+	}
+
+	inPlace := from == nil || from.ID() == s.codec.ID()
+	var encoderIDs []string
+	if inPlace {
+		onion, ok := s.codec.(codec.OnionCodec)
+		if !ok {
+			return we.With(e5.Info("codec: %s", s.codec.ID()))(ErrRecodecNotOnion)
+		}
+		encoderIDs = onion.EncoderIDs()
+		from = s.codec
+	}
+	fromID := from.ID()
+
+	atomic.AddInt32(&s.recodecs, 1)
+	defer atomic.AddInt32(&s.recodecs, -1)
+
+	var progress recodecProgress
+	if checkpoint != nil {
+		_, err := checkpoint.Load(&progress)
+		ce(err)
+	}
+
+	recodecKey := func(key Key) (err error) {
+		defer he(&err, e5.With(key))
+
+		// exclusive with deletions and reads missing the key
+		l := s.keyLock(key)
+		l.Lock()
+		defer l.Unlock()
+
+		fromPath := s.codecKeyToPath(fromID, key)
+		var value []byte
+		err = s.kv.KeyGet(fromPath, func(r io.Reader) (err error) {
+			value, err = io.ReadAll(r)
+			return
+		})
+		if is(err, ErrKeyNotFound) {
+			// deleted
+			return nil
+		}
+		ce(err)
+
+		if inPlace {
+			ids, err := codec.OnionEncoderIDs(sb.Decode(bytes.NewReader(value)))
+			ce(err)
+			if sameIDs(ids, encoderIDs) {
+				return nil
+			}
+		}
+
+		var tokens sb.Tokens
+		var sum []byte
+		ce(sb.Copy(
+			from.Decode(sb.Decode(bytes.NewReader(value))),
+			sb.CollectTokens(&tokens),
+			sb.Hash(s.newHashState, &sum, nil),
+		))
+		if !isOffloaded(tokens) && !bytes.Equal(sum, key.Hash[:]) {
+			if tapBad != nil {
+				tapBad(key)
+			}
+			return nil
+		}
+
+		buf := new(bytes.Buffer)
+		ce(sb.Copy(
+			tokens.Iter(),
+			s.codec.Encode(sb.Encode(buf)),
+		))
+
+		if inPlace {
+			ce(s.replaceValue(fromPath, buf.Bytes()))
+		} else {
+			ce(s.kv.KeyPut(s.keyToPath(key), bytes.NewReader(buf.Bytes())))
+			ce(s.kv.KeyDelete(fromPath))
+		}
+
+		if tapKey != nil {
+			tapKey(key)
+		}
+		return nil
+	}
+
+	rewrite := func(batch []Key) (err error) {
+		defer he(&err)
+		wg := pr2.NewWaitGroup(ctx)
+		put, wait := pr2.Consume(wg, s.parallel, func(_ int, key Key) error {
+			return recodecKey(key)
+		})
+		for _, key := range batch {
+			put(key)
+		}
+		err = wait(true)
+		wg.Cancel()
+		ce(err)
+		// do not save progress of an interrupted batch
+		ce(ctx.Err())
+
+		progress.Last = batch[len(batch)-1]
+		if checkpoint != nil {
+			ce(checkpoint.Save(progress))
+		}
+		return nil
+	}
+
+	// batches span pages
+	var keys []Key
+	ce(s.recodecPages(ctx, fromID, progress.Last, func(page []Key) (err error) {
+		defer he(&err)
+		keys = append(keys, page...)
+		for len(keys) >= batchSize {
+			ce(rewrite(keys[:batchSize]))
+			keys = keys[batchSize:]
+		}
+		return nil
+	}))
+	if len(keys) > 0 {
+		ce(rewrite(keys))
+	}
+
+	if checkpoint != nil {
+		// next run starts over
+		ce(checkpoint.Save(recodecProgress{}))
+	}
+
+	return nil
+}
+
+// recodecPages calls fn with keys greater than last under paths of the codec, in order.
+// Namespaces are found by one listing, then keys are listed and passed in pages of the same namespace and first hash byte
+func (s *Store) recodecPages(
+	ctx context.Context,
+	codecID string,
+	last Key,
+	fn func([]Key) error,
+) (err error) {
+	defer he(&err)
+
+	nsSet := make(map[key.Namespace]struct{})
+	ce(s.kv.KeyIter(s.codecObjPrefix(codecID), func(path string) error {
+		key, err := s.codecPathToKey(codecID, path)
+		if err != nil {
+			// ignore
+			return nil
+		}
+		nsSet[key.Namespace] = struct{}{}
+		return nil
+	}))
+	namespaces := make([]key.Namespace, 0, len(nsSet))
+	for ns := range nsSet {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return bytes.Compare(namespaces[i][:], namespaces[j][:]) < 0
+	})
+
+	for _, ns := range namespaces {
+		if bytes.Compare(ns[:], last.Namespace[:]) < 0 {
+			continue
+		}
+		for i, shard := range shards {
+			if ns == last.Namespace && i < int(last.Hash[0]) {
+				continue
+			}
+			ce(ctx.Err())
+
+			var keys []Key
+			ce(s.kv.KeyIter(s.codecNSPrefix(codecID, ns)+shard, func(path string) error {
+				key, err := s.codecPathToKey(codecID, path)
+				if err != nil {
+					// ignore
+					return nil
+				}
+				if key.Namespace != ns || key.Compare(last) <= 0 {
+					return nil
+				}
+				keys = append(keys, key)
+				return nil
+			}))
+			if len(keys) == 0 {
+				continue
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				return keys[i].Compare(keys[j]) < 0
+			})
+			ce(fn(keys))
+		}
+	}
+
+	return nil
+}
+
+func sameIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isOffloaded(tokens sb.Tokens) bool {
+	if len(tokens) != 1 || tokens[0].Kind != sb.KindRef {
+		return false
+	}
+	value, ok := tokens[0].Value.([]byte)
+	return ok && bytes.Equal(value, []byte("offloaded"))
+}
+
+// keyLock returns the lock serializing Recodec rewrites with deletions and reads of the key
+func (s *Store) keyLock(key Key) *sync.RWMutex {
+	return &s.keyLocks[key.Hash[0]]
+}
+
+// lockKeys locks keys in lock order, for deletions
+func (s *Store) lockKeys(keys []Key) (unlock func()) {
+	var indexes []int
+	seen := make(map[byte]bool)
+	for _, key := range keys {
+		if seen[key.Hash[0]] {
+			continue
+		}
+		seen[key.Hash[0]] = true
+		indexes = append(indexes, int(key.Hash[0]))
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		s.keyLocks[i].Lock()
+	}
+	return func() {
+		for _, i := range indexes {
+			s.keyLocks[i].Unlock()
+		}
+	}
+}
+
+// readDuringRecodec calls fn excluding rewrites of the key during Recodec.
+// If Recodec starts while fn is running, fn is called again after the rewrite if it returns ErrKeyNotFound
+func (s *Store) readDuringRecodec(key Key, fn func() error) error {
+	if atomic.LoadInt32(&s.recodecs) > 0 {
+		l := s.keyLock(key)
+		l.RLock()
+		defer l.RUnlock()
+		return fn()
+	}
+	err := fn()
+	if !is(err, ErrKeyNotFound) || atomic.LoadInt32(&s.recodecs) == 0 {
+		return err
+	}
+	l := s.keyLock(key)
+	l.RLock()
+	defer l.RUnlock()
+	return fn()
+}
@@ -0,0 +1,48 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storekv
+
+import (
+	"io"
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/store"
+)
+
+type KeyInfo struct {
+	Size    int64
+	ModTime time.Time // zero if not known
+}
+
+// StatKV is implemented by KVs that can provide value metadata without reading the value
+type StatKV interface {
+	KeyStat(key string) (KeyInfo, error)
+}
+
+var _ store.Stater = new(Store)
+
+func (s *Store) Stat(key Key) (info store.ObjectInfo, err error) {
+	defer he(&err, e5.With(key))
+	path := s.keyToPath(key)
+	info.Key = key
+	info.CodecID = s.codec.ID()
+
+	if kv, ok := s.kv.(StatKV); ok {
+		kvInfo, err := kv.KeyStat(path)
+		ce(err)
+		info.EncodedLen = kvInfo.Size
+		info.WriteTime = kvInfo.ModTime
+		return info, nil
+	}
+
+	err = s.kv.KeyGet(path, func(r io.Reader) error {
+		n, err := io.Copy(io.Discard, r)
+		info.EncodedLen = n
+		return err
+	})
+	ce(err)
+	return
+}
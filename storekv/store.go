@@ -9,6 +9,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync/atomic"
 
 	"github.com/reusee/e5"
 	"github.com/reusee/june/key"
@@ -21,8 +22,16 @@ func (s *Store) ID() (StoreID, error) {
 	return s.getID()
 }
 
-func (s *Store) Exists(key Key) (bool, error) {
+func (s *Store) Exists(key Key) (ok bool, err error) {
 	path := s.keyToPath(key)
+	ok, err = s.kv.KeyExists(path)
+	if err != nil || ok || atomic.LoadInt32(&s.recodecs) == 0 {
+		return
+	}
+	// may be rewriting
+	l := s.keyLock(key)
+	l.RLock()
+	defer l.RUnlock()
 	return s.kv.KeyExists(path)
 }
 
@@ -167,71 +176,97 @@ func (s *Store) Read(key Key, fn func(sb.Stream) error) error {
 	}
 
 	path := s.keyToPath(key)
-	return s.kv.KeyGet(path, func(r io.Reader) (err error) {
-		defer he(&err)
-		var tokens sb.Tokens
-		var sum []byte
-
-		if len(s.offloads) > 0 {
-			// offload
-			if err := sb.Copy(
-				sb.Deref(
-					s.codec.Decode(sb.Decode(r)),
-					func(value []byte) (sb.Stream, error) {
-						if !bytes.Equal(value, []byte("offloaded")) {
-							return nil, nil
-						}
-						for _, offload := range s.offloads {
-							offloadStore := offload(key, -1)
-							if offloadStore == nil {
-								continue
-							}
-							var offloadTokens sb.Tokens
-							err := offloadStore.Read(key, func(s sb.Stream) error {
-								return sb.Copy(
-									s,
-									sb.CollectTokens(&offloadTokens),
-								)
-							})
-							if is(err, ErrKeyNotFound) {
-								continue
-							}
-							ce(err)
-							return offloadTokens.Iter(), nil
-						}
-						return nil, nil
-					},
-				),
-				sb.CollectTokens(&tokens),
-				sb.Hash(s.newHashState, &sum, nil),
-			); err != nil {
-				return err
-			}
+	return s.readDuringRecodec(key, func() error {
+		return s.kv.KeyGet(path, func(r io.Reader) error {
+			return s.readValue(key, r, fn)
+		})
+	})
 
-		} else {
-			if err := sb.Copy(
+}
+
+func (s *Store) readValue(key Key, r io.Reader, fn func(sb.Stream) error) (err error) {
+	defer he(&err)
+	var tokens sb.Tokens
+	var sum []byte
+
+	if len(s.offloads) > 0 {
+		// offload
+		if err := sb.Copy(
+			sb.Deref(
 				s.codec.Decode(sb.Decode(r)),
-				sb.CollectTokens(&tokens),
-				sb.Hash(s.newHashState, &sum, nil),
-			); err != nil {
-				return err
-			}
+				func(value []byte) (sb.Stream, error) {
+					if !bytes.Equal(value, []byte("offloaded")) {
+						return nil, nil
+					}
+					for _, offload := range s.offloads {
+						offloadStore := offload(key, -1)
+						if offloadStore == nil {
+							continue
+						}
+						var offloadTokens sb.Tokens
+						err := offloadStore.Read(key, func(s sb.Stream) error {
+							return sb.Copy(
+								s,
+								sb.CollectTokens(&offloadTokens),
+							)
+						})
+						if is(err, ErrKeyNotFound) {
+							continue
+						}
+						ce(err)
+						return offloadTokens.Iter(), nil
+					}
+					return nil, nil
+				},
+			),
+			sb.CollectTokens(&tokens),
+			sb.Hash(s.newHashState, &sum, nil),
+		); err != nil {
+			return err
 		}
 
-		if !bytes.Equal(key.Hash[:], sum) {
-			return we.With(e5.With(key))(ErrKeyNotMatch)
+	} else {
+		if err := sb.Copy(
+			s.codec.Decode(sb.Decode(r)),
+			sb.CollectTokens(&tokens),
+			sb.Hash(s.newHashState, &sum, nil),
+		); err != nil {
+			return err
 		}
-		err = fn(tokens.Iter())
-		ce(err, e5.With(ErrRead), e5.With(key))
-		return nil
-	})
+	}
 
+	if !bytes.Equal(key.Hash[:], sum) {
+		return we.With(e5.With(key))(ErrKeyNotMatch)
+	}
+	err = fn(tokens.Iter())
+	ce(err, e5.With(ErrRead), e5.With(key))
+	return nil
 }
 
 func (s *Store) Write(
 	ns key.Namespace,
 	stream sb.Stream,
 	options ...WriteOption,
+) (res store.WriteResult, err error) {
+	return s.write(ns, stream, false, options...)
+}
+
+var _ store.Rewriter = new(Store)
+
+// Rewrite writes the object even if the key exists, replacing the stored value
+func (s *Store) Rewrite(
+	ns key.Namespace,
+	stream sb.Stream,
+	options ...WriteOption,
+) (res store.WriteResult, err error) {
+	return s.write(ns, stream, true, options...)
+}
+
+func (s *Store) write(
+	ns key.Namespace,
+	stream sb.Stream,
+	rewrite bool,
+	options ...WriteOption,
 ) (res store.WriteResult, err error) {
 	defer he(&err)
 
@@ -281,7 +316,7 @@ func (s *Store) Write(
 
 	path := s.keyToPath(res.Key)
 
-	if s.costInfo.Exists <= s.costInfo.Put {
+	if !rewrite && s.costInfo.Exists <= s.costInfo.Put {
 		var ok bool
 		ok, err = s.kv.KeyExists(path)
 		ce(err)
@@ -299,7 +334,13 @@ func (s *Store) Write(
 				continue
 			}
 			offloaded = true
-			result, e := offloadStore.Write(ns, tokens.Iter(), options...)
+			var result WriteResult
+			var e error
+			if rewriter, ok := offloadStore.(store.Rewriter); ok && rewrite {
+				result, e = rewriter.Rewrite(ns, tokens.Iter(), options...)
+			} else {
+				result, e = offloadStore.Write(ns, tokens.Iter(), options...)
+			}
 			ce(e)
 			if result.Key != res.Key {
 				err = we(ErrKeyNotMatch)
@@ -341,7 +382,11 @@ func (s *Store) Write(
 	}
 	res.BytesWritten += int64(buf.Len())
 
-	err = s.kv.KeyPut(path, buf)
+	if rewrite {
+		err = s.replaceValue(path, buf.Bytes())
+	} else {
+		err = s.kv.KeyPut(path, buf)
+	}
 	ce(err)
 
 	res.Written = true
@@ -349,10 +394,32 @@ func (s *Store) Write(
 	return
 }
 
+// replaceValue puts the value replacing the existing one.
+// KVs may skip existing keys in KeyPut, values are deleted and put again for them
+func (s *Store) replaceValue(path string, value []byte) (err error) {
+	defer he(&err)
+	ce(s.kv.KeyPut(path, bytes.NewReader(value)))
+	replaced := false
+	err = s.kv.KeyGet(path, func(r io.Reader) error {
+		bs, err := io.ReadAll(r)
+		replaced = bytes.Equal(bs, value)
+		return err
+	})
+	if !is(err, ErrKeyNotFound) {
+		ce(err)
+	}
+	if !replaced {
+		ce(s.kv.KeyDelete(path))
+		ce(s.kv.KeyPut(path, bytes.NewReader(value)))
+	}
+	return nil
+}
+
 func (s *Store) Delete(keys []Key) error {
 	var paths []string
 	for _, key := range keys {
 		paths = append(paths, s.keyToPath(key))
 	}
+	defer s.lockKeys(keys)()
 	return s.kv.KeyDelete(paths...)
 }
@@ -7,6 +7,7 @@ package storekv
 import (
 	"context"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/reusee/dscope"
@@ -107,6 +108,25 @@ func (Def) TestKV(
 		}
 		testStore(ctx, withStore, t)
 
+		// batch existence checks by listing
+		withStore = func(storeFunc func(store.Store), provides ...any) {
+			with(func(kv KV, prefix string) {
+				scope.Fork(provides...).Call(func(
+					newStore New,
+					codec Codec,
+				) {
+					store, err := newStore(
+						ctx,
+						costlyExistsKV{kv}, prefix,
+						WithCodec(codec),
+					)
+					ce(err)
+					storeFunc(store)
+				})
+			})
+		}
+		testStore(ctx, withStore, t)
+
 		// errors
 		with(func(kv KV, _ string) {
 			key := "foo"
@@ -128,5 +148,45 @@ func (Def) TestKV(
 
 		})
 
+		// create
+		with(func(kv KV, _ string) {
+			c, ok := kv.(CreateKV)
+			if !ok {
+				return
+			}
+			key := "created"
+			created, err := c.KeyCreate(key, strings.NewReader("foo"))
+			ce(err)
+			if !created {
+				t.Fatal()
+			}
+			created, err = c.KeyCreate(key, strings.NewReader("bar"))
+			ce(err)
+			if created {
+				t.Fatal()
+			}
+			ce(kv.KeyGet(key, func(r io.Reader) error {
+				bs, err := io.ReadAll(r)
+				ce(err)
+				if string(bs) != "foo" {
+					t.Fatalf("got %s", bs)
+				}
+				return nil
+			}))
+			ce(kv.KeyDelete(key))
+		})
+
+	}
+}
+
+// costlyExistsKV hides optional interfaces and makes listing cheaper than existence checks
+type costlyExistsKV struct {
+	KV
+}
+
+func (costlyExistsKV) CostInfo() CostInfo {
+	return CostInfo{
+		Exists: 100,
+		Iter:   1,
 	}
 }
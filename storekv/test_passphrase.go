@@ -0,0 +1,282 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storekv
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+// mapKV is a minimal KV for tests in this package
+type mapKV struct {
+	sync.Mutex
+	values map[string][]byte
+}
+
+var _ KV = new(mapKV)
+
+func newMapKV() *mapKV {
+	return &mapKV{
+		values: make(map[string][]byte),
+	}
+}
+
+func (m *mapKV) StoreID() string {
+	return "map"
+}
+
+func (m *mapKV) Name() string {
+	return "map"
+}
+
+func (m *mapKV) CostInfo() CostInfo {
+	return CostInfo{}
+}
+
+func (m *mapKV) KeyPut(key string, r io.Reader) error {
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.values[key]; !ok {
+		m.values[key] = bs
+	}
+	return nil
+}
+
+func (m *mapKV) KeyCreate(key string, r io.Reader) (bool, error) {
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.values[key]; ok {
+		return false, nil
+	}
+	m.values[key] = bs
+	return true, nil
+}
+
+func (m *mapKV) KeyGet(key string, fn func(io.Reader) error) error {
+	m.Lock()
+	bs, ok := m.values[key]
+	m.Unlock()
+	if !ok {
+		return we.With(e5.With(StringKey(key)))(ErrKeyNotFound)
+	}
+	return fn(bytes.NewReader(bs))
+}
+
+func (m *mapKV) KeyExists(key string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	_, ok := m.values[key]
+	return ok, nil
+}
+
+func (m *mapKV) KeyIter(prefix string, fn func(key string) error) error {
+	m.Lock()
+	var keys []string
+	for key := range m.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	m.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key); is(err, Break) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mapKV) KeyDelete(keys ...string) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, key := range keys {
+		delete(m.values, key)
+	}
+	return nil
+}
+
+func TestPassphrase(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	fastArgon := Argon2id{
+		Time:    1,
+		Memory:  1024,
+		Threads: 1,
+	}
+	fastScrypt := Scrypt{
+		N: 1024,
+		R: 8,
+		P: 1,
+	}
+
+	kv := newMapKV()
+	codec, err := PassphraseCodec(kv, "foo", []byte("foo"), fastArgon)
+	ce(err)
+	s, err := newStore(wg, kv, "foo", WithCodec(codec))
+	ce(err)
+	id, err := s.ID()
+	ce(err)
+	ns, err := key.NamespaceFromString("foo")
+	ce(err)
+	res, err := s.Write(ns, sb.Marshal(42))
+	ce(err)
+
+	// values are encrypted
+	kv.Lock()
+	for path, value := range kv.values {
+		if strings.Contains(path, passphrasePrefix) {
+			continue
+		}
+		if !strings.HasPrefix(path, codec.ID()) {
+			t.Fatalf("bad path: %s", path)
+		}
+		var i int
+		if err := sb.Copy(
+			sb.Decode(bytes.NewReader(value)),
+			sb.Unmarshal(&i),
+		); err == nil {
+			t.Fatal("not encrypted")
+		}
+	}
+	kv.Unlock()
+
+	read := func(codec Codec) {
+		s, err := newStore(wg, kv, "foo", WithCodec(codec))
+		ce(err)
+		id2, err := s.ID()
+		ce(err)
+		if id2 != id {
+			t.Fatalf("got %s", id2)
+		}
+		var i int
+		ce(s.Read(res.Key, func(stream sb.Stream) error {
+			return sb.Copy(stream, sb.Unmarshal(&i))
+		}))
+		if i != 42 {
+			t.Fatal()
+		}
+	}
+
+	// reopen
+	codec2, err := PassphraseCodec(kv, "foo", []byte("foo"))
+	ce(err)
+	if codec2.ID() != codec.ID() {
+		t.Fatal()
+	}
+	read(codec2)
+
+	// bad passphrase
+	_, err = PassphraseCodec(kv, "foo", []byte("bar"))
+	if !is(err, ErrBadPassphrase) {
+		t.Fatalf("got %v", err)
+	}
+	err = ChangePassphrase(kv, "foo", []byte("bar"), []byte("baz"))
+	if !is(err, ErrBadPassphrase) {
+		t.Fatalf("got %v", err)
+	}
+
+	// change
+	ce(ChangePassphrase(kv, "foo", []byte("foo"), []byte("bar"), fastScrypt))
+	_, err = PassphraseCodec(kv, "foo", []byte("foo"))
+	if !is(err, ErrBadPassphrase) {
+		t.Fatalf("got %v", err)
+	}
+	codec3, err := PassphraseCodec(kv, "foo", []byte("bar"))
+	ce(err)
+	if codec3.ID() != codec.ID() {
+		t.Fatal()
+	}
+	read(codec3)
+	n := 0
+	ce(kv.KeyIter("foo"+passphrasePrefix, func(string) error {
+		n++
+		return nil
+	}))
+	if n != 1 {
+		t.Fatalf("got %d", n)
+	}
+
+	// change again, keeping parameters
+	ce(ChangePassphrase(kv, "foo", []byte("bar"), []byte("baz")))
+	codec4, err := PassphraseCodec(kv, "foo", []byte("baz"))
+	ce(err)
+	read(codec4)
+	meta, _, err := loadPassphraseMeta(kv, "foo")
+	ce(err)
+	if meta.KDF.Name != "scrypt" || meta.Generation != 2 {
+		t.Fatalf("got %+v", meta)
+	}
+
+	// other prefix has its own key
+	codec5, err := PassphraseCodec(kv, "bar", []byte("baz"), fastArgon)
+	ce(err)
+	if codec5.ID() == codec.ID() {
+		t.Fatal()
+	}
+	_, err = PassphraseCodec(kv, "foo", []byte("qux"))
+	if !is(err, ErrBadPassphrase) {
+		t.Fatalf("got %v", err)
+	}
+
+	// concurrent first opens
+	const num = 8
+	codecs := make([]Codec, num)
+	errs := make([]error, num)
+	var w sync.WaitGroup
+	for i := 0; i < num; i++ {
+		i := i
+		w.Add(1)
+		go func() {
+			defer w.Done()
+			codecs[i], errs[i] = PassphraseCodec(kv, "baz", []byte("baz"), fastArgon)
+		}()
+	}
+	w.Wait()
+	for _, err := range errs {
+		ce(err)
+	}
+	s, err = newStore(wg, kv, "baz", WithCodec(codecs[0]))
+	ce(err)
+	res, err = s.Write(ns, sb.Marshal(42))
+	ce(err)
+	for _, codec := range codecs[1:] {
+		if codec.ID() != codecs[0].ID() {
+			t.Fatal()
+		}
+		s, err := newStore(wg, kv, "baz", WithCodec(codec))
+		ce(err)
+		var i int
+		ce(s.Read(res.Key, func(stream sb.Stream) error {
+			return sb.Copy(stream, sb.Unmarshal(&i))
+		}))
+		if i != 42 {
+			t.Fatal()
+		}
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storekv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/codec"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/opts"
+	"github.com/reusee/june/store"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+// overwriteKV replaces existing values in KeyPut
+type overwriteKV struct {
+	*mapKV
+}
+
+func (m overwriteKV) KeyPut(key string, r io.Reader) error {
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.values[key] = bs
+	return nil
+}
+
+type memCheckpoint struct {
+	sync.Mutex
+	value  []byte
+	onSave func()
+}
+
+var _ store.Checkpoint = new(memCheckpoint)
+
+func (m *memCheckpoint) Load(target any) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.value == nil {
+		return false, nil
+	}
+	return true, sb.Copy(
+		sb.Decode(bytes.NewReader(m.value)),
+		sb.Unmarshal(target),
+	)
+}
+
+func (m *memCheckpoint) Save(value any) error {
+	buf := new(bytes.Buffer)
+	if err := sb.Copy(
+		sb.Marshal(value),
+		sb.Encode(buf),
+	); err != nil {
+		return err
+	}
+	m.Lock()
+	m.value = buf.Bytes()
+	onSave := m.onSave
+	m.Unlock()
+	if onSave != nil {
+		onSave()
+	}
+	return nil
+}
+
+func TestRecodec(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	ns, err := key.NamespaceFromString("foo")
+	ce(err)
+	aesKey := bytes.Repeat([]byte("k"), 32)
+
+	oldCodec := codec.NewOnionCodec(
+		[]Codec{codec.HybridSnappy()},
+		[]Codec{codec.HybridSnappy()},
+	)
+	newEncoders := []Codec{codec.HybridZstd(), codec.AESGCM(aesKey)}
+	newCodec := codec.NewOnionCodec(
+		newEncoders,
+		append([]Codec{codec.HybridSnappy()}, newEncoders...),
+	)
+
+	for _, kv := range []KV{
+		newMapKV(),
+		overwriteKV{newMapKV()},
+	} {
+
+		oldStore, err := newStore(wg, kv, "foo", WithCodec(oldCodec))
+		ce(err)
+		const num = 200
+		var keys []Key
+		values := make(map[Key]string)
+		for i := 0; i < num; i++ {
+			value := fmt.Sprintf("%d-%s", i, bytes.Repeat([]byte("foo"), i))
+			res, err := oldStore.Write(ns, sb.Marshal(value))
+			ce(err)
+			keys = append(keys, res.Key)
+			values[res.Key] = value
+		}
+
+		s, err := newStore(wg, kv, "foo", WithCodec(newCodec))
+		ce(err)
+
+		// read while recodec
+		stop := make(chan struct{})
+		var readErr atomic.Value
+		var readers sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					key := keys[rand.Intn(len(keys))]
+					var value string
+					if err := s.Read(key, func(stream sb.Stream) error {
+						return sb.Copy(stream, sb.Unmarshal(&value))
+					}); err != nil {
+						readErr.Store(err)
+						return
+					}
+					if value != values[key] {
+						readErr.Store(fmt.Errorf("bad value"))
+						return
+					}
+				}
+			}()
+		}
+
+		// interrupted
+		checkpoint := new(memCheckpoint)
+		ctx, cancel := context.WithCancel(wg)
+		saves := 0
+		checkpoint.onSave = func() {
+			saves++
+			if saves == 3 {
+				cancel()
+			}
+		}
+		var n1 int64
+		err = s.Recodec(
+			ctx,
+			store.WithCheckpoint{Checkpoint: checkpoint},
+			RecodecBatchSize(16),
+			opts.TapKey(func(_ Key) {
+				atomic.AddInt64(&n1, 1)
+			}),
+		)
+		if !is(err, context.Canceled) {
+			t.Fatalf("got %v", err)
+		}
+		if n1 < 3*16 || n1 >= num {
+			t.Fatalf("got %d", n1)
+		}
+
+		// resume
+		checkpoint.onSave = nil
+		var n2 int64
+		ce(s.Recodec(
+			wg,
+			store.WithCheckpoint{Checkpoint: checkpoint},
+			RecodecBatchSize(16),
+			opts.TapKey(func(_ Key) {
+				atomic.AddInt64(&n2, 1)
+			}),
+		))
+		if n1+n2 != num {
+			t.Fatalf("got %d %d", n1, n2)
+		}
+
+		close(stop)
+		readers.Wait()
+		if err, ok := readErr.Load().(error); ok {
+			t.Fatal(err)
+		}
+
+		// all values encoded by new encoders
+		for _, key := range keys {
+			ce(kv.KeyGet(s.keyToPath(key), func(r io.Reader) error {
+				ids, err := codec.OnionEncoderIDs(sb.Decode(r))
+				ce(err)
+				if !sameIDs(ids, newCodec.EncoderIDs()) {
+					t.Fatalf("got %v", ids)
+				}
+				return nil
+			}))
+		}
+		newOnly, err := newStore(wg, kv, "foo", WithCodec(codec.NewOnionCodec(
+			newEncoders,
+			newEncoders,
+		)))
+		ce(err)
+		for _, key := range keys {
+			var value string
+			ce(newOnly.Read(key, func(stream sb.Stream) error {
+				return sb.Copy(stream, sb.Unmarshal(&value))
+			}))
+			if value != values[key] {
+				t.Fatal()
+			}
+		}
+
+		// nothing to rewrite
+		var n3 int64
+		ce(s.Recodec(
+			wg,
+			store.WithCheckpoint{Checkpoint: checkpoint},
+			opts.TapKey(func(_ Key) {
+				atomic.AddInt64(&n3, 1)
+			}),
+		))
+		if n3 != 0 {
+			t.Fatalf("got %d", n3)
+		}
+
+		// deleted keys are not rewritten back
+		ce(oldStore.Delete(keys[:1]))
+		res, err := oldStore.Write(ns, sb.Marshal("bar"))
+		ce(err)
+		ce(s.Recodec(wg))
+		ok, err := s.Exists(keys[0])
+		ce(err)
+		if ok {
+			t.Fatal()
+		}
+		var value string
+		ce(s.Read(res.Key, func(stream sb.Stream) error {
+			return sb.Copy(stream, sb.Unmarshal(&value))
+		}))
+		if value != "bar" {
+			t.Fatal()
+		}
+
+	}
+
+	// not onion
+	kv := newMapKV()
+	oldStore, err := newStore(wg, kv, "foo", WithCodec(codec.HybridSnappy()))
+	ce(err)
+	const num = 200
+	var keys []Key
+	values := make(map[Key]string)
+	for i := 0; i < num; i++ {
+		value := fmt.Sprintf("%d-%s", i, bytes.Repeat([]byte("foo"), i))
+		res, err := oldStore.Write(ns, sb.Marshal(value))
+		ce(err)
+		keys = append(keys, res.Key)
+		values[res.Key] = value
+	}
+	s, err := newStore(wg, kv, "foo", WithCodec(codec.HybridZstd()))
+	ce(err)
+	err = s.Recodec(wg)
+	if !is(err, ErrRecodecNotOnion) {
+		t.Fatalf("got %v", err)
+	}
+	err = s.Recodec(wg, RecodecFrom{codec.HybridZstd()})
+	if !is(err, ErrRecodecNotOnion) {
+		t.Fatalf("got %v", err)
+	}
+
+	// interrupted
+	checkpoint := new(memCheckpoint)
+	ctx, cancel := context.WithCancel(wg)
+	saves := 0
+	checkpoint.onSave = func() {
+		saves++
+		if saves == 3 {
+			cancel()
+		}
+	}
+	var n1 int64
+	err = s.Recodec(
+		ctx,
+		RecodecFrom{codec.HybridSnappy()},
+		store.WithCheckpoint{Checkpoint: checkpoint},
+		RecodecBatchSize(4),
+		opts.TapKey(func(_ Key) {
+			atomic.AddInt64(&n1, 1)
+		}),
+	)
+	if !is(err, context.Canceled) {
+		t.Fatalf("got %v", err)
+	}
+	if n1 < 3*4 || n1 >= num {
+		t.Fatalf("got %d", n1)
+	}
+
+	// resume
+	checkpoint.onSave = nil
+	var n2 int64
+	ce(s.Recodec(
+		wg,
+		RecodecFrom{codec.HybridSnappy()},
+		store.WithCheckpoint{Checkpoint: checkpoint},
+		RecodecBatchSize(4),
+		opts.TapKey(func(_ Key) {
+			atomic.AddInt64(&n2, 1)
+		}),
+	))
+	if n1+n2 != num {
+		t.Fatalf("got %d %d", n1, n2)
+	}
+
+	// moved to paths of the new codec
+	for _, key := range keys {
+		var value string
+		ce(s.Read(key, func(stream sb.Stream) error {
+			return sb.Copy(stream, sb.Unmarshal(&value))
+		}))
+		if value != values[key] {
+			t.Fatal()
+		}
+		ok, err := oldStore.Exists(key)
+		ce(err)
+		if ok {
+			t.Fatal()
+		}
+	}
+}
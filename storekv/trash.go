@@ -0,0 +1,66 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storekv
+
+import (
+	"time"
+
+	"github.com/reusee/june/store"
+)
+
+// TrashKV is implemented by KVs that can keep deleted values restorable
+type TrashKV interface {
+	KeyTrash(keys ...string) error
+	KeyRestore(keys ...string) error
+	TrashIter(prefix string, fn func(key string, deletedAt time.Time) error) error
+}
+
+var _ store.Trasher = new(Store)
+
+func (s *Store) trashKV() (TrashKV, error) {
+	kv, ok := s.kv.(TrashKV)
+	if !ok {
+		return nil, we(store.ErrTrashNotSupported)
+	}
+	return kv, nil
+}
+
+func (s *Store) Trash(keys []Key) (err error) {
+	defer he(&err)
+	kv, err := s.trashKV()
+	ce(err)
+	var paths []string
+	for _, key := range keys {
+		paths = append(paths, s.keyToPath(key))
+	}
+	defer s.lockKeys(keys)()
+	return kv.KeyTrash(paths...)
+}
+
+func (s *Store) Restore(keys []Key) (err error) {
+	defer he(&err)
+	kv, err := s.trashKV()
+	ce(err)
+	var paths []string
+	for _, key := range keys {
+		paths = append(paths, s.keyToPath(key))
+	}
+	defer s.lockKeys(keys)()
+	return kv.KeyRestore(paths...)
+}
+
+func (s *Store) IterTrash(fn func(key Key, deletedAt time.Time) error) (err error) {
+	defer he(&err)
+	kv, err := s.trashKV()
+	ce(err)
+	return kv.TrashIter(s.objPrefix(), func(k string, deletedAt time.Time) error {
+		key, err := s.pathToKey(k)
+		if err != nil {
+			// ignore
+			return nil
+		}
+		return fn(key, deletedAt)
+	})
+}
@@ -0,0 +1,29 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storelimit
+
+import (
+	"errors"
+
+	"github.com/reusee/june/juneerr"
+	"github.com/reusee/june/store"
+	"github.com/reusee/june/storekv"
+)
+
+type (
+	any = interface{}
+
+	CostInfo = storekv.CostInfo
+)
+
+var (
+	is = errors.Is
+
+	ce = juneerr.Check
+	he = juneerr.Handle
+	we = juneerr.Wrap
+
+	ErrClosed = store.ErrClosed
+)
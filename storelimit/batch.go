@@ -0,0 +1,65 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storelimit
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+)
+
+var _ storekv.BatchKV = new(KV)
+
+// KeyExistsMany is accounted as one exists check per key.
+// If upstream is not a BatchKV, keys are checked one by one
+func (k *KV) KeyExistsMany(keys []string) (_ []bool, err error) {
+	defer he(&err)
+	kv, ok := k.upstream.(storekv.BatchKV)
+	if !ok {
+		ret := make([]bool, len(keys))
+		for i, key := range keys {
+			ret[i], err = k.KeyExists(key)
+			ce(err)
+		}
+		return ret, nil
+	}
+	ce(k.acquire(len(keys), k.costInfo.Exists, func(u *Usage) *int64 {
+		return &u.Exists
+	}))
+	return kv.KeyExistsMany(keys)
+}
+
+// KeyGetMany is accounted as one get per key.
+// If upstream is not a BatchKV, keys are read one by one
+func (k *KV) KeyGetMany(keys []string, fn func(key string, r io.Reader) error) (err error) {
+	defer he(&err)
+	kv, ok := k.upstream.(storekv.BatchKV)
+	if !ok {
+		for _, key := range keys {
+			ce(k.KeyGet(key, func(r io.Reader) error {
+				return fn(key, r)
+			}), e5.Info("key %s", key))
+		}
+		return nil
+	}
+	ce(k.acquire(len(keys), k.costInfo.Get, func(u *Usage) *int64 {
+		return &u.Gets
+	}))
+	var n int64
+	err = kv.KeyGetMany(keys, func(key string, r io.Reader) error {
+		cr := &countReader{
+			Reader: r,
+		}
+		defer func() {
+			atomic.AddInt64(&n, cr.n)
+		}()
+		return fn(key, cr)
+	})
+	k.done(n)
+	ce(err)
+	return nil
+}
@@ -0,0 +1,375 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/store"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+)
+
+// KV limits request rate, bandwidth and cost of the upstream KV.
+// Cost of each operation is taken from upstream CostInfo
+type KV struct {
+	wg         *pr2.WaitGroup
+	name       string
+	upstream   storekv.KV
+	costInfo   CostInfo
+	reject     bool
+	budget     int64
+	checkpoint store.Checkpoint
+	interval   time.Duration
+	now        func() time.Time
+
+	mu       sync.Mutex
+	requests *bucket
+	bytes    *bucket
+	usage    Usage
+	dirty    bool
+	saveErr  error // last error of saving usage
+}
+
+// Usage is the consumption of current budget period
+type Usage struct {
+	PeriodStart time.Time // start of the calendar month, in UTC
+	Puts        int64
+	Gets        int64
+	Exists      int64
+	Iters       int64
+	Deletes     int64
+	Bytes       int64 // bytes put or got
+	CostUnits   int64
+	Delayed     int64 // operations queued by rate limits
+	Rejected    int64
+}
+
+type New func(
+	ctx context.Context,
+	upstream storekv.KV,
+	options ...Option,
+) (*KV, error)
+
+type Option interface {
+	IsOption()
+}
+
+// RequestsPerSecond limits the rate of operations
+type RequestsPerSecond float64
+
+func (RequestsPerSecond) IsOption() {}
+
+// BytesPerSecond limits the bandwidth of puts and gets.
+// Bytes are accounted after transferring, so a large transfer delays the following operations
+type BytesPerSecond float64
+
+func (BytesPerSecond) IsOption() {}
+
+// MonthlyBudget is the max cost units per calendar month.
+// Operations exceeding the budget are rejected with ErrBudgetExceeded
+type MonthlyBudget int64
+
+func (MonthlyBudget) IsOption() {}
+
+// RejectExcess makes operations exceeding rate limits return ErrRateLimited instead of waiting
+type RejectExcess bool
+
+func (RejectExcess) IsOption() {}
+
+// PersistUsage saves usage to the checkpoint, so budget consumption survives restarts.
+// Usage is saved periodically and on close
+type PersistUsage struct {
+	store.Checkpoint
+}
+
+func (PersistUsage) IsOption() {}
+
+// SaveInterval is the interval of saving usage to the PersistUsage checkpoint. Default is 10 seconds
+type SaveInterval time.Duration
+
+func (SaveInterval) IsOption() {}
+
+var (
+	ErrRateLimited    = errors.New("rate limited")
+	ErrBudgetExceeded = errors.New("budget exceeded")
+)
+
+func (Def) New() New {
+	return func(
+		ctx context.Context,
+		upstream storekv.KV,
+		options ...Option,
+	) (_ *KV, err error) {
+		defer he(&err)
+
+		kv := &KV{
+			wg: pr2.NewWaitGroup(ctx),
+			name: fmt.Sprintf("limit%d(%s)",
+				atomic.AddInt64(&serial, 1),
+				upstream.Name(),
+			),
+			upstream: upstream,
+			costInfo: upstream.CostInfo(),
+			interval: time.Second * 10,
+			now:      time.Now,
+		}
+
+		for _, option := range options {
+			switch option := option.(type) {
+			case RequestsPerSecond:
+				kv.requests = newBucket(float64(option))
+			case BytesPerSecond:
+				kv.bytes = newBucket(float64(option))
+			case MonthlyBudget:
+				kv.budget = int64(option)
+			case RejectExcess:
+				kv.reject = bool(option)
+			case PersistUsage:
+				kv.checkpoint = option.Checkpoint
+			case SaveInterval:
+				kv.interval = time.Duration(option)
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+
+		if kv.checkpoint != nil {
+			_, err := kv.checkpoint.Load(&kv.usage)
+			ce(err)
+			kv.wg.Go(kv.saveLoop)
+		}
+
+		return kv, nil
+	}
+}
+
+var serial int64
+
+var _ storekv.KV = new(KV)
+
+func (k *KV) StoreID() string {
+	return k.upstream.StoreID()
+}
+
+func (k *KV) Name() string {
+	return k.name
+}
+
+func (k *KV) CostInfo() CostInfo {
+	return k.costInfo
+}
+
+// Usage returns consumption of current budget period
+func (k *KV) Usage() Usage {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.rollPeriod(k.now())
+	return k.usage
+}
+
+func periodStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (k *KV) rollPeriod(now time.Time) {
+	start := periodStart(now)
+	if !k.usage.PeriodStart.Equal(start) {
+		k.usage = Usage{
+			PeriodStart: start,
+		}
+	}
+}
+
+// acquire waits or rejects per limits, and records n operations
+func (k *KV) acquire(n int, cost int, counter func(*Usage) *int64) (err error) {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	k.mu.Lock()
+	if k.saveErr != nil {
+		err := k.saveErr
+		k.mu.Unlock()
+		return we(err)
+	}
+	now := k.now()
+	k.rollPeriod(now)
+
+	if k.budget > 0 && k.usage.CostUnits+int64(n*cost) > k.budget {
+		k.usage.Rejected++
+		k.dirty = true
+		usage := k.usage
+		k.mu.Unlock()
+		return we.With(
+			e5.Info("used %d of %d", usage.CostUnits, k.budget),
+		)(ErrBudgetExceeded)
+	}
+
+	if k.reject {
+		if (k.requests != nil && k.requests.available(now) < float64(n)) ||
+			(k.bytes != nil && k.bytes.available(now) < 0) {
+			k.usage.Rejected++
+			k.dirty = true
+			k.mu.Unlock()
+			return we(ErrRateLimited)
+		}
+	}
+
+	var wait time.Duration
+	if k.requests != nil {
+		wait = k.requests.take(now, float64(n))
+	}
+	if k.bytes != nil {
+		if w := k.bytes.take(now, 0); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		k.usage.Delayed++
+	}
+	k.usage.CostUnits += int64(n * cost)
+	*counter(&k.usage) += int64(n)
+	k.dirty = true
+	k.mu.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-k.wg.Done():
+			return ErrClosed
+		}
+	}
+
+	return nil
+}
+
+// done records transferred bytes
+func (k *KV) done(n int64) {
+	if n == 0 {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.usage.Bytes += n
+	k.dirty = true
+	if k.bytes != nil {
+		k.bytes.take(k.now(), float64(n))
+	}
+}
+
+func (k *KV) saveLoop() {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			k.save()
+		case <-k.wg.Done():
+			k.save()
+			return
+		}
+	}
+}
+
+// save persists usage if changed. errors are returned by following operations
+func (k *KV) save() {
+	k.mu.Lock()
+	if !k.dirty {
+		k.mu.Unlock()
+		return
+	}
+	usage := k.usage
+	k.dirty = false
+	k.mu.Unlock()
+	err := k.checkpoint.Save(usage)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err != nil {
+		k.dirty = true
+	}
+	k.saveErr = err
+}
+
+type countReader struct {
+	io.Reader
+	n int64
+}
+
+func (c *countReader) Read(buf []byte) (int, error) {
+	n, err := c.Reader.Read(buf)
+	c.n += int64(n)
+	return n, err
+}
+
+func (k *KV) KeyPut(key string, r io.Reader) (err error) {
+	defer he(&err)
+	ce(k.acquire(1, k.costInfo.Put, func(u *Usage) *int64 {
+		return &u.Puts
+	}))
+	cr := &countReader{
+		Reader: r,
+	}
+	err = k.upstream.KeyPut(key, cr)
+	k.done(cr.n)
+	ce(err)
+	return nil
+}
+
+func (k *KV) KeyGet(key string, fn func(io.Reader) error) (err error) {
+	defer he(&err)
+	ce(k.acquire(1, k.costInfo.Get, func(u *Usage) *int64 {
+		return &u.Gets
+	}))
+	var n int64
+	err = k.upstream.KeyGet(key, func(r io.Reader) error {
+		cr := &countReader{
+			Reader: r,
+		}
+		defer func() {
+			n = cr.n
+		}()
+		return fn(cr)
+	})
+	k.done(n)
+	ce(err)
+	return nil
+}
+
+func (k *KV) KeyExists(key string) (_ bool, err error) {
+	defer he(&err)
+	ce(k.acquire(1, k.costInfo.Exists, func(u *Usage) *int64 {
+		return &u.Exists
+	}))
+	return k.upstream.KeyExists(key)
+}
+
+func (k *KV) KeyIter(prefix string, fn func(key string) error) (err error) {
+	defer he(&err)
+	ce(k.acquire(1, k.costInfo.Iter, func(u *Usage) *int64 {
+		return &u.Iters
+	}))
+	return k.upstream.KeyIter(prefix, fn)
+}
+
+func (k *KV) KeyDelete(keys ...string) (err error) {
+	defer he(&err)
+	ce(k.acquire(1, k.costInfo.Delete, func(u *Usage) *int64 {
+		return &u.Deletes
+	}))
+	return k.upstream.KeyDelete(keys...)
+}
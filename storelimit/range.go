@@ -0,0 +1,44 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storelimit
+
+import (
+	"io"
+
+	"github.com/reusee/june/storekv"
+)
+
+var _ storekv.RangeKV = new(KV)
+
+// KeyGetRange is accounted as a get.
+// If upstream is not a RangeKV, the value is read from the start
+func (k *KV) KeyGetRange(key string, offset, length int64, fn func(io.Reader) error) (err error) {
+	defer he(&err)
+	kv, ok := k.upstream.(storekv.RangeKV)
+	if !ok {
+		return k.KeyGet(key, func(r io.Reader) error {
+			if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+				return err
+			}
+			return fn(io.LimitReader(r, length))
+		})
+	}
+	ce(k.acquire(1, k.costInfo.Get, func(u *Usage) *int64 {
+		return &u.Gets
+	}))
+	var n int64
+	err = kv.KeyGetRange(key, offset, length, func(r io.Reader) error {
+		cr := &countReader{
+			Reader: r,
+		}
+		defer func() {
+			n = cr.n
+		}()
+		return fn(cr)
+	})
+	k.done(n)
+	ce(err)
+	return nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storelimit
+
+import (
+	"io"
+
+	"github.com/reusee/june/storekv"
+)
+
+var _ storekv.StatKV = new(KV)
+
+// KeyStat is accounted as an exists check.
+// If upstream is not a StatKV, the value is read and accounted as a get
+func (k *KV) KeyStat(key string) (info storekv.KeyInfo, err error) {
+	defer he(&err)
+	kv, ok := k.upstream.(storekv.StatKV)
+	if !ok {
+		ce(k.KeyGet(key, func(r io.Reader) (err error) {
+			info.Size, err = io.Copy(io.Discard, r)
+			return
+		}))
+		return
+	}
+	ce(k.acquire(1, k.costInfo.Exists, func(u *Usage) *int64 {
+		return &u.Exists
+	}))
+	return kv.KeyStat(key)
+}
@@ -0,0 +1,282 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storelimit
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/june/storemem"
+	"github.com/reusee/june/vars"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+func TestKV(
+	t *testing.T,
+	test storekv.TestKV,
+	newMem storemem.New,
+	newLimit New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+	with := func(fn func(storekv.KV, string)) {
+		kv, err := newLimit(
+			wg,
+			newMem(wg),
+			RequestsPerSecond(1e6),
+			BytesPerSecond(1e9),
+		)
+		ce(err)
+		fn(kv, "foo")
+	}
+	test(wg, t, with)
+}
+
+type costlyKV struct {
+	storekv.KV
+}
+
+func (costlyKV) CostInfo() CostInfo {
+	return CostInfo{
+		Put: 5,
+		Get: 1,
+	}
+}
+
+func TestLimit(
+	t *testing.T,
+	scope dscope.Scope,
+	newMem storemem.New,
+	newLimit New,
+	newKV storekv.New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	t.Run("requests", func(t *testing.T) {
+		defer he(nil, e5.TestingFatal(t))
+		kv, err := newLimit(wg, newMem(wg), RequestsPerSecond(20))
+		ce(err)
+		t0 := time.Now()
+		for i := 0; i < 30; i++ {
+			_, err := kv.KeyExists("foo")
+			ce(err)
+		}
+		if d := time.Since(t0); d < time.Millisecond*400 {
+			t.Fatalf("got %v", d)
+		}
+		usage := kv.Usage()
+		if usage.Exists != 30 {
+			t.Fatalf("got %+v", usage)
+		}
+		if usage.Delayed != 10 {
+			t.Fatalf("got %+v", usage)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		defer he(nil, e5.TestingFatal(t))
+		kv, err := newLimit(wg, newMem(wg), RequestsPerSecond(1), RejectExcess(true))
+		ce(err)
+		_, err = kv.KeyExists("foo")
+		ce(err)
+		_, err = kv.KeyExists("foo")
+		if !is(err, ErrRateLimited) {
+			t.Fatal()
+		}
+		if usage := kv.Usage(); usage.Rejected != 1 || usage.Exists != 1 {
+			t.Fatalf("got %+v", usage)
+		}
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		defer he(nil, e5.TestingFatal(t))
+		kv, err := newLimit(wg, newMem(wg), BytesPerSecond(1000))
+		ce(err)
+		t0 := time.Now()
+		ce(kv.KeyPut("foo", strings.NewReader(strings.Repeat("x", 1500))))
+		ce(kv.KeyGet("foo", func(r io.Reader) error {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		}))
+		if d := time.Since(t0); d < time.Millisecond*400 {
+			t.Fatalf("got %v", d)
+		}
+		if usage := kv.Usage(); usage.Bytes != 3000 {
+			t.Fatalf("got %+v", usage)
+		}
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		defer he(nil, e5.TestingFatal(t))
+		scope.Fork(func() vars.VarsSpec {
+			return func() (string, *pr2.WaitGroup) {
+				return t.TempDir(), wg
+			}
+		}).Call(func(
+			newCheckpoint vars.NewCheckpoint,
+		) {
+			upstream := costlyKV{
+				KV: newMem(wg),
+			}
+			checkpoint := newCheckpoint("limit")
+			ctx := pr2.NewWaitGroup(wg)
+			kv, err := newLimit(
+				ctx, upstream,
+				MonthlyBudget(12),
+				PersistUsage{checkpoint},
+			)
+			ce(err)
+			now := time.Date(2021, 6, 15, 0, 0, 0, 0, time.UTC)
+			kv.now = func() time.Time {
+				return now
+			}
+			ce(kv.KeyPut("foo", bytes.NewReader(nil)))
+			ce(kv.KeyPut("bar", bytes.NewReader(nil)))
+			err = kv.KeyPut("baz", bytes.NewReader(nil))
+			if !is(err, ErrBudgetExceeded) {
+				t.Fatal()
+			}
+			ce(kv.KeyGet("foo", func(io.Reader) error {
+				return nil
+			}))
+			usage := kv.Usage()
+			if usage.CostUnits != 11 || usage.Puts != 2 || usage.Gets != 1 {
+				t.Fatalf("got %+v", usage)
+			}
+
+			// restart
+			ctx.Cancel()
+			ctx.Wait()
+			kv, err = newLimit(
+				wg, upstream,
+				MonthlyBudget(12),
+				PersistUsage{checkpoint},
+			)
+			ce(err)
+			kv.now = func() time.Time {
+				return now
+			}
+			if usage := kv.Usage(); usage.CostUnits != 11 {
+				t.Fatalf("got %+v", usage)
+			}
+			err = kv.KeyPut("baz", bytes.NewReader(nil))
+			if !is(err, ErrBudgetExceeded) {
+				t.Fatal()
+			}
+
+			// next month
+			now = now.AddDate(0, 1, 0)
+			ce(kv.KeyPut("baz", bytes.NewReader(nil)))
+			usage = kv.Usage()
+			if usage.CostUnits != 5 || !usage.PeriodStart.Equal(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Fatalf("got %+v", usage)
+			}
+		})
+	})
+
+	t.Run("optional interfaces", func(t *testing.T) {
+		defer he(nil, e5.TestingFatal(t))
+		for _, upstream := range []storekv.KV{
+			newMem(wg),
+			// without optional interfaces
+			costlyKV{
+				KV: newMem(wg),
+			},
+		} {
+			kv, err := newLimit(wg, upstream)
+			ce(err)
+			ce(kv.KeyPut("foo", strings.NewReader("foobar")))
+			ce(kv.KeyGetRange("foo", 3, 2, func(r io.Reader) error {
+				bs, err := io.ReadAll(r)
+				ce(err)
+				if string(bs) != "ba" {
+					t.Fatalf("got %s", bs)
+				}
+				return nil
+			}))
+			info, err := kv.KeyStat("foo")
+			ce(err)
+			if info.Size != 6 {
+				t.Fatalf("got %+v", info)
+			}
+			oks, err := kv.KeyExistsMany([]string{"foo", "bar"})
+			ce(err)
+			if !oks[0] || oks[1] {
+				t.Fatal()
+			}
+			n := 0
+			ce(kv.KeyGetMany([]string{"foo"}, func(key string, r io.Reader) error {
+				n++
+				_, err := io.Copy(io.Discard, r)
+				return err
+			}))
+			if n != 1 {
+				t.Fatal()
+			}
+			if usage := kv.Usage(); usage.Gets < 2 || usage.Exists < 2 {
+				t.Fatalf("got %+v", usage)
+			}
+		}
+	})
+
+	t.Run("save on close", func(t *testing.T) {
+		defer he(nil, e5.TestingFatal(t))
+		scope.Fork(func() vars.VarsSpec {
+			return func() (string, *pr2.WaitGroup) {
+				return t.TempDir(), wg
+			}
+		}).Call(func(
+			newCheckpoint vars.NewCheckpoint,
+		) {
+			checkpoint := newCheckpoint("limit")
+			ctx := pr2.NewWaitGroup(wg)
+			kv, err := newLimit(ctx, newMem(wg), PersistUsage{checkpoint}, SaveInterval(time.Hour))
+			ce(err)
+			ce(kv.KeyPut("foo", strings.NewReader("foo")))
+			var usage Usage
+			ok, err := checkpoint.Load(&usage)
+			ce(err)
+			if ok {
+				t.Fatal()
+			}
+			ctx.Cancel()
+			ctx.Wait()
+			ok, err = checkpoint.Load(&usage)
+			ce(err)
+			if !ok || usage.Puts != 1 || usage.Bytes != 3 {
+				t.Fatalf("got %+v", usage)
+			}
+		})
+	})
+
+	t.Run("store", func(t *testing.T) {
+		defer he(nil, e5.TestingFatal(t))
+		kv, err := newLimit(wg, newMem(wg), RequestsPerSecond(1000))
+		ce(err)
+		s, err := newKV(wg, kv, "foo")
+		ce(err)
+		res, err := s.Write(key.Namespace{'f', 'o', 'o'}, sb.Marshal(42))
+		ce(err)
+		var i int
+		ce(s.Read(res.Key, func(stream sb.Stream) error {
+			return sb.Copy(stream, sb.Unmarshal(&i))
+		}))
+		if i != 42 {
+			t.Fatal()
+		}
+		if usage := kv.Usage(); usage.Puts == 0 || usage.Gets == 0 {
+			t.Fatalf("got %+v", usage)
+		}
+	})
+}
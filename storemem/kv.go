@@ -49,6 +49,22 @@ func (s *Store) KeyGet(key string, fn func(r io.Reader) error) (err error) {
 	return nil
 }
 
+var _ storekv.StatKV = new(Store)
+
+func (s *Store) KeyStat(key string) (info storekv.KeyInfo, err error) {
+	select {
+	case <-s.wg.Done():
+		return info, ErrClosed
+	default:
+	}
+	v, ok := s.values.Load(key)
+	if !ok {
+		return info, we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
+	}
+	info.Size = int64(len(v.([]byte)))
+	return
+}
+
 func (s *Store) KeyIter(prefix string, fn func(key string) error) error {
 	select {
 	case <-s.wg.Done():
@@ -87,6 +103,22 @@ func (s *Store) KeyPut(key string, r io.Reader) error {
 	return nil
 }
 
+var _ storekv.CreateKV = new(Store)
+
+func (s *Store) KeyCreate(key string, r io.Reader) (bool, error) {
+	select {
+	case <-s.wg.Done():
+		return false, ErrClosed
+	default:
+	}
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	_, loaded := s.values.LoadOrStore(key, bs)
+	return !loaded, nil
+}
+
 func (s *Store) KeyDelete(keys ...string) error {
 	select {
 	case <-s.wg.Done():
@@ -98,3 +130,25 @@ func (s *Store) KeyDelete(keys ...string) error {
 	}
 	return nil
 }
+
+var _ storekv.RangeKV = new(Store)
+
+func (s *Store) KeyGetRange(key string, offset, length int64, fn func(io.Reader) error) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer he(&err,
+		e5.With(storekv.StringKey(key)),
+	)
+	v, ok := s.values.Load(key)
+	if !ok {
+		return we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
+	}
+	if fn != nil {
+		err := fn(io.NewSectionReader(bytes.NewReader(v.([]byte)), offset, length))
+		ce(err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storemirror
+
+import (
+	"errors"
+
+	"github.com/reusee/june/juneerr"
+	"github.com/reusee/june/store"
+)
+
+type (
+	any = interface{}
+
+	Key         = store.Key
+	StoreID     = store.ID
+	WriteResult = store.WriteResult
+	WriteOption = store.WriteOption
+)
+
+var (
+	is = errors.Is
+
+	ce = juneerr.Check
+	he = juneerr.Handle
+	we = juneerr.Wrap
+
+	Break          = store.Break
+	ErrClosed      = store.ErrClosed
+	ErrKeyNotFound = store.ErrKeyNotFound
+	ErrKeyNotMatch = store.ErrKeyNotMatch
+	ErrRead        = store.ErrRead
+)
@@ -0,0 +1,142 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storemirror
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/opts"
+	"github.com/reusee/june/store"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+type RepairOption interface {
+	IsRepairOption()
+}
+
+// TapRepair is called after key is written to a lagging or corrupted replica
+type TapRepair func(key Key, replica store.Store)
+
+func (TapRepair) IsRepairOption() {}
+
+// Repair verifies all copies of keys, and rewrites missing or corrupted copies with a good one.
+// Keys without a good copy are reported by opts.TapBadKey
+func (s *Store) Repair(
+	ctx context.Context,
+	options ...RepairOption,
+) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	defer he(&err)
+
+	var tapRepair TapRepair
+	var tapBad opts.TapBadKey
+	for _, option := range options {
+		switch option := option.(type) {
+		case TapRepair:
+			tapRepair = option
+		case opts.TapBadKey:
+			tapBad = option
+		default:
+			panic(fmt.Errorf("bad option: %T", option))
+		}
+	}
+
+	// replicas having the key
+	holders := make(map[Key][]int)
+	for i, r := range s.replicas {
+		ce(r.IterAllKeys(func(key Key) error {
+			holders[key] = append(holders[key], i)
+			return nil
+		}))
+		ce(ctx.Err())
+	}
+
+	wg := pr2.NewWaitGroup(ctx)
+	defer wg.Cancel()
+	var l sync.Mutex
+	put, wait := pr2.Consume(wg, s.parallel, func(_ int, key Key) (err error) {
+		defer he(&err, e5.With(key))
+
+		// verify copies
+		const (
+			missing = iota
+			good
+			corrupted
+			unknown
+		)
+		states := make([]int, len(s.replicas))
+		var tokens sb.Tokens
+		for _, i := range holders[key] {
+			t, err := s.readVerified(s.replicas[i], key)
+			switch {
+			case err == nil:
+				states[i] = good
+				if tokens == nil {
+					tokens = t
+				}
+			case is(err, ErrKeyNotMatch) || is(err, sb.DecodeError):
+				states[i] = corrupted
+			default:
+				// read error, or deleted after listing
+				states[i] = unknown
+			}
+		}
+		tokensOK := tokens != nil
+
+		// rewrite missing and corrupted copies
+		var repaired []int
+		if tokensOK {
+			for i, r := range s.replicas {
+				var res WriteResult
+				switch states[i] {
+				case missing:
+					res, err = r.Write(key.Namespace, tokens.Iter())
+				case corrupted:
+					res, err = store.Rewrite(r, key.Namespace, tokens)
+				default:
+					continue
+				}
+				ce(err)
+				if res.Key != key {
+					return we.With(e5.With(res.Key))(ErrKeyNotMatch)
+				}
+				repaired = append(repaired, i)
+			}
+		}
+
+		l.Lock()
+		defer l.Unlock()
+		if !tokensOK {
+			if tapBad != nil {
+				tapBad(key)
+			}
+			return nil
+		}
+		if tapRepair != nil {
+			for _, i := range repaired {
+				tapRepair(key, s.replicas[i].Store)
+			}
+		}
+		return nil
+	})
+
+	for key := range holders {
+		if !put(key) {
+			break
+		}
+	}
+	ce(wait(true))
+
+	return nil
+}
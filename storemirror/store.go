@@ -0,0 +1,428 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storemirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/opts"
+	"github.com/reusee/june/store"
+	"github.com/reusee/june/sys"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+// Store mirrors objects to all replicas.
+// Writes succeed when the write quorum is reached, reads try replicas in latency order
+type Store struct {
+	wg           *pr2.WaitGroup
+	name         string
+	id           StoreID
+	replicas     []*replica
+	quorum       int
+	newHashState key.NewHashState
+	parallel     int
+}
+
+type replica struct {
+	store.Store
+	latency int64 // moving average of read latency, in nanoseconds
+}
+
+// errorPenalty is the latency observed for failed reads
+const errorPenalty = time.Second
+
+func (r *replica) observe(d time.Duration) {
+	for {
+		old := atomic.LoadInt64(&r.latency)
+		latency := int64(d)
+		if old > 0 {
+			latency = old - old/8 + int64(d)/8
+		}
+		if atomic.CompareAndSwapInt64(&r.latency, old, latency) {
+			return
+		}
+	}
+}
+
+type New func(
+	ctx context.Context,
+	replicas []store.Store,
+	options ...NewOption,
+) (*Store, error)
+
+type NewOption interface {
+	IsNewOption()
+}
+
+// WriteQuorum is the number of replicas that must acknowledge a write. Default is the majority
+type WriteQuorum int
+
+func (WriteQuorum) IsNewOption() {}
+
+var (
+	ErrNoReplica   = errors.New("no replica")
+	ErrBadQuorum   = errors.New("bad quorum")
+	ErrQuorumWrite = errors.New("write quorum not reached")
+)
+
+func (Def) New(
+	newHashState key.NewHashState,
+	parallel sys.Parallel,
+) New {
+	return func(
+		ctx context.Context,
+		stores []store.Store,
+		options ...NewOption,
+	) (_ *Store, err error) {
+		defer he(&err)
+
+		if len(stores) == 0 {
+			return nil, we(ErrNoReplica)
+		}
+
+		quorum := len(stores)/2 + 1
+		for _, option := range options {
+			switch option := option.(type) {
+			case WriteQuorum:
+				quorum = int(option)
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+		if quorum < 1 || quorum > len(stores) {
+			return nil, we.With(
+				e5.Info("quorum %d, replicas %d", quorum, len(stores)),
+			)(ErrBadQuorum)
+		}
+
+		var ids []string
+		var names []string
+		var replicas []*replica
+		for _, s := range stores {
+			id, err := s.ID()
+			ce(err)
+			ids = append(ids, string(id))
+			names = append(names, s.Name())
+			replicas = append(replicas, &replica{
+				Store: s,
+			})
+		}
+		sort.Strings(ids)
+
+		return &Store{
+			wg: pr2.NewWaitGroup(ctx),
+			name: fmt.Sprintf("mirror%d(%s)",
+				atomic.AddInt64(&serial, 1),
+				strings.Join(names, ", "),
+			),
+			id:           StoreID("(mirror(" + strings.Join(ids, ",") + "))"),
+			replicas:     replicas,
+			quorum:       quorum,
+			newHashState: newHashState,
+			parallel:     int(parallel),
+		}, nil
+	}
+}
+
+var serial int64
+
+var _ store.Store = new(Store)
+
+func (s *Store) Name() string {
+	return s.name
+}
+
+func (s *Store) ID() (StoreID, error) {
+	return s.id, nil
+}
+
+// byLatency returns replicas ordered by observed read latency
+func (s *Store) byLatency() []*replica {
+	ret := make([]*replica, len(s.replicas))
+	copy(ret, s.replicas)
+	sort.SliceStable(ret, func(i, j int) bool {
+		return atomic.LoadInt64(&ret[i].latency) < atomic.LoadInt64(&ret[j].latency)
+	})
+	return ret
+}
+
+// readVerified reads key from r, returns tokens if hash matches
+func (s *Store) readVerified(r store.Store, key Key) (tokens sb.Tokens, err error) {
+	defer he(&err)
+	var sum []byte
+	ce(r.Read(key, func(stream sb.Stream) error {
+		return sb.Copy(
+			stream,
+			sb.CollectTokens(&tokens),
+			sb.Hash(s.newHashState, &sum, nil),
+		)
+	}))
+	if !bytes.Equal(sum, key.Hash[:]) {
+		return nil, we.With(e5.With(key))(ErrKeyNotMatch)
+	}
+	return tokens, nil
+}
+
+func (s *Store) Read(key Key, fn func(sb.Stream) error) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	defer he(&err)
+
+	var errs []error
+	notFound := true
+	for _, r := range s.byLatency() {
+		t0 := time.Now()
+		tokens, err := s.readVerified(r, key)
+		if err != nil {
+			if is(err, ErrKeyNotFound) {
+				r.observe(time.Since(t0))
+			} else {
+				notFound = false
+				r.observe(max(time.Since(t0), errorPenalty))
+			}
+			errs = append(errs, err)
+			continue
+		}
+		r.observe(time.Since(t0))
+		ce(fn(tokens.Iter()), e5.With(ErrRead), e5.With(key))
+		return nil
+	}
+
+	if notFound {
+		return we.With(e5.With(key))(ErrKeyNotFound)
+	}
+	return we.With(e5.With(key))(errors.Join(errs...))
+}
+
+func (s *Store) Exists(key Key) (bool, error) {
+	select {
+	case <-s.wg.Done():
+		return false, ErrClosed
+	default:
+	}
+
+	var errs []error
+	for _, r := range s.byLatency() {
+		ok, err := r.Exists(key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	if len(errs) > 0 {
+		// not known
+		return false, errors.Join(errs...)
+	}
+	return false, nil
+}
+
+func (s *Store) Write(
+	ns key.Namespace,
+	stream sb.Stream,
+	options ...WriteOption,
+) (res WriteResult, err error) {
+	select {
+	case <-s.wg.Done():
+		err = ErrClosed
+		return
+	default:
+	}
+
+	defer he(&err)
+
+	tokens, err := sb.TokensFromStream(stream)
+	ce(err)
+
+	// taps are called once, with the quorum result
+	var tapKey []opts.TapKey
+	var tapResult []store.TapWriteResult
+	var childOptions []WriteOption
+	for _, option := range options {
+		switch option := option.(type) {
+		case opts.TapKey:
+			tapKey = append(tapKey, option)
+		case store.TapWriteResult:
+			tapResult = append(tapResult, option)
+		default:
+			childOptions = append(childOptions, option)
+		}
+	}
+
+	type result struct {
+		res WriteResult
+		err error
+	}
+	results := make(chan result, len(s.replicas))
+	for _, r := range s.replicas {
+		r := r
+		// writes to lagging replicas continue after returning
+		s.wg.Go(func() {
+			res, err := r.Write(ns, tokens.Iter(), childOptions...)
+			results <- result{res, err}
+		})
+	}
+
+	var acked []WriteResult
+	var errs []error
+	for len(acked) < s.quorum {
+		if len(errs) > len(s.replicas)-s.quorum {
+			return res, we.With(
+				e5.Info("%d acked, quorum %d", len(acked), s.quorum),
+			)(errors.Join(append([]error{ErrQuorumWrite}, errs...)...))
+		}
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		if len(acked) > 0 && r.res.Key != acked[0].Key {
+			return res, we.With(e5.With(r.res.Key))(ErrKeyNotMatch)
+		}
+		acked = append(acked, r.res)
+	}
+
+	res = acked[0]
+	for _, r := range acked[1:] {
+		res.Written = res.Written || r.Written
+		res.BytesWritten += r.BytesWritten
+	}
+	for _, fn := range tapKey {
+		fn(res.Key)
+	}
+	for _, fn := range tapResult {
+		fn(res)
+	}
+	return res, nil
+}
+
+// max keys checked against previous replicas at once in iteration
+const iterBatchSize = 256
+
+// iter iterates replicas in latency order.
+// Keys are called once, keys existing in previous replicas are skipped, so no key set is kept in memory
+func (s *Store) iter(
+	iter func(store.Store, func(Key) error) error,
+	fn func(Key) error,
+) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	defer he(&err)
+
+	var isBreak atomic.Bool
+	call := func(key Key) error {
+		err := fn(key)
+		if is(err, Break) {
+			isBreak.Store(true)
+		}
+		return err
+	}
+
+	replicas := s.byLatency()
+	for i, r := range replicas {
+		previous := replicas[:i]
+
+		emit := func(keys []Key) (err error) {
+			defer he(&err)
+			found := make([]bool, len(keys))
+			for _, p := range previous {
+				oks, err := store.ExistsMany(p, keys)
+				ce(err)
+				for j, ok := range oks {
+					found[j] = found[j] || ok
+				}
+			}
+			for j, key := range keys {
+				if found[j] {
+					continue
+				}
+				ce(call(key))
+			}
+			return nil
+		}
+
+		var l sync.Mutex
+		var batch []Key
+		ce(iter(r, func(key Key) error {
+			if len(previous) == 0 {
+				return call(key)
+			}
+			l.Lock()
+			batch = append(batch, key)
+			if len(batch) < iterBatchSize {
+				l.Unlock()
+				return nil
+			}
+			keys := batch
+			batch = nil
+			l.Unlock()
+			return emit(keys)
+		}))
+		if isBreak.Load() {
+			return nil
+		}
+		if len(batch) > 0 {
+			if err := emit(batch); is(err, Break) {
+				return nil
+			} else {
+				ce(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) IterKeys(ns key.Namespace, fn func(Key) error) error {
+	return s.iter(func(r store.Store, fn func(Key) error) error {
+		return r.IterKeys(ns, fn)
+	}, fn)
+}
+
+func (s *Store) IterAllKeys(fn func(Key) error) error {
+	return s.iter(func(r store.Store, fn func(Key) error) error {
+		return r.IterAllKeys(fn)
+	}, fn)
+}
+
+func (s *Store) Delete(keys []Key) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	var errs []error
+	for _, r := range s.replicas {
+		if err := r.Delete(keys); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return we(errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storemirror
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/opts"
+	"github.com/reusee/june/store"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/june/storemem"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+func TestStore(
+	t *testing.T,
+	testStore store.TestStore,
+	scope dscope.Scope,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	with := func(fn func(store.Store), defs ...any) {
+		scope.Fork(defs...).Call(func(
+			newMem storemem.New,
+			newKV storekv.New,
+			newStore New,
+		) {
+			var replicas []store.Store
+			for i := 0; i < 3; i++ {
+				s, err := newKV(wg, newMem(wg), "foo")
+				ce(err)
+				replicas = append(replicas, s)
+			}
+			s, err := newStore(wg, replicas, WriteQuorum(3))
+			ce(err)
+			fn(s)
+		})
+	}
+	testStore(wg, with, t)
+}
+
+type testReplica struct {
+	store.Store
+	failWrite  atomic.Bool
+	failRead   atomic.Bool
+	failExists atomic.Bool
+	corrupt    atomic.Bool
+	delay      time.Duration
+	reads      atomic.Int64
+	badKeys    sync.Map // corrupted until rewritten
+}
+
+var errTest = errors.New("test")
+
+func (r *testReplica) Write(ns key.Namespace, stream sb.Stream, options ...WriteOption) (WriteResult, error) {
+	if r.failWrite.Load() {
+		return WriteResult{}, errTest
+	}
+	res, err := r.Store.Write(ns, stream, options...)
+	if err == nil && res.Written {
+		r.badKeys.Delete(res.Key)
+	}
+	return res, err
+}
+
+func (r *testReplica) Exists(key Key) (bool, error) {
+	if r.failExists.Load() {
+		return false, errTest
+	}
+	return r.Store.Exists(key)
+}
+
+func (r *testReplica) Read(key Key, fn func(sb.Stream) error) error {
+	r.reads.Add(1)
+	time.Sleep(r.delay)
+	if r.failRead.Load() {
+		return errTest
+	}
+	if _, ok := r.badKeys.Load(key); ok || r.corrupt.Load() {
+		return r.Store.Read(key, func(sb.Stream) error {
+			return fn(sb.Marshal("corrupted"))
+		})
+	}
+	return r.Store.Read(key, fn)
+}
+
+func TestMirror(
+	t *testing.T,
+	newMem storemem.New,
+	newKV storekv.New,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	newReplicas := func(n int) (replicas []*testReplica, stores []store.Store) {
+		for i := 0; i < n; i++ {
+			s, err := newKV(wg, newMem(wg), "foo")
+			ce(err)
+			r := &testReplica{
+				Store: s,
+			}
+			replicas = append(replicas, r)
+			stores = append(stores, r)
+		}
+		return
+	}
+	ns := key.Namespace{'f', 'o', 'o'}
+
+	read := func(s store.Store, k Key, expected int) {
+		var i int
+		ce(s.Read(k, func(stream sb.Stream) error {
+			return sb.Copy(stream, sb.Unmarshal(&i))
+		}))
+		if i != expected {
+			t.Fatalf("got %d", i)
+		}
+	}
+
+	// bad quorum
+	_, stores := newReplicas(2)
+	_, err := newStore(wg, stores, WriteQuorum(3))
+	if !is(err, ErrBadQuorum) {
+		t.Fatal()
+	}
+
+	// write quorum
+	replicas, stores := newReplicas(3)
+	s, err := newStore(wg, stores)
+	ce(err)
+	replicas[2].failWrite.Store(true)
+	res, err := s.Write(ns, sb.Marshal(42))
+	ce(err)
+	ok, err := replicas[2].Store.Exists(res.Key)
+	ce(err)
+	if ok {
+		t.Fatal()
+	}
+	replicas[1].failWrite.Store(true)
+	_, err = s.Write(ns, sb.Marshal(1))
+	if !is(err, ErrQuorumWrite) {
+		t.Fatal()
+	}
+	if !is(err, errTest) {
+		t.Fatal()
+	}
+	replicas[1].failWrite.Store(false)
+	replicas[2].failWrite.Store(false)
+
+	// fallback on missing
+	s.replicas[0].latency = 1
+	s.replicas[1].latency = 2
+	s.replicas[2].latency = 3
+	ce(replicas[0].Store.Delete([]Key{res.Key}))
+	read(s, res.Key, 42)
+
+	// fallback on hash mismatch and error
+	replicas[0].corrupt.Store(true)
+	replicas[1].failRead.Store(true)
+	_, err = replicas[2].Store.Write(ns, sb.Marshal(42))
+	ce(err)
+	read(s, res.Key, 42)
+	replicas[0].corrupt.Store(false)
+	replicas[1].failRead.Store(false)
+
+	// not found
+	err = s.Read(Key{
+		Namespace: ns,
+	}, func(sb.Stream) error {
+		return nil
+	})
+	if !is(err, ErrKeyNotFound) {
+		t.Fatal()
+	}
+
+	// exists with errors
+	replicas[1].failExists.Store(true)
+	ok, err = s.Exists(Key{
+		Namespace: ns,
+	})
+	if !is(err, errTest) || ok {
+		t.Fatalf("got %v", err)
+	}
+	ok, err = s.Exists(res.Key)
+	ce(err)
+	if !ok {
+		t.Fatal()
+	}
+	replicas[1].failExists.Store(false)
+
+	// iterate keys in some replicas
+	replicas, stores = newReplicas(3)
+	s, err = newStore(wg, stores)
+	ce(err)
+	const numKeys = iterBatchSize*2 + 10
+	for i := 0; i < numKeys; i++ {
+		_, err := replicas[i%3].Store.Write(ns, sb.Marshal(i))
+		ce(err)
+		if i%2 == 0 {
+			_, err := replicas[(i+1)%3].Store.Write(ns, sb.Marshal(i))
+			ce(err)
+		}
+	}
+	var iterL sync.Mutex
+	iterated := make(map[Key]int)
+	ce(s.IterAllKeys(func(key Key) error {
+		iterL.Lock()
+		defer iterL.Unlock()
+		iterated[key]++
+		return nil
+	}))
+	if len(iterated) != numKeys {
+		t.Fatalf("got %d", len(iterated))
+	}
+	for _, n := range iterated {
+		if n != 1 {
+			t.Fatalf("got %d", n)
+		}
+	}
+	n := 0
+	ce(s.IterAllKeys(func(key Key) error {
+		n++
+		return Break
+	}))
+	if n != 1 {
+		t.Fatalf("got %d", n)
+	}
+
+	// latency order
+	replicas, stores = newReplicas(3)
+	replicas[0].delay = time.Millisecond * 20
+	s, err = newStore(wg, stores, WriteQuorum(3))
+	ce(err)
+	res, err = s.Write(ns, sb.Marshal(42))
+	ce(err)
+	for i := 0; i < 8; i++ {
+		read(s, res.Key, 42)
+	}
+	if n := replicas[0].reads.Load(); n != 1 {
+		t.Fatalf("got %d", n)
+	}
+
+	// penalty on error
+	replicas, stores = newReplicas(2)
+	s, err = newStore(wg, stores, WriteQuorum(2))
+	ce(err)
+	res, err = s.Write(ns, sb.Marshal(42))
+	ce(err)
+	s.replicas[0].latency = 1
+	s.replicas[1].latency = 2
+	replicas[0].failRead.Store(true)
+	read(s, res.Key, 42)
+	replicas[0].failRead.Store(false)
+	read(s, res.Key, 42)
+	if n := replicas[0].reads.Load(); n != 1 {
+		t.Fatalf("got %d", n)
+	}
+
+	// repair
+	replicas, stores = newReplicas(3)
+	s, err = newStore(wg, stores)
+	ce(err)
+	var keys []Key
+	for i := 0; i < 16; i++ {
+		// replica 1 and 2 lagging
+		res, err := replicas[0].Store.Write(ns, sb.Marshal(i))
+		ce(err)
+		keys = append(keys, res.Key)
+	}
+	bad, err := replicas[1].Store.Write(ns, sb.Marshal(-1))
+	ce(err)
+	replicas[1].corrupt.Store(true)
+	var numRepaired, numBad int64
+	ce(s.Repair(
+		wg,
+		TapRepair(func(_ Key, _ store.Store) {
+			atomic.AddInt64(&numRepaired, 1)
+		}),
+		opts.TapBadKey(func(key Key) {
+			if key != bad.Key {
+				t.Fatal()
+			}
+			atomic.AddInt64(&numBad, 1)
+		}),
+	))
+	if numRepaired != int64(len(keys))*2 {
+		t.Fatalf("got %d", numRepaired)
+	}
+	if numBad != 1 {
+		t.Fatalf("got %d", numBad)
+	}
+	replicas[1].corrupt.Store(false)
+	oks, err := store.ExistsMany(replicas[2], keys)
+	ce(err)
+	for _, ok := range oks {
+		if !ok {
+			t.Fatal()
+		}
+	}
+
+	// repair corrupted copies
+	replicas[1].badKeys.Store(keys[0], true)
+	replicas[2].badKeys.Store(keys[1], true)
+	var l sync.Mutex
+	repaired := make(map[Key][]store.Store)
+	ce(s.Repair(
+		wg,
+		TapRepair(func(key Key, r store.Store) {
+			l.Lock()
+			defer l.Unlock()
+			repaired[key] = append(repaired[key], r)
+		}),
+	))
+	if len(repaired[keys[0]]) != 1 || repaired[keys[0]][0] != replicas[1] {
+		t.Fatal()
+	}
+	if len(repaired[keys[1]]) != 1 || repaired[keys[1]][0] != replicas[2] {
+		t.Fatal()
+	}
+	read(replicas[1], keys[0], 0)
+	read(replicas[2], keys[1], 1)
+}
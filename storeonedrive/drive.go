@@ -5,6 +5,7 @@
 package storeonedrive
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -15,10 +16,10 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/reusee/e5"
 	"github.com/reusee/pr2"
+	"golang.org/x/oauth2"
 )
 
 type New func(
@@ -26,17 +27,45 @@ type New func(
 	client *http.Client,
 	drivePath string,
 	dir string,
+	options ...NewOption,
 ) (*Store, error)
 
+type NewOption interface {
+	IsNewOption()
+}
+
+// UploadThreshold sets the size above which values are uploaded by upload sessions. Default is 4MiB
+type UploadThreshold int64
+
+func (UploadThreshold) IsNewOption() {}
+
+// ChunkSize sets the size of chunks in upload sessions. Default is 10MiB.
+// Graph requires it to be a multiple of 320KiB
+type ChunkSize int64
+
+func (ChunkSize) IsNewOption() {}
+
+// Endpoint sets the Graph API endpoint. Default is https://graph.microsoft.com/v1.0
+type Endpoint string
+
+func (Endpoint) IsNewOption() {}
+
+const chunkSizeUnit = 320 * 1024
+
 type Store struct {
-	wg        *pr2.WaitGroup
-	name      string
-	storeID   string
-	dirOK     sync.Map
-	client    *http.Client
-	drivePath string
-	dir       string
-	idByPath  sync.Map
+	wg              *pr2.WaitGroup
+	name            string
+	storeID         string
+	dirOK           sync.Map
+	client          *http.Client
+	uploadClient    *http.Client
+	drivePath       string
+	dir             string
+	idByPath        sync.Map
+	endpoint        string
+	uploadThreshold int64
+	chunkSize       int64
+	retryPolicy     RetryPolicy
 }
 
 func (Def) New() New {
@@ -45,13 +74,15 @@ func (Def) New() New {
 		client *http.Client,
 		drivePath string,
 		dir string,
+		options ...NewOption,
 	) (
 		drive *Store,
 		err error,
 	) {
+		defer he(&err)
 
 		wg := pr2.NewWaitGroup(ctx)
-		return &Store{
+		store := &Store{
 			wg: wg,
 			name: fmt.Sprintf("onedrive%d(%s)",
 				atomic.AddInt64(&serial, 1),
@@ -61,13 +92,48 @@ func (Def) New() New {
 				drivePath,
 				dir,
 			),
-			client:    client,
-			drivePath: path.Clean(drivePath),
-			dir:       dir,
-		}, nil
+			client:          client,
+			uploadClient:    uploadClient(client),
+			drivePath:       path.Clean(drivePath),
+			dir:             dir,
+			endpoint:        "https://graph.microsoft.com/v1.0",
+			uploadThreshold: 4 * 1024 * 1024,
+			chunkSize:       32 * chunkSizeUnit,
+			retryPolicy:     defaultRetryPolicy,
+		}
+
+		for _, option := range options {
+			switch option := option.(type) {
+			case UploadThreshold:
+				store.uploadThreshold = int64(option)
+			case ChunkSize:
+				store.chunkSize = int64(option)
+			case Endpoint:
+				store.endpoint = strings.TrimSuffix(string(option), "/")
+			case RetryPolicy:
+				store.retryPolicy = option
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+		if store.chunkSize <= 0 || store.chunkSize%chunkSizeUnit != 0 {
+			return nil, we(fmt.Errorf("chunk size must be a multiple of %d: %d", chunkSizeUnit, store.chunkSize))
+		}
+
+		return store, nil
 	}
 }
 
+// uploadClient returns a client without authorization, upload URLs are pre-authenticated and reject Authorization headers
+func uploadClient(client *http.Client) *http.Client {
+	if t, ok := client.Transport.(*oauth2.Transport); ok {
+		return &http.Client{
+			Transport: t.Base,
+		}
+	}
+	return client
+}
+
 var serial int64
 
 func (s *Store) Name() string {
@@ -86,7 +152,7 @@ func (s *Store) req(
 	ctx context.Context,
 	method string,
 	path string,
-	body io.Reader,
+	body []byte,
 	contentType string,
 	target any,
 ) (err error) {
@@ -117,10 +183,6 @@ func (s *Store) req(
 			return fmt.Errorf("%s: %w", content, ErrNotFound)
 		case 409:
 			return fmt.Errorf("%s: %w", content, ErrExisted)
-		case 429:
-			// request throttle
-			pt("%s\n", content)
-			time.Sleep(time.Minute) //TODO use duration from response
 		}
 		ce(fmt.Errorf("%s", content))
 	}
@@ -131,11 +193,13 @@ func (s *Store) req(
 	return nil
 }
 
+// request sends the request, retrying on throttling, server errors and connection loss.
+// Response of the last try is returned if retries exhausted
 func (s *Store) request(
 	ctx context.Context,
 	method string,
 	path string,
-	body io.Reader,
+	body []byte,
 	contentType string,
 ) (_ *http.Response, err error) {
 	select {
@@ -145,20 +209,43 @@ func (s *Store) request(
 	}
 	defer he(&err)
 
-	if !strings.HasPrefix(path, "https") {
-		path = "https://graph.microsoft.com/v1.0" + path
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		path = s.endpoint + path
 	}
-	req, err := http.NewRequestWithContext(
-		ctx,
-		method,
-		path,
-		body,
-	)
-	ce(err)
-	if contentType != "" {
-		req.Header.Add("Content-Type", contentType)
+
+	retry := s.newRetryState()
+	for {
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(
+			ctx,
+			method,
+			path,
+			bodyReader,
+		)
+		ce(err)
+		if contentType != "" {
+			req.Header.Add("Content-Type", contentType)
+		}
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || !retry.more() {
+				return nil, err
+			}
+			ce(retry.wait(ctx, nil))
+			continue
+		}
+
+		if isRetryableStatus(resp.StatusCode) && retry.more() {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			ce(retry.wait(ctx, resp.Header))
+			continue
+		}
+
+		return resp, nil
 	}
-	resp, err := s.client.Do(req)
-	ce(err)
-	return resp, nil
 }
@@ -5,6 +5,7 @@
 package storeonedrive
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"path"
@@ -229,7 +230,7 @@ func (s *Store) ensureDir(dir string) (err error) {
 	err = s.req(
 		ctx,
 		"POST", addr,
-		strings.NewReader(`{
+		[]byte(`{
         "name": "`+path.Base(dir)+`",
         "folder": {},
         "@microsoft.graph.conflictBehavior": "fail"
@@ -262,13 +263,21 @@ func (s *Store) KeyPut(key string, r io.Reader) (err error) {
 	); err != nil {
 		return err
 	}
-	if err := s.req(
-		s.wg,
-		"PUT", s.keyToDrivePath(key, "content"),
-		r, "application/octet-stream",
-		nil,
-	); err != nil {
+
+	// small values are uploaded in one request
+	buf := new(bytes.Buffer)
+	n, err := io.CopyN(buf, r, s.uploadThreshold+1)
+	if err != nil && !is(err, io.EOF) {
 		return err
 	}
-	return nil
+	if n <= s.uploadThreshold {
+		return s.req(
+			s.wg,
+			"PUT", s.keyToDrivePath(key, "content"),
+			buf.Bytes(), "application/octet-stream",
+			nil,
+		)
+	}
+
+	return s.uploadSession(key, io.MultiReader(buf, r))
 }
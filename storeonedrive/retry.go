@@ -0,0 +1,98 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storeonedrive
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures retrying of requests failed by throttling, server errors or connection loss.
+// Delays requested by Retry-After headers are honored, other delays are doubled on each retry, with jitter.
+// All delays are capped to MaxDelay
+type RetryPolicy struct {
+	MaxRetries   int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+func (RetryPolicy) IsNewOption() {}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxRetries:   8,
+	InitialDelay: time.Millisecond * 500,
+	MaxDelay:     time.Second * 30,
+}
+
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests ||
+		code >= http.StatusInternalServerError
+}
+
+// retryAfter returns the delay in Retry-After header, or zero if not present or invalid
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+type retryState struct {
+	policy RetryPolicy
+	delay  time.Duration
+	n      int
+}
+
+func (s *Store) newRetryState() *retryState {
+	return &retryState{
+		policy: s.retryPolicy,
+		delay:  s.retryPolicy.InitialDelay,
+	}
+}
+
+// more reports whether retries not exhausted
+func (r *retryState) more() bool {
+	return r.n < r.policy.MaxRetries
+}
+
+// wait sleeps before next retry, for the duration in Retry-After header if present, capped to MaxDelay
+func (r *retryState) wait(ctx context.Context, header http.Header) error {
+	r.n++
+	d := retryAfter(header)
+	if d == 0 {
+		// jitter in [delay/2, delay]
+		d = r.delay/2 + time.Duration(rand.Int63n(int64(r.delay/2)+1))
+		r.delay *= 2
+		if r.delay > r.policy.MaxDelay {
+			r.delay = r.policy.MaxDelay
+		}
+	}
+	if d > r.policy.MaxDelay {
+		d = r.policy.MaxDelay
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storeonedrive
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+	"golang.org/x/oauth2"
+)
+
+func newFakeStore(
+	wg *pr2.WaitGroup,
+	newStore New,
+	graph *fakeGraph,
+	options ...NewOption,
+) (*Store, error) {
+	client := &http.Client{
+		Transport: &oauth2.Transport{
+			Source: oauth2.StaticTokenSource(&oauth2.Token{
+				AccessToken: "test",
+			}),
+			Base: graph.server.Client().Transport,
+		},
+	}
+	return newStore(
+		wg,
+		client,
+		"/me/drive/special/AppRoot/",
+		"test",
+		append([]NewOption{
+			Endpoint(graph.endpoint()),
+			RetryPolicy{
+				MaxRetries:   5,
+				InitialDelay: time.Millisecond,
+				MaxDelay:     time.Millisecond * 10,
+			},
+		}, options...)...,
+	)
+}
+
+func TestFakeKV(
+	t *testing.T,
+	testKV storekv.TestKV,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	with := func(fn func(storekv.KV, string)) {
+		graph := newFakeGraph()
+		defer graph.server.Close()
+		kv, err := newFakeStore(wg, newStore, graph)
+		ce(err)
+		fn(kv, "foo")
+	}
+
+	testKV(wg, t, with)
+}
+
+func TestUploadSession(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	graph := newFakeGraph()
+	defer graph.server.Close()
+	kv, err := newFakeStore(wg, newStore, graph,
+		UploadThreshold(1024),
+		ChunkSize(chunkSizeUnit),
+	)
+	ce(err)
+
+	get := func(key string) []byte {
+		var data []byte
+		ce(kv.KeyGet(key, func(r io.Reader) (err error) {
+			data, err = io.ReadAll(r)
+			return
+		}))
+		return data
+	}
+
+	// small value
+	ce(kv.KeyPut("foo/aaaa", strings.NewReader("foo")))
+	if sessions, _ := graph.stats(); sessions != 0 {
+		t.Fatalf("got %d", sessions)
+	}
+	if !bytes.Equal(get("foo/aaaa"), []byte("foo")) {
+		t.Fatal()
+	}
+
+	// chunked
+	data := make([]byte, chunkSizeUnit*3+42)
+	_, err = rand.Read(data)
+	ce(err)
+	ce(kv.KeyPut("foo/bbbb", bytes.NewReader(data)))
+	sessions, chunks := graph.stats()
+	if sessions != 1 {
+		t.Fatalf("got %d", sessions)
+	}
+	if chunks != 4 {
+		t.Fatalf("got %d", chunks)
+	}
+	if !bytes.Equal(get("foo/bbbb"), data) {
+		t.Fatal()
+	}
+
+	// resume after connection loss
+	dropped := 0
+	graph.setDrop(func(req *http.Request) bool {
+		if strings.HasPrefix(req.Header.Get("Content-Range"), "bytes 0-") {
+			return false
+		}
+		if dropped < 2 {
+			dropped++
+			return true
+		}
+		return false
+	})
+	ce(kv.KeyPut("foo/cccc", bytes.NewReader(data)))
+	if dropped != 2 {
+		t.Fatalf("got %d", dropped)
+	}
+	sessions, chunks = graph.stats()
+	if sessions != 2 {
+		t.Fatalf("got %d", sessions)
+	}
+	// half chunks received before connection loss, so resumed chunks are not aligned
+	if chunks != 4+5 {
+		t.Fatalf("got %d", chunks)
+	}
+	if !bytes.Equal(get("foo/cccc"), data) {
+		t.Fatal()
+	}
+
+	// session expired
+	graph.setDrop(nil)
+	graph.setFail(func(req *http.Request) (int, string) {
+		if req.Method == http.MethodPut &&
+			strings.HasPrefix(req.URL.Path, "/upload/") &&
+			!strings.HasPrefix(req.Header.Get("Content-Range"), "bytes 0-") {
+			graph.sessions = make(map[string]*fakeSession)
+			graph.fail = nil
+			return http.StatusNotFound, ""
+		}
+		return 0, ""
+	})
+	ce(kv.KeyPut("foo/dddd", bytes.NewReader(data)))
+	if sessions, _ := graph.stats(); sessions != 4 {
+		t.Fatalf("got %d", sessions)
+	}
+	if !bytes.Equal(get("foo/dddd"), data) {
+		t.Fatal()
+	}
+
+	// not progressing
+	stalled := 0
+	graph.setStall(func(req *http.Request) bool {
+		if strings.HasPrefix(req.Header.Get("Content-Range"), "bytes 0-") {
+			return false
+		}
+		stalled++
+		return stalled <= 2
+	})
+	ce(kv.KeyPut("foo/eeee", bytes.NewReader(data)))
+	if !bytes.Equal(get("foo/eeee"), data) {
+		t.Fatal()
+	}
+	stalled = 0
+	graph.setStall(func(req *http.Request) bool {
+		if strings.HasPrefix(req.Header.Get("Content-Range"), "bytes 0-") {
+			return false
+		}
+		stalled++
+		return true
+	})
+	err = kv.KeyPut("foo/ffff", bytes.NewReader(data))
+	if !is(err, errNoProgress) {
+		t.Fatalf("got %v", err)
+	}
+	if stalled != 5+1 {
+		t.Fatalf("got %d", stalled)
+	}
+	graph.setStall(nil)
+
+	// bad chunk size
+	_, err = newFakeStore(wg, newStore, graph, ChunkSize(1000))
+	if err == nil {
+		t.Fatal()
+	}
+}
+
+func TestRetryAfter(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	graph := newFakeGraph()
+	defer graph.server.Close()
+	kv, err := newFakeStore(wg, newStore, graph, RetryPolicy{
+		MaxRetries:   5,
+		InitialDelay: time.Millisecond,
+		MaxDelay:     time.Second * 5,
+	})
+	ce(err)
+
+	// throttled
+	n := 0
+	graph.setFail(func(req *http.Request) (int, string) {
+		if n < 2 {
+			n++
+			return http.StatusTooManyRequests, "1"
+		}
+		return 0, ""
+	})
+	t0 := time.Now()
+	ce(kv.KeyPut("foo/aaaa", strings.NewReader("foo")))
+	if n != 2 {
+		t.Fatal()
+	}
+	if time.Since(t0) < time.Second*2 {
+		t.Fatalf("Retry-After not honored: %v", time.Since(t0))
+	}
+
+	// server errors
+	n = 0
+	graph.setFail(func(req *http.Request) (int, string) {
+		if n < 3 {
+			n++
+			return http.StatusServiceUnavailable, ""
+		}
+		return 0, ""
+	})
+	ok, err := kv.KeyExists("foo/aaaa")
+	ce(err)
+	if !ok {
+		t.Fatal()
+	}
+
+	// exhausted
+	graph.setFail(func(req *http.Request) (int, string) {
+		return http.StatusTooManyRequests, "0"
+	})
+	_, err = kv.KeyExists("foo/aaaa")
+	if err == nil {
+		t.Fatal()
+	}
+	graph.setFail(nil)
+
+	// capped to MaxDelay
+	graph.setFail(func(req *http.Request) (int, string) {
+		return http.StatusTooManyRequests, "60"
+	})
+	capped, err := newFakeStore(wg, newStore, graph)
+	ce(err)
+	t0 = time.Now()
+	_, err = capped.KeyExists("foo/aaaa")
+	if err == nil {
+		t.Fatal()
+	}
+	if time.Since(t0) > time.Second*10 {
+		t.Fatalf("Retry-After not capped: %v", time.Since(t0))
+	}
+	graph.setFail(nil)
+
+	// Retry-After in http date
+	if d := retryAfter(http.Header{
+		"Retry-After": []string{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)},
+	}); d < time.Second*50 || d > time.Minute {
+		t.Fatalf("got %v", d)
+	}
+	if d := retryAfter(http.Header{
+		"Retry-After": []string{"3"},
+	}); d != time.Second*3 {
+		t.Fatalf("got %v", d)
+	}
+	if d := retryAfter(http.Header{}); d != 0 {
+		t.Fatalf("got %v", d)
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storeonedrive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fakeGraph is a minimal in-memory server of the Graph drive endpoints used by Store.
+// Items are addressed by paths after the first colon, the drive path before it is ignored
+type fakeGraph struct {
+	mu       sync.Mutex
+	server   *httptest.Server
+	items    map[string]*fakeItem // path -> item
+	ids      map[string]string    // id -> path
+	sessions map[string]*fakeSession
+	serial   int
+
+	// fail returns non-zero status to fail the request, with Retry-After header if retryAfter is not empty
+	fail func(req *http.Request) (status int, retryAfter string)
+	// drop returns true to receive part of the upload chunk then close the connection
+	drop func(req *http.Request) bool
+	// stall returns true to discard the upload chunk and respond with unchanged expected ranges
+	stall func(req *http.Request) bool
+
+	numSessions int
+	numChunks   int
+}
+
+type fakeItem struct {
+	id     string
+	folder bool
+	data   []byte
+}
+
+type fakeSession struct {
+	path     string
+	size     int64
+	received []byte
+}
+
+const fakeListPageSize = 50
+
+func newFakeGraph() *fakeGraph {
+	g := &fakeGraph{
+		items:    make(map[string]*fakeItem),
+		ids:      make(map[string]string),
+		sessions: make(map[string]*fakeSession),
+	}
+	g.items["/"] = &fakeItem{
+		id:     "root",
+		folder: true,
+	}
+	g.ids["root"] = "/"
+	g.server = httptest.NewServer(g)
+	return g
+}
+
+func (g *fakeGraph) endpoint() string {
+	return g.server.URL + "/v1.0"
+}
+
+func (g *fakeGraph) setFail(fn func(req *http.Request) (int, string)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.fail = fn
+}
+
+func (g *fakeGraph) setDrop(fn func(req *http.Request) bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.drop = fn
+}
+
+func (g *fakeGraph) setStall(fn func(req *http.Request) bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stall = fn
+}
+
+func (g *fakeGraph) stats() (sessions int, chunks int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.numSessions, g.numChunks
+}
+
+func (g *fakeGraph) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error": {"code": %q, "message": %q}}`, code, code)
+}
+
+func (g *fakeGraph) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		panic(err)
+	}
+}
+
+func (g *fakeGraph) itemJSON(p string, item *fakeItem) map[string]any {
+	ret := map[string]any{
+		"id":   item.id,
+		"name": path.Base(p),
+	}
+	if item.folder {
+		ret["folder"] = map[string]any{}
+	} else {
+		ret["file"] = map[string]any{}
+		ret["size"] = len(item.data)
+	}
+	return ret
+}
+
+// ensureFolder creates the folder and its parents if not exist
+func (g *fakeGraph) ensureFolder(p string) {
+	if _, ok := g.items[p]; ok {
+		return
+	}
+	g.ensureFolder(path.Dir(p))
+	g.createItem(p, true, nil)
+}
+
+func (g *fakeGraph) createItem(p string, folder bool, data []byte) *fakeItem {
+	g.serial++
+	item := &fakeItem{
+		id:     strconv.Itoa(g.serial),
+		folder: folder,
+		data:   data,
+	}
+	g.items[p] = item
+	g.ids[item.id] = p
+	return item
+}
+
+func (g *fakeGraph) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.fail != nil {
+		if status, retryAfter := g.fail(req); status != 0 {
+			_, _ = io.Copy(io.Discard, req.Body)
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			g.writeError(w, status, "fail")
+			return
+		}
+	}
+
+	p := strings.TrimPrefix(req.URL.Path, "/v1.0")
+
+	// upload sessions are pre-authenticated
+	if id, ok := strings.CutPrefix(p, "/upload/"); ok {
+		if req.Header.Get("Authorization") != "" {
+			g.writeError(w, http.StatusUnauthorized, "unauthenticated")
+			return
+		}
+		g.serveUpload(w, req, id)
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		g.writeError(w, http.StatusUnauthorized, "unauthenticated")
+		return
+	}
+
+	// address by id
+	var itemPath, sub string
+	if rest, ok := strings.CutPrefix(p, "/me/drive/items/"); ok {
+		id, s, _ := strings.Cut(rest, "/")
+		itemPath, ok = g.ids[id]
+		if !ok {
+			g.writeError(w, http.StatusNotFound, "itemNotFound")
+			return
+		}
+		sub = s
+	} else {
+		// address by path
+		_, rest, ok := strings.Cut(p, ":")
+		if !ok {
+			g.writeError(w, http.StatusBadRequest, "invalidRequest")
+			return
+		}
+		itemPath, sub, _ = strings.Cut(rest, ":")
+		itemPath = path.Clean("/" + itemPath)
+		sub = strings.Trim(sub, "/")
+	}
+	item, exists := g.items[itemPath]
+
+	switch {
+
+	case sub == "" && req.Method == http.MethodGet:
+		if !exists {
+			g.writeError(w, http.StatusNotFound, "itemNotFound")
+			return
+		}
+		g.writeJSON(w, http.StatusOK, g.itemJSON(itemPath, item))
+
+	case sub == "" && req.Method == http.MethodDelete:
+		if !exists {
+			g.writeError(w, http.StatusNotFound, "itemNotFound")
+			return
+		}
+		for p, item := range g.items {
+			if p == itemPath || strings.HasPrefix(p, itemPath+"/") {
+				delete(g.items, p)
+				delete(g.ids, item.id)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case sub == "content" && req.Method == http.MethodGet:
+		if !exists || item.folder {
+			g.writeError(w, http.StatusNotFound, "itemNotFound")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(item.data)
+
+	case sub == "content" && req.Method == http.MethodPut:
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return
+		}
+		g.ensureFolder(path.Dir(itemPath))
+		item := g.createItem(itemPath, false, data)
+		g.writeJSON(w, http.StatusCreated, g.itemJSON(itemPath, item))
+
+	case sub == "children" && req.Method == http.MethodGet:
+		if !exists || !item.folder {
+			g.writeError(w, http.StatusNotFound, "itemNotFound")
+			return
+		}
+		var children []string
+		for p := range g.items {
+			if p != "/" && path.Dir(p) == itemPath {
+				children = append(children, p)
+			}
+		}
+		sort.Strings(children)
+		skip, _ := strconv.Atoi(req.URL.Query().Get("$skiptoken"))
+		if skip > len(children) {
+			skip = len(children)
+		}
+		children = children[skip:]
+		ret := map[string]any{}
+		if len(children) > fakeListPageSize {
+			children = children[:fakeListPageSize]
+			ret["@odata.nextLink"] = fmt.Sprintf("%s%s?$skiptoken=%d",
+				g.server.URL,
+				req.URL.EscapedPath(),
+				skip+fakeListPageSize,
+			)
+		}
+		values := []any{}
+		for _, p := range children {
+			values = append(values, g.itemJSON(p, g.items[p]))
+		}
+		ret["value"] = values
+		g.writeJSON(w, http.StatusOK, ret)
+
+	case sub == "children" && req.Method == http.MethodPost:
+		var body struct {
+			Name string
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Name == "" {
+			g.writeError(w, http.StatusBadRequest, "invalidRequest")
+			return
+		}
+		childPath := path.Join(itemPath, body.Name)
+		if _, ok := g.items[childPath]; ok {
+			g.writeError(w, http.StatusConflict, "nameAlreadyExists")
+			return
+		}
+		g.ensureFolder(itemPath)
+		item := g.createItem(childPath, true, nil)
+		g.writeJSON(w, http.StatusCreated, g.itemJSON(childPath, item))
+
+	case sub == "createUploadSession" && req.Method == http.MethodPost:
+		_, _ = io.Copy(io.Discard, req.Body)
+		g.serial++
+		id := strconv.Itoa(g.serial)
+		g.sessions[id] = &fakeSession{
+			path: itemPath,
+			size: -1,
+		}
+		g.numSessions++
+		g.writeJSON(w, http.StatusOK, map[string]any{
+			"uploadUrl":          g.server.URL + "/upload/" + id,
+			"nextExpectedRanges": []string{"0-"},
+		})
+
+	default:
+		g.writeError(w, http.StatusBadRequest, "invalidRequest")
+	}
+}
+
+func (g *fakeGraph) serveUpload(w http.ResponseWriter, req *http.Request, id string) {
+	session, ok := g.sessions[id]
+	if !ok {
+		g.writeError(w, http.StatusNotFound, "itemNotFound")
+		return
+	}
+	expected := func() map[string]any {
+		return map[string]any{
+			"nextExpectedRanges": []string{fmt.Sprintf("%d-", len(session.received))},
+		}
+	}
+
+	switch req.Method {
+
+	case http.MethodGet:
+		g.writeJSON(w, http.StatusOK, expected())
+
+	case http.MethodPut:
+		g.numChunks++
+		var start, end, size int64
+		if _, err := fmt.Sscanf(
+			req.Header.Get("Content-Range"),
+			"bytes %d-%d/%d", &start, &end, &size,
+		); err != nil {
+			g.writeError(w, http.StatusBadRequest, "invalidRange")
+			return
+		}
+		if start != int64(len(session.received)) ||
+			end < start ||
+			(session.size >= 0 && size != session.size) {
+			g.writeError(w, http.StatusRequestedRangeNotSatisfiable, "invalidRange")
+			return
+		}
+		session.size = size
+
+		if g.stall != nil && g.stall(req) {
+			_, _ = io.Copy(io.Discard, req.Body)
+			g.writeJSON(w, http.StatusAccepted, expected())
+			return
+		}
+
+		if g.drop != nil && g.drop(req) {
+			// receive half of the chunk, then lose the connection
+			half := (end - start + 1) / 2
+			data, _ := io.ReadAll(io.LimitReader(req.Body, half))
+			session.received = append(session.received, data...)
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				panic(err)
+			}
+			conn.Close()
+			return
+		}
+
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return
+		}
+		if int64(len(data)) != end-start+1 {
+			g.writeError(w, http.StatusBadRequest, "invalidRange")
+			return
+		}
+		session.received = append(session.received, data...)
+		if int64(len(session.received)) < size {
+			g.writeJSON(w, http.StatusAccepted, expected())
+			return
+		}
+		// completed
+		delete(g.sessions, id)
+		g.ensureFolder(path.Dir(session.path))
+		item := g.createItem(session.path, false, session.received)
+		g.writeJSON(w, http.StatusCreated, g.itemJSON(session.path, item))
+
+	default:
+		g.writeError(w, http.StatusBadRequest, "invalidRequest")
+	}
+}
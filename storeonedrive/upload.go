@@ -0,0 +1,197 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storeonedrive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/reusee/e5"
+)
+
+// uploadSession uploads the value in chunks by a Graph upload session.
+// The value is spooled to a temporary file, so interrupted uploads can be resumed from the ranges expected by the server
+func (s *Store) uploadSession(key string, r io.Reader) (err error) {
+	defer he(&err)
+
+	f, err := os.CreateTemp("", "june-onedrive-upload-")
+	ce(err)
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	size, err := io.Copy(f, r)
+	ce(err)
+
+	ctx, cancel := context.WithTimeout(s.wg, defaultTimeout)
+	defer cancel()
+
+	uploadURL, err := s.createUploadSession(ctx, key)
+	ce(err)
+
+	var offset int64
+	retry := s.newRetryState()
+	for {
+		end := offset + s.chunkSize
+		if end > size {
+			end = size
+		}
+
+		var header http.Header
+		sessionLost := false
+		err := func() (err error) {
+			defer he(&err)
+			req, err := http.NewRequestWithContext(
+				ctx,
+				"PUT",
+				uploadURL,
+				io.NewSectionReader(f, offset, end-offset),
+			)
+			ce(err)
+			req.ContentLength = end - offset
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, end-1, size))
+			resp, err := s.uploadClient.Do(req)
+			ce(err)
+			defer resp.Body.Close()
+			content, err := io.ReadAll(resp.Body)
+			ce(err)
+
+			switch {
+
+			case resp.StatusCode == http.StatusOK,
+				resp.StatusCode == http.StatusCreated:
+				// completed
+				offset = size
+				return nil
+
+			case resp.StatusCode == http.StatusAccepted:
+				next, err := nextExpectedOffset(content)
+				ce(err)
+				if next <= offset {
+					// chunk not accepted, retry
+					return we.With(e5.Info("offset %d", offset))(errNoProgress)
+				}
+				offset = next
+				return nil
+
+			case resp.StatusCode == http.StatusNotFound:
+				sessionLost = true
+				return fmt.Errorf("%s: %w", content, ErrNotFound)
+
+			case isRetryableStatus(resp.StatusCode),
+				resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+				header = resp.Header
+				return fmt.Errorf("%s: %s", resp.Status, content)
+
+			}
+
+			return we.With(e5.Info("status %s", resp.Status))(
+				fmt.Errorf("%s: %w", content, errUploadFailed),
+			)
+		}()
+
+		if err == nil {
+			if offset >= size {
+				return nil
+			}
+			retry = s.newRetryState()
+			continue
+		}
+		if is(err, errUploadFailed) ||
+			ctx.Err() != nil ||
+			!retry.more() {
+			return err
+		}
+		ce(retry.wait(ctx, header))
+
+		// resume
+		if !sessionLost {
+			next, err := s.uploadStatus(ctx, uploadURL)
+			if err == nil {
+				if next >= size {
+					return nil
+				}
+				offset = next
+				continue
+			}
+			if !is(err, ErrNotFound) {
+				return err
+			}
+		}
+
+		// session expired, restart
+		uploadURL, err = s.createUploadSession(ctx, key)
+		ce(err)
+		offset = 0
+	}
+}
+
+var (
+	errUploadFailed = fmt.Errorf("upload failed")
+	errNoProgress   = fmt.Errorf("upload not progressing")
+)
+
+func (s *Store) createUploadSession(ctx context.Context, key string) (_ string, err error) {
+	defer he(&err)
+	var data struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	ce(s.req(
+		ctx,
+		"POST", s.keyToDrivePath(key, "createUploadSession"),
+		[]byte(`{
+        "item": {
+          "@microsoft.graph.conflictBehavior": "replace"
+        }
+      }`),
+		"application/json",
+		&data,
+	))
+	if data.UploadURL == "" {
+		return "", we(fmt.Errorf("no upload url"))
+	}
+	return data.UploadURL, nil
+}
+
+// uploadStatus returns the offset of the first byte not received by the server
+func (s *Store) uploadStatus(ctx context.Context, uploadURL string) (_ int64, err error) {
+	defer he(&err)
+	req, err := http.NewRequestWithContext(ctx, "GET", uploadURL, nil)
+	ce(err)
+	resp, err := s.uploadClient.Do(req)
+	ce(err)
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	ce(err)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nextExpectedOffset(content)
+	case http.StatusNotFound:
+		return 0, fmt.Errorf("%s: %w", content, ErrNotFound)
+	}
+	return 0, we(fmt.Errorf("%s: %s", resp.Status, content))
+}
+
+// nextExpectedOffset parses the start of the first range in nextExpectedRanges
+func nextExpectedOffset(content []byte) (_ int64, err error) {
+	defer he(&err, e5.Info("json %s", content))
+	var data struct {
+		NextExpectedRanges []string `json:"nextExpectedRanges"`
+	}
+	ce(json.Unmarshal(content, &data))
+	if len(data.NextExpectedRanges) == 0 {
+		return 0, we(fmt.Errorf("no expected ranges"))
+	}
+	start, _, _ := strings.Cut(data.NextExpectedRanges[0], "-")
+	offset, err := strconv.ParseInt(start, 10, 64)
+	ce(err)
+	return offset, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storepack
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/sb"
+)
+
+// Flush writes buffered puts and deletes to a new pack
+func (k *KV) Flush() (err error) {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	k.flushMu.Lock()
+	defer k.flushMu.Unlock()
+	return k.flush()
+}
+
+// Close stops accepting operations, waits for running ones, then flushes buffered puts and deletes.
+// The upstream KV must not be closed before Close returns
+func (k *KV) Close() (err error) {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	k.wg.Cancel()
+	k.wg.Wait()
+	k.flushMu.Lock()
+	defer k.flushMu.Unlock()
+	return k.flush()
+}
+
+// flush writes pending values. k.flushMu must be held
+func (k *KV) flush() (err error) {
+	defer he(&err)
+
+	k.mu.Lock()
+	if len(k.pending) == 0 {
+		k.mu.Unlock()
+		return nil
+	}
+	values := k.pending
+	k.flushing = values
+	k.pending = make(map[string]pendingValue)
+	k.size = 0
+	id := k.nextID()
+	k.mu.Unlock()
+
+	defer func() {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		if err != nil {
+			// restore values not overridden
+			for key, value := range values {
+				if _, ok := k.pending[key]; ok {
+					continue
+				}
+				k.pending[key] = value
+				k.size += int64(len(value.value))
+			}
+		}
+		k.flushing = nil
+	}()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	data := new(bytes.Buffer)
+	entries := make([]indexEntry, 0, len(keys))
+	for _, key := range keys {
+		value := values[key]
+		if value.deleted {
+			entries = append(entries, indexEntry{
+				Key:     key,
+				Deleted: true,
+			})
+			continue
+		}
+		entries = append(entries, indexEntry{
+			Key:    key,
+			Offset: int64(data.Len()),
+			Length: int64(len(value.value)),
+		})
+		data.Write(value.value)
+	}
+
+	ce(k.writePack(id, data.Bytes(), entries))
+
+	k.mu.Lock()
+	k.apply(id, entries)
+	k.mu.Unlock()
+
+	return nil
+}
+
+// writePack writes pack data, then the index
+func (k *KV) writePack(id string, data []byte, entries []indexEntry) (err error) {
+	defer he(&err, e5.Info("pack %s", id))
+	if len(data) > 0 {
+		ce(k.upstream.KeyPut(packPrefix+id, bytes.NewReader(data)))
+	}
+	buf := new(bytes.Buffer)
+	ce(sb.Copy(
+		sb.Marshal(entries),
+		sb.Encode(buf),
+	))
+	ce(k.upstream.KeyPut(indexPrefix+id, bytes.NewReader(buf.Bytes())))
+	if k.cache != nil {
+		ce(k.cache.KeyPut(indexPrefix+id, bytes.NewReader(buf.Bytes())))
+	}
+	return nil
+}
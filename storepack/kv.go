@@ -0,0 +1,518 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storepack
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+// KV aggregates values into large immutable pack files of the upstream KV.
+//
+// Upstream layout:
+//
+//	pack/<id>: concatenated values
+//	idx/<id>: index of the pack, also records deleted keys
+//
+// Pack ids are increasing, entries in later packs override earlier ones.
+// Puts and deletes are buffered in memory until the buffer reaches PackSize, Flush is called, or Close is called.
+// A successful KeyPut or KeyDelete is durable only after a following Flush or Close returns without error.
+// Cancelling the context without Close discards buffered changes, as a crash does.
+//
+// The index is loaded on start and updated by this KV only. Other KVs writing the same upstream
+// are not visible until Reload, and deletes of their keys are ignored. Use one writer per upstream
+type KV struct {
+	wg       *pr2.WaitGroup
+	name     string
+	upstream storekv.KV
+	cache    storekv.KV
+	packSize int64
+
+	mu       sync.RWMutex
+	index    map[string]location
+	packs    map[string]*pack
+	pending  map[string]pendingValue
+	flushing map[string]pendingValue
+	size     int64 // size of pending values
+	lastSeq  int64
+
+	flushMu sync.Mutex
+}
+
+type location struct {
+	pack   string
+	offset int64
+	length int64
+}
+
+type pack struct {
+	id      string
+	size    int64
+	live    int64 // bytes of values not overridden or deleted
+	entries []indexEntry
+}
+
+type pendingValue struct {
+	value   []byte
+	deleted bool
+}
+
+type indexEntry struct {
+	Key     string
+	Offset  int64
+	Length  int64
+	Deleted bool
+}
+
+const (
+	packPrefix  = "pack/"
+	indexPrefix = "idx/"
+)
+
+var _ storekv.KV = new(KV)
+
+type New func(
+	ctx context.Context,
+	upstream storekv.KV,
+	options ...NewOption,
+) (*KV, error)
+
+type NewOption interface {
+	IsNewOption()
+}
+
+// PackSize is the size of pending values to trigger a flush. Default is 16MiB
+type PackSize int64
+
+func (PackSize) IsNewOption() {}
+
+// IndexCache caches pack indexes in a local KV, to avoid reading all indexes from upstream on start
+type IndexCache struct {
+	storekv.KV
+}
+
+func (IndexCache) IsNewOption() {}
+
+func (Def) New() New {
+	return func(
+		ctx context.Context,
+		upstream storekv.KV,
+		options ...NewOption,
+	) (_ *KV, err error) {
+		defer he(&err)
+
+		kv := &KV{
+			wg: pr2.NewWaitGroup(ctx),
+			name: fmt.Sprintf("pack%d(%s)",
+				atomic.AddInt64(&serial, 1),
+				upstream.Name(),
+			),
+			upstream: upstream,
+			packSize: 16 * 1024 * 1024,
+			index:    make(map[string]location),
+			packs:    make(map[string]*pack),
+			pending:  make(map[string]pendingValue),
+		}
+
+		for _, option := range options {
+			switch option := option.(type) {
+			case PackSize:
+				kv.packSize = int64(option)
+			case IndexCache:
+				kv.cache = option.KV
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+
+		ce(kv.reload())
+
+		return kv, nil
+	}
+}
+
+var serial int64
+
+func (k *KV) StoreID() string {
+	return "pack(" + k.upstream.StoreID() + ")"
+}
+
+func (k *KV) Name() string {
+	return k.name
+}
+
+func (k *KV) CostInfo() storekv.CostInfo {
+	// only reads hit upstream
+	return storekv.CostInfo{
+		Get: k.upstream.CostInfo().Get,
+	}
+}
+
+// Reload reads all pack indexes from upstream, to see packs written by others
+func (k *KV) Reload() error {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	k.flushMu.Lock()
+	defer k.flushMu.Unlock()
+	return k.reload()
+}
+
+// reload rebuilds index from all pack indexes. k.flushMu must be held if concurrently accessed
+func (k *KV) reload() (err error) {
+	defer he(&err)
+
+	var ids []string
+	ce(k.upstream.KeyIter(indexPrefix, func(key string) error {
+		ids = append(ids, strings.TrimPrefix(key, indexPrefix))
+		return nil
+	}))
+	sort.Strings(ids)
+
+	var lastSeq int64
+	entries := make([][]indexEntry, 0, len(ids))
+	for _, id := range ids {
+		seq, err := strconv.ParseInt(id, 16, 64)
+		ce(err, e5.Info("bad pack id: %s", id))
+		if seq > lastSeq {
+			lastSeq = seq
+		}
+		es, err := k.readIndex(id)
+		ce(err)
+		entries = append(entries, es)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.index = make(map[string]location)
+	k.packs = make(map[string]*pack)
+	for i, id := range ids {
+		k.apply(id, entries[i])
+	}
+	if lastSeq > k.lastSeq {
+		k.lastSeq = lastSeq
+	}
+
+	return nil
+}
+
+// readIndex reads index of pack, from cache if possible
+func (k *KV) readIndex(id string) (entries []indexEntry, err error) {
+	defer he(&err, e5.Info("pack %s", id))
+	decode := func(r io.Reader) error {
+		return sb.Copy(
+			sb.Decode(r),
+			sb.Unmarshal(&entries),
+		)
+	}
+	if k.cache != nil {
+		err := k.cache.KeyGet(indexPrefix+id, decode)
+		if err == nil {
+			return entries, nil
+		}
+		if !is(err, ErrKeyNotFound) {
+			return nil, err
+		}
+	}
+	buf := new(bytes.Buffer)
+	ce(k.upstream.KeyGet(indexPrefix+id, func(r io.Reader) error {
+		_, err := io.Copy(buf, r)
+		return err
+	}))
+	if k.cache != nil {
+		ce(k.cache.KeyPut(indexPrefix+id, bytes.NewReader(buf.Bytes())))
+	}
+	ce(decode(buf))
+	return
+}
+
+// apply updates index by entries of pack. k.mu must be held if concurrently accessed
+func (k *KV) apply(id string, entries []indexEntry) {
+	p := &pack{
+		id:      id,
+		entries: entries,
+	}
+	k.packs[id] = p
+	for _, entry := range entries {
+		if old, ok := k.index[entry.Key]; ok {
+			if oldPack, ok := k.packs[old.pack]; ok {
+				oldPack.live -= old.length
+			}
+		}
+		if entry.Deleted {
+			delete(k.index, entry.Key)
+			continue
+		}
+		if end := entry.Offset + entry.Length; end > p.size {
+			p.size = end
+		}
+		p.live += entry.Length
+		k.index[entry.Key] = location{
+			pack:   id,
+			offset: entry.Offset,
+			length: entry.Length,
+		}
+	}
+}
+
+// nextID returns an increasing pack id. k.mu must be held
+func (k *KV) nextID() string {
+	seq := time.Now().UnixNano()
+	if seq <= k.lastSeq {
+		seq = k.lastSeq + 1
+	}
+	k.lastSeq = seq
+	return fmt.Sprintf("%016x", seq)
+}
+
+// lookup returns pending value or location of key. k.mu must be held
+func (k *KV) lookup(key string) (value pendingValue, loc location, ok bool) {
+	if v, ok := k.pending[key]; ok {
+		return v, loc, !v.deleted
+	}
+	if v, ok := k.flushing[key]; ok {
+		return v, loc, !v.deleted
+	}
+	loc, ok = k.index[key]
+	return
+}
+
+func (k *KV) KeyPut(key string, r io.Reader) (err error) {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	defer he(&err, e5.With(storekv.StringKey(key)))
+
+	// copy, not retaining reader bytes
+	buf := new(bytes.Buffer)
+	_, err = io.Copy(buf, r)
+	ce(err)
+
+	k.mu.Lock()
+	if old, ok := k.pending[key]; ok {
+		k.size -= int64(len(old.value))
+	}
+	k.pending[key] = pendingValue{
+		value: buf.Bytes(),
+	}
+	k.size += int64(buf.Len())
+	full := k.size >= k.packSize
+	k.mu.Unlock()
+
+	if full {
+		k.flushMu.Lock()
+		defer k.flushMu.Unlock()
+		ce(k.flush())
+	}
+
+	return nil
+}
+
+func (k *KV) KeyGet(key string, fn func(io.Reader) error) (err error) {
+	return k.KeyGetRange(key, 0, -1, fn)
+}
+
+var _ storekv.RangeKV = new(KV)
+
+// KeyGetRange reads part of the value. Negative length reads to the end
+func (k *KV) KeyGetRange(key string, offset, length int64, fn func(io.Reader) error) (err error) {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	defer he(&err, e5.With(storekv.StringKey(key)))
+
+	for retry := 0; ; retry++ {
+		k.mu.RLock()
+		value, loc, ok := k.lookup(key)
+		k.mu.RUnlock()
+		if !ok {
+			return we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
+		}
+
+		if loc.pack == "" {
+			// pending
+			loc.length = int64(len(value.value))
+		}
+		// clip to value
+		if offset > loc.length {
+			offset = loc.length
+		}
+		if length < 0 || offset+length > loc.length {
+			length = loc.length - offset
+		}
+
+		if loc.pack == "" {
+			if fn != nil {
+				ce(fn(bytes.NewReader(value.value[offset : offset+length])))
+			}
+			return nil
+		}
+
+		err := k.readPack(location{
+			pack:   loc.pack,
+			offset: loc.offset + offset,
+			length: length,
+		}, func(r io.Reader) error {
+			if fn == nil {
+				return nil
+			}
+			return fn(r)
+		})
+		if is(err, ErrKeyNotFound) && retry == 0 {
+			// pack may be removed by repacking
+			continue
+		}
+		ce(err)
+
+		return nil
+	}
+}
+
+// readPack reads the value at loc
+func (k *KV) readPack(loc location, fn func(io.Reader) error) error {
+	if loc.length == 0 {
+		return fn(bytes.NewReader(nil))
+	}
+	path := packPrefix + loc.pack
+	if kv, ok := k.upstream.(storekv.RangeKV); ok {
+		return kv.KeyGetRange(path, loc.offset, loc.length, fn)
+	}
+	return k.upstream.KeyGet(path, func(r io.Reader) error {
+		if _, err := io.CopyN(io.Discard, r, loc.offset); err != nil {
+			return err
+		}
+		return fn(io.LimitReader(r, loc.length))
+	})
+}
+
+func (k *KV) KeyExists(key string) (bool, error) {
+	select {
+	case <-k.wg.Done():
+		return false, ErrClosed
+	default:
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	_, _, ok := k.lookup(key)
+	return ok, nil
+}
+
+func (k *KV) KeyIter(prefix string, fn func(key string) error) (err error) {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	defer he(&err, e5.Info("prefix %s", prefix))
+
+	k.mu.RLock()
+	var keys []string
+	for key := range k.index {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for _, m := range []map[string]pendingValue{k.flushing, k.pending} {
+		for key := range m {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	var ret []string
+	for i, key := range keys {
+		if i > 0 && keys[i-1] == key {
+			continue
+		}
+		if _, _, ok := k.lookup(key); !ok {
+			continue
+		}
+		ret = append(ret, key)
+	}
+	k.mu.RUnlock()
+
+	for _, key := range ret {
+		if err := fn(key); is(err, Break) {
+			return nil
+		} else {
+			ce(err)
+		}
+	}
+
+	return nil
+}
+
+func (k *KV) KeyDelete(keys ...string) error {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, key := range keys {
+		if old, ok := k.pending[key]; ok {
+			k.size -= int64(len(old.value))
+			delete(k.pending, key)
+		}
+		_, inFlushing := k.flushing[key]
+		_, inIndex := k.index[key]
+		if !inFlushing && !inIndex {
+			// not flushed
+			continue
+		}
+		k.pending[key] = pendingValue{
+			deleted: true,
+		}
+	}
+	return nil
+}
+
+// Stats is the statistics of packs
+type Stats struct {
+	Packs     int
+	Keys      int
+	Bytes     int64 // total size of packs
+	LiveBytes int64 // size of values not overridden or deleted
+	Pending   int   // buffered puts and deletes
+}
+
+func (k *KV) Stats() (stats Stats) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	stats.Packs = len(k.packs)
+	stats.Keys = len(k.index)
+	for _, p := range k.packs {
+		stats.Bytes += p.size
+		stats.LiveBytes += p.live
+	}
+	stats.Pending = len(k.pending) + len(k.flushing)
+	return
+}
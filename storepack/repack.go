@@ -0,0 +1,208 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storepack
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/reusee/e5"
+)
+
+type RepackOption interface {
+	IsRepackOption()
+}
+
+// MinLiveRatio selects packs with live bytes ratio below it to repack. Default is 0.5
+type MinLiveRatio float64
+
+func (MinLiveRatio) IsRepackOption() {}
+
+// TapRepack is called after a pack is removed
+type TapRepack func(id string)
+
+func (TapRepack) IsRepackOption() {}
+
+// Repack rewrites live values of sparse packs into new packs, and removes the old packs.
+// Packs not indexed, for example by an interrupted flush, are also removed
+func (k *KV) Repack(
+	ctx context.Context,
+	options ...RepackOption,
+) (err error) {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	defer he(&err)
+
+	minLiveRatio := 0.5
+	var tap TapRepack
+	for _, option := range options {
+		switch option := option.(type) {
+		case MinLiveRatio:
+			minLiveRatio = float64(option)
+		case TapRepack:
+			tap = option
+		default:
+			panic(fmt.Errorf("bad option: %T", option))
+		}
+	}
+
+	k.flushMu.Lock()
+	defer k.flushMu.Unlock()
+	ce(k.flush())
+	// packs written by others
+	ce(k.reload())
+
+	// select
+	k.mu.RLock()
+	selected := make(map[string]*pack)
+	for id, p := range k.packs {
+		if p.size == 0 || float64(p.live)/float64(p.size) < minLiveRatio {
+			selected[id] = p
+		}
+	}
+	// first pack of keys in packs not selected, for checking deletions
+	firstPack := make(map[string]string)
+	for id, p := range k.packs {
+		if _, ok := selected[id]; ok {
+			continue
+		}
+		for _, entry := range p.entries {
+			if entry.Deleted {
+				continue
+			}
+			if first, ok := firstPack[entry.Key]; !ok || id < first {
+				firstPack[entry.Key] = id
+			}
+		}
+	}
+	k.mu.RUnlock()
+	ids := make([]string, 0, len(selected))
+	for id := range selected {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		ce(ctx.Err())
+		p := selected[id]
+		for _, entry := range p.entries {
+			ce(k.carry(p, entry, firstPack))
+		}
+		// flush by size
+		k.mu.RLock()
+		full := k.size >= k.packSize
+		k.mu.RUnlock()
+		if full {
+			ce(k.flush())
+		}
+	}
+	ce(k.flush())
+
+	// remove
+	for _, id := range ids {
+		p := selected[id]
+		// index first, the pack is not referenced after
+		ce(k.upstream.KeyDelete(indexPrefix + id))
+		if k.cache != nil {
+			ce(k.cache.KeyDelete(indexPrefix + id))
+		}
+		k.mu.Lock()
+		delete(k.packs, id)
+		k.mu.Unlock()
+		if p.size > 0 {
+			ce(k.upstream.KeyDelete(packPrefix + id))
+		}
+		if tap != nil {
+			tap(id)
+		}
+	}
+
+	// packs not indexed
+	indexed := make(map[string]bool)
+	ce(k.upstream.KeyIter(indexPrefix, func(key string) error {
+		indexed[strings.TrimPrefix(key, indexPrefix)] = true
+		return nil
+	}))
+	var orphans []string
+	ce(k.upstream.KeyIter(packPrefix, func(key string) error {
+		if !indexed[strings.TrimPrefix(key, packPrefix)] {
+			orphans = append(orphans, key)
+		}
+		return nil
+	}))
+	if len(orphans) > 0 {
+		ce(k.upstream.KeyDelete(orphans...))
+	}
+
+	return nil
+}
+
+// carry puts the entry of repacking pack to pending values if it's still effective
+func (k *KV) carry(p *pack, entry indexEntry, firstPack map[string]string) (err error) {
+	defer he(&err, e5.Info("pack %s", p.id), e5.Info("key %s", entry.Key))
+
+	if entry.Deleted {
+		// keep the deletion if the key is deleted and remains in earlier packs
+		first, ok := firstPack[entry.Key]
+		if !ok || first > p.id {
+			return nil
+		}
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		if _, _, ok := k.lookup(entry.Key); ok {
+			return nil
+		}
+		if _, ok := k.pending[entry.Key]; ok {
+			return nil
+		}
+		k.pending[entry.Key] = pendingValue{
+			deleted: true,
+		}
+		return nil
+	}
+
+	loc := location{
+		pack:   p.id,
+		offset: entry.Offset,
+		length: entry.Length,
+	}
+	k.mu.RLock()
+	current, ok := k.index[entry.Key]
+	_, inPending := k.pending[entry.Key]
+	k.mu.RUnlock()
+	if !ok || current != loc || inPending {
+		// overridden or deleted
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	ce(k.readPack(loc, func(r io.Reader) error {
+		_, err := io.Copy(buf, r)
+		return err
+	}))
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.index[entry.Key] != loc {
+		return nil
+	}
+	if _, ok := k.pending[entry.Key]; ok {
+		return nil
+	}
+	k.pending[entry.Key] = pendingValue{
+		value: buf.Bytes(),
+	}
+	k.size += int64(buf.Len())
+
+	return nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storepack
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/june/storemem"
+	"github.com/reusee/pr2"
+)
+
+func TestKV(
+	t *testing.T,
+	test storekv.TestKV,
+	newMem storemem.New,
+	newPack New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+	with := func(fn func(storekv.KV, string)) {
+		kv, err := newPack(
+			wg,
+			newMem(wg),
+			PackSize(4096),
+			IndexCache{newMem(wg)},
+		)
+		ce(err)
+		fn(kv, "foo")
+	}
+	test(wg, t, with)
+}
+
+// rangeless hides storekv.RangeKV of the KV
+type rangeless struct {
+	storekv.KV
+}
+
+func TestPack(
+	t *testing.T,
+	newMem storemem.New,
+	newPack New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	for _, upstreamRange := range []bool{true, false} {
+		t.Run(fmt.Sprintf("range %v", upstreamRange), func(t *testing.T) {
+			defer he(nil, e5.TestingFatal(t))
+
+			mem := newMem(wg)
+			var upstream storekv.KV = mem
+			if !upstreamRange {
+				upstream = rangeless{mem}
+			}
+			cache := newMem(wg)
+
+			kv, err := newPack(wg, upstream, PackSize(1024), IndexCache{cache})
+			ce(err)
+
+			const num = 1000
+			value := func(i int) string {
+				return strings.Repeat(fmt.Sprintf("%d,", i), i%7)
+			}
+			for i := 0; i < num; i++ {
+				ce(kv.KeyPut(fmt.Sprintf("key/%d", i), strings.NewReader(value(i))))
+			}
+			ce(kv.Flush())
+
+			countUpstream := func(prefix string) (n int) {
+				ce(mem.KeyIter(prefix, func(string) error {
+					n++
+					return nil
+				}))
+				return
+			}
+			packs := countUpstream(packPrefix)
+			if packs == 0 || packs > num/20 {
+				t.Fatalf("got %d", packs)
+			}
+			if n := countUpstream(indexPrefix); n != packs {
+				t.Fatalf("got %d", n)
+			}
+			stats := kv.Stats()
+			if stats.Keys != num || stats.Pending != 0 || stats.LiveBytes != stats.Bytes {
+				t.Fatalf("got %+v", stats)
+			}
+
+			check := func(kv *KV, deleted func(int) bool) {
+				n := 0
+				ce(kv.KeyIter("key/", func(string) error {
+					n++
+					return nil
+				}))
+				expectedNum := 0
+				for i := 0; i < num; i++ {
+					key := fmt.Sprintf("key/%d", i)
+					err := kv.KeyGet(key, func(r io.Reader) error {
+						bs, err := io.ReadAll(r)
+						ce(err)
+						if string(bs) != value(i) {
+							t.Fatalf("%s: got %q", key, bs)
+						}
+						return nil
+					})
+					if deleted(i) {
+						if !is(err, ErrKeyNotFound) {
+							t.Fatalf("%s: got %v", key, err)
+						}
+						continue
+					}
+					ce(err)
+					expectedNum++
+				}
+				if n != expectedNum {
+					t.Fatalf("got %d", n)
+				}
+			}
+			check(kv, func(int) bool { return false })
+
+			// reopen
+			kv2, err := newPack(wg, upstream, PackSize(1024), IndexCache{cache})
+			ce(err)
+			check(kv2, func(int) bool { return false })
+			if n := func() (n int) {
+				ce(cache.KeyIter(indexPrefix, func(string) error {
+					n++
+					return nil
+				}))
+				return
+			}(); n != packs {
+				t.Fatalf("got %d", n)
+			}
+
+			// delete and override
+			deleted := func(i int) bool {
+				return i%3 != 0
+			}
+			for i := 0; i < num; i++ {
+				if deleted(i) {
+					ce(kv.KeyDelete(fmt.Sprintf("key/%d", i)))
+				}
+			}
+			ce(kv.KeyPut("key/0", strings.NewReader(value(0))))
+			check(kv, deleted)
+			ce(kv.Flush())
+			check(kv, deleted)
+			stats = kv.Stats()
+			if stats.LiveBytes*2 > stats.Bytes {
+				t.Fatalf("got %+v", stats)
+			}
+
+			// orphan pack
+			ce(upstream.KeyPut(packPrefix+"ffffffffffffffff", strings.NewReader("foo")))
+
+			// repack
+			var removed []string
+			ce(kv.Repack(wg, MinLiveRatio(0.9), TapRepack(func(id string) {
+				removed = append(removed, id)
+			})))
+			if len(removed) == 0 {
+				t.Fatal()
+			}
+			stats = kv.Stats()
+			if float64(stats.LiveBytes) < float64(stats.Bytes)*0.9 {
+				t.Fatalf("got %+v", stats)
+			}
+			check(kv, deleted)
+			if ok, err := upstream.KeyExists(packPrefix + "ffffffffffffffff"); err != nil || ok {
+				t.Fatal()
+			}
+
+			// reopen after repack, without cache
+			kv3, err := newPack(wg, upstream, PackSize(1024))
+			ce(err)
+			check(kv3, deleted)
+			if s := kv3.Stats(); s != stats {
+				t.Fatalf("got %+v, expected %+v", s, stats)
+			}
+		})
+	}
+}
+
+func TestPackWriters(
+	t *testing.T,
+	newMem storemem.New,
+	newPack New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	upstream := newMem(wg)
+	get := func(kv *KV, key string) string {
+		var ret string
+		ce(kv.KeyGet(key, func(r io.Reader) error {
+			bs, err := io.ReadAll(r)
+			ce(err)
+			ret = string(bs)
+			return nil
+		}))
+		return ret
+	}
+
+	// flush on close
+	kv, err := newPack(wg, upstream)
+	ce(err)
+	ce(kv.KeyPut("foo", strings.NewReader("foo")))
+	ce(kv.Close())
+	if err := kv.KeyPut("bar", strings.NewReader("bar")); !is(err, ErrClosed) {
+		t.Fatalf("got %v", err)
+	}
+	if err := kv.Close(); !is(err, ErrClosed) {
+		t.Fatalf("got %v", err)
+	}
+	a, err := newPack(wg, upstream)
+	ce(err)
+	if s := get(a, "foo"); s != "foo" {
+		t.Fatalf("got %q", s)
+	}
+
+	// discarded on cancel
+	ctx := pr2.NewWaitGroup(wg)
+	kv, err = newPack(ctx, upstream)
+	ce(err)
+	ce(kv.KeyPut("discarded", strings.NewReader("discarded")))
+	ctx.Cancel()
+	ctx.Wait()
+	kv, err = newPack(wg, upstream)
+	ce(err)
+	if ok, err := kv.KeyExists("discarded"); err != nil || ok {
+		t.Fatal()
+	}
+
+	// error of flushing on close
+	closed := pr2.NewWaitGroup(wg)
+	kv, err = newPack(wg, newMem(closed))
+	ce(err)
+	ce(kv.KeyPut("foo", strings.NewReader("foo")))
+	closed.Cancel()
+	if err := kv.Close(); err == nil {
+		t.Fatal()
+	}
+
+	// another writer
+	b, err := newPack(wg, upstream)
+	ce(err)
+	ce(a.KeyPut("bar", strings.NewReader("bar")))
+	ce(a.Flush())
+	if ok, err := b.KeyExists("bar"); err != nil || ok {
+		t.Fatal()
+	}
+	ce(b.Reload())
+	if s := get(b, "bar"); s != "bar" {
+		t.Fatalf("got %q", s)
+	}
+
+	// repack of one writer keeps packs of others
+	ce(b.KeyPut("baz", strings.NewReader("baz")))
+	ce(b.Flush())
+	ce(a.KeyPut("qux", strings.NewReader("qux")))
+	ce(a.Flush())
+	ce(b.Repack(wg))
+	c, err := newPack(wg, upstream)
+	ce(err)
+	for _, key := range []string{"foo", "bar", "baz", "qux"} {
+		if s := get(c, key); s != key {
+			t.Fatalf("got %q", s)
+		}
+	}
+}
@@ -193,6 +193,31 @@ func (b *Batch) KeyGet(key string, fn func(io.Reader) error) (err error) {
 	return b.store.keyGet(b.wg.Add, b.get, key, fn)
 }
 
+var _ storekv.RangeKV = new(Batch)
+
+func (b *Batch) KeyGetRange(key string, offset, length int64, fn func(io.Reader) error) (err error) {
+	select {
+	case <-b.wg.Done():
+		return b.wg.Err()
+	default:
+	}
+	defer b.wg.Add()()
+	return b.store.keyGet(b.wg.Add, b.get, key, rangeFunc(offset, length, fn))
+}
+
+var _ storekv.StatKV = new(Batch)
+
+func (b *Batch) KeyStat(key string) (info storekv.KeyInfo, err error) {
+	select {
+	case <-b.wg.Done():
+		return info, b.wg.Err()
+	default:
+	}
+	defer b.wg.Add()()
+	err = b.store.keyGet(b.wg.Add, b.get, key, statFunc(&info))
+	return
+}
+
 func (b *Batch) KeyIter(prefix string, fn func(key string) error) (err error) {
 	select {
 	case <-b.wg.Done():
@@ -212,6 +237,45 @@ func (b *Batch) KeyIter(prefix string, fn func(key string) error) (err error) {
 	)
 }
 
+var _ storekv.BatchKV = new(Batch)
+
+func (b *Batch) KeyExistsMany(keys []string) (_ []bool, err error) {
+	select {
+	case <-b.wg.Done():
+		return nil, b.wg.Err()
+	default:
+	}
+	defer b.wg.Add()()
+	return b.store.keyExistsMany(
+		b.wg.Add,
+		b.newIter,
+		func(fn func()) {
+			defer b.lockRead()()
+			fn()
+		},
+		keys,
+	)
+}
+
+func (b *Batch) KeyGetMany(keys []string, fn func(string, io.Reader) error) (err error) {
+	select {
+	case <-b.wg.Done():
+		return b.wg.Err()
+	default:
+	}
+	defer b.wg.Add()()
+	return b.store.keyGetMany(
+		b.wg.Add,
+		b.newIter,
+		func(fn func()) {
+			defer b.lockRead()()
+			fn()
+		},
+		keys,
+		fn,
+	)
+}
+
 func (b *Batch) newIter(options *pebble.IterOptions) *pebble.Iterator {
 	defer b.lockRead()()
 	return b.batch.NewIter(options)
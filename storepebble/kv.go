@@ -78,6 +78,35 @@ func (s *Store) keyGet(
 	return nil
 }
 
+var _ storekv.RangeKV = new(Store)
+
+func (s *Store) KeyGetRange(key string, offset, length int64, fn func(io.Reader) error) (err error) {
+	return s.keyGet(s.wg.Add, s.DB.Get, key, rangeFunc(offset, length, fn))
+}
+
+func rangeFunc(offset, length int64, fn func(io.Reader) error) func(io.Reader) error {
+	if fn == nil {
+		return nil
+	}
+	return func(r io.Reader) error {
+		return fn(io.NewSectionReader(r.(io.ReaderAt), offset, length))
+	}
+}
+
+var _ storekv.StatKV = new(Store)
+
+func (s *Store) KeyStat(key string) (info storekv.KeyInfo, err error) {
+	err = s.keyGet(s.wg.Add, s.DB.Get, key, statFunc(&info))
+	return
+}
+
+func statFunc(info *storekv.KeyInfo) func(io.Reader) error {
+	return func(r io.Reader) error {
+		info.Size = r.(*bytes.Reader).Size()
+		return nil
+	}
+}
+
 func (s *Store) KeyPut(key string, r io.Reader) (err error) {
 	return s.keyPut(s.wg.Add, s.DB.Get, s.DB.Set, key, r)
 }
@@ -0,0 +1,140 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storepebble
+
+import (
+	"bytes"
+	"io"
+	"sort"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+)
+
+var _ storekv.BatchKV = new(Store)
+
+func (s *Store) KeyExistsMany(keys []string) ([]bool, error) {
+	return s.keyExistsMany(
+		s.wg.Add,
+		s.DB.NewIter,
+		func(fn func()) {
+			fn()
+		},
+		keys,
+	)
+}
+
+func (s *Store) KeyGetMany(keys []string, fn func(string, io.Reader) error) error {
+	return s.keyGetMany(
+		s.wg.Add,
+		s.DB.NewIter,
+		func(fn func()) {
+			fn()
+		},
+		keys,
+		fn,
+	)
+}
+
+func (s *Store) keyExistsMany(
+	add func() func(),
+	newIter func(*pebble.IterOptions) *pebble.Iterator,
+	withRLock func(func()),
+	keys []string,
+) (_ []bool, err error) {
+	ret := make([]bool, len(keys))
+	err = s.keySeekMany(add, newIter, withRLock, keys, func(i int, _ []byte) error {
+		ret[i] = true
+		return nil
+	})
+	return ret, err
+}
+
+func (s *Store) keyGetMany(
+	add func() func(),
+	newIter func(*pebble.IterOptions) *pebble.Iterator,
+	withRLock func(func()),
+	keys []string,
+	fn func(string, io.Reader) error,
+) (err error) {
+	found := make([]bool, len(keys))
+	err = s.keySeekMany(add, newIter, withRLock, keys, func(i int, value []byte) error {
+		found[i] = true
+		return fn(keys[i], bytes.NewReader(value))
+	})
+	if err != nil {
+		return err
+	}
+	for i, ok := range found {
+		if !ok {
+			return we.With(e5.With(storekv.StringKey(keys[i])))(ErrKeyNotFound)
+		}
+	}
+	return nil
+}
+
+// keySeekMany seeks keys in order with one iterator, calls fn with index and value of existing keys
+func (s *Store) keySeekMany(
+	add func() func(),
+	newIter func(*pebble.IterOptions) *pebble.Iterator,
+	withRLock func(func()),
+	keys []string,
+	fn func(int, []byte) error,
+) (err error) {
+	defer he(&err)
+	defer add()()
+	defer catchErr(&err, pebble.ErrClosed)
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	indexes := make([]int, len(keys))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return keys[indexes[i]] < keys[indexes[j]]
+	})
+
+	var iter *pebble.Iterator
+	withRLock(func() {
+		iter = newIter(new(pebble.IterOptions))
+	})
+	defer func() {
+		withRLock(func() {
+			if e := iter.Error(); e != nil && err == nil {
+				err = e
+			}
+			if e := iter.Close(); e != nil && err == nil {
+				err = e
+			}
+		})
+	}()
+
+	for _, i := range indexes {
+		var bsKey []byte
+		withMarshalKey(func(k []byte) {
+			bsKey = append(k[:0:0], k...)
+		}, Kv, keys[i])
+		var value []byte
+		withRLock(func() {
+			if iter.SeekGE(bsKey) && bytes.Equal(iter.Key(), bsKey) {
+				value = iter.Value()
+				if value == nil {
+					value = []byte{}
+				}
+			}
+		})
+		if value == nil {
+			continue
+		}
+		err = fn(i, value)
+		ce(err, e5.With(storekv.StringKey(keys[i])))
+	}
+
+	return nil
+}
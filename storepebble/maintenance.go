@@ -0,0 +1,44 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storepebble
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/reusee/e5"
+	"github.com/reusee/sb"
+)
+
+// Backup writes a consistent checkpoint of the database to destPath, which must not exist.
+// The checkpoint is created in the same vfs.FS of the store, and can be opened by New
+func (s *Store) Backup(ctx context.Context, destPath string) (err error) {
+	defer he(&err, e5.Info("backup to %s", destPath))
+	defer s.wg.Add()()
+	defer catchErr(&err, pebble.ErrClosed)
+
+	ce(ctx.Err())
+	ce(s.DB.Checkpoint(destPath, pebble.WithFlushedWAL()))
+
+	return nil
+}
+
+// Compact compacts all keys to reclaim space of deleted values
+func (s *Store) Compact(ctx context.Context) (err error) {
+	defer he(&err)
+	defer s.wg.Add()()
+	defer catchErr(&err, pebble.ErrClosed)
+
+	ce(ctx.Err())
+	// all keys are tuples, between sb.Min and sb.Max
+	start := new(bytes.Buffer)
+	ce(sb.Copy(sb.Marshal(sb.Min), sb.Encode(start)))
+	end := new(bytes.Buffer)
+	ce(sb.Copy(sb.Marshal(sb.Max), sb.Encode(end)))
+	ce(s.DB.Compact(start.Bytes(), end.Bytes(), true))
+
+	return nil
+}
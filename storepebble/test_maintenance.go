@@ -0,0 +1,108 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storepebble
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/pr2"
+)
+
+func TestBackup(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	s, err := newStore(wg, nil, t.TempDir())
+	ce(err)
+	const num = 1000
+	for i := 0; i < num; i++ {
+		key := fmt.Sprintf("foo%d", i)
+		ce(s.KeyPut(key, strings.NewReader(key)))
+	}
+
+	// concurrent writes
+	var writeWG sync.WaitGroup
+	writeWG.Add(1)
+	go func() {
+		defer writeWG.Done()
+		for i := 0; i < num; i++ {
+			key := fmt.Sprintf("bar%d", i)
+			ce(s.KeyPut(key, strings.NewReader(key)))
+		}
+	}()
+	dest := filepath.Join(t.TempDir(), "backup")
+	ce(s.Backup(wg, dest))
+	writeWG.Wait()
+
+	// exists
+	if err := s.Backup(wg, dest); err == nil {
+		t.Fatal()
+	}
+
+	backup, err := newStore(wg, nil, dest)
+	ce(err)
+	for i := 0; i < num; i++ {
+		key := fmt.Sprintf("foo%d", i)
+		ce(backup.KeyGet(key, func(r io.Reader) error {
+			bs, err := io.ReadAll(r)
+			ce(err)
+			if string(bs) != key {
+				t.Fatalf("got %s", bs)
+			}
+			return nil
+		}))
+	}
+}
+
+func TestCompact(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	s, err := newStore(wg, nil, t.TempDir())
+	ce(err)
+	const num = 1000
+	var keys []string
+	for i := 0; i < num; i++ {
+		key := fmt.Sprintf("foo%d", i)
+		// not compressible
+		value := make([]byte, 4096)
+		rand.Read(value)
+		ce(s.KeyPut(key, bytes.NewReader(value)))
+		keys = append(keys, key)
+	}
+	ce(s.DB.Flush())
+	size := s.DB.Metrics().Total().Size
+
+	ce(s.KeyDelete(keys[1:]...))
+	ce(s.Compact(wg))
+	if n := s.DB.Metrics().Total().Size; n*2 > size {
+		t.Fatalf("got %d, before %d", n, size)
+	}
+
+	ok, err := s.KeyExists(keys[0])
+	ce(err)
+	if !ok {
+		t.Fatal()
+	}
+	ok, err = s.KeyExists(keys[1])
+	ce(err)
+	if ok {
+		t.Fatal()
+	}
+}
@@ -14,22 +14,36 @@ import (
 	"github.com/reusee/june/storekv"
 )
 
+func isNoSuchKey(err error) bool {
+	var resp minio.ErrorResponse
+	return as(err, &resp) && resp.Code == "NoSuchKey"
+}
+
 func (k *KV) KeyExists(key string) (_ bool, err error) {
 	defer k.wg.Add()()
 	defer he(&err)
 	ctx, cancel := context.WithTimeout(k.wg, k.timeout)
 	defer cancel()
-	_, err = k.client.StatObject(ctx, k.bucket, key, minio.StatObjectOptions{})
-	if err != nil {
-		var resp minio.ErrorResponse
-		if as(err, &resp) && resp.Code == "NoSuchKey" {
-			return false, nil
-		}
-		ce(err)
+	err = k.retry(ctx, func() error {
+		_, err := k.client.StatObject(ctx, k.bucket, key, minio.StatObjectOptions{})
+		return err
+	})
+	if isNoSuchKey(err) {
+		return false, nil
 	}
+	ce(err)
 	return true, nil
 }
 
+// getObject sends the GET request, errors are returned before reading the body
+func (k *KV) getObject(ctx context.Context, key string, options minio.GetObjectOptions) (body io.ReadCloser, err error) {
+	err = k.retry(ctx, func() (err error) {
+		body, _, _, err = k.core.GetObject(ctx, k.bucket, key, options)
+		return
+	})
+	return
+}
+
 func (k *KV) KeyGet(key string, fn func(io.Reader) error) (err error) {
 	defer k.wg.Add()()
 	defer he(&err,
@@ -37,47 +51,189 @@ func (k *KV) KeyGet(key string, fn func(io.Reader) error) (err error) {
 	)
 	ctx, cancel := context.WithTimeout(k.wg, k.timeout)
 	defer cancel()
-	obj, err := k.client.GetObject(ctx, k.bucket, key, minio.GetObjectOptions{})
-	var resp minio.ErrorResponse
-	if as(err, &resp) && resp.Code == "NoSuchKey" {
+	body, err := k.getObject(ctx, key, minio.GetObjectOptions{})
+	if isNoSuchKey(err) {
 		return we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
 	}
 	ce(err)
-	defer obj.Close()
+	defer body.Close()
 	if fn != nil {
-		if err := fn(obj); as(err, &resp) && resp.Code == "NoSuchKey" {
-			return we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
-		} else {
-			ce(err)
-		}
+		ce(fn(body))
 	}
 	return nil
 }
 
-func (k *KV) KeyPut(key string, r io.Reader) (err error) {
+var _ storekv.RangeKV = new(KV)
+
+func (k *KV) KeyGetRange(key string, offset, length int64, fn func(io.Reader) error) (err error) {
 	defer k.wg.Add()()
 	defer he(&err,
 		e5.With(storekv.StringKey(key)),
 	)
 	ctx, cancel := context.WithTimeout(k.wg, k.timeout)
 	defer cancel()
-	var content []byte
+	var options minio.GetObjectOptions
+	err = options.SetRange(offset, offset+length-1)
+	ce(err)
+	var r io.Reader
+	body, err := k.getObject(ctx, key, options)
+	var resp minio.ErrorResponse
+	if isNoSuchKey(err) {
+		return we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
+	} else if as(err, &resp) && resp.Code == "InvalidRange" {
+		// offset past the end
+		r = bytes.NewReader(nil)
+	} else {
+		ce(err)
+		defer body.Close()
+		r = body
+	}
+	if fn != nil {
+		err := fn(r)
+		ce(err)
+	}
+	return nil
+}
+
+var _ storekv.StatKV = new(KV)
+
+func (k *KV) KeyStat(key string) (info storekv.KeyInfo, err error) {
+	defer k.wg.Add()()
+	defer he(&err,
+		e5.With(storekv.StringKey(key)),
+	)
+	ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+	defer cancel()
+	var stat minio.ObjectInfo
+	err = k.retry(ctx, func() (err error) {
+		stat, err = k.client.StatObject(ctx, k.bucket, key, minio.StatObjectOptions{})
+		return
+	})
+	if isNoSuchKey(err) {
+		return info, we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
+	}
+	ce(err)
+	info.Size = stat.Size
+	info.ModTime = stat.LastModified
+	return
+}
+
+func (k *KV) KeyPut(key string, r io.Reader) (err error) {
+	defer k.wg.Add()()
+	defer he(&err,
+		e5.With(storekv.StringKey(key)),
+	)
+
+	// decide whether to upload in parts by size, reading up to threshold if the size is unknown
+	var head []byte
+	multipart := false
 	if b, ok := r.(interface {
 		Bytes() []byte
 	}); ok {
-		content = b.Bytes()
+		head = b.Bytes()
+		multipart = int64(len(head)) > k.multipartThreshold
+		r = bytes.NewReader(head)
+	} else if l, ok := r.(interface {
+		Len() int
+	}); ok && int64(l.Len()) > k.multipartThreshold {
+		multipart = true
 	} else {
-		var err error
-		content, err = io.ReadAll(r)
+		head, err = io.ReadAll(io.LimitReader(r, k.multipartThreshold+1))
 		ce(err)
+		multipart = int64(len(head)) > k.multipartThreshold
+		r = io.MultiReader(bytes.NewReader(head), r)
+	}
+
+	if multipart {
+		return k.putMultipart(key, r)
 	}
-	if _, err := k.client.PutObject(
-		ctx, k.bucket, key,
-		bytes.NewReader(content), int64(len(content)),
-		minio.PutObjectOptions{},
-	); err != nil {
+
+	ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+	defer cancel()
+	ce(k.retry(ctx, func() error {
+		_, err := k.core.PutObject(
+			ctx, k.bucket, key,
+			bytes.NewReader(head), int64(len(head)),
+			"", "",
+			minio.PutObjectOptions{},
+		)
 		return err
+	}))
+	return nil
+}
+
+// putMultipart uploads r in parts, only one part is buffered in memory
+func (k *KV) putMultipart(key string, r io.Reader) (err error) {
+	defer he(&err)
+
+	var uploadID string
+	func() {
+		ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+		defer cancel()
+		ce(k.retry(ctx, func() (err error) {
+			uploadID, err = k.core.NewMultipartUpload(ctx, k.bucket, key, minio.PutObjectOptions{})
+			return
+		}))
+	}()
+
+	if err := k.uploadParts(key, uploadID, r); err != nil {
+		ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+		defer cancel()
+		_ = k.core.AbortMultipartUpload(ctx, k.bucket, key, uploadID)
+		return err
+	}
+
+	return nil
+}
+
+// uploadParts uploads parts of r and completes the upload
+func (k *KV) uploadParts(key string, uploadID string, r io.Reader) (err error) {
+	defer he(&err)
+
+	var parts []minio.CompletePart
+	buf := make([]byte, k.partSize)
+	for partNumber := 1; ; partNumber++ {
+		n, err := io.ReadFull(r, buf)
+		if n == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		eof := err == io.ErrUnexpectedEOF
+
+		ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+		var part minio.ObjectPart
+		err = k.retry(ctx, func() (err error) {
+			part, err = k.core.PutObjectPart(
+				ctx, k.bucket, key, uploadID, partNumber,
+				bytes.NewReader(buf[:n]), int64(n),
+				minio.PutObjectPartOptions{},
+			)
+			return
+		})
+		cancel()
+		ce(err, e5.Info("part %d", partNumber))
+		parts = append(parts, minio.CompletePart{
+			PartNumber: part.PartNumber,
+			ETag:       part.ETag,
+		})
+
+		if eof {
+			break
+		}
 	}
+
+	ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+	defer cancel()
+	ce(k.retry(ctx, func() error {
+		_, err := k.core.CompleteMultipartUpload(
+			ctx, k.bucket, key, uploadID, parts,
+			minio.PutObjectOptions{},
+		)
+		return err
+	}))
+
 	return nil
 }
 
@@ -93,10 +249,13 @@ loop:
 			break loop
 		default:
 		}
-		res, err := k.core.ListObjects(
-			k.bucket, prefix, marker, "", -1,
-		)
-		ce(err)
+		var res minio.ListBucketResult
+		ce(k.retry(k.wg, func() (err error) {
+			res, err = k.core.ListObjects(
+				k.bucket, prefix, marker, "", -1,
+			)
+			return
+		}))
 		if len(res.Contents) == 0 {
 			break
 		}
@@ -120,33 +279,44 @@ func (k *KV) KeyDelete(keys ...string) (err error) {
 	defer k.wg.Add()()
 	defer he(&err)
 	for len(keys) > 0 {
-		i := 0
-
-		ctx, cancel := context.WithTimeout(k.wg, k.timeout)
-		defer cancel()
-		ch := make(chan minio.ObjectInfo)
-		errChan := k.client.RemoveObjects(
-			ctx,
-			k.bucket,
-			ch,
-			minio.RemoveObjectsOptions{},
-		)
-		for ; i < 1000 && i < len(keys); i++ {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case err := <-errChan:
-				return err.Err
-			case ch <- minio.ObjectInfo{
-				Key: keys[i],
-			}:
-			}
+		n := len(keys)
+		if n > 1000 {
+			n = 1000
 		}
-		close(ch)
-		err := <-errChan
-		ce(err.Err)
-
-		keys = keys[i:]
+		batch := keys[:n]
+		ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+		err := k.retry(ctx, func() error {
+			return k.deleteBatch(ctx, batch)
+		})
+		cancel()
+		ce(err)
+		keys = keys[n:]
 	}
 	return nil
 }
+
+func (k *KV) deleteBatch(ctx context.Context, keys []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch := make(chan minio.ObjectInfo)
+	errChan := k.client.RemoveObjects(
+		ctx,
+		k.bucket,
+		ch,
+		minio.RemoveObjectsOptions{},
+	)
+	for _, key := range keys {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errChan:
+			return err.Err
+		case ch <- minio.ObjectInfo{
+			Key: key,
+		}:
+		}
+	}
+	close(ch)
+	err := <-errChan
+	return err.Err
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package stores3
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// RetryPolicy configures retrying of requests failed by network errors, server errors or throttling.
+// Delays are doubled on each retry, with jitter, and capped to MaxDelay
+type RetryPolicy struct {
+	MaxRetries   int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+func (RetryPolicy) IsNewOption() {}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxRetries:   5,
+	InitialDelay: time.Millisecond * 200,
+	MaxDelay:     time.Second * 10,
+}
+
+// error codes retried by RetryPolicy
+var retryableCodes = map[string]bool{
+	"SlowDown":             true,
+	"RequestTimeout":       true,
+	"Throttling":           true,
+	"ThrottlingException":  true,
+	"RequestLimitExceeded": true,
+	"RequestThrottled":     true,
+	"InternalError":        true,
+}
+
+func init() {
+	// requests are retried by RetryPolicy, not the minio client.
+	// MaxRetry is the only retry setting of the minio client, and applies to all clients
+	minio.MaxRetry = 1
+}
+
+func isRetryable(err error) bool {
+	var resp minio.ErrorResponse
+	if as(err, &resp) {
+		return resp.StatusCode >= http.StatusInternalServerError ||
+			resp.StatusCode == http.StatusTooManyRequests ||
+			retryableCodes[resp.Code]
+	}
+	// network errors
+	var urlErr *url.Error
+	if as(err, &urlErr) {
+		return !is(err, context.Canceled) &&
+			!is(err, context.DeadlineExceeded)
+	}
+	return false
+}
+
+// retry calls fn until it succeeds, returns a non-retryable error, or retries exhausted
+func (k *KV) retry(ctx context.Context, fn func() error) error {
+	delay := k.retryPolicy.InitialDelay
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil ||
+			i >= k.retryPolicy.MaxRetries ||
+			!isRetryable(err) {
+			return err
+		}
+
+		// jitter in [delay/2, delay]
+		d := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > k.retryPolicy.MaxDelay {
+			delay = k.retryPolicy.MaxDelay
+		}
+	}
+}
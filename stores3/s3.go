@@ -7,6 +7,7 @@ package stores3
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -27,6 +28,10 @@ type KV struct {
 	core    *minio.Core
 	timeout time.Duration
 
+	multipartThreshold int64
+	partSize           int64
+	retryPolicy        RetryPolicy
+
 	endpoint string
 	key      string
 	secret   string
@@ -71,6 +76,24 @@ type NewOption interface {
 	IsNewOption()
 }
 
+// MultipartThreshold sets the size above which values are uploaded in parts. Default is 64MiB
+type MultipartThreshold int64
+
+func (MultipartThreshold) IsNewOption() {}
+
+// PartSize sets the size of parts in multipart uploads. Default is 16MiB.
+// S3 requires parts except the last one to be at least 5MiB
+type PartSize int64
+
+func (PartSize) IsNewOption() {}
+
+// WithTransport sets the HTTP transport of the client
+type WithTransport struct {
+	http.RoundTripper
+}
+
+func (WithTransport) IsNewOption() {}
+
 func (Def) New(
 	timeout Timeout,
 ) New {
@@ -81,19 +104,45 @@ func (Def) New(
 		secret string,
 		_ bool,
 		bucket string,
-		_ ...NewOption,
+		options ...NewOption,
 	) (_ *KV, err error) {
 		defer he(&err)
 
+		var transport http.RoundTripper
+		multipartThreshold := int64(64 * 1024 * 1024)
+		partSize := int64(16 * 1024 * 1024)
+		retryPolicy := defaultRetryPolicy
+		for _, option := range options {
+			switch option := option.(type) {
+			case MultipartThreshold:
+				multipartThreshold = int64(option)
+			case PartSize:
+				partSize = int64(option)
+			case RetryPolicy:
+				retryPolicy = option
+			case WithTransport:
+				transport = option.RoundTripper
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+
+		if transport == nil {
+			transport, err = minio.DefaultTransport(true)
+			ce(err)
+		}
+
 		client, err := minio.New(endpoint, &minio.Options{
-			Creds:  credentials.NewStaticV4(key, secret, ""),
-			Secure: true,
+			Creds:     credentials.NewStaticV4(key, secret, ""),
+			Secure:    true,
+			Transport: transport,
 		})
 		ce(err)
 
 		core, err := minio.NewCore(endpoint, &minio.Options{
-			Creds:  credentials.NewStaticV4(key, secret, ""),
-			Secure: true,
+			Creds:     credentials.NewStaticV4(key, secret, ""),
+			Secure:    true,
+			Transport: transport,
 		})
 		ce(err)
 
@@ -107,13 +156,16 @@ func (Def) New(
 				endpoint,
 				bucket,
 			),
-			client:   client,
-			core:     core,
-			bucket:   bucket,
-			timeout:  time.Duration(timeout),
-			endpoint: endpoint,
-			key:      key,
-			secret:   secret,
+			client:             client,
+			core:               core,
+			bucket:             bucket,
+			timeout:            time.Duration(timeout),
+			endpoint:           endpoint,
+			key:                key,
+			secret:             secret,
+			multipartThreshold: multipartThreshold,
+			partSize:           partSize,
+			retryPolicy:        retryPolicy,
 		}
 
 		return kv, nil
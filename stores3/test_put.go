@@ -0,0 +1,359 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package stores3
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+)
+
+func TestFakeKV(
+	t *testing.T,
+	testKV storekv.TestKV,
+	newKV New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	_, server := newFakeS3()
+	defer server.Close()
+
+	with := func(fn func(storekv.KV, string)) {
+		kv, err := newKV(
+			wg,
+			strings.TrimPrefix(server.URL, "https://"),
+			"foo", "bar", true, "test",
+			WithTransport{server.Client().Transport},
+		)
+		ce(err)
+		fn(kv, strconv.FormatInt(rand.Int63(), 10)+"/")
+	}
+	testKV(wg, t, with)
+}
+
+// countingReader generates deterministic bytes and counts bytes read
+type countingReader struct {
+	size int64
+	read int64
+}
+
+func (c *countingReader) Read(buf []byte) (int, error) {
+	read := atomic.LoadInt64(&c.read)
+	if read >= c.size {
+		return 0, io.EOF
+	}
+	n := int64(len(buf))
+	if n > c.size-read {
+		n = c.size - read
+	}
+	for i := int64(0); i < n; i++ {
+		buf[i] = byte((read + i) % 251)
+	}
+	atomic.AddInt64(&c.read, n)
+	return int(n), nil
+}
+
+func TestMultipart(
+	t *testing.T,
+	newKV New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	fake, server := newFakeS3()
+	defer server.Close()
+
+	const threshold = 1024 * 1024
+	const partSize = 256 * 1024
+	kv, err := newKV(
+		wg,
+		strings.TrimPrefix(server.URL, "https://"),
+		"foo", "bar", true, "test",
+		WithTransport{server.Client().Transport},
+		MultipartThreshold(threshold),
+		PartSize(partSize),
+	)
+	ce(err)
+
+	const size = threshold*3 + 123
+	src := &countingReader{
+		size: size,
+	}
+	var l sync.Mutex
+	var partSizes []int64
+	var readBeforeFirstPart int64
+	fake.setFail(func(req *http.Request) (int, string) {
+		if req.Method == http.MethodPut && req.URL.Query().Has("partNumber") {
+			l.Lock()
+			if len(partSizes) == 0 {
+				readBeforeFirstPart = atomic.LoadInt64(&src.read)
+			}
+			partSizes = append(partSizes, req.ContentLength)
+			l.Unlock()
+		}
+		return 0, ""
+	})
+
+	ce(kv.KeyPut("foo", src))
+
+	// streaming
+	if readBeforeFirstPart >= size {
+		t.Fatalf("got %d", readBeforeFirstPart)
+	}
+	if len(partSizes) != size/partSize+1 {
+		t.Fatalf("got %d", len(partSizes))
+	}
+	for i, n := range partSizes {
+		if i < len(partSizes)-1 && n != partSize {
+			t.Fatalf("got %d", n)
+		}
+	}
+
+	// content
+	expected, err := io.ReadAll(&countingReader{
+		size: size,
+	})
+	ce(err)
+	ce(kv.KeyGet("foo", func(r io.Reader) error {
+		bs, err := io.ReadAll(r)
+		ce(err)
+		if !bytes.Equal(bs, expected) {
+			t.Fatal()
+		}
+		return nil
+	}))
+
+	// in-memory value
+	l.Lock()
+	partSizes = partSizes[:0]
+	l.Unlock()
+	ce(kv.KeyPut("bar", bytes.NewBuffer(expected)))
+	if len(partSizes) != size/partSize+1 {
+		t.Fatalf("got %d", len(partSizes))
+	}
+	ce(kv.KeyGet("bar", func(r io.Reader) error {
+		bs, err := io.ReadAll(r)
+		ce(err)
+		if !bytes.Equal(bs, expected) {
+			t.Fatal()
+		}
+		return nil
+	}))
+
+	// small value
+	l.Lock()
+	partSizes = partSizes[:0]
+	l.Unlock()
+	ce(kv.KeyPut("baz", strings.NewReader("baz")))
+	if len(partSizes) != 0 {
+		t.Fatal()
+	}
+
+	if fake.numUploads() != 0 {
+		t.Fatal()
+	}
+}
+
+func TestRetry(
+	t *testing.T,
+	newKV New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	fake, server := newFakeS3()
+	defer server.Close()
+
+	kv, err := newKV(
+		wg,
+		strings.TrimPrefix(server.URL, "https://"),
+		"foo", "bar", true, "test",
+		WithTransport{server.Client().Transport},
+		MultipartThreshold(1024),
+		PartSize(256),
+		RetryPolicy{
+			MaxRetries:   3,
+			InitialDelay: time.Millisecond,
+			MaxDelay:     time.Millisecond * 4,
+		},
+	)
+	ce(err)
+
+	var attempts int64
+	setFail := func(fn func(req *http.Request) bool, status int, code string) {
+		atomic.StoreInt64(&attempts, 0)
+		fake.setFail(func(req *http.Request) (int, string) {
+			if !fn(req) {
+				return 0, ""
+			}
+			atomic.AddInt64(&attempts, 1)
+			return status, code
+		})
+	}
+	isPart := func(n string) func(*http.Request) bool {
+		return func(req *http.Request) bool {
+			return req.Method == http.MethodPut && req.URL.Query().Get("partNumber") == n
+		}
+	}
+	isPut := func(req *http.Request) bool {
+		return req.Method == http.MethodPut && !req.URL.Query().Has("partNumber")
+	}
+	content := bytes.Repeat([]byte("foo"), 1024)
+
+	// throttled part
+	n := 0
+	setFail(func(req *http.Request) bool {
+		if !isPart("2")(req) {
+			return false
+		}
+		n++
+		return n <= 2
+	}, http.StatusServiceUnavailable, "SlowDown")
+	ce(kv.KeyPut("foo", bytes.NewReader(content)))
+	if attempts != 2 {
+		t.Fatalf("got %d", attempts)
+	}
+	ce(kv.KeyGet("foo", func(r io.Reader) error {
+		bs, err := io.ReadAll(r)
+		ce(err)
+		if !bytes.Equal(bs, content) {
+			t.Fatal()
+		}
+		return nil
+	}))
+
+	// throttled get
+	n = 0
+	setFail(func(req *http.Request) bool {
+		if req.Method != http.MethodGet || req.URL.Path != "/test/foo" {
+			return false
+		}
+		n++
+		return n <= 1
+	}, http.StatusServiceUnavailable, "SlowDown")
+	ce(kv.KeyGet("foo", nil))
+	if attempts != 1 {
+		t.Fatalf("got %d", attempts)
+	}
+
+	// retries exhausted
+	setFail(isPut, http.StatusInternalServerError, "InternalError")
+	err = kv.KeyPut("bar", strings.NewReader("bar"))
+	if !isRetryable(err) {
+		t.Fatalf("got %v", err)
+	}
+	if attempts != 4 {
+		t.Fatalf("got %d", attempts)
+	}
+
+	// not retryable
+	setFail(isPut, http.StatusBadRequest, "InvalidArgument")
+	err = kv.KeyPut("bar", strings.NewReader("bar"))
+	if err == nil || isRetryable(err) {
+		t.Fatalf("got %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("got %d", attempts)
+	}
+
+	// aborted multipart upload
+	setFail(isPart("1"), http.StatusServiceUnavailable, "SlowDown")
+	err = kv.KeyPut("baz", bytes.NewReader(content))
+	if !isRetryable(err) {
+		t.Fatalf("got %v", err)
+	}
+	if attempts != 4 {
+		t.Fatalf("got %d", attempts)
+	}
+	if fake.numUploads() != 0 {
+		t.Fatal()
+	}
+	ok, err := kv.KeyExists("baz")
+	ce(err)
+	if ok {
+		t.Fatal()
+	}
+}
+
+// flakyTransport fails requests while fail is positive
+type flakyTransport struct {
+	http.RoundTripper
+	fail     atomic.Int64
+	attempts atomic.Int64
+}
+
+var errTransport = errors.New("transport failed")
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.attempts.Add(1)
+	if f.fail.Add(-1) >= 0 {
+		return nil, errTransport
+	}
+	return f.RoundTripper.RoundTrip(req)
+}
+
+func TestRetryNetworkError(
+	t *testing.T,
+	newKV New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	_, server := newFakeS3()
+	defer server.Close()
+
+	transport := &flakyTransport{
+		RoundTripper: server.Client().Transport,
+	}
+	kv, err := newKV(
+		wg,
+		strings.TrimPrefix(server.URL, "https://"),
+		"foo", "bar", true, "test",
+		WithTransport{transport},
+		RetryPolicy{
+			MaxRetries:   3,
+			InitialDelay: time.Millisecond,
+			MaxDelay:     time.Millisecond * 4,
+		},
+	)
+	ce(err)
+	ce(kv.KeyPut("foo", strings.NewReader("foo")))
+
+	// retried
+	transport.attempts.Store(0)
+	transport.fail.Store(2)
+	ce(kv.KeyGet("foo", nil))
+	if n := transport.attempts.Load(); n != 3 {
+		t.Fatalf("got %d", n)
+	}
+
+	// retries exhausted
+	transport.attempts.Store(0)
+	transport.fail.Store(100)
+	err = kv.KeyGet("foo", nil)
+	if !is(err, errTransport) {
+		t.Fatalf("got %v", err)
+	}
+	if !strings.Contains(err.Error(), errTransport.Error()) {
+		t.Fatalf("got %v", err)
+	}
+	if n := transport.attempts.Load(); n != 4 {
+		t.Fatalf("got %d", n)
+	}
+	transport.fail.Store(0)
+}
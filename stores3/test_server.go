@@ -0,0 +1,297 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package stores3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeS3 is a minimal in-memory S3 server for testing, with path-style buckets
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	serial  int
+
+	// fail returns a non-empty error code to fail the request
+	fail func(req *http.Request) (status int, code string)
+}
+
+func newFakeS3() (*fakeS3, *httptest.Server) {
+	s := &fakeS3{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+	return s, httptest.NewTLSServer(s)
+}
+
+func (s *fakeS3) setFail(fn func(req *http.Request) (int, string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fn
+}
+
+func (s *fakeS3) numUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+const fakeListPageSize = 100
+
+func (s *fakeS3) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func (s *fakeS3) writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	bs, err := xml.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	_, _ = w.Write(bs)
+}
+
+func etag(bs []byte) string {
+	sum := md5.Sum(bs)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail != nil {
+		if status, code := s.fail(req); code != "" {
+			_, _ = io.Copy(io.Discard, req.Body)
+			s.writeError(w, status, code)
+			return
+		}
+	}
+
+	// /bucket/key
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	key := ""
+	if len(parts) > 1 {
+		key = parts[1]
+	}
+	query := req.URL.Query()
+
+	switch {
+
+	case key == "" && query.Has("location"):
+		s.writeXML(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Value   string   `xml:",chardata"`
+		}{
+			Value: "us-east-1",
+		})
+
+	case key == "" && req.Method == http.MethodGet:
+		// list objects v1
+		prefix := query.Get("prefix")
+		marker := query.Get("marker")
+		var keys []string
+		for k := range s.objects {
+			if strings.HasPrefix(k, prefix) && k > marker {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		truncated := false
+		if len(keys) > fakeListPageSize {
+			keys = keys[:fakeListPageSize]
+			truncated = true
+		}
+		type content struct {
+			Key          string
+			LastModified string
+			ETag         string
+			Size         int
+		}
+		var contents []content
+		for _, k := range keys {
+			contents = append(contents, content{
+				Key:          k,
+				LastModified: time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+				ETag:         etag(s.objects[k]),
+				Size:         len(s.objects[k]),
+			})
+		}
+		s.writeXML(w, struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Name        string
+			Prefix      string
+			Marker      string
+			MaxKeys     int
+			IsTruncated bool
+			Contents    []content
+		}{
+			Name:        bucket,
+			Prefix:      prefix,
+			Marker:      marker,
+			MaxKeys:     fakeListPageSize,
+			IsTruncated: truncated,
+			Contents:    contents,
+		})
+
+	case key == "" && req.Method == http.MethodPost && query.Has("delete"):
+		var del struct {
+			Objects []struct {
+				Key string
+			} `xml:"Object"`
+		}
+		if err := xml.NewDecoder(req.Body).Decode(&del); err != nil {
+			s.writeError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		for _, obj := range del.Objects {
+			delete(s.objects, obj.Key)
+		}
+		s.writeXML(w, struct {
+			XMLName xml.Name `xml:"DeleteResult"`
+		}{})
+
+	case req.Method == http.MethodPost && query.Has("uploads"):
+		s.serial++
+		uploadID := strconv.Itoa(s.serial)
+		s.uploads[uploadID] = make(map[int][]byte)
+		s.writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{
+			Bucket:   bucket,
+			Key:      key,
+			UploadId: uploadID,
+		})
+
+	case req.Method == http.MethodPut && query.Has("uploadId"):
+		upload, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			s.writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		partNumber, err := strconv.Atoi(query.Get("partNumber"))
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+		content, err := io.ReadAll(req.Body)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		upload[partNumber] = content
+		w.Header().Set("ETag", etag(content))
+
+	case req.Method == http.MethodPost && query.Has("uploadId"):
+		uploadID := query.Get("uploadId")
+		upload, ok := s.uploads[uploadID]
+		if !ok {
+			s.writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(req.Body).Decode(&complete); err != nil {
+			s.writeError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var content []byte
+		for _, part := range complete.Parts {
+			bs, ok := upload[part.PartNumber]
+			if !ok || strings.Trim(etag(bs), `"`) != strings.Trim(part.ETag, `"`) {
+				s.writeError(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			content = append(content, bs...)
+		}
+		delete(s.uploads, uploadID)
+		s.objects[key] = content
+		s.writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{
+			Bucket: bucket,
+			Key:    key,
+			ETag:   etag(content),
+		})
+
+	case req.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case req.Method == http.MethodPut:
+		content, err := io.ReadAll(req.Body)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.objects[key] = content
+		w.Header().Set("ETag", etag(content))
+
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		content, ok := s.objects[key]
+		if !ok {
+			s.writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(content))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		status := http.StatusOK
+		if r := req.Header.Get("Range"); r != "" {
+			var start, end int
+			if _, err := fmt.Sscanf(r, "bytes=%d-%d", &start, &end); err != nil {
+				s.writeError(w, http.StatusBadRequest, "InvalidArgument")
+				return
+			}
+			if start >= len(content) {
+				s.writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+				return
+			}
+			if end >= len(content) {
+				end = len(content) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+			content = content[start : end+1]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.WriteHeader(status)
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(content)
+		}
+
+	case req.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		s.writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storesftp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+)
+
+// Layout of remote files:
+//
+//	key "foo/bar/baz" is stored in <root>/foo/bar/@ba/baz
+//
+// Slashes in key delimit directories. The last component is fanned out by its first two bytes,
+// to avoid too many files in a single directory.
+// Directory names starting with '@' are fan-out directories, since '@' is always escaped in key components.
+// Temporary files are written to <root>/@tmp and renamed to the target path
+
+const (
+	fanPrefix  = "@"
+	fanLength  = 2
+	tmpDirName = "@tmp"
+)
+
+// escape escapes a key component or a prefix of it.
+// '%', '@', '/', control characters and leading '.' are percent-encoded
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '%' || c == '@' || c == '/' || c < 0x20 || c == 0x7f || (i == 0 && c == '.') {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func unescape(s string) (string, error) {
+	return url.PathUnescape(s)
+}
+
+func fanOf(escaped string) string {
+	if len(escaped) > fanLength {
+		return fanPrefix + escaped[:fanLength]
+	}
+	return fanPrefix + escaped
+}
+
+// dirPath returns the remote directory of key components
+func (k *KV) dirPath(components []string) (string, error) {
+	parts := []string{k.root}
+	for _, c := range components {
+		if c == "" {
+			return "", errBadKey
+		}
+		parts = append(parts, escape(c))
+	}
+	return path.Join(parts...), nil
+}
+
+// keyPath returns the remote directory and file path of key
+func (k *KV) keyPath(key string) (dir string, file string, err error) {
+	components := strings.Split(key, "/")
+	name := components[len(components)-1]
+	if name == "" {
+		return "", "", we.With(e5.With(storekv.StringKey(key)))(errBadKey)
+	}
+	dir, err = k.dirPath(components[:len(components)-1])
+	if err != nil {
+		return "", "", we.With(e5.With(storekv.StringKey(key)))(err)
+	}
+	escaped := escape(name)
+	dir = path.Join(dir, fanOf(escaped))
+	return dir, path.Join(dir, escaped), nil
+}
+
+func isNotExist(err error) bool {
+	var statusErr *sftp.StatusError
+	if as(err, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxNoSuchFile {
+		return true
+	}
+	return is(err, fs.ErrNotExist)
+}
+
+func (k *KV) KeyExists(key string) (ok bool, err error) {
+	select {
+	case <-k.wg.Done():
+		return false, ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	defer he(&err, e5.With(storekv.StringKey(key)))
+	_, file, err := k.keyPath(key)
+	ce(err)
+	ce(k.do(func(client *sftp.Client) error {
+		_, err := client.Stat(file)
+		if err == nil {
+			ok = true
+			return nil
+		}
+		if isNotExist(err) {
+			ok = false
+			return nil
+		}
+		return err
+	}))
+	return
+}
+
+func (k *KV) KeyGet(key string, fn func(io.Reader) error) (err error) {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	defer he(&err, e5.With(storekv.StringKey(key)))
+	_, file, err := k.keyPath(key)
+	ce(err)
+	var f *sftp.File
+	ce(k.do(func(client *sftp.Client) (err error) {
+		f, err = client.Open(file)
+		if isNotExist(err) {
+			return we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
+		}
+		return err
+	}))
+	defer f.Close()
+	if fn != nil {
+		ce(fn(f))
+	}
+	return nil
+}
+
+// mkdirAll creates dir and its parents if not created before
+func (k *KV) mkdirAll(client *sftp.Client, dir string) error {
+	if _, ok := k.dirs.Load(dir); ok {
+		return nil
+	}
+	if err := client.MkdirAll(dir); err != nil {
+		return err
+	}
+	k.dirs.Store(dir, true)
+	return nil
+}
+
+func (k *KV) KeyPut(key string, r io.Reader) (err error) {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	defer he(&err, e5.With(storekv.StringKey(key)))
+
+	dir, file, err := k.keyPath(key)
+	ce(err)
+
+	// buffer content for retrying after reconnect
+	var content []byte
+	if b, ok := r.(interface {
+		Bytes() []byte
+	}); ok {
+		content = b.Bytes()
+	} else {
+		content, err = io.ReadAll(r)
+		ce(err)
+	}
+
+	var randBytes [16]byte
+	_, err = rand.Read(randBytes[:])
+	ce(err)
+	tmpDir := path.Join(k.root, tmpDirName)
+	tmpFile := path.Join(tmpDir, hex.EncodeToString(randBytes[:]))
+
+	ce(k.do(func(client *sftp.Client) (err error) {
+		// write temp file
+		if err := k.mkdirAll(client, tmpDir); err != nil {
+			return err
+		}
+		f, err := client.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				_ = client.Remove(tmpFile)
+			}
+		}()
+		if _, err := io.Copy(f, bytes.NewReader(content)); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		// rename to target
+		err = rename(client, tmpFile, file)
+		if isNotExist(err) {
+			// directory not exists or removed by others
+			k.dirs.Delete(dir)
+			if err = k.mkdirAll(client, dir); err != nil {
+				return err
+			}
+			err = rename(client, tmpFile, file)
+		}
+		return err
+	}))
+
+	return nil
+}
+
+// rename replaces newname atomically if the server supports posix-rename extension
+func rename(client *sftp.Client, oldname, newname string) error {
+	err := client.PosixRename(oldname, newname)
+	var statusErr *sftp.StatusError
+	if as(err, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxOpUnsupported {
+		// not atomic
+		if err := client.Remove(newname); err != nil && !isNotExist(err) {
+			return err
+		}
+		return client.Rename(oldname, newname)
+	}
+	return err
+}
+
+func (k *KV) KeyDelete(keys ...string) (err error) {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	defer he(&err)
+	for _, key := range keys {
+		_, file, err := k.keyPath(key)
+		ce(err)
+		ce(k.do(func(client *sftp.Client) error {
+			err := client.Remove(file)
+			if isNotExist(err) {
+				return nil
+			}
+			return err
+		}), e5.With(storekv.StringKey(key)))
+	}
+	return nil
+}
+
+// readDir lists dir, returns nil if not exists
+func (k *KV) readDir(dir string) (infos []fs.FileInfo, err error) {
+	err = k.do(func(client *sftp.Client) error {
+		infos, err = client.ReadDir(dir)
+		if isNotExist(err) {
+			infos = nil
+			return nil
+		}
+		return err
+	})
+	return
+}
+
+func (k *KV) KeyIter(prefix string, fn func(key string) error) (err error) {
+	select {
+	case <-k.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer k.wg.Add()()
+	defer he(&err, e5.Info("prefix %s", prefix))
+
+	// keys in fan directory
+	iterFan := func(dirKey string, dir string) (err error) {
+		defer he(&err)
+		infos, err := k.readDir(dir)
+		ce(err)
+		for _, info := range infos {
+			if info.IsDir() {
+				continue
+			}
+			name, err := unescape(info.Name())
+			if err != nil {
+				// not a key
+				continue
+			}
+			key := dirKey + name
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			ce(fn(key))
+		}
+		return nil
+	}
+
+	var iter func(dirKey string, dir string) error
+	iter = func(dirKey string, dir string) (err error) {
+		defer he(&err)
+		infos, err := k.readDir(dir)
+		ce(err)
+
+		// escaped prefix of names in this directory
+		var namePrefix string
+		matchNames := true
+		if len(prefix) > len(dirKey) {
+			rest := prefix[len(dirKey):]
+			if strings.Contains(rest, "/") {
+				matchNames = false
+			}
+			namePrefix = escape(rest)
+		}
+
+		for _, info := range infos {
+			if !info.IsDir() {
+				continue
+			}
+			name := info.Name()
+
+			if strings.HasPrefix(name, fanPrefix) {
+				fan := strings.TrimPrefix(name, fanPrefix)
+				if len(fan) > fanLength {
+					// not a fan directory
+					continue
+				}
+				if !matchNames {
+					continue
+				}
+				if !strings.HasPrefix(fan, namePrefix) && !strings.HasPrefix(namePrefix, fan) {
+					continue
+				}
+				ce(iterFan(dirKey, path.Join(dir, name)))
+				continue
+			}
+
+			component, err := unescape(name)
+			if err != nil {
+				// not a key directory
+				continue
+			}
+			subKey := dirKey + component + "/"
+			if !strings.HasPrefix(subKey, prefix) && !strings.HasPrefix(prefix, subKey) {
+				continue
+			}
+			ce(iter(subKey, path.Join(dir, name)))
+		}
+
+		return nil
+	}
+
+	// start from the deepest directory of prefix
+	var dirKey string
+	components := strings.Split(prefix, "/")
+	dirComponents := components[:len(components)-1]
+	dir, err := k.dirPath(dirComponents)
+	if is(err, errBadKey) {
+		// no key matches
+		return nil
+	}
+	ce(err)
+	if len(dirComponents) > 0 {
+		dirKey = strings.Join(dirComponents, "/") + "/"
+	}
+
+	err = iter(dirKey, dir)
+	if is(err, Break) {
+		return nil
+	}
+	ce(err)
+	return nil
+}
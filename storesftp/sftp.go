@@ -0,0 +1,219 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storesftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/sftp"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+	"golang.org/x/crypto/ssh"
+)
+
+type KV struct {
+	wg      *pr2.WaitGroup
+	name    string
+	storeID string
+	root    string
+	dial    Dial
+
+	mu     sync.Mutex
+	client *sftp.Client
+	closer io.Closer
+	serial int64 // increased on each dial
+
+	dirs sync.Map // created directories
+}
+
+var _ storekv.KV = new(KV)
+
+func (k *KV) CostInfo() storekv.CostInfo {
+	return storekv.CostInfo{
+		Put:    2, // write and rename
+		Get:    1,
+		Exists: 1,
+		Iter:   4, // one request per directory
+		Delete: 1,
+	}
+}
+
+// Dial connects to the SFTP server. closer is called when the connection is dropped, can be nil
+type Dial func(ctx context.Context) (client *sftp.Client, closer io.Closer, err error)
+
+// SSHDial returns a Dial connecting by SSH
+func SSHDial(network, addr string, config *ssh.ClientConfig) Dial {
+	return func(ctx context.Context) (_ *sftp.Client, _ io.Closer, err error) {
+		defer he(&err)
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, network, addr)
+		ce(err)
+		c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		sshClient := ssh.NewClient(c, chans, reqs)
+		client, err := sftp.NewClient(sshClient)
+		if err != nil {
+			sshClient.Close()
+			return nil, nil, err
+		}
+		return client, sshClient, nil
+	}
+}
+
+type New func(
+	ctx context.Context,
+	dial Dial,
+	root string,
+	options ...NewOption,
+) (*KV, error)
+
+type NewOption interface {
+	IsNewOption()
+}
+
+// WithStoreID sets the stable id of the store. Default is derived from root
+type WithStoreID string
+
+func (WithStoreID) IsNewOption() {}
+
+func (Def) New() New {
+	return func(
+		ctx context.Context,
+		dial Dial,
+		root string,
+		options ...NewOption,
+	) (_ *KV, err error) {
+		defer he(&err)
+
+		storeID := fmt.Sprintf("sftp(%s)", root)
+		for _, option := range options {
+			switch option := option.(type) {
+			case WithStoreID:
+				storeID = string(option)
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+
+		kv := &KV{
+			wg: pr2.NewWaitGroup(ctx),
+			name: fmt.Sprintf("sftp%d(%s)",
+				atomic.AddInt64(&serial, 1),
+				root,
+			),
+			storeID: storeID,
+			root:    path.Clean(root),
+			dial:    dial,
+		}
+
+		// connect
+		_, _, err = kv.getClient()
+		ce(err)
+
+		kv.wg.Go(func() {
+			<-kv.wg.Done()
+			kv.mu.Lock()
+			defer kv.mu.Unlock()
+			kv.closeClient()
+		})
+
+		return kv, nil
+	}
+}
+
+var serial int64
+
+func (k *KV) StoreID() string {
+	return k.storeID
+}
+
+func (k *KV) Name() string {
+	return k.name
+}
+
+func (k *KV) getClient() (_ *sftp.Client, _ int64, err error) {
+	defer he(&err)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.client != nil {
+		return k.client, k.serial, nil
+	}
+	select {
+	case <-k.wg.Done():
+		return nil, 0, we(ErrClosed)
+	default:
+	}
+	client, closer, err := k.dial(k.wg)
+	ce(err)
+	k.client = client
+	k.closer = closer
+	k.serial++
+	return client, k.serial, nil
+}
+
+func (k *KV) closeClient() {
+	if k.client != nil {
+		k.client.Close()
+		k.client = nil
+	}
+	if k.closer != nil {
+		k.closer.Close()
+		k.closer = nil
+	}
+}
+
+// reset closes the client if it is the one of serial
+func (k *KV) reset(serial int64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.serial != serial {
+		// already reset
+		return
+	}
+	k.closeClient()
+	k.dirs.Range(func(key, _ any) bool {
+		k.dirs.Delete(key)
+		return true
+	})
+}
+
+func isConnError(err error) bool {
+	var netErr net.Error
+	return is(err, sftp.ErrSSHFxConnectionLost) ||
+		is(err, io.EOF) ||
+		is(err, io.ErrClosedPipe) ||
+		is(err, net.ErrClosed) ||
+		as(err, &netErr)
+}
+
+// do calls fn with a client, reconnects and retries once if the connection is dropped
+func (k *KV) do(fn func(*sftp.Client) error) error {
+	for retry := 0; ; retry++ {
+		client, serial, err := k.getClient()
+		if err != nil {
+			return err
+		}
+		err = fn(client)
+		if err == nil {
+			return nil
+		}
+		if !isConnError(err) || retry > 0 {
+			return err
+		}
+		k.reset(serial)
+	}
+}
+
+var errBadKey = errors.New("bad key")
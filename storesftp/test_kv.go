@@ -0,0 +1,276 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storesftp
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+)
+
+// testServer serves SFTP in process over pipes
+type testServer struct {
+	dir   string
+	dials int64
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newTestServer(t *testing.T) *testServer {
+	return &testServer{
+		dir: t.TempDir(),
+	}
+}
+
+func (s *testServer) Dial(ctx context.Context) (_ *sftp.Client, _ io.Closer, err error) {
+	defer he(&err)
+	atomic.AddInt64(&s.dials, 1)
+	clientConn, serverConn := net.Pipe()
+	server, err := sftp.NewServer(
+		serverConn,
+		sftp.WithServerWorkingDirectory(s.dir),
+	)
+	ce(err)
+	go func() {
+		_ = server.Serve()
+		server.Close()
+	}()
+	s.mu.Lock()
+	s.conns = append(s.conns, serverConn)
+	s.mu.Unlock()
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		clientConn.Close()
+		return nil, nil, err
+	}
+	return client, clientConn, nil
+}
+
+// drop closes all server side connections
+func (s *testServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = s.conns[:0]
+}
+
+func TestKV(
+	t *testing.T,
+	testKV storekv.TestKV,
+	newKV New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	server := newTestServer(t)
+	with := func(fn func(storekv.KV, string)) {
+		kv, err := newKV(wg, server.Dial, "kv")
+		ce(err)
+		fn(kv, strconv.FormatInt(rand.Int63(), 10)+"/")
+	}
+	testKV(wg, t, with)
+}
+
+func TestIter(
+	t *testing.T,
+	newKV New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	server := newTestServer(t)
+	kv, err := newKV(wg, server.Dial, "kv")
+	ce(err)
+
+	keys := []string{
+		"a",
+		"ab/c",
+		"ab/d/e",
+		"ab/d/f g",
+		"ab/d/fgh",
+		"b/c",
+		"bc",
+		"bcd",
+		".",
+		"..",
+		".foo/@bar",
+		"%2F",
+	}
+	for _, key := range keys {
+		ce(kv.KeyPut(key, strings.NewReader(key)))
+	}
+
+	// fan-out
+	for key, p := range map[string]string{
+		"a":         "kv/@a/a",
+		"bcd":       "kv/@bc/bcd",
+		"ab/d/fgh":  "kv/ab/d/@fg/fgh",
+		".":         "kv/@%2/%2E",
+		".foo/@bar": "kv/%2Efoo/@%4/%40bar",
+		"%2F":       "kv/@%2/%252F",
+	} {
+		if _, err := os.Stat(filepath.Join(server.dir, filepath.FromSlash(p))); err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+	}
+
+	list := func(prefix string) (ret []string) {
+		ce(kv.KeyIter(prefix, func(key string) error {
+			ret = append(ret, key)
+			return nil
+		}))
+		sort.Strings(ret)
+		return
+	}
+	for prefix, expected := range map[string]string{
+		"":       "%2F,.,..,.foo/@bar,a,ab/c,ab/d/e,ab/d/f g,ab/d/fgh,b/c,bc,bcd",
+		"a":      "a,ab/c,ab/d/e,ab/d/f g,ab/d/fgh",
+		"ab/":    "ab/c,ab/d/e,ab/d/f g,ab/d/fgh",
+		"ab/d":   "ab/d/e,ab/d/f g,ab/d/fgh",
+		"ab/d/":  "ab/d/e,ab/d/f g,ab/d/fgh",
+		"ab/d/f": "ab/d/f g,ab/d/fgh",
+		"b":      "b/c,bc,bcd",
+		"bc":     "bc,bcd",
+		".":      ".,..,.foo/@bar",
+		"..":     "..",
+		".foo/":  ".foo/@bar",
+		"%":      "%2F",
+		"c":      "",
+		"a//":    "",
+	} {
+		if got := strings.Join(list(prefix), ","); got != expected {
+			t.Fatalf("%s: got %s", prefix, got)
+		}
+	}
+
+	// break
+	n := 0
+	ce(kv.KeyIter("", func(key string) error {
+		n++
+		return Break
+	}))
+	if n != 1 {
+		t.Fatal()
+	}
+
+	// overwrite
+	ce(kv.KeyPut("a", strings.NewReader("foo")))
+	ce(kv.KeyGet("a", func(r io.Reader) error {
+		bs, err := io.ReadAll(r)
+		ce(err)
+		if string(bs) != "foo" {
+			t.Fatal()
+		}
+		return nil
+	}))
+
+	// no temp files left
+	entries, err := os.ReadDir(filepath.Join(server.dir, "kv", tmpDirName))
+	ce(err)
+	if len(entries) != 0 {
+		t.Fatal()
+	}
+
+	// bad key
+	err = kv.KeyPut("a/", strings.NewReader("foo"))
+	if !is(err, errBadKey) {
+		t.Fatal()
+	}
+	err = kv.KeyPut("a//b", strings.NewReader("foo"))
+	if !is(err, errBadKey) {
+		t.Fatal()
+	}
+}
+
+func TestReconnect(
+	t *testing.T,
+	newKV New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	server := newTestServer(t)
+	kv, err := newKV(wg, server.Dial, "kv")
+	ce(err)
+	if atomic.LoadInt64(&server.dials) != 1 {
+		t.Fatal()
+	}
+
+	ce(kv.KeyPut("foo/bar", strings.NewReader("baz")))
+
+	// get
+	server.drop()
+	ce(kv.KeyGet("foo/bar", func(r io.Reader) error {
+		bs, err := io.ReadAll(r)
+		ce(err)
+		if string(bs) != "baz" {
+			t.Fatal()
+		}
+		return nil
+	}))
+	if atomic.LoadInt64(&server.dials) != 2 {
+		t.Fatal()
+	}
+
+	// put
+	server.drop()
+	ce(kv.KeyPut("foo/qux", strings.NewReader("quux")))
+	if atomic.LoadInt64(&server.dials) != 3 {
+		t.Fatal()
+	}
+
+	// exists
+	server.drop()
+	ok, err := kv.KeyExists("foo/qux")
+	ce(err)
+	if !ok {
+		t.Fatal()
+	}
+
+	// iter
+	server.drop()
+	var keys []string
+	ce(kv.KeyIter("foo/", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "foo/bar,foo/qux" {
+		t.Fatalf("got %v", keys)
+	}
+
+	// delete
+	server.drop()
+	ce(kv.KeyDelete("foo/bar"))
+	_, err = kv.KeyExists("foo/bar")
+	ce(err)
+	if atomic.LoadInt64(&server.dials) != 6 {
+		t.Fatal()
+	}
+
+	// closed
+	kv.wg.Cancel()
+	_, err = kv.KeyExists("foo/qux")
+	if !is(err, ErrClosed) {
+		t.Fatal()
+	}
+}
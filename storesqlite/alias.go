@@ -5,12 +5,16 @@
 package storesqlite
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/reusee/june/index"
 	"github.com/reusee/june/juneerr"
+	"github.com/reusee/june/store"
 )
 
 var (
+	is = errors.Is
 	ce = juneerr.Check
 	he = juneerr.Handle
 	we = juneerr.Wrap
@@ -19,4 +23,10 @@ var (
 
 type (
 	any = interface{}
+
+	IndexEntry      = index.Entry
+	StoreID         = store.ID
+	StoreIndex      = index.StoreIndex
+	IndexTapEntry   = index.TapEntry
+	IndexSaveOption = index.SaveOption
 )
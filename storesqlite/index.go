@@ -0,0 +1,295 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storesqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/index"
+	"github.com/reusee/pp"
+	"github.com/reusee/sb"
+)
+
+var _ index.IndexManager = new(Store)
+
+func (s *Store) IndexFor(id StoreID) (index.Index, error) {
+	return Index{
+		name: fmt.Sprintf("sqlite-index%d(%v, %v)",
+			atomic.AddInt64(&indexSerial, 1),
+			s.Name(),
+			id,
+		),
+		store: s,
+		id:    id,
+	}, nil
+}
+
+var indexSerial int64
+
+// Index stores entries in kv table with Idx kind.
+// Keys are ordered encoding of StoreIndex, values are sb encoding of entries
+type Index struct {
+	name  string
+	store *Store
+	id    StoreID
+}
+
+func (i Index) Name() string {
+	return i.name
+}
+
+// indexKey returns the kv table key of value.
+// keys are bound as blobs, compared bytewise
+func (i Index) indexKey(value sb.Stream) ([]byte, error) {
+	return index.OrderedKey(sb.Marshal(StoreIndex{
+		ID:    i.id,
+		Value: value,
+	}))
+}
+
+func (i Index) Save(entry IndexEntry, options ...IndexSaveOption) (err error) {
+	defer he(&err)
+	done := i.store.wg.Add()
+	defer done()
+
+	if entry.Type == nil {
+		return we.With(
+			e5.Info("entry type is nil: %+v", entry),
+		)(index.ErrInvalidEntry)
+	}
+	if entry.Key == nil && entry.Path == nil {
+		return we.With(
+			e5.Info("both entry key and path is nil: %+v", entry),
+		)(index.ErrInvalidEntry)
+	}
+
+	var tapEntry []IndexTapEntry
+	for _, option := range options {
+		switch option := option.(type) {
+		case IndexTapEntry:
+			tapEntry = append(tapEntry, option)
+		default:
+			panic(fmt.Errorf("unknown option: %T", option))
+		}
+	}
+
+	defer i.store.lockWrite()()
+
+	tx, err := i.store.DB.Begin()
+	ce(err)
+	defer he(&err, e5.Do(func() {
+		tx.Rollback()
+	}))
+	for _, obj := range entryObjects(entry) {
+		key, err := i.indexKey(sb.Marshal(obj))
+		ce(err)
+		buf := new(bytes.Buffer)
+		ce(sb.Copy(
+			sb.Marshal(obj),
+			sb.Encode(buf),
+		))
+		_, err = tx.Exec(`
+      insert or ignore into kv
+      (kind, key, value)
+      values
+      (?, ?, ?)
+      `,
+			Idx,
+			key,
+			buf.Bytes(),
+		)
+		ce(err)
+	}
+	ce(tx.Commit())
+
+	for _, tap := range tapEntry {
+		tap(entry)
+	}
+
+	return nil
+}
+
+// entryObjects returns the entry and its pre-entry
+func entryObjects(entry IndexEntry) []any {
+	objs := []any{entry}
+	if entry.Key != nil {
+		objs = append(objs, index.PreEntry{
+			Key:   *entry.Key,
+			Type:  entry.Type,
+			Tuple: entry.Tuple,
+		})
+	}
+	return objs
+}
+
+func (i Index) Delete(entry IndexEntry) (err error) {
+	defer he(&err)
+	done := i.store.wg.Add()
+	defer done()
+
+	defer i.store.lockWrite()()
+
+	tx, err := i.store.DB.Begin()
+	ce(err)
+	defer he(&err, e5.Do(func() {
+		tx.Rollback()
+	}))
+	for _, obj := range entryObjects(entry) {
+		key, err := i.indexKey(sb.Marshal(obj))
+		ce(err)
+		_, err = tx.Exec(`
+      delete from kv
+      where kind = ?
+      and key = ?
+      `,
+			Idx,
+			key,
+		)
+		ce(err)
+	}
+	ce(tx.Commit())
+
+	return nil
+}
+
+// max keys in one page of iteration
+const iterPageSize = 512
+
+type indexIter struct {
+	store  *Store
+	lower  []byte
+	upper  []byte
+	order  index.Order
+	values [][]byte
+	end    bool
+}
+
+func (i Index) Iter(
+	lower *sb.Tokens,
+	upper *sb.Tokens,
+	order index.Order,
+) (
+	_ pp.Src,
+	_ io.Closer,
+	err error,
+) {
+	defer he(&err)
+	done := i.store.wg.Add()
+	defer done()
+
+	iter := &indexIter{
+		store: i.store,
+		order: order,
+	}
+
+	if lower == nil {
+		iter.lower, err = i.indexKey(sb.Marshal(sb.Min))
+	} else {
+		iter.lower, err = i.indexKey(lower.Iter())
+	}
+	ce(err)
+
+	if upper == nil {
+		iter.upper, err = i.indexKey(sb.Marshal(sb.Max))
+	} else {
+		iter.upper, err = i.indexKey(upper.Iter())
+	}
+	ce(err)
+
+	return iter.Iter, iter, nil
+}
+
+func (m *indexIter) Close() error {
+	return nil
+}
+
+// fetch queries next page of entries. entries are not retained in transactions between pages
+func (m *indexIter) fetch() (err error) {
+	defer he(&err)
+	done := m.store.wg.Add()
+	defer done()
+
+	defer m.store.lockRead()()
+
+	var rows *sql.Rows
+	if m.order == index.Asc {
+		rows, err = m.store.DB.Query(`
+      select key, value from kv
+      where kind = ?
+      and key >= ? and key < ?
+      order by key asc
+      limit ?
+      `,
+			Idx,
+			m.lower,
+			m.upper,
+			iterPageSize,
+		)
+	} else {
+		rows, err = m.store.DB.Query(`
+      select key, value from kv
+      where kind = ?
+      and key >= ? and key < ?
+      order by key desc
+      limit ?
+      `,
+			Idx,
+			m.lower,
+			m.upper,
+			iterPageSize,
+		)
+	}
+	ce(err)
+	defer rows.Close()
+
+	n := 0
+	var last []byte
+	for rows.Next() {
+		var key, value []byte
+		ce(rows.Scan(&key, &value))
+		m.values = append(m.values, value)
+		last = key
+		n++
+	}
+	ce(rows.Err())
+	if n < iterPageSize {
+		m.end = true
+	}
+	if n > 0 {
+		if m.order == index.Asc {
+			// lower bound is inclusive
+			m.lower = append(last, 0)
+		} else {
+			m.upper = last
+		}
+	}
+
+	return nil
+}
+
+func (m *indexIter) Iter() (_ any, _ pp.Src, err error) {
+	defer he(&err)
+
+	if len(m.values) == 0 && !m.end {
+		ce(m.fetch())
+	}
+	if len(m.values) == 0 {
+		return nil, nil, nil
+	}
+	value := m.values[0]
+	m.values = m.values[1:]
+
+	tokens, err := sb.TokensFromStream(
+		sb.Decode(bytes.NewReader(value)),
+	)
+	ce(err)
+
+	return tokens.Iter(), m.Iter, nil
+}
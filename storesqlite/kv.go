@@ -284,3 +284,41 @@ func (s *Store) del(tx *sql.Tx, key string) error {
 	)
 	return err
 }
+
+var _ storekv.StatKV = new(Store)
+
+func (s *Store) KeyStat(key string) (info storekv.KeyInfo, err error) {
+	defer he(&err)
+	done := s.wg.Add()
+	defer done()
+
+	defer s.lockRead()()
+
+	v, ok := s.mem.Load(key)
+	if ok {
+		if v == nil {
+			return info, we.With(
+				e5.With(storekv.StringKey(key)),
+			)(storekv.ErrKeyNotFound)
+		}
+		info.Size = int64(len(v.([]byte)))
+		return
+	}
+
+	err = s.DB.QueryRow(`
+    select length(value) from kv
+    where kind = ?
+    and key = ?
+    `,
+		Kv,
+		key,
+	).Scan(&info.Size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return info, we.With(
+			e5.With(storekv.StringKey(key)),
+		)(storekv.ErrKeyNotFound)
+	}
+	ce(err)
+
+	return
+}
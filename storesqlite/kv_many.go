@@ -0,0 +1,125 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storesqlite
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+)
+
+var _ storekv.BatchKV = new(Store)
+
+// max keys in one query
+const queryBatchSize = 512
+
+func (s *Store) KeyExistsMany(keys []string) (_ []bool, err error) {
+	defer he(&err)
+	done := s.wg.Add()
+	defer done()
+
+	defer s.lockRead()()
+
+	ret := make([]bool, len(keys))
+	err = s.queryMany(keys, func(i int, _ []byte) error {
+		ret[i] = true
+		return nil
+	}, false)
+	ce(err)
+
+	return ret, nil
+}
+
+func (s *Store) KeyGetMany(keys []string, fn func(string, io.Reader) error) (err error) {
+	defer he(&err)
+	done := s.wg.Add()
+	defer done()
+
+	defer s.lockRead()()
+
+	found := make([]bool, len(keys))
+	err = s.queryMany(keys, func(i int, value []byte) error {
+		found[i] = true
+		return fn(keys[i], bytes.NewReader(value))
+	}, true)
+	ce(err)
+	for i, ok := range found {
+		if !ok {
+			return we.With(
+				e5.With(storekv.StringKey(keys[i])),
+			)(storekv.ErrKeyNotFound)
+		}
+	}
+
+	return nil
+}
+
+// queryMany calls fn for existing keys, resolving from the mem overlay first
+func (s *Store) queryMany(
+	keys []string,
+	fn func(int, []byte) error,
+	withValue bool,
+) (err error) {
+	defer he(&err)
+
+	indexes := make(map[string][]int)
+	var pending []string
+	for i, key := range keys {
+		v, ok := s.mem.Load(key)
+		if ok {
+			if v != nil {
+				err := fn(i, v.([]byte))
+				ce(err)
+			}
+			continue
+		}
+		if _, ok := indexes[key]; !ok {
+			pending = append(pending, key)
+		}
+		indexes[key] = append(indexes[key], i)
+	}
+
+	column := "null"
+	if withValue {
+		column = "value"
+	}
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > queryBatchSize {
+			n = queryBatchSize
+		}
+		batch := pending[:n]
+		pending = pending[n:]
+
+		args := make([]any, 0, len(batch)+1)
+		args = append(args, Kv)
+		for _, key := range batch {
+			args = append(args, key)
+		}
+		rows, err := s.DB.Query(`
+      select key, `+column+` from kv
+      where kind = ?
+      and key in (?`+strings.Repeat(`, ?`, len(batch)-1)+`)
+      `,
+			args...,
+		)
+		ce(err)
+		for rows.Next() {
+			var key string
+			var value []byte
+			ce(rows.Scan(&key, &value), e5.Close(rows))
+			for _, i := range indexes[key] {
+				ce(fn(i, value), e5.Close(rows))
+			}
+		}
+		ce(rows.Err(), e5.Close(rows))
+		ce(rows.Close())
+	}
+
+	return nil
+}
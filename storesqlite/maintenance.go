@@ -0,0 +1,52 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storesqlite
+
+import (
+	"context"
+	"os"
+
+	"github.com/reusee/e5"
+)
+
+// Backup writes a consistent copy of the database to destPath, which must not exist.
+// Buffered writes are flushed before copying
+func (s *Store) Backup(ctx context.Context, destPath string) (err error) {
+	defer he(&err, e5.Info("backup to %s", destPath))
+	done := s.wg.Add()
+	defer done()
+
+	// reserve the path, vacuum into accepts empty files
+	f, err := os.OpenFile(destPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	ce(err)
+	ce(f.Close())
+	defer func() {
+		if err != nil {
+			os.Remove(destPath)
+		}
+	}()
+
+	ce(s.flush())
+
+	// copied in one read transaction, not restarted by concurrent writes
+	_, err = s.DB.ExecContext(ctx, `vacuum into ?`, destPath)
+	ce(err)
+
+	return nil
+}
+
+// Compact rebuilds the database file to reclaim space of deleted values
+func (s *Store) Compact(ctx context.Context) (err error) {
+	defer he(&err)
+	done := s.wg.Add()
+	defer done()
+
+	ce(s.flush())
+
+	_, err = s.DB.ExecContext(ctx, `vacuum`)
+	ce(err)
+
+	return nil
+}
@@ -136,59 +136,63 @@ func (s *Store) tryLockWrite() func() {
 	return nil
 }
 
-func (s *Store) sync() {
-
-	sync := func() (err error) {
-		defer he(&err)
-
+func (s *Store) lockWrite() func() {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	for s.numRead > 0 || s.numWrite > 0 {
+		s.cond.Wait()
+	}
+	s.numWrite++
+	return func() {
 		s.cond.L.Lock()
-		for s.numRead > 0 || s.numWrite > 0 {
-			s.cond.Wait()
-		}
-		s.numWrite++
+		s.numWrite--
 		s.cond.L.Unlock()
-		defer func() {
-			s.cond.L.Lock()
-			s.numWrite--
-			s.cond.L.Unlock()
-			s.cond.Broadcast()
-		}()
+		s.cond.Broadcast()
+	}
+}
 
-		tx, err := s.DB.Begin()
-		ce(err)
-		defer he(&err, e5.Do(func() {
-			tx.Rollback()
-		}))
+// flush writes buffered puts and deletes to the database
+func (s *Store) flush() (err error) {
+	defer he(&err)
 
-		// put
-		s.mem.Range(func(k, v any) bool {
-			key := k.(string)
+	defer s.lockWrite()()
 
-			if v == nil {
-				// delete
-				ce(s.del(tx, key))
+	tx, err := s.DB.Begin()
+	ce(err)
+	defer he(&err, e5.Do(func() {
+		tx.Rollback()
+	}))
 
-			} else {
-				// put
-				ce(s.put(tx, key, v.([]byte)))
-			}
+	// put
+	s.mem.Range(func(k, v any) bool {
+		key := k.(string)
 
-			s.mem.Delete(key)
+		if v == nil {
+			// delete
+			ce(s.del(tx, key))
 
-			return true
-		})
+		} else {
+			// put
+			ce(s.put(tx, key, v.([]byte)))
+		}
 
-		ce(tx.Commit())
+		s.mem.Delete(key)
 
-		return nil
-	}
+		return true
+	})
+
+	ce(tx.Commit())
+
+	return nil
+}
 
+func (s *Store) sync() {
 	for {
 		select {
 		case <-s.dirty:
-			ce(sync())
+			ce(s.flush())
 		case <-s.wg.Done():
-			ce(sync())
+			ce(s.flush())
 			return
 		}
 	}
@@ -0,0 +1,109 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storesqlite
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/index"
+	"github.com/reusee/june/key"
+	"github.com/reusee/pr2"
+)
+
+func TestIndex(
+	t *testing.T,
+	newStore New,
+	test index.TestIndex,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	s, err := newStore(wg, filepath.Join(t.TempDir(), "db"))
+	ce(err)
+	with := func(fn func(index.IndexManager)) {
+		fn(s)
+	}
+	test(with, t)
+}
+
+func TestIndexOrder(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	path := filepath.Join(t.TempDir(), "db")
+	s, err := newStore(wg, path)
+	ce(err)
+	idx, err := s.IndexFor("foo")
+	ce(err)
+
+	k, err := key.KeyFromString("foo:beef")
+	ce(err)
+	// more than one page, ints are little endian in sb encoding, not ordered bytewise
+	const num = iterPageSize + 100
+	for i := 0; i < num*2; i++ {
+		ce(idx.Save(index.NewEntry(index.TestingIndex, i, k)))
+	}
+
+	check := func(idx index.Index) {
+		for _, order := range []index.Order{index.Asc, index.Desc} {
+			var nums []int
+			ce(index.Select(
+				idx,
+				index.Lower(index.NewEntry(index.TestingIndex, 10)),
+				index.Upper(index.NewEntry(index.TestingIndex, num*2-10)),
+				order,
+				index.Tap(func(i int, _ key.Key) {
+					nums = append(nums, i)
+				}),
+			))
+			if len(nums) != (num-10)*2 {
+				t.Fatalf("got %d", len(nums))
+			}
+			for j, i := range nums {
+				expected := 10 + j
+				if order == index.Desc {
+					expected = num*2 - 11 - j
+				}
+				if i != expected {
+					t.Fatalf("got %d, expected %d", i, expected)
+				}
+			}
+		}
+	}
+	check(idx)
+
+	// delete
+	ce(idx.Delete(index.NewEntry(index.TestingIndex, 0, k)))
+	n := 0
+	ce(index.Select(
+		idx,
+		index.Exact(index.NewEntry(index.TestingIndex, 0, k)),
+		index.Count(&n),
+	))
+	if n != 0 {
+		t.Fatalf("got %d", n)
+	}
+	ce(idx.Save(index.NewEntry(index.TestingIndex, 0, k)))
+
+	// reopen
+	s2, err := newStore(wg, path)
+	ce(err)
+	idx2, err := s2.IndexFor("foo")
+	ce(err)
+	check(idx2)
+
+	// other id
+	idx3, err := s2.IndexFor("bar")
+	ce(err)
+	ce(index.Select(idx3, index.Count(&n)))
+	if n != 0 {
+		t.Fatalf("got %d", n)
+	}
+}
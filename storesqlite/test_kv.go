@@ -5,10 +5,13 @@
 package storesqlite
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/reusee/e5"
@@ -37,3 +40,78 @@ func TestKV(
 	}
 	test(wg, t, with)
 }
+
+func TestKeyMany(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+	s, err := newStore(
+		wg,
+		filepath.Join(t.TempDir(), "db"),
+	)
+	ce(err)
+
+	var keys []string
+	for i := 0; i < queryBatchSize+8; i++ {
+		key := fmt.Sprintf("foo%d", i)
+		ce(s.KeyPut(key, strings.NewReader(key)))
+		keys = append(keys, key)
+	}
+	ce(s.KeyDelete(keys[0]))
+	keys = append(keys, "bar")
+
+	oks, err := s.KeyExistsMany(keys)
+	ce(err)
+	for i, ok := range oks {
+		if ok != (i != 0 && i != len(keys)-1) {
+			t.Fatalf("%s: got %v", keys[i], ok)
+		}
+	}
+
+	n := 0
+	ce(s.KeyGetMany(keys[1:len(keys)-1], func(key string, r io.Reader) error {
+		bs, err := io.ReadAll(r)
+		ce(err)
+		if string(bs) != key {
+			t.Fatal()
+		}
+		n++
+		return nil
+	}))
+	if n != len(keys)-2 {
+		t.Fatalf("got %d", n)
+	}
+
+	err = s.KeyGetMany(keys, func(string, io.Reader) error {
+		return nil
+	})
+	if !errors.Is(err, storekv.ErrKeyNotFound) {
+		t.Fatal()
+	}
+}
+
+func TestKeyStat(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+	s, err := newStore(
+		wg,
+		filepath.Join(t.TempDir(), "db"),
+	)
+	ce(err)
+
+	ce(s.KeyPut("foo", strings.NewReader("foobar")))
+	info, err := s.KeyStat("foo")
+	ce(err)
+	if info.Size != 6 {
+		t.Fatalf("got %d", info.Size)
+	}
+	_, err = s.KeyStat("bar")
+	if !errors.Is(err, storekv.ErrKeyNotFound) {
+		t.Fatal()
+	}
+}
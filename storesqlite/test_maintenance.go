@@ -0,0 +1,124 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storesqlite
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/index"
+	"github.com/reusee/june/key"
+	"github.com/reusee/pr2"
+)
+
+func TestBackup(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	s, err := newStore(wg, filepath.Join(t.TempDir(), "db"))
+	ce(err)
+	const num = 1000
+	for i := 0; i < num; i++ {
+		key := fmt.Sprintf("foo%d", i)
+		ce(s.KeyPut(key, strings.NewReader(key)))
+	}
+	idx, err := s.IndexFor("foo")
+	ce(err)
+	k, err := key.KeyFromString("foo:beef")
+	ce(err)
+	ce(idx.Save(index.NewEntry(index.TestingIndex, 42, k)))
+
+	// concurrent writes
+	var writeWG sync.WaitGroup
+	writeWG.Add(1)
+	go func() {
+		defer writeWG.Done()
+		for i := 0; i < num; i++ {
+			key := fmt.Sprintf("bar%d", i)
+			ce(s.KeyPut(key, strings.NewReader(key)))
+		}
+	}()
+	dest := filepath.Join(t.TempDir(), "backup")
+	ce(s.Backup(wg, dest))
+	writeWG.Wait()
+
+	// exists
+	if err := s.Backup(wg, dest); !is(err, os.ErrExist) {
+		t.Fatalf("got %v", err)
+	}
+
+	backup, err := newStore(wg, dest)
+	ce(err)
+	for i := 0; i < num; i++ {
+		key := fmt.Sprintf("foo%d", i)
+		ce(backup.KeyGet(key, func(r io.Reader) error {
+			bs, err := io.ReadAll(r)
+			ce(err)
+			if string(bs) != key {
+				t.Fatalf("got %s", bs)
+			}
+			return nil
+		}))
+	}
+	idx, err = backup.IndexFor("foo")
+	ce(err)
+	n := 0
+	ce(index.Select(idx, index.Count(&n)))
+	if n != 2 {
+		t.Fatalf("got %d", n)
+	}
+}
+
+func TestCompact(
+	t *testing.T,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	path := filepath.Join(t.TempDir(), "db")
+	s, err := newStore(wg, path)
+	ce(err)
+	const num = 1000
+	value := strings.Repeat("x", 4096)
+	var keys []string
+	for i := 0; i < num; i++ {
+		key := fmt.Sprintf("foo%d", i)
+		ce(s.KeyPut(key, strings.NewReader(value)))
+		keys = append(keys, key)
+	}
+	ce(s.flush())
+	stat, err := os.Stat(path)
+	ce(err)
+	size := stat.Size()
+
+	ce(s.KeyDelete(keys[1:]...))
+	ce(s.Compact(wg))
+	stat, err = os.Stat(path)
+	ce(err)
+	if stat.Size()*2 > size {
+		t.Fatalf("got %d, before %d", stat.Size(), size)
+	}
+
+	ok, err := s.KeyExists(keys[0])
+	ce(err)
+	if !ok {
+		t.Fatal()
+	}
+	ok, err = s.KeyExists(keys[1])
+	ce(err)
+	if ok {
+		t.Fatal()
+	}
+}
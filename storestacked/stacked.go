@@ -6,10 +6,14 @@ package storestacked
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync/atomic"
 
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
 	"github.com/reusee/june/store"
+	"github.com/reusee/june/sys"
 	"github.com/reusee/pr2"
 )
 
@@ -21,6 +25,9 @@ type Store struct {
 	id          StoreID
 	ReadPolicy  ReadPolicy
 	WritePolicy WritePolicy
+	writeBack   *writeBack
+
+	newHashState key.NewHashState
 }
 
 type WritePolicy uint8
@@ -28,6 +35,7 @@ type WritePolicy uint8
 const (
 	WriteThrough WritePolicy = iota
 	WriteAround
+	WriteBack // write to Backing, flush to Upstream asynchronously
 )
 
 type ReadPolicy uint8
@@ -44,25 +52,64 @@ type New func(
 	store.Store,
 	ReadPolicy,
 	WritePolicy,
+	...NewOption,
 ) (*Store, error)
 
-func (Def) New() New {
+type NewOption interface {
+	IsNewOption()
+}
+
+var (
+	ErrBadPolicy   = errors.New("bad policy")
+	ErrNoPendingKV = errors.New("no pending kv")
+)
+
+func (Def) New(
+	parallel sys.Parallel,
+	newHashState key.NewHashState,
+) New {
 	return func(
 		ctx context.Context,
 		upstream store.Store,
 		backing store.Store,
 		readPolicy ReadPolicy,
 		writePolicy WritePolicy,
+		options ...NewOption,
 	) (_ *Store, err error) {
 		defer he(&err)
 
+		var pendingKV PendingKV
+		workers := int(parallel)
+		for _, option := range options {
+			switch option := option.(type) {
+			case PendingKV:
+				pendingKV = option
+			case FlushWorkers:
+				workers = int(option)
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+
+		if writePolicy == WriteBack && readPolicy == ReadAround {
+			// pending objects must be readable from Backing
+			return nil, we.With(
+				e5.Info("%v / %v", readPolicy, writePolicy),
+			)(ErrBadPolicy)
+		}
+
+		if writePolicy == WriteBack && pendingKV.KV == nil {
+			// pending keys must survive restarts
+			return nil, we(ErrNoPendingKV)
+		}
+
 		id1, err := upstream.ID()
 		ce(err)
 		id2, err := backing.ID()
 		ce(err)
 
 		wg := pr2.NewWaitGroup(ctx)
-		return &Store{
+		s := &Store{
 			wg: wg,
 			name: fmt.Sprintf("stacked%d(%s, %s)",
 				atomic.AddInt64(&serial, 1),
@@ -74,7 +121,15 @@ func (Def) New() New {
 			Backing:     backing,
 			ReadPolicy:  readPolicy,
 			WritePolicy: writePolicy,
-		}, nil
+
+			newHashState: newHashState,
+		}
+
+		if writePolicy == WriteBack {
+			ce(s.setupWriteBack(pendingKV.KV, pendingKV.Prefix, workers))
+		}
+
+		return s, nil
 	}
 }
 
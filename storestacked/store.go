@@ -239,6 +239,30 @@ func (s *Store) Write(
 	case WriteAround:
 		return s.Upstream.Write(ns, stream, options...)
 
+	case WriteBack:
+		tokens, err := sb.TokensFromStream(stream)
+		ce(err)
+		// persist pending before writing to Backing, flushes are not lost if crashed after writing
+		var hash []byte
+		ce(sb.Copy(tokens.Iter(), sb.Hash(s.newHashState, &hash, nil)))
+		key := Key{
+			Namespace: ns,
+		}
+		copy(key.Hash[:], hash)
+		ce(s.markPending(key))
+		res, err := s.Backing.Write(ns, tokens.Iter(), options...)
+		if is(err, ErrIgnore) {
+			// not cached
+			ce(s.unmarkPending(key))
+			return s.Upstream.Write(ns, tokens.Iter(), options...)
+		}
+		ce(err)
+		if res.Key != key {
+			return res, we.With(e5.With(res.Key))(ErrKeyNotMatch)
+		}
+		s.addPending(key)
+		return res, nil
+
 	}
 	panic("bad policy")
 }
@@ -263,6 +287,12 @@ func (s *Store) Delete(keys []Key) (err error) {
 		ce(s.Upstream.Delete(keys))
 		return nil
 
+	case WriteBack:
+		ce(s.removePending(keys))
+		ce(s.Backing.Delete(keys))
+		ce(s.Upstream.Delete(keys))
+		return nil
+
 	}
 	panic("bad policy")
 }
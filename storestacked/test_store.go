@@ -5,14 +5,19 @@
 package storestacked
 
 import (
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"testing"
 
 	"github.com/reusee/dscope"
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
 	"github.com/reusee/june/store"
 	"github.com/reusee/june/storekv"
 	"github.com/reusee/june/storemem"
 	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
 )
 
 func TestStore(
@@ -29,7 +34,11 @@ func TestStore(
 		for _, writePolicy := range []WritePolicy{
 			WriteThrough,
 			WriteAround,
+			WriteBack,
 		} {
+			if readPolicy == ReadAround && writePolicy == WriteBack {
+				continue
+			}
 
 			t.Run(fmt.Sprintf("%v / %v", readPolicy, writePolicy), func(t *testing.T) {
 				with := func(fn func(store.Store), defs ...any) {
@@ -46,7 +55,13 @@ func TestStore(
 						if err != nil {
 							t.Fatal(err)
 						}
-						store, err := newStore(wg, upstream, backing, readPolicy, writePolicy)
+						store, err := newStore(
+							wg, upstream, backing, readPolicy, writePolicy,
+							PendingKV{
+								KV:     newMem(wg),
+								Prefix: "pending/",
+							},
+						)
 						ce(err)
 						fn(store)
 					})
@@ -57,3 +72,164 @@ func TestStore(
 		}
 	}
 }
+
+// failStore fails writes if fail is set
+type failStore struct {
+	store.Store
+	fail atomic.Bool
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (f *failStore) Write(ns key.Namespace, stream sb.Stream, options ...WriteOption) (store.WriteResult, error) {
+	if f.fail.Load() {
+		return store.WriteResult{}, errWriteFailed
+	}
+	return f.Store.Write(ns, stream, options...)
+}
+
+func TestWriteBack(
+	t *testing.T,
+	newMem storemem.New,
+	newKV storekv.New,
+	newStore New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	backing, err := newKV(wg, newMem(wg), "foo")
+	ce(err)
+	upstreamKV, err := newKV(wg, newMem(wg), "foo")
+	ce(err)
+	upstream := &failStore{
+		Store: upstreamKV,
+	}
+	upstream.fail.Store(true)
+	pending := PendingKV{
+		KV:     newMem(wg),
+		Prefix: "pending/",
+	}
+	ns := key.Namespace{'f', 'o', 'o'}
+
+	// upstream failing
+	ctx1 := pr2.NewWaitGroup(wg)
+	s1, err := newStore(ctx1, upstream, backing, ReadThrough, WriteBack, pending, FlushWorkers(2))
+	ce(err)
+	var keys []Key
+	for i := 0; i < 16; i++ {
+		res, err := s1.Write(ns, sb.Marshal(i))
+		ce(err)
+		keys = append(keys, res.Key)
+		ok, err := s1.Exists(res.Key)
+		ce(err)
+		if !ok {
+			t.Fatal()
+		}
+	}
+	err = s1.Flush(wg)
+	if !is(err, errWriteFailed) {
+		t.Fatalf("got %v", err)
+	}
+	oks, err := store.ExistsMany(upstream, keys)
+	ce(err)
+	for _, ok := range oks {
+		if ok {
+			t.Fatal()
+		}
+	}
+	// crash
+	ctx1.Cancel()
+
+	// resume
+	upstream.fail.Store(false)
+	s2, err := newStore(wg, upstream, backing, ReadThrough, WriteBack, pending)
+	ce(err)
+	ce(s2.Flush(wg))
+	oks, err = store.ExistsMany(upstream, keys)
+	ce(err)
+	for _, ok := range oks {
+		if !ok {
+			t.Fatal()
+		}
+	}
+	n := 0
+	ce(pending.KV.KeyIter(pending.Prefix, func(string) error {
+		n++
+		return nil
+	}))
+	if n != 0 {
+		t.Fatalf("got %d", n)
+	}
+
+	// crash before writing to Backing
+	failBacking := &failStore{
+		Store: backing,
+	}
+	failBacking.fail.Store(true)
+	ctx3 := pr2.NewWaitGroup(wg)
+	s3, err := newStore(ctx3, upstream, failBacking, ReadThrough, WriteBack, pending)
+	ce(err)
+	_, err = s3.Write(ns, sb.Marshal(42))
+	if !is(err, errWriteFailed) {
+		t.Fatalf("got %v", err)
+	}
+	n = 0
+	ce(pending.KV.KeyIter(pending.Prefix, func(string) error {
+		n++
+		return nil
+	}))
+	if n != 1 {
+		t.Fatalf("got %d", n)
+	}
+	ctx3.Cancel()
+	s4, err := newStore(wg, upstream, backing, ReadThrough, WriteBack, pending)
+	ce(err)
+	ce(s4.Flush(wg))
+	n = 0
+	ce(pending.KV.KeyIter(pending.Prefix, func(string) error {
+		n++
+		return nil
+	}))
+	if n != 0 {
+		t.Fatalf("got %d", n)
+	}
+
+	// flush on close
+	upstream.fail.Store(true)
+	ctx5 := pr2.NewWaitGroup(wg)
+	s5, err := newStore(ctx5, upstream, backing, ReadThrough, WriteBack, pending)
+	ce(err)
+	res, err := s5.Write(ns, sb.Marshal("close"))
+	ce(err)
+	if err := s5.Flush(wg); !is(err, errWriteFailed) {
+		t.Fatalf("got %v", err)
+	}
+	upstream.fail.Store(false)
+	ctx5.Cancel()
+	ctx5.Wait()
+	ok, err := upstream.Exists(res.Key)
+	ce(err)
+	if !ok {
+		t.Fatal()
+	}
+	n = 0
+	ce(pending.KV.KeyIter(pending.Prefix, func(string) error {
+		n++
+		return nil
+	}))
+	if n != 0 {
+		t.Fatalf("got %d", n)
+	}
+
+	// bad policy
+	_, err = newStore(wg, upstream, backing, ReadAround, WriteBack, pending)
+	if !is(err, ErrBadPolicy) {
+		t.Fatal()
+	}
+
+	// no pending kv
+	_, err = newStore(wg, upstream, backing, ReadThrough, WriteBack)
+	if !is(err, ErrNoPendingKV) {
+		t.Fatalf("got %v", err)
+	}
+}
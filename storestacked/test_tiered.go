@@ -0,0 +1,206 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storestacked
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/store"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/june/storemem"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+func TestTiered(
+	t *testing.T,
+	testStore store.TestStore,
+	newMem storemem.New,
+	newKV storekv.New,
+	newTiered NewTiered,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	newTiers := func(capacities ...int64) (tiers []Tier) {
+		for _, capacity := range capacities {
+			s, err := newKV(wg, newMem(wg), "foo")
+			ce(err)
+			tiers = append(tiers, Tier{
+				Store:    s,
+				Capacity: capacity,
+			})
+		}
+		return
+	}
+
+	t.Run("store", func(t *testing.T) {
+		with := func(fn func(store.Store), _ ...any) {
+			s, err := newTiered(wg, newTiers(0, 0, 0))
+			ce(err)
+			fn(s)
+		}
+		testStore(wg, with, t)
+	})
+
+	ns := key.Namespace{'f', 'o', 'o'}
+	value := func(i int) string {
+		return fmt.Sprintf("%s%08d", strings.Repeat("x", 100), i)
+	}
+
+	// object size
+	var objectSize int
+	ce(sb.Copy(sb.Marshal(value(0)), sb.EncodedLen(&objectSize, nil)))
+
+	tiers := newTiers(int64(objectSize)*2, int64(objectSize)*4, 0)
+	s, err := newTiered(wg, tiers)
+	ce(err)
+	var keys []Key
+	for i := 0; i < 8; i++ {
+		res, err := s.Write(ns, sb.Marshal(value(i)))
+		ce(err)
+		keys = append(keys, res.Key)
+	}
+	read := func(i int) {
+		var v string
+		ce(s.Read(keys[i], func(stream sb.Stream) error {
+			return sb.Copy(stream, sb.Unmarshal(&v))
+		}))
+		if v != value(i) {
+			t.Fatal()
+		}
+	}
+	inTier := func(tier int, i int) bool {
+		ok, err := tiers[tier].Store.Exists(keys[i])
+		ce(err)
+		return ok
+	}
+
+	// promote
+	read(0)
+	if inTier(1, 0) {
+		t.Fatal()
+	}
+	read(0)
+	if !inTier(1, 0) || inTier(0, 0) {
+		t.Fatal()
+	}
+	read(0)
+	read(0)
+	if !inTier(0, 0) {
+		t.Fatal()
+	}
+	stats := s.Stats()
+	if stats[0].Promotions != 1 || stats[1].Promotions != 1 {
+		t.Fatalf("got %+v", stats)
+	}
+	if stats[2].Hits != 2 || stats[1].Hits != 2 {
+		t.Fatalf("got %+v", stats)
+	}
+
+	// demote least recently accessed
+	for _, i := range []int{1, 2} {
+		for j := 0; j < 4; j++ {
+			read(i)
+		}
+	}
+	if inTier(0, 0) || !inTier(1, 0) {
+		t.Fatal()
+	}
+	if !inTier(0, 1) || !inTier(0, 2) {
+		t.Fatal()
+	}
+	for _, i := range []int{3, 4, 5, 6, 7} {
+		for j := 0; j < 2; j++ {
+			read(i)
+		}
+	}
+	stats = s.Stats()
+	for i, stat := range stats {
+		if stat.Capacity > 0 && stat.Bytes > stat.Capacity {
+			t.Fatalf("tier %d: got %+v", i, stat)
+		}
+	}
+	if stats[0].Demotions != 1 || stats[1].Demotions == 0 {
+		t.Fatalf("got %+v", stats)
+	}
+	if stats[1].NumObjects != 4 {
+		t.Fatalf("got %+v", stats[1])
+	}
+
+	// all readable
+	for i := range keys {
+		read(i)
+	}
+	n := 0
+	ce(s.IterKeys(ns, func(Key) error {
+		n++
+		return nil
+	}))
+	if n != len(keys) {
+		t.Fatalf("got %d", n)
+	}
+
+	// capacity enforced on new
+	s2, err := newTiered(wg, []Tier{
+		{
+			Store:    tiers[1].Store,
+			Capacity: int64(objectSize),
+		},
+		tiers[2],
+	})
+	ce(err)
+	stats = s2.Stats()
+	if stats[0].NumObjects != 1 {
+		t.Fatalf("got %+v", stats)
+	}
+
+	// delete
+	ce(s.Delete(keys[:1]))
+	for tier := range tiers {
+		if inTier(tier, 0) {
+			t.Fatal()
+		}
+	}
+
+	// delete before promoting
+	s3, err := newTiered(wg, newTiers(0, 0))
+	ce(err)
+	res, err := s3.Write(ns, sb.Marshal(value(0)))
+	ce(err)
+	ce(s3.Read(res.Key, func(sb.Stream) error {
+		return nil
+	}))
+	ce(s3.Read(res.Key, func(stream sb.Stream) error {
+		// promoting after fn returns
+		return s3.Delete([]Key{res.Key})
+	}))
+	ok, err := s3.Exists(res.Key)
+	ce(err)
+	if ok {
+		t.Fatal()
+	}
+
+	// bounded access records
+	s4, err := newTiered(wg, newTiers(0, 0), MaxAccessRecords(4))
+	ce(err)
+	for i := 0; i < 32; i++ {
+		res, err := s4.Write(ns, sb.Marshal(value(i)))
+		ce(err)
+		ce(s4.Read(res.Key, func(sb.Stream) error {
+			return nil
+		}))
+	}
+	s4.accessMu.Lock()
+	n = len(s4.access)
+	s4.accessMu.Unlock()
+	if n > 4 {
+		t.Fatalf("got %d", n)
+	}
+}
@@ -0,0 +1,566 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storestacked
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/store"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+// Tier is one layer of a Tiered store
+type Tier struct {
+	Store store.Store
+	// Capacity is the max encoded bytes kept in the tier. Zero means unlimited
+	Capacity int64
+}
+
+// Tiered is a store of several tiers, ordered from the fastest to the slowest.
+// Writes go to the last tier.
+// Reads search tiers in order, objects read frequently are copied to the faster tier.
+// When a tier exceeds its capacity, least accessed objects are moved to the slower tier.
+// The last tier never demotes objects
+type Tiered struct {
+	wg           *pr2.WaitGroup
+	name         string
+	id           StoreID
+	tiers        []*tierState
+	promoteAfter int64
+	maxAccess    int
+	accessMu     sync.Mutex
+	access       map[Key]*accessInfo
+	clock        int64
+	moveMu       sync.Mutex
+}
+
+type tierState struct {
+	Tier
+	mu         sync.Mutex
+	objects    map[Key]int64 // key -> encoded length, tracked for tiers with capacity
+	bytes      int64
+	hits       int64
+	misses     int64
+	promotions int64
+	demotions  int64
+}
+
+type accessInfo struct {
+	hits       int64 // hits since last promotion
+	lastAccess int64
+}
+
+// TierStats is the statistics of a tier
+type TierStats struct {
+	Name       string
+	Capacity   int64
+	NumObjects int64 // zero if capacity is unlimited
+	Bytes      int64 // zero if capacity is unlimited
+	Hits       int64
+	Misses     int64
+	Promotions int64 // objects promoted into the tier
+	Demotions  int64 // objects demoted from the tier
+}
+
+type NewTiered func(
+	ctx context.Context,
+	tiers []Tier,
+	options ...TieredOption,
+) (*Tiered, error)
+
+type TieredOption interface {
+	IsTieredOption()
+}
+
+// PromoteAfter is the number of hits in a tier before the object is promoted to the faster one
+type PromoteAfter int
+
+func (PromoteAfter) IsTieredOption() {}
+
+// MaxAccessRecords is the max number of keys with access records kept in memory.
+// When exceeded, the least recently accessed half is dropped
+type MaxAccessRecords int
+
+func (MaxAccessRecords) IsTieredOption() {}
+
+var ErrNoTier = errors.New("no tier")
+
+func (Def) NewTiered() NewTiered {
+	return func(
+		ctx context.Context,
+		tiers []Tier,
+		options ...TieredOption,
+	) (_ *Tiered, err error) {
+		defer he(&err)
+
+		promoteAfter := int64(2)
+		maxAccess := 1 << 16
+		for _, option := range options {
+			switch option := option.(type) {
+			case PromoteAfter:
+				promoteAfter = int64(option)
+			case MaxAccessRecords:
+				maxAccess = int(option)
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+		if promoteAfter < 1 {
+			promoteAfter = 1
+		}
+		if maxAccess < 1 {
+			maxAccess = 1
+		}
+
+		if len(tiers) == 0 {
+			return nil, we(ErrNoTier)
+		}
+
+		var ids []string
+		var names []string
+		var states []*tierState
+		for _, tier := range tiers {
+			id, err := tier.Store.ID()
+			ce(err)
+			ids = append(ids, string(id))
+			names = append(names, tier.Store.Name())
+			state := &tierState{
+				Tier: tier,
+			}
+			if tier.Capacity > 0 {
+				// load usage
+				state.objects = make(map[Key]int64)
+				ce(tier.Store.IterAllKeys(func(key Key) (err error) {
+					defer he(&err)
+					info, err := store.Stat(tier.Store, key)
+					ce(err)
+					state.objects[key] = info.EncodedLen
+					state.bytes += info.EncodedLen
+					return nil
+				}))
+			}
+			states = append(states, state)
+		}
+
+		t := &Tiered{
+			wg: pr2.NewWaitGroup(ctx),
+			name: fmt.Sprintf("tiered%d(%s)",
+				atomic.AddInt64(&serial, 1),
+				strings.Join(names, ", "),
+			),
+			id:           StoreID("(tiered(" + strings.Join(ids, ",") + "))"),
+			tiers:        states,
+			promoteAfter: promoteAfter,
+			maxAccess:    maxAccess,
+			access:       make(map[Key]*accessInfo),
+		}
+
+		// enforce capacities
+		t.moveMu.Lock()
+		defer t.moveMu.Unlock()
+		for i := range t.tiers {
+			ce(t.demote(i))
+		}
+
+		return t, nil
+	}
+}
+
+var _ store.Store = new(Tiered)
+
+func (t *Tiered) Name() string {
+	return t.name
+}
+
+func (t *Tiered) ID() (StoreID, error) {
+	return t.id, nil
+}
+
+// Stats returns statistics of tiers, in tier order
+func (t *Tiered) Stats() []TierStats {
+	ret := make([]TierStats, 0, len(t.tiers))
+	for _, tier := range t.tiers {
+		tier.mu.Lock()
+		ret = append(ret, TierStats{
+			Name:       tier.Store.Name(),
+			Capacity:   tier.Capacity,
+			NumObjects: int64(len(tier.objects)),
+			Bytes:      tier.bytes,
+			Hits:       tier.hits,
+			Misses:     tier.misses,
+			Promotions: tier.promotions,
+			Demotions:  tier.demotions,
+		})
+		tier.mu.Unlock()
+	}
+	return ret
+}
+
+func (t *Tiered) last() *tierState {
+	return t.tiers[len(t.tiers)-1]
+}
+
+// touch records an access of key, returns true if the object should be promoted
+func (t *Tiered) touch(key Key, tierIndex int) bool {
+	t.accessMu.Lock()
+	defer t.accessMu.Unlock()
+	t.clock++
+	info, ok := t.access[key]
+	if !ok {
+		if len(t.access) >= t.maxAccess {
+			t.evictAccess()
+		}
+		info = new(accessInfo)
+		t.access[key] = info
+	}
+	info.hits++
+	info.lastAccess = t.clock
+	if tierIndex > 0 && info.hits >= t.promoteAfter {
+		info.hits = 0
+		return true
+	}
+	return false
+}
+
+// evictAccess drops the least recently accessed half of access records. accessMu must be held
+func (t *Tiered) evictAccess() {
+	clocks := make([]int64, 0, len(t.access))
+	for _, info := range t.access {
+		clocks = append(clocks, info.lastAccess)
+	}
+	sort.Slice(clocks, func(i, j int) bool {
+		return clocks[i] < clocks[j]
+	})
+	threshold := clocks[len(clocks)/2]
+	for key, info := range t.access {
+		if info.lastAccess <= threshold {
+			delete(t.access, key)
+		}
+	}
+}
+
+func (t *Tiered) accessOf(key Key) accessInfo {
+	t.accessMu.Lock()
+	defer t.accessMu.Unlock()
+	if info, ok := t.access[key]; ok {
+		return *info
+	}
+	return accessInfo{}
+}
+
+func (t *Tiered) forget(keys []Key) {
+	t.accessMu.Lock()
+	defer t.accessMu.Unlock()
+	for _, key := range keys {
+		delete(t.access, key)
+	}
+}
+
+func (t *tierState) add(key Key, length int64) {
+	if t.objects == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.objects[key]; ok {
+		return
+	}
+	t.objects[key] = length
+	t.bytes += length
+}
+
+func (t *tierState) remove(key Key) {
+	if t.objects == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if length, ok := t.objects[key]; ok {
+		delete(t.objects, key)
+		t.bytes -= length
+	}
+}
+
+func (t *Tiered) Read(key Key, fn func(sb.Stream) error) (err error) {
+	select {
+	case <-t.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	defer he(&err)
+
+	for i, tier := range t.tiers {
+		var promote bool
+		var tokens sb.Tokens
+		err := tier.Store.Read(key, func(stream sb.Stream) (err error) {
+			tier.mu.Lock()
+			tier.hits++
+			tier.mu.Unlock()
+			promote = t.touch(key, i)
+			if !promote {
+				return fn(stream)
+			}
+			tokens, err = sb.TokensFromStream(stream)
+			return err
+		})
+		if is(err, ErrKeyNotFound) {
+			tier.mu.Lock()
+			tier.misses++
+			tier.mu.Unlock()
+			continue
+		}
+		ce(err)
+		if promote {
+			ce(fn(tokens.Iter()), e5.With(store.ErrRead), e5.With(key))
+			ce(t.promote(key, i, tokens))
+		}
+		return nil
+	}
+
+	return we.With(e5.With(key))(ErrKeyNotFound)
+}
+
+// promote copies object from tier i to tier i - 1
+func (t *Tiered) promote(key Key, i int, tokens sb.Tokens) (err error) {
+	defer he(&err)
+
+	t.moveMu.Lock()
+	defer t.moveMu.Unlock()
+
+	// the object may be deleted or moved after reading
+	ok, err := t.tiers[i].Store.Exists(key)
+	ce(err)
+	if !ok {
+		return nil
+	}
+
+	to := t.tiers[i-1]
+	var length int
+	ce(sb.Copy(tokens.Iter(), sb.EncodedLen(&length, nil)))
+	if to.Capacity > 0 && int64(length) > to.Capacity {
+		// too large
+		return nil
+	}
+
+	res, err := to.Store.Write(key.Namespace, tokens.Iter())
+	if is(err, ErrIgnore) {
+		return nil
+	}
+	ce(err)
+	if res.Key != key {
+		return we.With(e5.With(key))(ErrKeyNotMatch)
+	}
+	to.add(key, int64(length))
+	to.mu.Lock()
+	to.promotions++
+	to.mu.Unlock()
+
+	return t.demote(i - 1)
+}
+
+// demote moves least accessed objects out of tier i until it fits the capacity
+func (t *Tiered) demote(i int) (err error) {
+	defer he(&err)
+
+	tier := t.tiers[i]
+	if tier.Capacity <= 0 || i == len(t.tiers)-1 {
+		return nil
+	}
+
+	tier.mu.Lock()
+	if tier.bytes <= tier.Capacity {
+		tier.mu.Unlock()
+		return nil
+	}
+	type candidate struct {
+		key    Key
+		length int64
+		access accessInfo
+	}
+	candidates := make([]candidate, 0, len(tier.objects))
+	for key, length := range tier.objects {
+		candidates = append(candidates, candidate{
+			key:    key,
+			length: length,
+		})
+	}
+	bytes := tier.bytes
+	tier.mu.Unlock()
+
+	for i := range candidates {
+		candidates[i].access = t.accessOf(candidates[i].key)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i].access, candidates[j].access
+		if a.hits != b.hits {
+			return a.hits < b.hits
+		}
+		return a.lastAccess < b.lastAccess
+	})
+
+	next := t.tiers[i+1]
+	for _, c := range candidates {
+		if bytes <= tier.Capacity {
+			break
+		}
+
+		// copy to next tier
+		ok, err := next.Store.Exists(c.key)
+		ce(err)
+		if !ok {
+			ce(tier.Store.Read(c.key, func(stream sb.Stream) (err error) {
+				defer he(&err)
+				res, err := next.Store.Write(c.key.Namespace, stream)
+				ce(err)
+				if res.Key != c.key {
+					return we.With(e5.With(c.key))(ErrKeyNotMatch)
+				}
+				return nil
+			}))
+			next.add(c.key, c.length)
+		}
+
+		ce(tier.Store.Delete([]Key{c.key}))
+		tier.remove(c.key)
+		tier.mu.Lock()
+		tier.demotions++
+		tier.mu.Unlock()
+		bytes -= c.length
+	}
+
+	return t.demote(i + 1)
+}
+
+func (t *Tiered) Write(
+	ns key.Namespace,
+	stream sb.Stream,
+	options ...WriteOption,
+) (res store.WriteResult, err error) {
+	select {
+	case <-t.wg.Done():
+		err = ErrClosed
+		return
+	default:
+	}
+
+	defer he(&err)
+
+	last := t.last()
+	if last.objects == nil {
+		return last.Store.Write(ns, stream, options...)
+	}
+	var length int
+	res, err = last.Store.Write(ns, sb.Tee(stream, sb.EncodedLen(&length, nil)), options...)
+	ce(err)
+	last.add(res.Key, int64(length))
+	return res, nil
+}
+
+func (t *Tiered) Exists(key Key) (bool, error) {
+	select {
+	case <-t.wg.Done():
+		return false, ErrClosed
+	default:
+	}
+
+	for _, tier := range t.tiers {
+		ok, err := tier.Store.Exists(key)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (t *Tiered) iter(
+	iter func(store.Store, func(Key) error) error,
+	fn func(Key) error,
+) (err error) {
+	select {
+	case <-t.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	defer he(&err)
+
+	// keys in faster tiers, usually a small set
+	keys := make(map[Key]struct{})
+	for _, tier := range t.tiers[:len(t.tiers)-1] {
+		ce(iter(tier.Store, func(key Key) error {
+			keys[key] = struct{}{}
+			return nil
+		}))
+	}
+
+	isBreak := false
+	ce(iter(t.last().Store, func(key Key) error {
+		delete(keys, key)
+		err := fn(key)
+		if is(err, Break) {
+			isBreak = true
+		}
+		return err
+	}))
+	if isBreak {
+		return nil
+	}
+
+	for key := range keys {
+		err := fn(key)
+		if is(err, Break) {
+			return nil
+		}
+		ce(err)
+	}
+
+	return nil
+}
+
+func (t *Tiered) IterKeys(ns key.Namespace, fn func(Key) error) error {
+	return t.iter(func(s store.Store, fn func(Key) error) error {
+		return s.IterKeys(ns, fn)
+	}, fn)
+}
+
+func (t *Tiered) IterAllKeys(fn func(Key) error) error {
+	return t.iter(func(s store.Store, fn func(Key) error) error {
+		return s.IterAllKeys(fn)
+	}, fn)
+}
+
+func (t *Tiered) Delete(keys []Key) (err error) {
+	select {
+	case <-t.wg.Done():
+		return ErrClosed
+	default:
+	}
+
+	defer he(&err)
+
+	t.moveMu.Lock()
+	defer t.moveMu.Unlock()
+	for _, tier := range t.tiers {
+		ce(tier.Store.Delete(keys))
+		for _, key := range keys {
+			tier.remove(key)
+		}
+	}
+	t.forget(keys)
+	return nil
+}
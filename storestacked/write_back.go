@@ -0,0 +1,314 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storestacked
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+// PendingKV persists keys not yet flushed to Upstream under WriteBack policy, it is required by WriteBack.
+// Keys are persisted before writing to Backing, and pending keys found in KV are flushed after New.
+type PendingKV struct {
+	KV     storekv.KV
+	Prefix string
+}
+
+func (PendingKV) IsNewOption() {}
+
+// FlushWorkers is the max number of concurrent flushes under WriteBack policy
+type FlushWorkers int
+
+func (FlushWorkers) IsNewOption() {}
+
+type writeBack struct {
+	kv     storekv.KV
+	prefix string
+	put    func(Key) bool
+
+	cond     *sync.Cond
+	pending  map[Key]bool // true if flushing or queued
+	failed   map[Key]error
+	flushing map[Key]struct{}
+}
+
+func (w *writeBack) keyPath(key Key) string {
+	buf := new(strings.Builder)
+	buf.WriteString(w.prefix)
+	buf.WriteString(hex.EncodeToString(key.Namespace[:]))
+	buf.WriteString("/")
+	buf.WriteString(key.Hash.String())
+	return buf.String()
+}
+
+func (w *writeBack) pathKey(path string) (ret Key, err error) {
+	defer he(&err, e5.Info("path %s", path))
+	parts := strings.Split(strings.TrimPrefix(path, w.prefix), "/")
+	if len(parts) != 2 {
+		return ret, we(key.ErrBadKey)
+	}
+	bs, err := hex.DecodeString(parts[0])
+	ce(err)
+	copy(ret.Namespace[:], bs)
+	ret.Hash, err = key.HashFromString(parts[1])
+	ce(err)
+	return
+}
+
+func (s *Store) setupWriteBack(
+	kv storekv.KV,
+	prefix string,
+	workers int,
+) (err error) {
+	defer he(&err)
+
+	w := &writeBack{
+		kv:       kv,
+		prefix:   prefix,
+		cond:     sync.NewCond(new(sync.Mutex)),
+		pending:  make(map[Key]bool),
+		failed:   make(map[Key]error),
+		flushing: make(map[Key]struct{}),
+	}
+	put, _ := pr2.Consume(s.wg, workers, func(_ int, key Key) error {
+		s.flushKey(key)
+		return nil
+	})
+	w.put = put
+	s.writeBack = w
+
+	// resume
+	var keys []Key
+	ce(kv.KeyIter(prefix, func(path string) error {
+		key, err := w.pathKey(path)
+		if err != nil {
+			// ignore
+			return nil
+		}
+		keys = append(keys, key)
+		return nil
+	}))
+	w.cond.L.Lock()
+	for _, key := range keys {
+		w.pending[key] = true
+	}
+	w.cond.L.Unlock()
+	for _, key := range keys {
+		w.put(key)
+	}
+
+	s.wg.Go(func() {
+		<-s.wg.Done()
+		s.flushOnClose()
+	})
+
+	return nil
+}
+
+// flushOnClose tries to flush keys not flushed by workers before closing.
+// Keys failed are still persisted in PendingKV, and flushed after the next New
+func (s *Store) flushOnClose() {
+	w := s.writeBack
+	w.cond.L.Lock()
+	var keys []Key
+	for key := range w.pending {
+		keys = append(keys, key)
+		w.pending[key] = true
+	}
+	w.failed = make(map[Key]error)
+	w.cond.L.Unlock()
+	for _, key := range keys {
+		s.flushKey(key)
+	}
+}
+
+// markPending persists key as pending, before writing to Backing
+func (s *Store) markPending(key Key) error {
+	w := s.writeBack
+	return w.kv.KeyPut(w.keyPath(key), bytes.NewReader(nil))
+}
+
+// unmarkPending removes the persisted key not written to Backing
+func (s *Store) unmarkPending(key Key) error {
+	w := s.writeBack
+	w.cond.L.Lock()
+	_, ok := w.pending[key]
+	w.cond.L.Unlock()
+	if ok {
+		return nil
+	}
+	return w.kv.KeyDelete(w.keyPath(key))
+}
+
+// addPending queues key for flushing
+func (s *Store) addPending(key Key) {
+	w := s.writeBack
+	w.cond.L.Lock()
+	_, ok := w.pending[key]
+	if !ok {
+		w.pending[key] = true
+	}
+	w.cond.L.Unlock()
+	if ok {
+		return
+	}
+	w.put(key)
+}
+
+func (s *Store) removePending(keys []Key) (err error) {
+	defer he(&err)
+	w := s.writeBack
+	w.cond.L.Lock()
+	// wait in-flight flushes
+	for {
+		flushing := false
+		for _, key := range keys {
+			if _, ok := w.flushing[key]; ok {
+				flushing = true
+				break
+			}
+		}
+		if !flushing {
+			break
+		}
+		w.cond.Wait()
+	}
+	for _, key := range keys {
+		delete(w.pending, key)
+		delete(w.failed, key)
+	}
+	w.cond.L.Unlock()
+	w.cond.Broadcast()
+	paths := make([]string, 0, len(keys))
+	for _, key := range keys {
+		paths = append(paths, w.keyPath(key))
+	}
+	ce(w.kv.KeyDelete(paths...))
+	return nil
+}
+
+func (s *Store) flushKey(key Key) {
+	w := s.writeBack
+
+	w.cond.L.Lock()
+	queued, ok := w.pending[key]
+	if !ok || !queued {
+		// deleted or failed
+		w.cond.L.Unlock()
+		return
+	}
+	w.flushing[key] = struct{}{}
+	w.cond.L.Unlock()
+
+	err := func() (err error) {
+		defer he(&err, e5.With(key))
+		err = s.Backing.Read(key, func(stream sb.Stream) (err error) {
+			defer he(&err)
+			res, err := s.Upstream.Write(key.Namespace, stream)
+			ce(err)
+			if res.Key != key {
+				return we.With(e5.With(res.Key))(ErrKeyNotMatch)
+			}
+			return nil
+		})
+		if is(err, ErrKeyNotFound) {
+			// marked but not written to Backing, or deleted
+			err = nil
+		}
+		ce(err)
+		ce(w.kv.KeyDelete(w.keyPath(key)))
+		return nil
+	}()
+
+	w.cond.L.Lock()
+	delete(w.flushing, key)
+	if err != nil {
+		w.pending[key] = false
+		w.failed[key] = err
+	} else {
+		delete(w.pending, key)
+	}
+	w.cond.L.Unlock()
+	w.cond.Broadcast()
+}
+
+// Flush retries failed flushes and waits for all pending keys to be written to Upstream.
+// Keys failed again are reported in the returned error and kept pending
+func (s *Store) Flush(ctx context.Context) (err error) {
+	select {
+	case <-s.wg.Done():
+		return ErrClosed
+	default:
+	}
+	defer he(&err)
+	w := s.writeBack
+	if w == nil {
+		return nil
+	}
+
+	// retry failed
+	w.cond.L.Lock()
+	var retry []Key
+	for key := range w.failed {
+		retry = append(retry, key)
+		w.pending[key] = true
+	}
+	w.failed = make(map[Key]error)
+	w.cond.L.Unlock()
+	for _, key := range retry {
+		w.put(key)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		w.cond.L.Lock()
+		defer w.cond.L.Unlock()
+		w.cond.Broadcast()
+	})
+	defer stop()
+	stopWG := context.AfterFunc(s.wg, func() {
+		w.cond.L.Lock()
+		defer w.cond.L.Unlock()
+		w.cond.Broadcast()
+	})
+	defer stopWG()
+
+	w.cond.L.Lock()
+	defer w.cond.L.Unlock()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.wg.Err(); err != nil {
+			return err
+		}
+		flushing := false
+		for _, queued := range w.pending {
+			if queued {
+				flushing = true
+				break
+			}
+		}
+		if !flushing {
+			break
+		}
+		w.cond.Wait()
+	}
+
+	var errs []error
+	for _, err := range w.failed {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -14,11 +14,12 @@ func _() {
 	var x [1]struct{}
 	_ = x[WriteThrough-0]
 	_ = x[WriteAround-1]
+	_ = x[WriteBack-2]
 }
 
-const _WritePolicy_name = "WriteThroughWriteAround"
+const _WritePolicy_name = "WriteThroughWriteAroundWriteBack"
 
-var _WritePolicy_index = [...]uint8{0, 12, 23}
+var _WritePolicy_index = [...]uint8{0, 12, 23, 32}
 
 func (i WritePolicy) String() string {
 	if i >= WritePolicy(len(_WritePolicy_index)-1) {
@@ -0,0 +1,24 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storewebdav
+
+import (
+	"errors"
+
+	"github.com/reusee/june/juneerr"
+	"github.com/reusee/june/store"
+)
+
+var (
+	is = errors.Is
+	we = juneerr.Wrap
+	ce = juneerr.Check
+	he = juneerr.Handle
+
+	Break = store.Break
+
+	ErrKeyNotFound = store.ErrKeyNotFound
+	ErrClosed      = store.ErrClosed
+)
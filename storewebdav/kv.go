@@ -0,0 +1,280 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storewebdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+)
+
+func (k *KV) KeyExists(key string) (_ bool, err error) {
+	defer k.wg.Add()()
+	defer he(&err, e5.With(storekv.StringKey(key)))
+	ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+	defer cancel()
+	resp, err := k.do(ctx, http.MethodHead, k.keyURL(key), nil, nil)
+	ce(err)
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, statusError(resp)
+}
+
+func (k *KV) KeyGet(key string, fn func(io.Reader) error) (err error) {
+	defer k.wg.Add()()
+	defer he(&err, e5.With(storekv.StringKey(key)))
+	ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+	defer cancel()
+	resp, err := k.do(ctx, http.MethodGet, k.keyURL(key), nil, nil)
+	ce(err)
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return we.With(e5.With(storekv.StringKey(key)))(ErrKeyNotFound)
+	default:
+		return statusError(resp)
+	}
+	if fn != nil {
+		ce(fn(resp.Body))
+	}
+	return nil
+}
+
+// mkcol creates collections of dir and its parents
+func (k *KV) mkcol(dir string) (err error) {
+	defer he(&err)
+	if dir == "" {
+		return nil
+	}
+	if _, ok := k.collections.Load(dir); ok {
+		return nil
+	}
+	ce(k.mkcol(parentDir(dir)))
+	ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+	defer cancel()
+	resp, err := k.do(ctx, "MKCOL", k.keyURL(dir+"/"), nil, nil)
+	ce(err)
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusCreated,
+		http.StatusOK,
+		http.StatusMethodNotAllowed, // exists
+		http.StatusMovedPermanently:
+	default:
+		return statusError(resp)
+	}
+	k.collections.Store(dir, true)
+	return nil
+}
+
+// parentDir returns the collection containing key, without trailing slash
+func parentDir(key string) string {
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return ""
+	}
+	return key[:i]
+}
+
+func (k *KV) KeyPut(key string, r io.Reader) (err error) {
+	defer k.wg.Add()()
+	defer he(&err, e5.With(storekv.StringKey(key)))
+
+	var content []byte
+	if b, ok := r.(interface {
+		Bytes() []byte
+	}); ok {
+		content = b.Bytes()
+	} else {
+		content, err = io.ReadAll(r)
+		ce(err)
+	}
+
+	dir := parentDir(key)
+	put := func() (_ *http.Response, err error) {
+		ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+		defer cancel()
+		resp, err := k.do(ctx, http.MethodPut, k.keyURL(key), bytes.NewReader(content), nil)
+		if err != nil {
+			return nil, err
+		}
+		drain(resp)
+		return resp, nil
+	}
+	for retry := 0; ; retry++ {
+		ce(k.mkcol(dir))
+		resp, err := put()
+		ce(err)
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+			return nil
+		case http.StatusConflict:
+			// collection removed by others
+			if retry == 0 {
+				k.collections.Range(func(key, _ any) bool {
+					k.collections.Delete(key)
+					return true
+				})
+				continue
+			}
+		}
+		return statusError(resp)
+	}
+}
+
+func (k *KV) KeyDelete(keys ...string) (err error) {
+	defer k.wg.Add()()
+	defer he(&err)
+	for _, key := range keys {
+		ce(k.delete(key), e5.With(storekv.StringKey(key)))
+	}
+	return nil
+}
+
+func (k *KV) delete(key string) (err error) {
+	defer he(&err)
+	ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+	defer cancel()
+	resp, err := k.do(ctx, http.MethodDelete, k.keyURL(key), nil, nil)
+	ce(err)
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+	default:
+		return statusError(resp)
+	}
+	return nil
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>` +
+	`<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/></D:prop></D:propfind>`
+
+type entry struct {
+	key          string
+	isCollection bool
+}
+
+// list returns entries in collection dir
+func (k *KV) list(dir string) (entries []entry, err error) {
+	defer he(&err, e5.Info("collection %s", dir))
+	ctx, cancel := context.WithTimeout(k.wg, k.timeout)
+	defer cancel()
+
+	target := k.keyURL(dir)
+	if dir != "" {
+		target = k.keyURL(dir + "/")
+	}
+	resp, err := k.do(ctx, "PROPFIND", target, strings.NewReader(propfindBody), http.Header{
+		"Depth":        {"1"},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	})
+	ce(err)
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusMultiStatus:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, statusError(resp)
+	}
+
+	var ms multistatus
+	ce(xml.NewDecoder(resp.Body).Decode(&ms))
+	for _, r := range ms.Responses {
+		u, err := url.Parse(r.Href)
+		ce(err)
+		p := u.Path
+		if !strings.HasPrefix(p, k.base.Path) {
+			continue
+		}
+		key := strings.TrimSuffix(strings.TrimPrefix(p, k.base.Path), "/")
+		if key == dir {
+			// self
+			continue
+		}
+		if path.Dir("/"+key) != path.Clean("/"+dir) {
+			// not direct child
+			continue
+		}
+		isCollection := false
+		for _, propstat := range r.Propstat {
+			if propstat.Prop.ResourceType.Collection != nil {
+				isCollection = true
+			}
+		}
+		entries = append(entries, entry{
+			key:          key,
+			isCollection: isCollection,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	return entries, nil
+}
+
+func (k *KV) KeyIter(prefix string, fn func(key string) error) (err error) {
+	defer k.wg.Add()()
+	defer he(&err, e5.Info("prefix %s", prefix))
+
+	var iter func(dir string) error
+	iter = func(dir string) (err error) {
+		defer he(&err)
+		entries, err := k.list(dir)
+		ce(err)
+		for _, e := range entries {
+			if e.isCollection {
+				// descend if keys under the collection may match prefix
+				p := e.key + "/"
+				if strings.HasPrefix(p, prefix) || strings.HasPrefix(prefix, p) {
+					ce(iter(e.key))
+				}
+				continue
+			}
+			if !strings.HasPrefix(e.key, prefix) {
+				continue
+			}
+			ce(fn(e.key))
+		}
+		return nil
+	}
+
+	err = iter(parentDir(prefix))
+	if is(err, Break) {
+		return nil
+	}
+	ce(err)
+	return nil
+}
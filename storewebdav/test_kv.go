@@ -0,0 +1,138 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storewebdav
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+	"golang.org/x/net/webdav"
+)
+
+func newTestServer() *httptest.Server {
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != "foo" || pass != "bar" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	}))
+}
+
+func TestKV(
+	t *testing.T,
+	testKV storekv.TestKV,
+	newKV New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	server := newTestServer()
+	defer server.Close()
+
+	with := func(fn func(storekv.KV, string)) {
+		kv, err := newKV(
+			wg,
+			server.URL+"/dav",
+			BasicAuth{"foo", "bar"},
+		)
+		ce(err)
+		fn(kv, strconv.FormatInt(rand.Int63(), 10)+"/")
+	}
+	testKV(wg, t, with)
+}
+
+func TestIter(
+	t *testing.T,
+	newKV New,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	server := newTestServer()
+	defer server.Close()
+
+	kv, err := newKV(wg, server.URL+"/dav/", BasicAuth{"foo", "bar"})
+	ce(err)
+
+	keys := []string{
+		"a",
+		"ab/c",
+		"ab/d/e",
+		"ab/d/f g",
+		"b/c",
+		"bc",
+	}
+	for _, key := range keys {
+		ce(kv.KeyPut(key, strings.NewReader(key)))
+	}
+
+	list := func(prefix string) (ret []string) {
+		ce(kv.KeyIter(prefix, func(key string) error {
+			ret = append(ret, key)
+			return nil
+		}))
+		sort.Strings(ret)
+		return
+	}
+	for prefix, expected := range map[string]string{
+		"":      "a,ab/c,ab/d/e,ab/d/f g,b/c,bc",
+		"a":     "a,ab/c,ab/d/e,ab/d/f g",
+		"ab/":   "ab/c,ab/d/e,ab/d/f g",
+		"ab/d":  "ab/d/e,ab/d/f g",
+		"ab/d/": "ab/d/e,ab/d/f g",
+		"b":     "b/c,bc",
+		"c":     "",
+	} {
+		if got := strings.Join(list(prefix), ","); got != expected {
+			t.Fatalf("%s: got %s", prefix, got)
+		}
+	}
+
+	ce(kv.KeyGet("ab/d/f g", func(r io.Reader) error {
+		bs, err := io.ReadAll(r)
+		ce(err)
+		if string(bs) != "ab/d/f g" {
+			t.Fatal()
+		}
+		return nil
+	}))
+
+	// timeout is per request, not including callbacks
+	kv.timeout = time.Millisecond * 300
+	n := 0
+	ce(kv.KeyIter("", func(string) error {
+		n++
+		time.Sleep(time.Millisecond * 100)
+		return nil
+	}))
+	if n != len(keys) {
+		t.Fatalf("got %d", n)
+	}
+
+	// bad auth
+	kv, err = newKV(wg, server.URL+"/dav/", BasicAuth{"foo", "baz"})
+	ce(err)
+	_, err = kv.KeyExists("a")
+	if !is(err, ErrBadStatus) {
+		t.Fatal()
+	}
+}
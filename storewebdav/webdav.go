@@ -0,0 +1,178 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package storewebdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+)
+
+type KV struct {
+	wg      *pr2.WaitGroup
+	name    string
+	storeID string
+
+	client  *http.Client
+	base    *url.URL // with trailing slash
+	user    string
+	pass    string
+	timeout time.Duration
+
+	collections sync.Map // created collection paths
+}
+
+var _ storekv.KV = new(KV)
+
+func (k *KV) CostInfo() storekv.CostInfo {
+	// listing traverses collections, one request per collection
+	return storekv.CostInfo{
+		Put:    1,
+		Get:    1,
+		Exists: 1,
+		Iter:   4,
+		Delete: 1,
+	}
+}
+
+type New func(
+	ctx context.Context,
+	endpoint string,
+	options ...NewOption,
+) (*KV, error)
+
+type NewOption interface {
+	IsNewOption()
+}
+
+// BasicAuth sets the user and password for HTTP basic authentication
+type BasicAuth struct {
+	User     string
+	Password string
+}
+
+func (BasicAuth) IsNewOption() {}
+
+// WithClient sets the HTTP client. Default is http.DefaultClient
+type WithClient struct {
+	*http.Client
+}
+
+func (WithClient) IsNewOption() {}
+
+var ErrBadStatus = errors.New("bad status")
+
+func (Def) New(
+	timeout Timeout,
+) New {
+	return func(
+		ctx context.Context,
+		endpoint string,
+		options ...NewOption,
+	) (_ *KV, err error) {
+		defer he(&err)
+
+		base, err := url.Parse(endpoint)
+		ce(err)
+		if !strings.HasSuffix(base.Path, "/") {
+			base.Path += "/"
+		}
+
+		kv := &KV{
+			wg: pr2.NewWaitGroup(ctx),
+			name: fmt.Sprintf("webdav%d(%s)",
+				atomic.AddInt64(&serial, 1),
+				base.Host,
+			),
+			storeID: fmt.Sprintf("webdav(%s)",
+				base.String(),
+			),
+			client:  http.DefaultClient,
+			base:    base,
+			timeout: time.Duration(timeout),
+		}
+
+		for _, option := range options {
+			switch option := option.(type) {
+			case BasicAuth:
+				kv.user = option.User
+				kv.pass = option.Password
+			case WithClient:
+				kv.client = option.Client
+			default:
+				panic(fmt.Errorf("bad option: %T", option))
+			}
+		}
+
+		return kv, nil
+	}
+}
+
+var serial int64
+
+func (k *KV) StoreID() string {
+	return k.storeID
+}
+
+func (k *KV) Name() string {
+	return k.name
+}
+
+// keyURL returns the url of key. slashes in key delimit collections
+func (k *KV) keyURL(key string) string {
+	u := *k.base
+	u.Path += key
+	u.RawPath = ""
+	return u.String()
+}
+
+// do sends a request. The response body must be closed by caller
+func (k *KV) do(
+	ctx context.Context,
+	method string,
+	target string,
+	body io.Reader,
+	header http.Header,
+) (_ *http.Response, err error) {
+	defer he(&err, e5.Info("%s %s", method, target))
+	select {
+	case <-k.wg.Done():
+		return nil, we(ErrClosed)
+	default:
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	ce(err)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if k.user != "" || k.pass != "" {
+		req.SetBasicAuth(k.user, k.pass)
+	}
+	resp, err := k.client.Do(req)
+	ce(err)
+	return resp, nil
+}
+
+func statusError(resp *http.Response) error {
+	return we.With(
+		e5.Info("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status),
+	)(ErrBadStatus)
+}
+
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,246 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package tx
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/entity"
+	"github.com/reusee/june/index"
+	"github.com/reusee/june/store"
+	"github.com/reusee/june/storebolt"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/pr2"
+)
+
+func TestBoltTx(
+	t *testing.T,
+	newBolt storebolt.New,
+	newKV storekv.New,
+	scope Scope,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	db, err := newBolt(wg, filepath.Join(t.TempDir(), "db"))
+	ce(err)
+
+	scope.Fork(
+		func() KVToStore {
+			return func(kv storekv.KV) (store.Store, error) {
+				return newKV(wg, kv, "foo")
+			}
+		},
+		UseBoltTx,
+		&db,
+	).Call(func(
+		tx BoltTx,
+	) {
+
+		// commit tx
+		var key1 Key
+		ce(tx(wg, func(
+			save entity.Save,
+		) {
+			summary, err := save(wg, entity.NSEntity, 42)
+			ce(err)
+			key1 = summary.Key
+		}))
+
+		ce(tx(wg, func(
+			fetch entity.Fetch,
+			selIndex index.SelectIndex,
+		) {
+			var i int
+			ce(fetch(key1, &i))
+			if i != 42 {
+				t.Fatal()
+			}
+
+			ce(selIndex(
+				wg,
+				index.MatchEntry(entity.IdxSummaryKey, key1),
+				index.Count(&i),
+			))
+			if i != 1 {
+				t.Fatal()
+			}
+		}))
+
+		// error, no commit
+		errFoo := fmt.Errorf("foo")
+		var key2 Key
+		err = tx(wg, func(
+			save entity.Save,
+		) {
+			summary, err := save(wg, entity.NSEntity, 1)
+			ce(err)
+			key2 = summary.Key
+			ce(errFoo)
+		})
+		if !errors.Is(err, errFoo) {
+			t.Fatal()
+		}
+
+		ce(tx(wg, func(
+			fetch entity.Fetch,
+			selIndex index.SelectIndex,
+		) {
+			var i int
+			err := fetch(key2, &i)
+			if !errors.Is(err, store.ErrKeyNotFound) {
+				t.Fatal()
+			}
+
+			ce(selIndex(
+				wg,
+				index.MatchEntry(entity.IdxSummaryKey, key2),
+				index.Count(&i),
+			))
+			if i != 0 {
+				t.Fatal()
+			}
+			ce(selIndex(
+				wg,
+				index.MatchEntry(entity.IdxSummaryKey, key1),
+				index.Count(&i),
+			))
+			if i != 1 {
+				t.Fatal()
+			}
+		}))
+
+		// tx inside tx, partial commit
+		var key3, key4 Key
+		err = tx(wg, func(
+			save entity.Save,
+			store store.Store,
+		) {
+			ce(tx(wg, func(
+				save entity.Save,
+			) {
+				summary, err := save(wg, entity.NSEntity, 99)
+				ce(err)
+				key3 = summary.Key
+			}))
+
+			// should see committed key
+			ok, err := store.Exists(key3)
+			ce(err)
+			if !ok {
+				t.Fatal()
+			}
+
+			summary, err := save(wg, entity.NSEntity, 1)
+			ce(err)
+			key4 = summary.Key
+			ce(errFoo)
+		})
+		if !errors.Is(err, errFoo) {
+			t.Fatalf("got %v", err)
+		}
+
+		ce(tx(wg, func(
+			fetch entity.Fetch,
+			selIndex index.SelectIndex,
+		) {
+			var i int
+
+			ce(fetch(key3, &i))
+			if i != 99 {
+				t.Fatal()
+			}
+
+			err := fetch(key4, &i)
+			if !errors.Is(err, store.ErrKeyNotFound) {
+				t.Fatal()
+			}
+
+			ce(selIndex(
+				wg,
+				index.MatchEntry(entity.IdxSummaryKey, key4),
+				index.Count(&i),
+			))
+			if i != 0 {
+				t.Fatal()
+			}
+			ce(selIndex(
+				wg,
+				index.MatchEntry(entity.IdxSummaryKey, key3),
+				index.Count(&i),
+			))
+			if i != 1 {
+				t.Fatal()
+			}
+		}))
+
+	})
+
+}
+
+func TestBoltTxEntityDelete(
+	t *testing.T,
+	newBolt storebolt.New,
+	newKV storekv.New,
+	scope Scope,
+	wg *pr2.WaitGroup,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	db, err := newBolt(wg, filepath.Join(t.TempDir(), "db"))
+	ce(err)
+
+	scope.Fork(
+		func() KVToStore {
+			return func(kv storekv.KV) (store.Store, error) {
+				return newKV(wg, kv, "foo")
+			}
+		},
+		UseBoltTx,
+		&db,
+	).Call(func(
+		tx BoltTx,
+	) {
+
+		ce(tx(wg, func(
+			save entity.SaveEntity,
+			sel index.SelectIndex,
+			del entity.Delete,
+		) {
+
+			s, err := save(wg, 42)
+			ce(err)
+			_ = s
+
+			var c int
+			ce(sel(
+				wg,
+				index.MatchEntry(entity.IdxPairObjectSummary, s.Key),
+				index.Count(&c),
+			))
+			if c != 1 {
+				t.Fatal()
+			}
+
+			ce(del(wg, s.Key))
+
+			ce(sel(
+				wg,
+				index.MatchEntry(entity.IdxPairObjectSummary, s.Key),
+				index.Count(&c),
+			))
+			if c != 0 {
+				t.Fatal()
+			}
+
+		}))
+
+	})
+
+}
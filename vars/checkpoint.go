@@ -0,0 +1,47 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package vars
+
+import (
+	"github.com/reusee/june/store"
+)
+
+// NewCheckpoint returns a store.Checkpoint saving progress to the var named key
+type NewCheckpoint func(key string) store.Checkpoint
+
+func (Def) NewCheckpoint(
+	get Get,
+	set Set,
+) NewCheckpoint {
+	return func(key string) store.Checkpoint {
+		return checkpoint{
+			key: key,
+			get: get,
+			set: set,
+		}
+	}
+}
+
+type checkpoint struct {
+	key string
+	get Get
+	set Set
+}
+
+var _ store.Checkpoint = checkpoint{}
+
+func (c checkpoint) Load(target any) (bool, error) {
+	err := c.get(c.key, target)
+	if is(err, ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c checkpoint) Save(value any) error {
+	return c.set(c.key, value)
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 The June Authors. All rights reserved.
+// Use of this source code is governed by Apache License
+// that can be found in the LICENSE file.
+
+package vars
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/reusee/e5"
+	"github.com/reusee/june/key"
+	"github.com/reusee/june/opts"
+	"github.com/reusee/june/store"
+	"github.com/reusee/june/storekv"
+	"github.com/reusee/june/storemem"
+	"github.com/reusee/pr2"
+	"github.com/reusee/sb"
+)
+
+func TestReplicateCheckpoint(
+	t *testing.T,
+	scope Scope,
+	wg *pr2.WaitGroup,
+	from store.Store,
+	newMem storemem.New,
+	newKV storekv.New,
+	replicate store.Replicate,
+) {
+	defer he(nil, e5.TestingFatal(t))
+
+	scope.Fork(func() VarsSpec {
+		return func() (string, *pr2.WaitGroup) {
+			return t.TempDir(), wg
+		}
+	}).Call(func(
+		newCheckpoint NewCheckpoint,
+	) {
+		checkpoint := newCheckpoint("replicate")
+
+		ns := key.Namespace{'f', 'o', 'o'}
+		n := 64
+		for i := 0; i < n; i++ {
+			_, err := from.Write(ns, sb.Marshal(i))
+			ce(err)
+		}
+
+		to, err := newKV(wg, newMem(wg), "foo")
+		ce(err)
+
+		// interrupted
+		ctx, cancel := context.WithCancel(wg)
+		var copied int64
+		err = replicate(
+			ctx, from, to,
+			store.WithCheckpoint{Checkpoint: checkpoint},
+			store.ReplicateBatchSize(8),
+			opts.TapKey(func(_ store.Key) {
+				if atomic.AddInt64(&copied, 1) == 20 {
+					cancel()
+				}
+			}),
+		)
+		if err == nil {
+			t.Fatal()
+		}
+		var progress struct {
+			Last store.Key
+		}
+		ok, err := checkpoint.Load(&progress)
+		ce(err)
+		if !ok || !progress.Last.Valid() {
+			t.Fatal()
+		}
+
+		// keys not done before the saved progress
+		remaining := 0
+		ce(from.IterAllKeys(func(key store.Key) error {
+			if key.Compare(progress.Last) <= 0 {
+				return nil
+			}
+			ok, err := to.Exists(key)
+			ce(err)
+			if !ok {
+				remaining++
+			}
+			return nil
+		}))
+		if remaining == 0 || remaining >= n {
+			t.Fatalf("got %d", remaining)
+		}
+
+		// resume
+		var bytes int64
+		var resumed int64
+		err = replicate(
+			wg, from, to,
+			store.WithCheckpoint{Checkpoint: checkpoint},
+			store.ReplicateBatchSize(8),
+			opts.TapKey(func(_ store.Key) {
+				atomic.AddInt64(&copied, 1)
+				atomic.AddInt64(&resumed, 1)
+			}),
+			store.TapWriteResult(func(res store.WriteResult) {
+				atomic.AddInt64(&bytes, res.BytesWritten)
+			}),
+		)
+		ce(err)
+		if resumed != int64(remaining) {
+			t.Fatalf("got %d, expected %d", resumed, remaining)
+		}
+		if copied < int64(n) {
+			t.Fatalf("got %d", copied)
+		}
+		if bytes == 0 {
+			t.Fatal()
+		}
+
+		num := 0
+		ce(to.IterAllKeys(func(key store.Key) error {
+			num++
+			return from.Read(key, func(sb.Stream) error {
+				return nil
+			})
+		}))
+		if num != n {
+			t.Fatalf("got %d", num)
+		}
+
+		ok, err = checkpoint.Load(&progress)
+		ce(err)
+		if !ok || progress.Last.Valid() {
+			t.Fatal()
+		}
+	})
+}